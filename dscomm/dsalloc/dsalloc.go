@@ -7,7 +7,7 @@ package dsalloc
 import (
     "encoding/json"
     "sync"
-    "dstore/dscomm/dsinter"
+    "fdump/dscomm/dsinter"
 )
 
 type Alloc struct {
@@ -57,17 +57,23 @@ func (alloc *Alloc) NewId() (int64, error) {
     if freeIds > 0 {
         newId = alloc.freeIds[freeIds - 1]
         alloc.freeIds = alloc.freeIds[0:freeIds - 1]
+        err = alloc.storeState()
+        if err != nil {
+            alloc.freeIds = append(alloc.freeIds, newId)
+            newId = -1
+            return newId, err
+        }
         return newId, err
     }
 
     newId = alloc.topId + 1
+    alloc.topId = newId
     err = alloc.storeState()
     if err != nil {
-        alloc.freeIds = append(alloc.freeIds, newId)
+        alloc.topId = newId - 1
         newId = -1
         return newId, err
     }
-    alloc.topId = newId
     return newId, err
 }
 
@@ -4,7 +4,7 @@ package dsalloc
 import (
     "testing"
     "github.com/stretchr/testify/require"
-    "dstore/dscomm/dskvdb"
+    "fdump/dscomm/dskvdb"
 )
 
 func TestAlloc01(t *testing.T) {
@@ -34,6 +34,33 @@ func TestAlloc01(t *testing.T) {
     require.NoError(t, err)
 }
 
+func TestAllocReopen(t *testing.T) {
+    var err error
+
+    dataDir := t.TempDir()
+
+    db, err := dskvdb.OpenDB(dataDir, "tmp.db")
+    defer db.Close()
+    require.NoError(t, err)
+
+    key := []byte("blockids")
+    alloc, err := OpenAlloc(db, key)
+    require.NoError(t, err)
+
+    id1, err := alloc.NewId()
+    require.NoError(t, err)
+    id2, err := alloc.NewId()
+    require.NoError(t, err)
+
+    alloc, err = OpenAlloc(db, key)
+    require.NoError(t, err)
+
+    id3, err := alloc.NewId()
+    require.NoError(t, err)
+    require.NotEqual(t, id1, id3)
+    require.NotEqual(t, id2, id3)
+}
+
 func BenchmarkIdAlloc(b *testing.B) {
     var err error
 
@@ -0,0 +1,141 @@
+/*
+ * Copyright 2022 Oleg Borodin  <borodin@unix7.org>
+ */
+
+package dsauth
+
+import (
+    "errors"
+
+    "fdump/dscomm/dsdescr"
+    "fdump/dscomm/dserr"
+    "fdump/dscomm/dslog"
+    "fdump/dscomm/dsrpc"
+)
+
+type UserStore interface {
+    GetUser(login string) (bool, *dsdescr.User, error)
+    UpgradeUser(login string) error
+}
+
+// Auth checks requests of service users against password records
+// of the store. Plaintext passwords of previous versions are upgraded
+// to password records after the successful challenge auth.
+type Auth struct {
+    store       UserStore
+    legacyAuth  bool
+    saltSecret  []byte
+}
+
+func NewAuth(store UserStore) *Auth {
+    var auth Auth
+    auth.store = store
+    auth.saltSecret = dsrpc.CreateSalt()
+    return &auth
+}
+
+// SetLegacyAuth allows the salt+pass auth of not yet migrated clients.
+func (auth *Auth) SetLegacyAuth(flag bool) {
+    auth.legacyAuth = flag
+}
+
+func (auth *Auth) Midware(debugMode bool) dsrpc.HandlerFunc {
+    return func(context *dsrpc.Context) error {
+
+        var err error
+        login := context.AuthIdent()
+        reqAuth := context.Auth()
+
+        has, user, err := auth.store.GetUser(string(login))
+        if err != nil {
+            resErr := errors.New("auth mismatch")
+            context.SendError(resErr)
+            return dserr.Err(err)
+        }
+        if !has {
+            resErr := errors.New("auth error")
+            context.SendError(resErr)
+            return dserr.Err(resErr)
+        }
+
+        if debugMode {
+            dslog.LogDebug("auth ", string(reqAuth.JSON()))
+        }
+
+        var ok bool
+        switch {
+            case reqAuth.IsLegacy():
+                // Legacy auth needs the plaintext, so only
+                // not yet upgraded users are able to use it
+                if !auth.legacyAuth || dsrpc.IsPassHash(user.Pass) {
+                    resErr := errors.New("legacy auth disabled")
+                    context.SendError(resErr)
+                    return dserr.Err(resErr)
+                }
+                ok = dsrpc.CheckHash(login, []byte(user.Pass), reqAuth.Salt, reqAuth.Hash)
+            default:
+                _, _, storedKey, err := auth.recordKey(login, user.Pass)
+                if err != nil {
+                    resErr := errors.New("auth error")
+                    context.SendError(resErr)
+                    return dserr.Err(err)
+                }
+                ok = context.CheckAuth(storedKey)
+                if ok && !dsrpc.IsPassHash(user.Pass) {
+                    err = auth.store.UpgradeUser(user.Login)
+                    if err != nil {
+                        dslog.LogErrorf("password upgrade for %s error: %v", user.Login, err)
+                        err = nil
+                    }
+                }
+        }
+        if debugMode {
+            dslog.LogDebugf("auth for %s is %v", login, ok)
+        }
+        if !ok {
+            resErr := errors.New("auth mismatch")
+            context.SendError(resErr)
+            return dserr.Err(resErr)
+        }
+        return dserr.Err(err)
+    }
+}
+
+// Salt returns the salt and the params of the password record for
+// the auth challenge, unknown users get a dummy salt.
+func (auth *Auth) Salt(ident []byte) ([]byte, *dsrpc.PassParams, error) {
+    var err error
+    var salt []byte
+    var params *dsrpc.PassParams
+
+    has, user, err := auth.store.GetUser(string(ident))
+    if err != nil {
+        return salt, params, dserr.Err(err)
+    }
+    if !has {
+        return dsrpc.DummySalt(auth.saltSecret, ident), dsrpc.DefaultPassParams(), dserr.Err(err)
+    }
+    salt, params, _, err = auth.recordKey(ident, user.Pass)
+    if err != nil {
+        return salt, params, dserr.Err(err)
+    }
+    return salt, params, dserr.Err(err)
+}
+
+// recordKey returns the salt, the params and the stored key of the
+// password record. Plaintext passwords get the salt of unknown users,
+// so the record is not changed before the auth is passed.
+func (auth *Auth) recordKey(ident []byte, record string) ([]byte, *dsrpc.PassParams, []byte, error) {
+    var err error
+    if !dsrpc.IsPassHash(record) {
+        salt := dsrpc.DummySalt(auth.saltSecret, ident)
+        params := dsrpc.DefaultPassParams()
+        storedKey := dsrpc.StoredKey(dsrpc.DeriveKey([]byte(record), salt, params))
+        return salt, params, storedKey, dserr.Err(err)
+    }
+    params, salt, storedKey, err := dsrpc.ParsePassHash(record)
+    if err != nil {
+        return salt, params, storedKey, dserr.Err(err)
+    }
+    return salt, params, storedKey, dserr.Err(err)
+}
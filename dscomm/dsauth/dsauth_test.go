@@ -0,0 +1,92 @@
+/*
+ * Copyright 2022 Oleg Borodin  <borodin@unix7.org>
+ */
+
+package dsauth
+
+import (
+    "sync"
+    "testing"
+    "time"
+
+    "github.com/stretchr/testify/require"
+
+    "fdump/dscomm/dsdescr"
+    "fdump/dscomm/dsrpc"
+)
+
+type testStore struct {
+    mtx     sync.Mutex
+    users   map[string]*dsdescr.User
+}
+
+func (store *testStore) GetUser(login string) (bool, *dsdescr.User, error) {
+    store.mtx.Lock()
+    defer store.mtx.Unlock()
+    user, has := store.users[login]
+    if !has {
+        return false, nil, nil
+    }
+    userCopy := *user
+    return true, &userCopy, nil
+}
+
+func (store *testStore) UpgradeUser(login string) error {
+    store.mtx.Lock()
+    defer store.mtx.Unlock()
+    user := store.users[login]
+    if !dsrpc.IsPassHash(user.Pass) {
+        user.Pass = dsrpc.HashPass([]byte(user.Pass))
+    }
+    return nil
+}
+
+func (store *testStore) pass(login string) string {
+    _, user, _ := store.GetUser(login)
+    return user.Pass
+}
+
+func TestAuthUpgrade(t *testing.T) {
+    var err error
+
+    // Plaintext record of previous versions
+    user := dsdescr.NewUser()
+    user.Login = "qwert"
+    user.Pass  = "12345"
+    store := &testStore{ users: map[string]*dsdescr.User{ user.Login: user } }
+
+    auth := NewAuth(store)
+    auth.SetLegacyAuth(true)
+
+    serv := dsrpc.NewService()
+    serv.SetSaltFunc(auth.Salt)
+    serv.PreMiddleware(auth.Midware(false))
+    serv.Handler("hello", func(context *dsrpc.Context) error {
+        return context.SendResult(dsrpc.NewEmpty(), 0)
+    })
+    go serv.Listen("127.0.0.1:8084")
+    defer serv.Stop()
+    time.Sleep(10 * time.Millisecond)
+
+    // The challenge does not touch the record
+    _, _, err = auth.Salt([]byte(user.Login))
+    require.NoError(t, err)
+    require.Equal(t, "12345", store.pass(user.Login))
+
+    params := dsrpc.NewEmpty()
+    result := dsrpc.NewEmpty()
+    err = dsrpc.Exec("127.0.0.1:8084", "hello", params, result, dsrpc.CreateAuth([]byte("qwert"), []byte("54321")))
+    require.Error(t, err)
+    require.Equal(t, "12345", store.pass(user.Login))
+
+    err = dsrpc.Exec("127.0.0.1:8084", "hello", params, result, dsrpc.CreateAuth([]byte("other"), []byte("12345")))
+    require.Error(t, err)
+
+    // The record is upgraded after the successful auth
+    err = dsrpc.Exec("127.0.0.1:8084", "hello", params, result, dsrpc.CreateAuth([]byte("qwert"), []byte("12345")))
+    require.NoError(t, err)
+    require.True(t, dsrpc.IsPassHash(store.pass(user.Login)))
+
+    err = dsrpc.Exec("127.0.0.1:8084", "hello", params, result, dsrpc.CreateAuth([]byte("qwert"), []byte("12345")))
+    require.NoError(t, err)
+}
@@ -0,0 +1,158 @@
+/*
+ * Copyright 2022 Oleg Borodin  <borodin@unix7.org>
+ */
+
+package dschunk
+
+import (
+    "crypto/sha256"
+    "encoding/hex"
+    "errors"
+    "io"
+    "math/bits"
+)
+
+const DefMinSize    int = 256 * 1024
+const DefAvgSize    int = 1024 * 1024
+const DefMaxSize    int = 4 * 1024 * 1024
+
+// gearSeed keeps the gear table and so chunk boundaries stable,
+// the seed must not be changed.
+const gearSeed      uint64 = 0x6664756D70636463
+
+var gear [256]uint64
+
+func init() {
+    state := gearSeed
+    for i := range gear {
+        // splitmix64
+        state += 0x9E3779B97F4A7C15
+        z := state
+        z = (z ^ (z >> 30)) * 0xBF58476D1CE4E5B9
+        z = (z ^ (z >> 27)) * 0x94D049BB133111EB
+        gear[i] = z ^ (z >> 31)
+    }
+}
+
+// Chunker splits the data stream into content defined chunks
+// with the FastCDC gear hash and normalized chunking. Chunks
+// of the same data have the same boundaries regardless of
+// the data offset in the stream.
+type Chunker struct {
+    reader      io.Reader
+    buffer      []byte
+    start       int
+    end         int
+    eof         bool
+    minSize     int
+    avgSize     int
+    maxSize     int
+    maskS       uint64
+    maskL       uint64
+}
+
+func NewChunker(reader io.Reader, minSize, avgSize, maxSize int) (*Chunker, error) {
+    var err error
+    var chunker Chunker
+    if minSize < 64 || minSize >= avgSize || avgSize >= maxSize {
+        err = errors.New("wrong chunk sizes, must be 64 <= min < avg < max")
+        return &chunker, err
+    }
+    avgBits := bits.Len(uint(avgSize)) - 1
+    chunker.reader  = reader
+    chunker.buffer  = make([]byte, 2 * maxSize)
+    chunker.minSize = minSize
+    chunker.avgSize = avgSize
+    chunker.maxSize = maxSize
+    chunker.maskS   = ^uint64(0) << (64 - (avgBits + 1))
+    chunker.maskL   = ^uint64(0) << (64 - (avgBits - 1))
+    return &chunker, err
+}
+
+// Next returns the next chunk or io.EOF at the end of data.
+// The chunk data are valid until the next call.
+func (chunker *Chunker) Next() ([]byte, error) {
+    var err error
+    var chunk []byte
+
+    err = chunker.fill()
+    if err != nil {
+        return chunk, err
+    }
+    if chunker.start == chunker.end {
+        return chunk, io.EOF
+    }
+    cut := chunker.cutPoint(chunker.buffer[chunker.start:chunker.end])
+    chunk = chunker.buffer[chunker.start:chunker.start + cut]
+    chunker.start += cut
+    return chunk, err
+}
+
+// fill reads data until the buffer keeps the max chunk size
+// after the start or the reader ends.
+func (chunker *Chunker) fill() error {
+    var err error
+    if chunker.eof || chunker.end - chunker.start >= chunker.maxSize {
+        return err
+    }
+    copy(chunker.buffer, chunker.buffer[chunker.start:chunker.end])
+    chunker.end -= chunker.start
+    chunker.start = 0
+    for chunker.end < chunker.maxSize {
+        read, err := chunker.reader.Read(chunker.buffer[chunker.end:])
+        chunker.end += read
+        if err == io.EOF {
+            chunker.eof = true
+            return nil
+        }
+        if err != nil {
+            return err
+        }
+    }
+    return err
+}
+
+// cutPoint returns the size of the chunk at the data start.
+func (chunker *Chunker) cutPoint(data []byte) int {
+    size := len(data)
+    if size <= chunker.minSize {
+        return size
+    }
+    if size > chunker.maxSize {
+        size = chunker.maxSize
+    }
+    normSize := chunker.avgSize
+    if size < normSize {
+        normSize = size
+    }
+    var hash uint64
+    i := chunker.minSize
+    for ; i < normSize; i++ {
+        hash = (hash << 1) + gear[data[i]]
+        if hash & chunker.maskS == 0 {
+            return i
+        }
+    }
+    for ; i < size; i++ {
+        hash = (hash << 1) + gear[data[i]]
+        if hash & chunker.maskL == 0 {
+            return i
+        }
+    }
+    return size
+}
+
+// ChunkId returns the hex sha256 sum of the chunk data.
+func ChunkId(data []byte) string {
+    sum := sha256.Sum256(data)
+    return hex.EncodeToString(sum[:])
+}
+
+// ValidChunkId reports whether the id is the hex sha256 sum.
+func ValidChunkId(chunkId string) bool {
+    if len(chunkId) != sha256.Size * 2 {
+        return false
+    }
+    _, err := hex.DecodeString(chunkId)
+    return err == nil
+}
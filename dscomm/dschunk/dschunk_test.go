@@ -0,0 +1,71 @@
+/*
+ * Copyright 2022 Oleg Borodin  <borodin@unix7.org>
+ */
+
+package dschunk
+
+import (
+    "bytes"
+    "io"
+    "math/rand"
+    "testing"
+
+    "github.com/stretchr/testify/require"
+)
+
+func chunkIds(t *testing.T, data []byte) ([]string, []int) {
+    chunker, err := NewChunker(bytes.NewReader(data), 2 * 1024, 8 * 1024, 32 * 1024)
+    require.NoError(t, err)
+    ids := make([]string, 0)
+    sizes := make([]int, 0)
+    var joined bytes.Buffer
+    for {
+        chunk, err := chunker.Next()
+        if err == io.EOF {
+            break
+        }
+        require.NoError(t, err)
+        ids = append(ids, ChunkId(chunk))
+        sizes = append(sizes, len(chunk))
+        joined.Write(chunk)
+    }
+    require.Equal(t, len(data), joined.Len())
+    require.True(t, bytes.Equal(data, joined.Bytes()))
+    return ids, sizes
+}
+
+func TestChunker01(t *testing.T) {
+    data := make([]byte, 1024 * 1024)
+    rand.New(rand.NewSource(1)).Read(data)
+
+    ids, sizes := chunkIds(t, data)
+    require.Greater(t, len(ids), 16)
+    for i, size := range sizes {
+        require.LessOrEqual(t, size, 32 * 1024)
+        if i < len(sizes) - 1 {
+            require.GreaterOrEqual(t, size, 2 * 1024)
+        }
+    }
+    sameIds, _ := chunkIds(t, data)
+    require.Equal(t, ids, sameIds)
+
+    shifted := append([]byte("inserted head"), data...)
+    shiftedIds, _ := chunkIds(t, shifted)
+    known := make(map[string]bool)
+    for _, id := range ids {
+        known[id] = true
+    }
+    var common int
+    for _, id := range shiftedIds {
+        if known[id] {
+            common++
+        }
+    }
+    require.Greater(t, common, len(ids) - 3)
+
+    require.True(t, ValidChunkId(ids[0]))
+    require.False(t, ValidChunkId("abc"))
+
+    emptyIds, _ := chunkIds(t, []byte{})
+    require.Equal(t, 0, len(emptyIds))
+}
@@ -42,6 +42,36 @@ func (descr *User) Pack() ([]byte, error) {
 }
 
 
+// Quota keeps limits and the current usage of the login,
+// zero limit means no limit.
+type Quota struct {
+    Login       string      `json:"login"	msgpack:"login"`
+    MaxBytes    int64       `json:"maxBytes"	msgpack:"maxBytes"`
+    MaxFiles    int64       `json:"maxFiles"	msgpack:"maxFiles"`
+    UsedBytes   int64       `json:"usedBytes"	msgpack:"usedBytes"`
+    UsedFiles   int64       `json:"usedFiles"	msgpack:"usedFiles"`
+    UpdatedAt   int64       `json:"updatedAt"	msgpack:"updatedAt"`
+}
+
+func NewQuota() *Quota {
+    var descr Quota
+    return &descr
+}
+
+func UnpackQuota(descrBin []byte) (*Quota, error) {
+    var err error
+    var descr Quota
+    err = encoder.Unmarshal(descrBin, &descr)
+    return &descr, err
+}
+
+func (descr *Quota) Pack() ([]byte, error) {
+    var err error
+    descrBin, err := encoder.Marshal(descr)
+    return descrBin, err
+}
+
+
 type File struct {
     FilePath    string      `json:"filePath"	msgpack:"filePath"`
     Login       string      `json:"login"	msgpack:"login"`
@@ -49,6 +79,7 @@ type File struct {
     BatchCount  int64       `json:"batchCount"	msgpack:"batchCount"`
     BatchSize   int64       `json:"batchSize"	msgpack:"batchSize"`
     BlockSize   int64       `json:"blockSize"	msgpack:"blockSize"`
+    RecoCount   int64       `json:"recoCount"	msgpack:"recoCount"`
     DataSize    int64       `json:"dataSize"	msgpack:"dataSize"`
     CreatedAt   int64       `json:"createdAt"	msgpack:"createdAt"`
     UpdatedAt   int64       `json:"updatedAt"	msgpack:"updatedAt"`
@@ -78,6 +109,10 @@ type Batch struct {
     FileId      int64       `json:"fileId"	msgpack:"fileId"`
     BatchSize   int64       `json:"batchSize"	msgpack:"batchSize"`
     BlockSize   int64       `json:"blockSize"	msgpack:"blockSize"`
+    RecoCount   int64       `json:"recoCount"	msgpack:"recoCount"`
+    // Sizes of data blocks covered by the recovery blocks,
+    // empty if the batch is not encoded yet.
+    DataSizes   []int64     `json:"dataSizes"	msgpack:"dataSizes"`
     CreatedAt   int64       `json:"createdAt"	msgpack:"createdAt"`
     UpdatedAt   int64       `json:"updatedAt"	msgpack:"updatedAt"`
 }
@@ -121,6 +156,8 @@ type Block struct {
     HasLocal    bool        `json:"hasLocal"	msgpack:"hasLocal"`
     HasRemote   bool        `json:"hasRemote"	msgpack:"hasRemote"`
     BstoreId    int64       `json:"bstoreId"	msgpack:"bstoreId"`
+
+    Corrupted   bool        `json:"corrupted"	msgpack:"corrupted"`
 }
 
 func NewBlock() *Block {
@@ -140,3 +177,69 @@ func (descr *Block) Pack() ([]byte, error) {
     descrBin, err := encoder.Marshal(descr)
     return descrBin, err
 }
+
+
+// Chunk is the content defined chunk of the login keyed by
+// the hex sha256 sum of the data, RefCount counts chunk files
+// referencing the chunk.
+type Chunk struct {
+    Login       string      `json:"login"	msgpack:"login"`
+    ChunkId     string      `json:"chunkId"	msgpack:"chunkId"`
+    DataSize    int64       `json:"dataSize"	msgpack:"dataSize"`
+    RefCount    int64       `json:"refCount"	msgpack:"refCount"`
+    FilePath    string      `json:"filePath"	msgpack:"filePath"`
+    // The recovery file with parity shards and sha256 sums of data
+    // and parity shards, empty if the chunk is not encoded yet.
+    RecoPath    string      `json:"recoPath"	msgpack:"recoPath"`
+    ShardSums   []string    `json:"shardSums"	msgpack:"shardSums"`
+    CreatedAt   int64       `json:"createdAt"	msgpack:"createdAt"`
+    UpdatedAt   int64       `json:"updatedAt"	msgpack:"updatedAt"`
+}
+
+func NewChunk() *Chunk {
+    var descr Chunk
+    return &descr
+}
+
+func UnpackChunk(descrBin []byte) (*Chunk, error) {
+    var err error
+    var descr Chunk
+    err = encoder.Unmarshal(descrBin, &descr)
+    return &descr, err
+}
+
+func (descr *Chunk) Pack() ([]byte, error) {
+    var err error
+    descrBin, err := encoder.Marshal(descr)
+    return descrBin, err
+}
+
+// ChunkFile is the file of the login stored as the ordered
+// list of chunks.
+type ChunkFile struct {
+    Login       string      `json:"login"	msgpack:"login"`
+    FilePath    string      `json:"filePath"	msgpack:"filePath"`
+    ChunkIds    []string    `json:"chunkIds"	msgpack:"chunkIds"`
+    DataSize    int64       `json:"dataSize"	msgpack:"dataSize"`
+    CreatedAt   int64       `json:"createdAt"	msgpack:"createdAt"`
+    UpdatedAt   int64       `json:"updatedAt"	msgpack:"updatedAt"`
+}
+
+func NewChunkFile() *ChunkFile {
+    var descr ChunkFile
+    descr.ChunkIds = make([]string, 0)
+    return &descr
+}
+
+func UnpackChunkFile(descrBin []byte) (*ChunkFile, error) {
+    var err error
+    var descr ChunkFile
+    err = encoder.Unmarshal(descrBin, &descr)
+    return &descr, err
+}
+
+func (descr *ChunkFile) Pack() ([]byte, error) {
+    var err error
+    descrBin, err := encoder.Marshal(descr)
+    return descrBin, err
+}
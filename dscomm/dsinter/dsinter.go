@@ -23,11 +23,52 @@ type Alloc interface {
     JSON() ([]byte, error)
 }
 
-type BStoreReg interface {
+type UserReg interface {
     PutUser(descr *dsdescr.User) error
     HasUser(login string) (bool, error)
     GetUser(login string) (*dsdescr.User, error)
     ListUsers() ([]*dsdescr.User, error)
     DeleteUser(login string) error
+}
+
+type BStoreReg interface {
+    UserReg
+
+    PutBlock(descr *dsdescr.Block) error
+    HasBlock(fileId, batchId, blockType, blockId int64) (bool, error)
+    GetBlock(fileId, batchId, blockType, blockId int64) (*dsdescr.Block, error)
+    ListBlocks(fileId int64) ([]*dsdescr.Block, error)
+    DeleteBlock(fileId, batchId, blockType, blockId int64) error
+
+    NewFileId() (int64, error)
+    FreeFileId(fileId int64) error
+    PutFile(descr *dsdescr.File) error
+    HasFile(fileId int64) (bool, error)
+    GetFile(fileId int64) (*dsdescr.File, error)
+    HasFilePath(login, filePath string) (bool, error)
+    ListFiles(login, pathPrefix string) ([]*dsdescr.File, error)
+    ListFileIds() ([]int64, error)
+    DeleteFile(fileId int64) error
+
+    PutBatch(descr *dsdescr.Batch) error
+    HasBatch(fileId, batchId int64) (bool, error)
+    GetBatch(fileId, batchId int64) (*dsdescr.Batch, error)
+    ListBatches(fileId int64) ([]*dsdescr.Batch, error)
+    DeleteBatch(fileId, batchId int64) error
+
+    PutQuota(descr *dsdescr.Quota) error
+    HasQuota(login string) (bool, error)
+    GetQuota(login string) (*dsdescr.Quota, error)
+
+    PutChunk(descr *dsdescr.Chunk) error
+    HasChunk(login, chunkId string) (bool, error)
+    GetChunk(login, chunkId string) (*dsdescr.Chunk, error)
+    ListChunks(login string) ([]*dsdescr.Chunk, error)
+    DeleteChunk(login, chunkId string) error
 
+    PutChunkFile(descr *dsdescr.ChunkFile) error
+    HasChunkFile(login, filePath string) (bool, error)
+    GetChunkFile(login, filePath string) (*dsdescr.ChunkFile, error)
+    ListChunkFiles(login, pathPrefix string) ([]*dsdescr.ChunkFile, error)
+    DeleteChunkFile(login, filePath string) error
 }
@@ -0,0 +1,22 @@
+/*
+ * Copyright 2022 Oleg Borodin  <borodin@unix7.org>
+ */
+
+package dspack
+
+// AttrDescr keeps extended attributes with POSIX ACLs, SELinux
+// labels and file capabilities, and file flags of the entry.
+type AttrDescr struct {
+    XAttrs  map[string][]byte   `json:"xattrs,omitempty"`
+    Flags   uint32              `json:"flags,omitempty"`
+}
+
+func NewAttrDescr() *AttrDescr {
+    var descr AttrDescr
+    descr.XAttrs = make(map[string][]byte)
+    return &descr
+}
+
+func (descr *AttrDescr) Empty() bool {
+    return len(descr.XAttrs) == 0 && descr.Flags == 0
+}
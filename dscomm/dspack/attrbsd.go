@@ -0,0 +1,48 @@
+//go:build freebsd || netbsd || openbsd || darwin
+// +build freebsd netbsd openbsd darwin
+
+/*
+ * Copyright 2022 Oleg Borodin  <borodin@unix7.org>
+ */
+
+package dspack
+
+import (
+    "io/fs"
+    "syscall"
+
+    "golang.org/x/sys/unix"
+)
+
+// readAttrs returns file flags of the entry,
+// extended attributes are not collected.
+func readAttrs(filePath string, fileMode fs.FileMode) (*AttrDescr, error) {
+    var err error
+    descr := NewAttrDescr()
+    var sysStat syscall.Stat_t
+    err = syscall.Lstat(filePath, &sysStat)
+    if err != nil {
+        return descr, err
+    }
+    descr.Flags = uint32(sysStat.Flags)
+    return descr, err
+}
+
+func writeXAttrs(filePath string, descr *AttrDescr) error {
+    var err error
+    return err
+}
+
+// writeFlags sets file flags, flags are set last because
+// immutable and append only files may not be changed.
+func writeFlags(filePath string, fileMode fs.FileMode, descr *AttrDescr) error {
+    var err error
+    if descr.Flags == 0 || !(fileMode.IsRegular() || fileMode.IsDir()) {
+        return err
+    }
+    err = unix.Chflags(filePath, int(descr.Flags))
+    if err != nil {
+        return err
+    }
+    return err
+}
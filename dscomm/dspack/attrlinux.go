@@ -0,0 +1,146 @@
+//go:build linux
+// +build linux
+
+/*
+ * Copyright 2022 Oleg Borodin  <borodin@unix7.org>
+ */
+
+package dspack
+
+import (
+    "bytes"
+    "errors"
+    "io/fs"
+    "os"
+    "syscall"
+    "unsafe"
+
+    "golang.org/x/sys/unix"
+)
+
+// Inode flags of linux/fs.h changeable with chattr(1) are restored,
+// others are managed by the file system.
+const fsSyncFlag        uint32 = 0x00000008
+const fsImmutableFlag   uint32 = 0x00000010
+const fsAppendFlag      uint32 = 0x00000020
+const fsNodumpFlag      uint32 = 0x00000040
+const fsNoatimeFlag     uint32 = 0x00000080
+const fsDirsyncFlag     uint32 = 0x00010000
+const fsNocowFlag       uint32 = 0x00800000
+
+const attrFlagsMask uint32 = fsSyncFlag | fsImmutableFlag | fsAppendFlag | fsNodumpFlag |
+                                fsNoatimeFlag | fsDirsyncFlag | fsNocowFlag
+
+// readAttrs returns extended attributes of the entry, POSIX ACLs are
+// kept in system.posix_acl_* attributes, and flags of files and dirs.
+func readAttrs(filePath string, fileMode fs.FileMode) (*AttrDescr, error) {
+    var err error
+    descr := NewAttrDescr()
+
+    names, err := listXAttrs(filePath)
+    if unsupportedAttr(err) {
+        return descr, nil
+    }
+    if err != nil {
+        return descr, err
+    }
+    for _, name := range names {
+        value, err := getXAttr(filePath, name)
+        if unsupportedAttr(err) {
+            continue
+        }
+        if err != nil {
+            return descr, err
+        }
+        descr.XAttrs[name] = value
+    }
+    if fileMode.IsRegular() || fileMode.IsDir() {
+        file, err := os.OpenFile(filePath, os.O_RDONLY|syscall.O_NOFOLLOW|syscall.O_NONBLOCK, 0)
+        if err == nil {
+            defer file.Close()
+            flags, err := unix.IoctlGetUint32(int(file.Fd()), unix.FS_IOC_GETFLAGS)
+            if err == nil {
+                descr.Flags = flags & attrFlagsMask
+            }
+        }
+    }
+    return descr, nil
+}
+
+func listXAttrs(filePath string) ([]string, error) {
+    var err error
+    names := make([]string, 0)
+    size, err := unix.Llistxattr(filePath, nil)
+    if err != nil || size == 0 {
+        return names, err
+    }
+    buffer := make([]byte, size)
+    size, err = unix.Llistxattr(filePath, buffer)
+    if err != nil {
+        return names, err
+    }
+    for _, name := range bytes.Split(buffer[0:size], []byte{ 0 }) {
+        if len(name) > 0 {
+            names = append(names, string(name))
+        }
+    }
+    return names, err
+}
+
+func getXAttr(filePath, name string) ([]byte, error) {
+    var err error
+    var value []byte
+    size, err := unix.Lgetxattr(filePath, name, nil)
+    if err != nil {
+        return value, err
+    }
+    value = make([]byte, size)
+    size, err = unix.Lgetxattr(filePath, name, value)
+    if err != nil {
+        return value, err
+    }
+    value = value[0:size]
+    return value, err
+}
+
+// writeXAttrs sets extended attributes of the entry, attributes
+// are set after the owner and the mode because chown drops file
+// capabilities and chmod rewrites the ACL mask.
+func writeXAttrs(filePath string, descr *AttrDescr) error {
+    var err error
+    for name, value := range descr.XAttrs {
+        err = unix.Lsetxattr(filePath, name, value, 0)
+        if unsupportedAttr(err) {
+            continue
+        }
+        if err != nil {
+            return err
+        }
+    }
+    return err
+}
+
+// writeFlags sets file flags, flags are set last because
+// immutable and append only files may not be changed.
+func writeFlags(filePath string, fileMode fs.FileMode, descr *AttrDescr) error {
+    var err error
+    if descr.Flags == 0 || !(fileMode.IsRegular() || fileMode.IsDir()) {
+        return err
+    }
+    file, err := os.OpenFile(filePath, os.O_RDONLY|syscall.O_NOFOLLOW|syscall.O_NONBLOCK, 0)
+    if err != nil {
+        return err
+    }
+    defer file.Close()
+    flags := descr.Flags & attrFlagsMask
+    _, _, errno := unix.Syscall(unix.SYS_IOCTL, file.Fd(), uintptr(unix.FS_IOC_SETFLAGS), uintptr(unsafe.Pointer(&flags)))
+    if errno != 0 && !unsupportedAttr(errno) {
+        err = errno
+        return err
+    }
+    return err
+}
+
+func unsupportedAttr(err error) bool {
+    return errors.Is(err, unix.ENOTSUP) || errors.Is(err, unix.ENOTTY) || errors.Is(err, unix.ENODATA)
+}
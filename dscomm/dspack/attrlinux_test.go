@@ -0,0 +1,122 @@
+//go:build linux
+// +build linux
+
+/*
+ * Copyright 2022 Oleg Borodin  <borodin@unix7.org>
+ */
+
+package dspack
+
+import(
+    "encoding/binary"
+    "io/fs"
+    "os"
+    "path/filepath"
+    "testing"
+
+    "github.com/stretchr/testify/require"
+    "golang.org/x/sys/unix"
+)
+
+func testACL() []byte {
+    // posix_acl_xattr_header with user::rw-, user:1000:r--,
+    // group::r--, mask::r--, other::---
+    entries := [][3]uint32{
+        { 0x01, 6, 0xFFFFFFFF },
+        { 0x02, 4, 1000 },
+        { 0x04, 4, 0xFFFFFFFF },
+        { 0x10, 4, 0xFFFFFFFF },
+        { 0x20, 0, 0xFFFFFFFF },
+    }
+    acl := make([]byte, 4 + 8 * len(entries))
+    binary.LittleEndian.PutUint32(acl[0:4], 2)
+    for i, entry := range entries {
+        pos := 4 + 8 * i
+        binary.LittleEndian.PutUint16(acl[pos:], uint16(entry[0]))
+        binary.LittleEndian.PutUint16(acl[pos + 2:], uint16(entry[1]))
+        binary.LittleEndian.PutUint32(acl[pos + 4:], entry[2])
+    }
+    return acl
+}
+
+func testCapability() []byte {
+    // vfs_cap_data revision 2 with cap_net_bind_service permitted
+    capData := make([]byte, 20)
+    binary.LittleEndian.PutUint32(capData[0:4], 0x02000001)
+    binary.LittleEndian.PutUint32(capData[4:8], 1 << 10)
+    return capData
+}
+
+func TestPackAttrs(t *testing.T) {
+    var err error
+
+    if os.Getuid() != 0 {
+        t.Skip("attributes are restored by root")
+    }
+    srcDir := t.TempDir()
+    destDir := t.TempDir()
+
+    subDir := filepath.Join(srcDir, "sub")
+    err = os.Mkdir(subDir, 0750)
+    require.NoError(t, err)
+    filePath := filepath.Join(subDir, "server")
+    err = os.WriteFile(filePath, []byte("binary"), 0750)
+    require.NoError(t, err)
+
+    err = unix.Lsetxattr(subDir, "user.comment", []byte("dir comment"), 0)
+    if err == unix.ENOTSUP {
+        t.Skip("extended attributes are not supported")
+    }
+    require.NoError(t, err)
+    err = unix.Lsetxattr(filePath, "user.comment", []byte("file comment"), 0)
+    require.NoError(t, err)
+    err = unix.Lsetxattr(filePath, "system.posix_acl_access", testACL(), 0)
+    require.NoError(t, err)
+    err = unix.Lsetxattr(filePath, "security.capability", testCapability(), 0)
+    require.NoError(t, err)
+
+    err = writeFlags(filePath, 0, &AttrDescr{ Flags: fsNodumpFlag })
+    require.NoError(t, err)
+
+    srcAttrs, err := readAttrs(filePath, 0)
+    require.NoError(t, err)
+
+    packPath := filepath.Join(destDir, "test.pack")
+    packFile, err := os.OpenFile(packPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+    require.NoError(t, err)
+    err = Pack([]string{ srcDir }, packFile)
+    require.NoError(t, err)
+    packFile.Close()
+
+    unpack := func(unpackDir string, skipAttrs bool) {
+        packFile, err := os.Open(packPath)
+        require.NoError(t, err)
+        defer packFile.Close()
+        options := NewUnpackOptions()
+        options.SkipAttrs = skipAttrs
+        _, err = UnpackSelected(packFile, unpackDir, options)
+        require.NoError(t, err)
+    }
+
+    unpackDir := filepath.Join(destDir, "unpack")
+    unpack(unpackDir, false)
+
+    unpackPath := filepath.Join(unpackDir, filePath)
+    attrs, err := readAttrs(unpackPath, 0)
+    require.NoError(t, err)
+    require.Equal(t, []byte("file comment"), attrs.XAttrs["user.comment"])
+    require.Equal(t, srcAttrs.XAttrs["system.posix_acl_access"], attrs.XAttrs["system.posix_acl_access"])
+    require.Equal(t, testCapability(), attrs.XAttrs["security.capability"])
+    require.Equal(t, srcAttrs.Flags, attrs.Flags)
+
+    dirAttrs, err := readAttrs(filepath.Join(unpackDir, subDir), fs.ModeDir)
+    require.NoError(t, err)
+    require.Equal(t, []byte("dir comment"), dirAttrs.XAttrs["user.comment"])
+
+    skipDir := filepath.Join(destDir, "skip")
+    unpack(skipDir, true)
+    attrs, err = readAttrs(filepath.Join(skipDir, filePath), 0)
+    require.NoError(t, err)
+    _, ok := attrs.XAttrs["user.comment"]
+    require.False(t, ok)
+}
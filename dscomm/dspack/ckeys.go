@@ -0,0 +1,312 @@
+/*
+ * Copyright 2022 Oleg Borodin  <borodin@unix7.org>
+ */
+
+package dspack
+
+import (
+    "bufio"
+    "crypto/rand"
+    "crypto/sha256"
+    "encoding/hex"
+    "errors"
+    "fmt"
+    "io"
+    "os"
+    "strings"
+
+    "golang.org/x/crypto/chacha20poly1305"
+    "golang.org/x/crypto/curve25519"
+    "golang.org/x/crypto/hkdf"
+    "golang.org/x/crypto/scrypt"
+)
+
+const dataKeySize   int = 32
+
+const StanzaX25519  string = "x25519"
+const StanzaScrypt  string = "scrypt"
+
+const scryptLogN    int = 15
+
+// CryptKeys are recipient public keys and the passphrase to encrypt
+// the archive data key, identities and the passphrase to decrypt it.
+// Keys are hex encoded X25519 keys.
+type CryptKeys struct {
+    Recipients  []string
+    Identities  []string
+    Passphrase  string
+}
+
+func NewCryptKeys() *CryptKeys {
+    var keys CryptKeys
+    keys.Recipients = make([]string, 0)
+    keys.Identities = make([]string, 0)
+    return &keys
+}
+
+// GenerateKey returns new hex encoded X25519 public and private keys.
+func GenerateKey() (string, string, error) {
+    var err error
+    var pubKey, privKey string
+    privBin := make([]byte, curve25519.ScalarSize)
+    _, err = rand.Read(privBin)
+    if err != nil {
+        return pubKey, privKey, err
+    }
+    pubBin, err := curve25519.X25519(privBin, curve25519.Basepoint)
+    if err != nil {
+        return pubKey, privKey, err
+    }
+    pubKey  = hex.EncodeToString(pubBin)
+    privKey = hex.EncodeToString(privBin)
+    return pubKey, privKey, err
+}
+
+// PublicKey returns the public key of the hex encoded private key.
+func PublicKey(privKey string) (string, error) {
+    var err error
+    var pubKey string
+    privBin, err := decodeKey(privKey)
+    if err != nil {
+        return pubKey, err
+    }
+    pubBin, err := curve25519.X25519(privBin, curve25519.Basepoint)
+    if err != nil {
+        return pubKey, err
+    }
+    pubKey = hex.EncodeToString(pubBin)
+    return pubKey, err
+}
+
+// ReadKeyFile returns keys of the file, one key per line,
+// empty lines and lines beginning with # are skipped.
+func ReadKeyFile(filePath string) ([]string, error) {
+    var err error
+    keys := make([]string, 0)
+    file, err := os.Open(filePath)
+    if err != nil {
+        return keys, err
+    }
+    defer file.Close()
+    scanner := bufio.NewScanner(file)
+    for scanner.Scan() {
+        line := strings.TrimSpace(scanner.Text())
+        if len(line) == 0 || strings.HasPrefix(line, "#") {
+            continue
+        }
+        keys = append(keys, line)
+    }
+    err = scanner.Err()
+    if err != nil {
+        return keys, err
+    }
+    return keys, err
+}
+
+func decodeKey(key string) ([]byte, error) {
+    var err error
+    keyBin, err := hex.DecodeString(strings.TrimSpace(key))
+    if err != nil {
+        return keyBin, err
+    }
+    if len(keyBin) != curve25519.PointSize {
+        err = errors.New("wrong key size")
+        return keyBin, err
+    }
+    return keyBin, err
+}
+
+// KeyStanza is the archive data key encrypted for the recipient
+// or with the passphrase.
+type KeyStanza struct {
+    Type        string      `json:"type"`
+    EphKey      []byte      `json:"ephKey,omitempty"`
+    Salt        []byte      `json:"salt,omitempty"`
+    LogN        int         `json:"logN,omitempty"`
+    WrappedKey  []byte      `json:"wrappedKey"`
+}
+
+func NewKeyStanza() *KeyStanza {
+    var stanza KeyStanza
+    return &stanza
+}
+
+func wrapKey(wrapKey, dataKey []byte) ([]byte, error) {
+    var err error
+    var wrapped []byte
+    aead, err := chacha20poly1305.New(wrapKey)
+    if err != nil {
+        return wrapped, err
+    }
+    nonce := make([]byte, chacha20poly1305.NonceSize)
+    wrapped = aead.Seal(nil, nonce, dataKey, nil)
+    return wrapped, err
+}
+
+func unwrapKey(wrapKey, wrapped []byte) ([]byte, error) {
+    var err error
+    var dataKey []byte
+    aead, err := chacha20poly1305.New(wrapKey)
+    if err != nil {
+        return dataKey, err
+    }
+    nonce := make([]byte, chacha20poly1305.NonceSize)
+    dataKey, err = aead.Open(nil, nonce, wrapped, nil)
+    if err != nil {
+        return dataKey, err
+    }
+    if len(dataKey) != dataKeySize {
+        err = errors.New("wrong data key size")
+        return dataKey, err
+    }
+    return dataKey, err
+}
+
+func x25519WrapKey(shared, ephPub, recPub []byte) ([]byte, error) {
+    var err error
+    salt := make([]byte, 0, len(ephPub) + len(recPub))
+    salt = append(salt, ephPub...)
+    salt = append(salt, recPub...)
+    wrapKey := make([]byte, chacha20poly1305.KeySize)
+    _, err = io.ReadFull(hkdf.New(sha256.New, shared, salt, []byte("dspack x25519")), wrapKey)
+    return wrapKey, err
+}
+
+func wrapForRecipient(recipient string, dataKey []byte) (*KeyStanza, error) {
+    var err error
+    stanza := NewKeyStanza()
+    recPub, err := decodeKey(recipient)
+    if err != nil {
+        return stanza, err
+    }
+    ephPriv := make([]byte, curve25519.ScalarSize)
+    _, err = rand.Read(ephPriv)
+    if err != nil {
+        return stanza, err
+    }
+    ephPub, err := curve25519.X25519(ephPriv, curve25519.Basepoint)
+    if err != nil {
+        return stanza, err
+    }
+    shared, err := curve25519.X25519(ephPriv, recPub)
+    if err != nil {
+        return stanza, err
+    }
+    key, err := x25519WrapKey(shared, ephPub, recPub)
+    if err != nil {
+        return stanza, err
+    }
+    stanza.Type   = StanzaX25519
+    stanza.EphKey = ephPub
+    stanza.WrappedKey, err = wrapKey(key, dataKey)
+    return stanza, err
+}
+
+func unwrapForIdentity(identity string, stanza *KeyStanza) ([]byte, error) {
+    var err error
+    var dataKey []byte
+    privBin, err := decodeKey(identity)
+    if err != nil {
+        return dataKey, err
+    }
+    recPub, err := curve25519.X25519(privBin, curve25519.Basepoint)
+    if err != nil {
+        return dataKey, err
+    }
+    shared, err := curve25519.X25519(privBin, stanza.EphKey)
+    if err != nil {
+        return dataKey, err
+    }
+    key, err := x25519WrapKey(shared, stanza.EphKey, recPub)
+    if err != nil {
+        return dataKey, err
+    }
+    dataKey, err = unwrapKey(key, stanza.WrappedKey)
+    return dataKey, err
+}
+
+func wrapForPassphrase(passphrase string, dataKey []byte) (*KeyStanza, error) {
+    var err error
+    stanza := NewKeyStanza()
+    salt := make([]byte, 16)
+    _, err = rand.Read(salt)
+    if err != nil {
+        return stanza, err
+    }
+    key, err := scrypt.Key([]byte(passphrase), salt, 1 << scryptLogN, 8, 1, chacha20poly1305.KeySize)
+    if err != nil {
+        return stanza, err
+    }
+    stanza.Type = StanzaScrypt
+    stanza.Salt = salt
+    stanza.LogN = scryptLogN
+    stanza.WrappedKey, err = wrapKey(key, dataKey)
+    return stanza, err
+}
+
+func unwrapForPassphrase(passphrase string, stanza *KeyStanza) ([]byte, error) {
+    var err error
+    var dataKey []byte
+    if stanza.LogN < 1 || stanza.LogN > 22 {
+        err = fmt.Errorf("wrong scrypt work factor %d", stanza.LogN)
+        return dataKey, err
+    }
+    key, err := scrypt.Key([]byte(passphrase), stanza.Salt, 1 << stanza.LogN, 8, 1, chacha20poly1305.KeySize)
+    if err != nil {
+        return dataKey, err
+    }
+    dataKey, err = unwrapKey(key, stanza.WrappedKey)
+    return dataKey, err
+}
+
+// wrapDataKey encrypts the data key for all recipients and the passphrase.
+func (keys *CryptKeys) wrapDataKey(dataKey []byte) ([]*KeyStanza, error) {
+    var err error
+    stanzas := make([]*KeyStanza, 0)
+    for _, recipient := range keys.Recipients {
+        stanza, err := wrapForRecipient(recipient, dataKey)
+        if err != nil {
+            err = fmt.Errorf("recipient %s: %v", recipient, err)
+            return stanzas, err
+        }
+        stanzas = append(stanzas, stanza)
+    }
+    if len(keys.Passphrase) > 0 {
+        stanza, err := wrapForPassphrase(keys.Passphrase, dataKey)
+        if err != nil {
+            return stanzas, err
+        }
+        stanzas = append(stanzas, stanza)
+    }
+    if len(stanzas) == 0 {
+        err = errors.New("no recipients or passphrase to encrypt")
+        return stanzas, err
+    }
+    return stanzas, err
+}
+
+// unwrapDataKey decrypts the data key with any of identities or the passphrase.
+func (keys *CryptKeys) unwrapDataKey(stanzas []*KeyStanza) ([]byte, error) {
+    var err error
+    for _, stanza := range stanzas {
+        switch stanza.Type {
+            case StanzaX25519:
+                for _, identity := range keys.Identities {
+                    dataKey, err := unwrapForIdentity(identity, stanza)
+                    if err == nil {
+                        return dataKey, err
+                    }
+                }
+            case StanzaScrypt:
+                if len(keys.Passphrase) == 0 {
+                    continue
+                }
+                dataKey, err := unwrapForPassphrase(keys.Passphrase, stanza)
+                if err == nil {
+                    return dataKey, err
+                }
+        }
+    }
+    err = errors.New("no identity or passphrase matches the archive")
+    return nil, err
+}
@@ -0,0 +1,312 @@
+/*
+ * Copyright 2022 Oleg Borodin  <borodin@unix7.org>
+ */
+
+package dspack
+
+import (
+    "bytes"
+    "compress/gzip"
+    "fmt"
+    "io"
+    "path/filepath"
+    "strings"
+
+    "github.com/klauspost/compress/zstd"
+)
+
+const CodecNone     string = "none"
+const CodecGzip     string = "gzip"
+const CodecZstd     string = "zstd"
+
+// Files smaller than minCompressSize are stored as is, larger ones
+// are stored as is if the probe of probeSize bytes is compressed
+// worse than to maxProbeRatio percents.
+const minCompressSize   int64 = 1024
+const probeSize         int64 = 1024 * 64
+const maxProbeRatio     int64 = 90
+
+// Compressed bins are written as frames of the data size and data
+// up to binFrameSize bytes, the frame of zero size ends the bin.
+// The stored size is not known before the bin is written, so the head
+// descr of the framed bin keeps the zero size.
+const binFrameSize      int64 = 1024 * 1024
+
+var compressedExts = map[string]bool{
+    ".gz":      true,
+    ".tgz":     true,
+    ".bz2":     true,
+    ".xz":      true,
+    ".txz":     true,
+    ".zst":     true,
+    ".lz4":     true,
+    ".lzma":    true,
+    ".zip":     true,
+    ".7z":      true,
+    ".rar":     true,
+    ".jpg":     true,
+    ".jpeg":    true,
+    ".png":     true,
+    ".gif":     true,
+    ".webp":    true,
+    ".mp3":     true,
+    ".mp4":     true,
+    ".mkv":     true,
+    ".avi":     true,
+    ".ogg":     true,
+    ".pdf":     true,
+}
+
+func ValidCodec(codec string) bool {
+    switch codec {
+        case CodecNone, CodecGzip, CodecZstd:
+            return true
+    }
+    return false
+}
+
+func newEncoder(codec string, writer io.Writer) (io.WriteCloser, error) {
+    var err error
+    var encoder io.WriteCloser
+    switch codec {
+        case CodecGzip:
+            encoder = gzip.NewWriter(writer)
+        case CodecZstd:
+            encoder, err = zstd.NewWriter(writer)
+        default:
+            err = fmt.Errorf("unknown codec %s", codec)
+    }
+    return encoder, err
+}
+
+func newDecoder(codec string, reader io.Reader) (io.ReadCloser, error) {
+    var err error
+    var decoder io.ReadCloser
+    switch codec {
+        case CodecGzip:
+            decoder, err = gzip.NewReader(reader)
+        case CodecZstd:
+            zstdDecoder, err := zstd.NewReader(reader, zstd.WithDecoderConcurrency(1))
+            if err != nil {
+                return decoder, err
+            }
+            decoder = zstdDecoder.IOReadCloser()
+        default:
+            err = fmt.Errorf("unknown codec %s", codec)
+    }
+    return decoder, err
+}
+
+// compressible probes the beginning of the file, the reader
+// position is restored to the file start.
+func compressible(codec, filePath string, size int64, reader io.ReadSeeker) (bool, error) {
+    var err error
+    if !compressCandidate(codec, filePath, size) {
+        return false, err
+    }
+    probe, err := readProbe(reader, size)
+    if err != nil {
+        return false, err
+    }
+    _, err = reader.Seek(0, io.SeekStart)
+    if err != nil {
+        return false, err
+    }
+    return compressProbe(codec, probe)
+}
+
+// compressCandidate reports whether the file of the size and
+// the name is worth to probe.
+func compressCandidate(codec, filePath string, size int64) bool {
+    if codec == CodecNone || size < minCompressSize {
+        return false
+    }
+    if compressedExts[strings.ToLower(filepath.Ext(filePath))] {
+        return false
+    }
+    return true
+}
+
+// readProbe reads the probe from the beginning of the data of the size.
+func readProbe(reader io.Reader, size int64) ([]byte, error) {
+    var err error
+    probeLen := probeSize
+    if size < probeLen {
+        probeLen = size
+    }
+    probe := make([]byte, probeLen)
+    readLen, err := io.ReadFull(reader, probe)
+    if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+        return probe, err
+    }
+    return probe[0:readLen], nil
+}
+
+// compressProbe reports whether the probe is compressed better
+// than to maxProbeRatio percents.
+func compressProbe(codec string, probe []byte) (bool, error) {
+    var err error
+    var buffer bytes.Buffer
+    encoder, err := newEncoder(codec, &buffer)
+    if err != nil {
+        return false, err
+    }
+    encoder.Write(probe)
+    err = encoder.Close()
+    if err != nil {
+        return false, err
+    }
+    if int64(buffer.Len()) * 100 > int64(len(probe)) * maxProbeRatio {
+        return false, err
+    }
+    return true, err
+}
+
+// frameWriter writes the data into the bin frames of the writer.
+type frameWriter struct {
+    writer  *Writer
+    buffer  []byte
+}
+
+func newFrameWriter(writer *Writer) *frameWriter {
+    return &frameWriter{
+        writer: writer,
+        buffer: make([]byte, 0, binFrameSize),
+    }
+}
+
+func (frames *frameWriter) Write(data []byte) (int, error) {
+    var err error
+    written := 0
+    for len(data) > 0 {
+        size := int(binFrameSize) - len(frames.buffer)
+        if size > len(data) {
+            size = len(data)
+        }
+        frames.buffer = append(frames.buffer, data[0:size]...)
+        data = data[size:]
+        written += size
+        if int64(len(frames.buffer)) == binFrameSize {
+            err = frames.flush()
+            if err != nil {
+                return written, err
+            }
+        }
+    }
+    return written, err
+}
+
+// Close writes the rest of the data and the closing frame.
+func (frames *frameWriter) Close() error {
+    var err error
+    err = frames.flush()
+    if err != nil {
+        return err
+    }
+    return frames.writer.write(encoderI64(0))
+}
+
+func (frames *frameWriter) flush() error {
+    var err error
+    if len(frames.buffer) == 0 {
+        return err
+    }
+    err = frames.writer.write(encoderI64(int64(len(frames.buffer))))
+    if err != nil {
+        return err
+    }
+    err = frames.writer.write(frames.buffer)
+    if err != nil {
+        return err
+    }
+    frames.buffer = frames.buffer[0:0]
+    return err
+}
+
+// frameReader reads the data of the bin frames up to the closing frame.
+type frameReader struct {
+    reader  io.Reader
+    rest    int64
+    done    bool
+}
+
+func newFrameReader(reader io.Reader) *frameReader {
+    return &frameReader{
+        reader: reader,
+    }
+}
+
+func (frames *frameReader) Read(data []byte) (int, error) {
+    var err error
+    for frames.rest == 0 {
+        if frames.done {
+            return 0, io.EOF
+        }
+        err = frames.next()
+        if err != nil {
+            return 0, err
+        }
+    }
+    if int64(len(data)) > frames.rest {
+        data = data[0:frames.rest]
+    }
+    read, err := frames.reader.Read(data)
+    frames.rest -= int64(read)
+    if err == io.EOF {
+        err = io.ErrUnexpectedEOF
+    }
+    return read, err
+}
+
+// next reads the frame size, the closing frame ends the bin.
+func (frames *frameReader) next() error {
+    var err error
+    sizeBin := make([]byte, sizeOfInt64)
+    _, err = io.ReadFull(frames.reader, sizeBin)
+    if err == io.EOF {
+        err = io.ErrUnexpectedEOF
+    }
+    if err != nil {
+        return err
+    }
+    size := decoderI64(sizeBin)
+    if size < 0 || size > binFrameSize {
+        err = fmt.Errorf("wrong bin frame size %d", size)
+        return err
+    }
+    if size == 0 {
+        frames.done = true
+    }
+    frames.rest = size
+    return err
+}
+
+// skip skips the rest of the frames seeking the seekable input
+// and reading other inputs.
+func (frames *frameReader) skip() error {
+    var err error
+    seeker, seekable := frames.reader.(io.Seeker)
+    for {
+        if frames.rest > 0 {
+            if seekable {
+                _, err = seeker.Seek(frames.rest, io.SeekCurrent)
+            } else {
+                _, err = io.CopyN(io.Discard, frames.reader, frames.rest)
+            }
+            if err == io.EOF {
+                err = io.ErrUnexpectedEOF
+            }
+            if err != nil {
+                return err
+            }
+            frames.rest = 0
+        }
+        if frames.done {
+            return err
+        }
+        err = frames.next()
+        if err != nil {
+            return err
+        }
+    }
+}
@@ -0,0 +1,468 @@
+/*
+ * Copyright 2022 Oleg Borodin  <borodin@unix7.org>
+ */
+
+package dspack
+
+import (
+    "bytes"
+    "crypto/cipher"
+    "crypto/rand"
+    "crypto/sha256"
+    "encoding/binary"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io"
+
+    "golang.org/x/crypto/chacha20poly1305"
+)
+
+// The encrypted archive is the crypt header, the crypt descr with
+// wrapped data keys and the plain archive stream split to chunks of
+// cryptChunkSize bytes. Each chunk is sealed with ChaCha20-Poly1305
+// by the data key, the nonce is the chunk number with the last chunk
+// flag, additional data is the hash of the crypt header and descr.
+
+const magicCodeG    int64   = 0xCE00ABBA
+const magicCodeH    int64   = 0xCE44ABBA
+
+const cryptHeaderSize   int64 = 8 * 4
+const cryptChunkSize    int64 = 1024 * 64
+const CipherChaCha      string = "chacha20poly1305"
+
+type CryptHeader struct {
+    MagicCodeG          int64   `json:"magicCodeG"`
+    CryptHeaderVersion  int64   `json:"cryptHeaderVersion"`
+    CryptDescrSize      int64   `json:"cryptDescrSize"`
+    MagicCodeH          int64   `json:"magicCodeH"`
+}
+
+func NewCryptHeader() *CryptHeader {
+    var header CryptHeader
+    header.MagicCodeG         = magicCodeG
+    header.CryptHeaderVersion = 1
+    header.MagicCodeH         = magicCodeH
+    return &header
+}
+
+func (header *CryptHeader) Pack() ([]byte, error) {
+    var err error
+    headerBin := make([]byte, 0, cryptHeaderSize)
+    headerBin = append(headerBin, encoderI64(header.MagicCodeG)...)
+    headerBin = append(headerBin, encoderI64(header.CryptHeaderVersion)...)
+    headerBin = append(headerBin, encoderI64(header.CryptDescrSize)...)
+    headerBin = append(headerBin, encoderI64(header.MagicCodeH)...)
+    return headerBin, err
+}
+
+func UnpackCryptHeader(headerBin []byte) (*CryptHeader, error) {
+    var err error
+    header := NewCryptHeader()
+    if int64(len(headerBin)) != cryptHeaderSize {
+        err = errors.New("wrong crypt header size")
+        return header, err
+    }
+    header.MagicCodeG         = decoderI64(headerBin[0:8])
+    header.CryptHeaderVersion = decoderI64(headerBin[8:16])
+    header.CryptDescrSize     = decoderI64(headerBin[16:24])
+    header.MagicCodeH         = decoderI64(headerBin[24:32])
+    if header.MagicCodeG != magicCodeG || header.MagicCodeH != magicCodeH {
+        err = errors.New("wrong crypt header magic code")
+        return header, err
+    }
+    return header, err
+}
+
+type CryptDescr struct {
+    Cipher      string          `json:"cipher"`
+    ChunkSize   int64           `json:"chunkSize"`
+    Stanzas     []*KeyStanza    `json:"stanzas"`
+}
+
+func NewCryptDescr() *CryptDescr {
+    var descr CryptDescr
+    descr.Cipher    = CipherChaCha
+    descr.ChunkSize = cryptChunkSize
+    descr.Stanzas   = make([]*KeyStanza, 0)
+    return &descr
+}
+
+func UnpackCryptDescr(descrBin []byte) (*CryptDescr, error) {
+    var err error
+    var descr CryptDescr
+    err = json.Unmarshal(descrBin, &descr)
+    return &descr, err
+}
+
+func (descr *CryptDescr) Pack() ([]byte, error) {
+    var err error
+    descrBin, err := json.Marshal(descr)
+    return descrBin, err
+}
+
+func chunkNonce(chunkId int64, last bool) []byte {
+    nonce := make([]byte, chacha20poly1305.NonceSize)
+    binary.BigEndian.PutUint64(nonce[3:11], uint64(chunkId))
+    if last {
+        nonce[11] = 1
+    }
+    return nonce
+}
+
+// EncryptWriter encrypts the archive stream, Close must be called
+// to write the last chunk.
+type EncryptWriter struct {
+    byteWriter  io.Writer
+    aead        cipher.AEAD
+    adata       []byte
+    chunkId     int64
+    buffer      []byte
+}
+
+func NewEncryptWriter(byteWriter io.Writer, keys *CryptKeys) (*EncryptWriter, error) {
+    var err error
+    var writer EncryptWriter
+    writer.byteWriter = byteWriter
+    writer.buffer     = make([]byte, 0, cryptChunkSize)
+
+    dataKey := make([]byte, dataKeySize)
+    _, err = rand.Read(dataKey)
+    if err != nil {
+        return &writer, err
+    }
+    descr := NewCryptDescr()
+    descr.Stanzas, err = keys.wrapDataKey(dataKey)
+    if err != nil {
+        return &writer, err
+    }
+    descrBin, err := descr.Pack()
+    if err != nil {
+        return &writer, err
+    }
+    header := NewCryptHeader()
+    header.CryptDescrSize = int64(len(descrBin))
+    headerBin, err := header.Pack()
+    if err != nil {
+        return &writer, err
+    }
+    writer.adata = cryptAData(headerBin, descrBin)
+    writer.aead, err = chacha20poly1305.New(dataKey)
+    if err != nil {
+        return &writer, err
+    }
+    _, err = byteWriter.Write(headerBin)
+    if err != nil {
+        return &writer, err
+    }
+    _, err = byteWriter.Write(descrBin)
+    if err != nil {
+        return &writer, err
+    }
+    return &writer, err
+}
+
+func cryptAData(headerBin, descrBin []byte) []byte {
+    hasher := sha256.New()
+    hasher.Write(headerBin)
+    hasher.Write(descrBin)
+    return hasher.Sum(nil)
+}
+
+func (writer *EncryptWriter) Write(data []byte) (int, error) {
+    var err error
+    var written int
+    for len(data) > 0 {
+        if int64(len(writer.buffer)) == cryptChunkSize {
+            err = writer.flush(false)
+            if err != nil {
+                return written, err
+            }
+        }
+        free := int(cryptChunkSize) - len(writer.buffer)
+        if free > len(data) {
+            free = len(data)
+        }
+        writer.buffer = append(writer.buffer, data[0:free]...)
+        data = data[free:]
+        written += free
+    }
+    return written, err
+}
+
+func (writer *EncryptWriter) flush(last bool) error {
+    var err error
+    nonce := chunkNonce(writer.chunkId, last)
+    chunk := writer.aead.Seal(nil, nonce, writer.buffer, writer.adata)
+    _, err = writer.byteWriter.Write(chunk)
+    if err != nil {
+        return err
+    }
+    writer.chunkId += 1
+    writer.buffer = writer.buffer[:0]
+    return err
+}
+
+func (writer *EncryptWriter) Close() error {
+    return writer.flush(true)
+}
+
+// DecryptReader decrypts the archive stream and verifies each chunk.
+// The reader is seekable over plain data if the source is seekable.
+type DecryptReader struct {
+    byteReader  io.Reader
+    seeker      io.Seeker
+    aead        cipher.AEAD
+    adata       []byte
+    dataStart   int64
+    nextChunk   int64
+    lastChunk   int64
+    chunkId     int64
+    chunk       []byte
+    pos         int64
+}
+
+// IsEncrypted reports whether the archive begins with the crypt header.
+func IsEncrypted(headBin []byte) bool {
+    if len(headBin) < sizeOfInt64 {
+        return false
+    }
+    return decoderI64(headBin[0:sizeOfInt64]) == magicCodeG
+}
+
+func NewDecryptReader(byteReader io.Reader, keys *CryptKeys) (*DecryptReader, error) {
+    var err error
+    var reader DecryptReader
+    reader.byteReader = byteReader
+    reader.lastChunk  = -1
+    reader.chunkId    = -1
+
+    seeker, ok := byteReader.(io.Seeker)
+    if ok {
+        startPos, seekErr := seeker.Seek(0, io.SeekCurrent)
+        if seekErr == nil {
+            reader.seeker = seeker
+            reader.dataStart = startPos
+        }
+    }
+
+    headerBin := make([]byte, cryptHeaderSize)
+    _, err = io.ReadFull(byteReader, headerBin)
+    if err != nil {
+        return &reader, err
+    }
+    header, err := UnpackCryptHeader(headerBin)
+    if err != nil {
+        err = fmt.Errorf("archive is not encrypted: %v", err)
+        return &reader, err
+    }
+    if header.CryptDescrSize <= 0 || header.CryptDescrSize > maxDescrSize {
+        err = fmt.Errorf("wrong crypt descr size %d", header.CryptDescrSize)
+        return &reader, err
+    }
+    descrBin := make([]byte, header.CryptDescrSize)
+    _, err = io.ReadFull(byteReader, descrBin)
+    if err != nil {
+        return &reader, err
+    }
+    descr, err := UnpackCryptDescr(descrBin)
+    if err != nil {
+        return &reader, err
+    }
+    if descr.Cipher != CipherChaCha || descr.ChunkSize != cryptChunkSize {
+        err = fmt.Errorf("unsupported cipher %s with chunk size %d", descr.Cipher, descr.ChunkSize)
+        return &reader, err
+    }
+    dataKey, err := keys.unwrapDataKey(descr.Stanzas)
+    if err != nil {
+        return &reader, err
+    }
+    reader.aead, err = chacha20poly1305.New(dataKey)
+    if err != nil {
+        return &reader, err
+    }
+    reader.adata = cryptAData(headerBin, descrBin)
+    reader.dataStart += cryptHeaderSize + header.CryptDescrSize
+    return &reader, err
+}
+
+// loadChunk reads and opens the chunk, the chunk is the last one
+// if it is opened with the last flag.
+func (reader *DecryptReader) loadChunk(chunkId int64) error {
+    var err error
+    sealedSize := cryptChunkSize + int64(chacha20poly1305.Overhead)
+    if chunkId != reader.nextChunk {
+        if reader.seeker == nil {
+            err = errors.New("encrypted source is not seekable")
+            return err
+        }
+        _, err = reader.seeker.Seek(reader.dataStart + chunkId * sealedSize, io.SeekStart)
+        if err != nil {
+            return err
+        }
+        reader.nextChunk = chunkId
+    }
+    sealed := make([]byte, sealedSize)
+    size, err := io.ReadFull(reader.byteReader, sealed)
+    if err == io.EOF || size < chacha20poly1305.Overhead {
+        err = errors.New("encrypted archive is truncated")
+        return err
+    }
+    if err != nil && err != io.ErrUnexpectedEOF {
+        return err
+    }
+    sealed = sealed[0:size]
+    reader.nextChunk += 1
+
+    chunk, err := reader.aead.Open(nil, chunkNonce(chunkId, false), sealed, reader.adata)
+    if err != nil {
+        chunk, err = reader.aead.Open(nil, chunkNonce(chunkId, true), sealed, reader.adata)
+        if err != nil {
+            err = &chunkAuthError{ chunkId: chunkId }
+            return err
+        }
+        reader.lastChunk = chunkId
+    } else if int64(size) < sealedSize {
+        err = errors.New("encrypted archive is truncated")
+        return err
+    }
+    reader.chunkId = chunkId
+    reader.chunk   = chunk
+    return err
+}
+
+// chunkAuthError is returned for the damaged or tampered
+// encrypted chunk.
+type chunkAuthError struct {
+    chunkId     int64
+}
+
+func (authErr *chunkAuthError) Error() string {
+    return fmt.Sprintf("encrypted chunk %d authentication failed", authErr.chunkId)
+}
+
+func (reader *DecryptReader) Read(data []byte) (int, error) {
+    var err error
+    var read int
+    for len(data) > 0 {
+        chunkId := reader.pos / cryptChunkSize
+        if reader.lastChunk >= 0 && chunkId > reader.lastChunk {
+            break
+        }
+        if chunkId != reader.chunkId {
+            err = reader.loadChunk(chunkId)
+            if err != nil {
+                return read, err
+            }
+        }
+        offset := reader.pos - chunkId * cryptChunkSize
+        if offset >= int64(len(reader.chunk)) {
+            if chunkId == reader.lastChunk {
+                break
+            }
+            err = errors.New("encrypted archive is truncated")
+            return read, err
+        }
+        size, _ := bytes.NewReader(reader.chunk[offset:]).Read(data)
+        data = data[size:]
+        read += size
+        reader.pos += int64(size)
+    }
+    if read == 0 && len(data) > 0 {
+        err = io.EOF
+    }
+    return read, err
+}
+
+// Seek moves over plain data of the seekable source.
+func (reader *DecryptReader) Seek(offset int64, whence int) (int64, error) {
+    var err error
+    if reader.seeker == nil {
+        err = errors.New("encrypted source is not seekable")
+        return reader.pos, err
+    }
+    var newPos int64
+    switch whence {
+        case io.SeekStart:
+            newPos = offset
+        case io.SeekCurrent:
+            newPos = reader.pos + offset
+        case io.SeekEnd:
+            plainSize, err := reader.plainSize()
+            if err != nil {
+                return reader.pos, err
+            }
+            newPos = plainSize + offset
+        default:
+            err = errors.New("wrong seek whence")
+            return reader.pos, err
+    }
+    if newPos < 0 {
+        err = errors.New("negative seek position")
+        return reader.pos, err
+    }
+    reader.pos = newPos
+    return reader.pos, err
+}
+
+// plainSize returns the plain size of the archive, the last chunk
+// must be opened with the last flag, so truncated archives fail.
+func (reader *DecryptReader) plainSize() (int64, error) {
+    var err error
+    var plainSize int64
+    chunkCount, _, err := reader.chunkCount()
+    if err != nil {
+        return plainSize, err
+    }
+    err = reader.loadChunk(chunkCount - 1)
+    if err != nil {
+        return plainSize, err
+    }
+    if reader.lastChunk != chunkCount - 1 {
+        err = errors.New("encrypted archive is truncated")
+        return plainSize, err
+    }
+    plainSize = (chunkCount - 1) * cryptChunkSize + int64(len(reader.chunk))
+    return plainSize, err
+}
+
+// estimateSize returns the plain size of the archive by the source
+// size without opening the last chunk, the size is used to salvage
+// damaged archives.
+func (reader *DecryptReader) estimateSize() (int64, error) {
+    var err error
+    var plainSize int64
+    chunkCount, dataSize, err := reader.chunkCount()
+    if err != nil {
+        return plainSize, err
+    }
+    sealedSize := cryptChunkSize + int64(chacha20poly1305.Overhead)
+    lastSize := dataSize - (chunkCount - 1) * sealedSize
+    plainSize = (chunkCount - 1) * cryptChunkSize + lastSize - int64(chacha20poly1305.Overhead)
+    return plainSize, err
+}
+
+// chunkCount returns the count of sealed chunks
+// and the size of sealed data of the source.
+func (reader *DecryptReader) chunkCount() (int64, int64, error) {
+    var err error
+    var chunkCount int64
+    endPos, err := reader.seeker.Seek(0, io.SeekEnd)
+    if err != nil {
+        return chunkCount, 0, err
+    }
+    reader.nextChunk = -1
+    sealedSize := cryptChunkSize + int64(chacha20poly1305.Overhead)
+    dataSize := endPos - reader.dataStart
+    chunkCount = (dataSize + sealedSize - 1) / sealedSize
+    if chunkCount == 0 {
+        err = errors.New("encrypted archive is truncated")
+        return chunkCount, dataSize, err
+    }
+    lastSize := dataSize - (chunkCount - 1) * sealedSize
+    if lastSize < int64(chacha20poly1305.Overhead) {
+        err = errors.New("encrypted archive is truncated")
+        return chunkCount, dataSize, err
+    }
+    return chunkCount, dataSize, err
+}
@@ -0,0 +1,307 @@
+/*
+ * Copyright 2022 Oleg Borodin  <borodin@unix7.org>
+ */
+
+package dspack
+
+import (
+    "bufio"
+    "errors"
+    "io/fs"
+    "os"
+    "path/filepath"
+    "strings"
+    "syscall"
+)
+
+const IgnoreFileName    string = ".fdumpignore"
+
+// PackReport counts packed and skipped entries. Skipped dirs are
+// counted once, their contents are not walked. Mount points
+// of other file systems are packed without contents and counted
+// as skipped.
+type PackReport struct {
+    Entries     int64       `json:"entries"`
+    Skipped     int64       `json:"skipped"`
+    Excluded    int64       `json:"excluded"`
+    OtherFS     int64       `json:"otherFS"`
+    TooLarge    int64       `json:"tooLarge"`
+}
+
+func NewPackReport() *PackReport {
+    var report PackReport
+    return &report
+}
+
+// excludeRule is the gitignore-style pattern relative
+// to the base dir of archive paths.
+type excludeRule struct {
+    base        string
+    segments    []string
+    negate      bool
+    dirOnly     bool
+    anchored    bool
+}
+
+// parseExcludeRule returns nil for empty and comment lines.
+func parseExcludeRule(base, line string) *excludeRule {
+    var rule excludeRule
+    line = strings.TrimRight(line, " \t\r")
+    if len(line) == 0 || strings.HasPrefix(line, "#") {
+        return nil
+    }
+    if strings.HasPrefix(line, "!") {
+        rule.negate = true
+        line = line[1:]
+    }
+    line = strings.TrimPrefix(line, "\\")
+    if strings.HasSuffix(line, "/") {
+        rule.dirOnly = true
+        line = strings.TrimRight(line, "/")
+    }
+    rule.anchored = strings.Contains(line, "/")
+    line = strings.TrimLeft(line, "/")
+    if len(line) == 0 {
+        return nil
+    }
+    rule.base = base
+    rule.segments = strings.Split(line, "/")
+    return &rule
+}
+
+// match reports whether the rule matches the archive path. Patterns
+// without slashes match the name at any depth, others are anchored
+// to the base dir.
+func (rule *excludeRule) match(entryPath string, isDir bool) bool {
+    if rule.dirOnly && !isDir {
+        return false
+    }
+    relPath := entryPath
+    if len(rule.base) > 0 {
+        if !strings.HasPrefix(entryPath, rule.base + "/") {
+            return false
+        }
+        relPath = entryPath[len(rule.base) + 1:]
+    }
+    if !rule.anchored {
+        match, _ := filepath.Match(rule.segments[0], filepath.Base(relPath))
+        return match
+    }
+    return matchSegments(rule.segments, strings.Split(relPath, "/"))
+}
+
+// matchSegments matches path names with glob segments, the ** segment
+// matches any number of names, the trailing one at least one name.
+func matchSegments(patterns, names []string) bool {
+    if len(patterns) == 0 {
+        return len(names) == 0
+    }
+    if patterns[0] == "**" {
+        if len(patterns) == 1 {
+            return len(names) > 0
+        }
+        for i := 0; i <= len(names); i++ {
+            if matchSegments(patterns[1:], names[i:]) {
+                return true
+            }
+        }
+        return false
+    }
+    if len(names) == 0 {
+        return false
+    }
+    match, _ := filepath.Match(patterns[0], names[0])
+    if !match {
+        return false
+    }
+    return matchSegments(patterns[1:], names[1:])
+}
+
+// ReadExcludeFile returns patterns of the file, one pattern per line.
+func ReadExcludeFile(filePath string) ([]string, error) {
+    var err error
+    patterns := make([]string, 0)
+    file, err := os.Open(filePath)
+    if err != nil {
+        return patterns, err
+    }
+    defer file.Close()
+    scanner := bufio.NewScanner(file)
+    for scanner.Scan() {
+        line := strings.TrimRight(scanner.Text(), " \t\r")
+        if len(line) == 0 || strings.HasPrefix(line, "#") {
+            continue
+        }
+        patterns = append(patterns, line)
+    }
+    err = scanner.Err()
+    if err != nil {
+        return patterns, err
+    }
+    return patterns, err
+}
+
+const (
+    filterPack  int = iota
+    filterSkip
+    filterEmpty
+)
+
+// packFilter selects entries for packing with exclude patterns,
+// ignore files of walked dirs, the file system and the file size.
+type packFilter struct {
+    options     *PackOptions
+    report      *PackReport
+    rules       []*excludeRule
+    dirRules    map[string][]*excludeRule
+    skipped     map[string]bool
+    rootDev     uint64
+    hasRoot     bool
+}
+
+func newPackFilter(options *PackOptions, report *PackReport) *packFilter {
+    var filter packFilter
+    filter.options  = options
+    filter.report   = report
+    filter.rules    = make([]*excludeRule, 0)
+    filter.dirRules = make(map[string][]*excludeRule)
+    filter.skipped  = make(map[string]bool)
+    for _, pattern := range options.Exclude {
+        rule := parseExcludeRule("", pattern)
+        if rule != nil {
+            filter.rules = append(filter.rules, rule)
+        }
+    }
+    return &filter
+}
+
+// setRoot keeps the file system of the source, sources
+// which cannot be read are skipped by walking.
+func (filter *packFilter) setRoot(filePath string) {
+    var sysStat syscall.Stat_t
+    filter.hasRoot = false
+    err := syscall.Lstat(filePath, &sysStat)
+    if err != nil {
+        return
+    }
+    filter.rootDev = uint64(sysStat.Dev)
+    filter.hasRoot = true
+}
+
+// loadIgnore reads the ignore file of the dir. The empty
+// ignore file excludes all contents of the dir.
+func (filter *packFilter) loadIgnore(filePath, entryPath string) error {
+    var err error
+    if len(filter.options.IgnoreFile) == 0 {
+        return err
+    }
+    ignorePath := filepath.Join(filePath, filter.options.IgnoreFile)
+    patterns, err := ReadExcludeFile(ignorePath)
+    if errors.Is(err, fs.ErrNotExist) {
+        return nil
+    }
+    if err != nil {
+        return err
+    }
+    if len(patterns) == 0 {
+        patterns = append(patterns, "*")
+    }
+    rules := make([]*excludeRule, 0, len(patterns))
+    for _, pattern := range patterns {
+        rule := parseExcludeRule(entryPath, pattern)
+        if rule != nil {
+            rules = append(rules, rule)
+        }
+    }
+    filter.dirRules[entryPath] = rules
+    return err
+}
+
+// excluded applies global rules and rules of ignore files from
+// the outer dir to the inner one, the last matching rule wins.
+func (filter *packFilter) excluded(entryPath string, isDir bool) bool {
+    excluded := false
+    apply := func(rules []*excludeRule) {
+        for _, rule := range rules {
+            if rule.match(entryPath, isDir) {
+                excluded = !rule.negate
+            }
+        }
+    }
+    apply(filter.rules)
+    if len(filter.dirRules) == 0 {
+        return excluded
+    }
+    dirs := parentDirs(entryPath)
+    for i := len(dirs) - 1; i >= 0; i-- {
+        apply(filter.dirRules[dirs[i]])
+    }
+    return excluded
+}
+
+// skippedParent reports whether a parent dir of the entry is skipped,
+// it happens if file lists are packed without recursion.
+func (filter *packFilter) skippedParent(entryPath string) bool {
+    if len(filter.skipped) == 0 {
+        return false
+    }
+    for _, dir := range parentDirs(entryPath) {
+        if filter.skipped[dir] {
+            return true
+        }
+    }
+    return false
+}
+
+// check returns the filter action for the entry and
+// counts skipped entries.
+func (filter *packFilter) check(filePath, entryPath string, fileMode fs.FileMode, sysStat *syscall.Stat_t) (int, error) {
+    var err error
+    isDir := fileMode & fs.ModeDir != 0
+    skip := func(counter *int64) (int, error) {
+        *counter += 1
+        filter.report.Skipped += 1
+        if isDir {
+            filter.skipped[entryPath] = true
+        }
+        return filterSkip, nil
+    }
+    if filter.skippedParent(entryPath) || filter.excluded(entryPath, isDir) {
+        return skip(&filter.report.Excluded)
+    }
+    if filter.options.MaxFileSize > 0 && fileMode.IsRegular() && sysStat.Size > filter.options.MaxFileSize {
+        return skip(&filter.report.TooLarge)
+    }
+    if filter.options.OneFileSystem && filter.hasRoot && uint64(sysStat.Dev) != filter.rootDev {
+        if !isDir {
+            return skip(&filter.report.OtherFS)
+        }
+        filter.report.OtherFS += 1
+        filter.report.Skipped += 1
+        filter.skipped[entryPath] = true
+        return filterEmpty, err
+    }
+    if isDir {
+        err = filter.loadIgnore(filePath, entryPath)
+        if err != nil {
+            return filterSkip, err
+        }
+    }
+    return filterPack, err
+}
+
+// parentDirs returns parent dirs of the archive path from the inner
+// one, the archive root is the empty path.
+func parentDirs(entryPath string) []string {
+    dirs := make([]string, 0)
+    for {
+        dir := filepath.Dir(entryPath)
+        if dir == "." || dir == "/" || dir == entryPath {
+            dirs = append(dirs, "")
+            break
+        }
+        dirs = append(dirs, dir)
+        entryPath = dir
+    }
+    return dirs
+}
@@ -0,0 +1,64 @@
+/*
+ * Copyright 2022 Oleg Borodin  <borodin@unix7.org>
+ */
+
+package dspack
+
+import (
+    "errors"
+    "bytes"
+)
+
+const magicCodeE    int64   = 0xFE00ABBA
+const magicCodeF    int64   = 0xFE44ABBA
+
+// Footer closes the archive and points to the index chunk.
+type Footer struct {
+    MagicCodeE      int64   `json:"magicCodeE"`
+    FooterVersion   int64   `json:"footerVersion"`
+    IndexOffset     int64   `json:"indexOffset"`
+    MagicCodeF      int64   `json:"magicCodeF"`
+}
+
+func NewFooter() *Footer {
+    var footer Footer
+    footer.MagicCodeE       = magicCodeE
+    footer.FooterVersion    = 1
+    footer.IndexOffset      = 0
+    footer.MagicCodeF       = magicCodeF
+    return &footer
+}
+
+func (footer *Footer) Pack() ([]byte, error) {
+    var err error
+
+    footerBytes := make([]byte, 0, footerSize)
+    footerBuffer := bytes.NewBuffer(footerBytes)
+
+    footerBuffer.Write(encoderI64(footer.MagicCodeE))
+    footerBuffer.Write(encoderI64(footer.FooterVersion))
+    footerBuffer.Write(encoderI64(footer.IndexOffset))
+    footerBuffer.Write(encoderI64(footer.MagicCodeF))
+
+    return footerBuffer.Bytes(), err
+}
+
+func UnpackFooter(footerBytes []byte) (*Footer, error) {
+    var err error
+    footer := NewFooter()
+
+    if int64(len(footerBytes)) != footerSize {
+        err = errors.New("wrong footer size")
+        return footer, err
+    }
+    footer.MagicCodeE    = decoderI64(footerBytes[0:8])
+    footer.FooterVersion = decoderI64(footerBytes[8:16])
+    footer.IndexOffset   = decoderI64(footerBytes[16:24])
+    footer.MagicCodeF    = decoderI64(footerBytes[24:32])
+
+    if footer.MagicCodeE != magicCodeE || footer.MagicCodeF != magicCodeF {
+        err = errors.New("wrong footer magic code")
+        return footer, err
+    }
+    return footer, err
+}
@@ -13,18 +13,32 @@ type HeadDescr struct {
     Path    string          `json:"path"`
     Mtime   int64           `json:"mtime"`
     Atime   int64           `json:"atime"`
-    Ctime   int64           `json:"atime"`
+    Ctime   int64           `json:"ctime"`
+    MtimeNsec   int64       `json:"mtimeNsec,omitempty"`
+    AtimeNsec   int64       `json:"atimeNsec,omitempty"`
+    CtimeNsec   int64       `json:"ctimeNsec,omitempty"`
     Size    int64           `json:"size"`
+    OrigSize    int64       `json:"origSize,omitempty"`
+    Codec       string      `json:"codec,omitempty"`
+    Framed      bool        `json:"framed,omitempty"`
+    FileSize    int64       `json:"fileSize,omitempty"`
+    Sparse      []SparseRegion  `json:"sparse,omitempty"`
     Mode    uint32          `json:"mode"`
     Type    int64           `json:"type"`
     SLink   string          `json:"sLink,omitempty"`
+    HLink   string          `json:"hLink,omitempty"`
+    Major   uint32          `json:"major,omitempty"`
+    Minor   uint32          `json:"minor,omitempty"`
     Match   bool            `json:"match"`
+    Status  string          `json:"status,omitempty"`
     Uid     uint32          `json:"uid"`
     Gid     uint32          `json:"gid"`
     User    string          `json:"user"`
     Group   string          `json:"group"`
     HType   string          `json:"hType"`
     HInit   []byte          `json:"hInit"`
+    Attrs   *AttrDescr      `json:"attrs,omitempty"`
+    Error   string          `json:"error,omitempty"`
 }
 
 func NewHeadDescr() *HeadDescr {
@@ -32,6 +46,12 @@ func NewHeadDescr() *HeadDescr {
     return &descr
 }
 
+// IsSparse reports whether the file entry keeps only data regions
+// of the sparse file of the file size.
+func (descr *HeadDescr) IsSparse() bool {
+    return descr.Type == DTypeFile && descr.FileSize > 0
+}
+
 func UnpackHeadDescr(descrBin []byte) (*HeadDescr, error) {
     var err error
     var descr HeadDescr
@@ -75,7 +75,7 @@ func UnpackHeader(headerBytes []byte) (*Header, error) {
 
     descrVersionBytes := make([]byte, sizeOfInt64)
     headerReader.Read(descrVersionBytes)
-    header.HeaderVersion = decoderI64(descrVersionBytes)
+    header.HeadDescrVersion = decoderI64(descrVersionBytes)
 
     descrSizeBytes := make([]byte, sizeOfInt64)
     headerReader.Read(descrSizeBytes)
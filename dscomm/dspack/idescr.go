@@ -0,0 +1,42 @@
+/*
+ * Copyright 2022 Oleg Borodin  <borodin@unix7.org>
+ */
+
+package dspack
+
+import (
+    "encoding/json"
+)
+
+type IndexItem struct {
+    Offset  int64           `json:"offset"`
+    Descr   *HeadDescr      `json:"descr"`
+}
+
+func NewIndexItem() *IndexItem {
+    var item IndexItem
+    return &item
+}
+
+type IndexDescr struct {
+    Items   []*IndexItem    `json:"items"`
+}
+
+func NewIndexDescr() *IndexDescr {
+    var descr IndexDescr
+    descr.Items = make([]*IndexItem, 0)
+    return &descr
+}
+
+func UnpackIndexDescr(descrBin []byte) (*IndexDescr, error) {
+    var err error
+    var descr IndexDescr
+    err = json.Unmarshal(descrBin, &descr)
+    return &descr, err
+}
+
+func (descr *IndexDescr) Pack() ([]byte, error) {
+    var err error
+    descrBin, err := json.Marshal(descr)
+    return descrBin, err
+}
@@ -0,0 +1,116 @@
+/*
+ * Copyright 2022 Oleg Borodin  <borodin@unix7.org>
+ */
+
+package dspack
+
+import (
+    "encoding/json"
+    "path/filepath"
+    "sort"
+    "strings"
+    "syscall"
+)
+
+// ManifestItem keeps the state of the packed path used to find
+// changed entries for incremental archives.
+type ManifestItem struct {
+    Path        string      `json:"path"`
+    Type        int64       `json:"type"`
+    Size        int64       `json:"size"`
+    Mtime       int64       `json:"mtime"`
+    MtimeNsec   int64       `json:"mtimeNsec,omitempty"`
+    Ctime       int64       `json:"ctime"`
+    CtimeNsec   int64       `json:"ctimeNsec,omitempty"`
+    Dev         uint64      `json:"dev"`
+    Ino         uint64      `json:"ino"`
+    HSum        []byte      `json:"hSum,omitempty"`
+}
+
+func newManifestItem(itemPath string, sysStat *syscall.Stat_t) *ManifestItem {
+    var item ManifestItem
+    statDescr := NewHeadDescr()
+    setStatTimes(statDescr, sysStat)
+    item.Path       = itemPath
+    item.Size       = sysStat.Size
+    item.Mtime      = statDescr.Mtime
+    item.MtimeNsec  = statDescr.MtimeNsec
+    item.Ctime      = statDescr.Ctime
+    item.CtimeNsec  = statDescr.CtimeNsec
+    item.Dev        = uint64(sysStat.Dev)
+    item.Ino        = uint64(sysStat.Ino)
+    return &item
+}
+
+// Same reports whether the item describes the same unchanged entry.
+func (item *ManifestItem) Same(other *ManifestItem) bool {
+    return item.Type == other.Type && item.Size == other.Size &&
+        item.Mtime == other.Mtime && item.MtimeNsec == other.MtimeNsec &&
+        item.Ctime == other.Ctime && item.CtimeNsec == other.CtimeNsec &&
+        item.Dev == other.Dev && item.Ino == other.Ino
+}
+
+// Manifest keeps items of all packed paths including unchanged
+// paths of incremental archives.
+type Manifest struct {
+    Items   map[string]*ManifestItem    `json:"items"`
+}
+
+func NewManifest() *Manifest {
+    var manifest Manifest
+    manifest.Items = make(map[string]*ManifestItem)
+    return &manifest
+}
+
+func UnpackManifest(manifestBin []byte) (*Manifest, error) {
+    var err error
+    manifest := NewManifest()
+    err = json.Unmarshal(manifestBin, manifest)
+    return manifest, err
+}
+
+func (manifest *Manifest) Pack() ([]byte, error) {
+    var err error
+    manifestBin, err := json.Marshal(manifest)
+    return manifestBin, err
+}
+
+// Unchanged reports whether the item is kept by the manifest
+// with the same state.
+func (manifest *Manifest) Unchanged(item *ManifestItem) bool {
+    prevItem, ok := manifest.Items[item.Path]
+    return ok && prevItem.Same(item)
+}
+
+// keep copies the item of the path and items under it to the current
+// manifest, so paths which cannot be read are not taken as deleted.
+func (manifest *Manifest) keep(current *Manifest, itemPath string) {
+    for prevPath, prevItem := range manifest.Items {
+        if prevPath != itemPath && !strings.HasPrefix(prevPath, itemPath + "/") {
+            continue
+        }
+        if current.Items[prevPath] == nil {
+            current.Items[prevPath] = prevItem
+        }
+    }
+}
+
+// deleted returns manifest paths under the dirs missing
+// in the current manifest.
+func (manifest *Manifest) deleted(current *Manifest, dirs []string) []string {
+    paths := make([]string, 0)
+    for itemPath := range manifest.Items {
+        if current.Items[itemPath] != nil {
+            continue
+        }
+        for _, dir := range dirs {
+            dir = strings.TrimLeft(filepath.Clean(dir), "/")
+            if dir == "" || dir == "." || itemPath == dir || strings.HasPrefix(itemPath, dir + "/") {
+                paths = append(paths, itemPath)
+                break
+            }
+        }
+    }
+    sort.Strings(paths)
+    return paths
+}
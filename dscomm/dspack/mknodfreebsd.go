@@ -0,0 +1,16 @@
+//go:build freebsd
+// +build freebsd
+
+/*
+ * Copyright 2022 Oleg Borodin  <borodin@unix7.org>
+ */
+
+package dspack
+
+import (
+    "golang.org/x/sys/unix"
+)
+
+func mknod(nodePath string, mode uint32, dev uint64) error {
+    return unix.Mknod(nodePath, mode, dev)
+}
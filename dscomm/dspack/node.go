@@ -0,0 +1,87 @@
+/*
+ * Copyright 2022 Oleg Borodin  <borodin@unix7.org>
+ */
+
+package dspack
+
+import (
+    "errors"
+    "fmt"
+    "io/fs"
+    "os"
+
+    "golang.org/x/sys/unix"
+)
+
+// IsNode reports whether the entry type is the device, fifo or socket.
+func IsNode(dType int64) bool {
+    switch dType {
+        case DTypeBlock, DTypeChar, DTypeFifo, DTypeSocket:
+            return true
+    }
+    return false
+}
+
+// entryType returns the entry type of the file mode.
+func entryType(fileMode fs.FileMode) int64 {
+    switch {
+        case fileMode & fs.ModeDir != 0:
+            return DTypeDir
+        case fileMode & fs.ModeSymlink != 0:
+            return DTypeSlink
+        case fileMode & (fs.ModeDevice|fs.ModeNamedPipe|fs.ModeSocket) != 0:
+            return nodeType(fileMode)
+    }
+    return DTypeFile
+}
+
+// nodeType returns the entry type of the device, fifo or socket mode.
+func nodeType(fileMode fs.FileMode) int64 {
+    switch {
+        case fileMode & fs.ModeCharDevice != 0:
+            return DTypeChar
+        case fileMode & fs.ModeDevice != 0:
+            return DTypeBlock
+        case fileMode & fs.ModeNamedPipe != 0:
+            return DTypeFifo
+    }
+    return DTypeSocket
+}
+
+// makeNode replaces the node path with the new device, fifo or socket.
+// Devices are created only by root.
+func makeNode(nodePath string, headDescr *HeadDescr) error {
+    var err error
+    var mode uint32
+    var dev uint64
+
+    switch headDescr.Type {
+        case DTypeBlock:
+            mode = unix.S_IFBLK
+            dev  = unix.Mkdev(headDescr.Major, headDescr.Minor)
+        case DTypeChar:
+            mode = unix.S_IFCHR
+            dev  = unix.Mkdev(headDescr.Major, headDescr.Minor)
+        case DTypeFifo:
+            mode = unix.S_IFIFO
+        case DTypeSocket:
+            mode = unix.S_IFSOCK
+        default:
+            err = fmt.Errorf("unknown node type %d", headDescr.Type)
+            return err
+    }
+    isDevice := headDescr.Type == DTypeBlock || headDescr.Type == DTypeChar
+    if isDevice && os.Getuid() != 0 {
+        err = errors.New("device requires root privileges")
+        return err
+    }
+    err = os.Remove(nodePath)
+    if err != nil && !errors.Is(err, fs.ErrNotExist) {
+        return err
+    }
+    err = mknod(nodePath, mode | 0600, dev)
+    if err != nil {
+        return err
+    }
+    return err
+}
@@ -5,8 +5,9 @@
 package dspack
 
 import (
-    "bytes"
     "encoding/json"
+    "errors"
+    "fmt"
     "io"
     "io/fs"
     "os"
@@ -18,37 +19,138 @@ import (
     "time"
     "sync"
     "context"
+
+    "golang.org/x/sys/unix"
 )
 
 
+// PackOptions sets the codec of file entries and keys
+// to encrypt the archive, nil keys disable encryption.
+// With the since manifest only changed entries, dirs and deletion
+// markers are packed. The manifest collects items of all packed
+// paths if set, paths which cannot be read keep their items
+// of the since manifest. With NoRecursion the dirs are packed without
+// their contents, as file lists of find are packed.
+// Entries matching gitignore-style exclude patterns or patterns
+// of ignore files in walked dirs are skipped, an empty ignore file
+// skips all contents of its dir. With OneFileSystem mount points are
+// packed without contents, files larger than MaxFileSize are skipped
+// if it is set. The report counts packed and skipped entries if set.
+type PackOptions struct {
+    Codec       string
+    Keys        *CryptKeys
+    Since       *Manifest
+    Manifest    *Manifest
+    NoRecursion bool
+
+    Exclude         []string
+    IgnoreFile      string
+    OneFileSystem   bool
+    MaxFileSize     int64
+    Report          *PackReport
+}
+
+func NewPackOptions() *PackOptions {
+    var options PackOptions
+    options.Codec = CodecNone
+    options.Exclude = make([]string, 0)
+    options.IgnoreFile = IgnoreFileName
+    return &options
+}
+
+// fileKey identifies the inode of hard linked files.
+type fileKey struct {
+    dev     uint64
+    ino     uint64
+}
+
 func Pack(dirs []string, outWriter io.Writer) error {
+    return PackWithOptions(dirs, outWriter, NewPackOptions())
+}
+
+func PackWithOptions(dirs []string, outWriter io.Writer, options *PackOptions) error {
     var err error
 
+    if options.Keys != nil {
+        encWriter, err := NewEncryptWriter(outWriter, options.Keys)
+        if err != nil {
+            return err
+        }
+        outWriter = encWriter
+    }
     writer := NewWriter(outWriter)
+    err = writer.SetCodec(options.Codec)
+    if err != nil {
+        return err
+    }
+
+    links := make(map[fileKey]string)
+
+    manifest := options.Manifest
+    if manifest == nil {
+        manifest = NewManifest()
+    }
+    report := options.Report
+    if report == nil {
+        report = NewPackReport()
+    }
+    filter := newPackFilter(options, report)
 
     packFunc := func(filePath string, fileInfo os.FileInfo, walkErr error) error {
         var err error
         if walkErr != nil {
+            if options.Since != nil && !errors.Is(walkErr, fs.ErrNotExist) {
+                itemPath := strings.TrimLeft(filepath.Clean(filePath), "/")
+                options.Since.keep(manifest, itemPath)
+            }
             return err
         }
         fileMode := fileInfo.Mode()
 
-        if fileMode & (fs.ModeDevice|fs.ModeCharDevice) != 0  {
-            return err
-        }
-        if fileMode & (fs.ModeNamedPipe|fs.ModeSocket|fs.ModeIrregular) != 0 {
+        if fileMode & fs.ModeIrregular != 0 {
             return err
         }
 
         filePath = filepath.Clean(filePath)
         if filePath == "." {
-            return err
+            return filter.loadIgnore(filePath, "")
         }
 
         headDescr := NewHeadDescr()
         headDescr.Path  = strings.TrimLeft(filePath, "/")
         headDescr.HInit = writer.hashInit
 
+        var itemStat syscall.Stat_t
+        err = syscall.Lstat(filePath, &itemStat)
+        if err != nil {
+            return err
+        }
+        action, err := filter.check(filePath, headDescr.Path, fileMode, &itemStat)
+        if err != nil {
+            return err
+        }
+        if action == filterSkip {
+            if fileMode & fs.ModeDir != 0 {
+                return filepath.SkipDir
+            }
+            return err
+        }
+        item := newManifestItem(headDescr.Path, &itemStat)
+        item.Type = entryType(fileMode)
+        manifest.Items[item.Path] = item
+        if options.Since != nil && item.Type != DTypeDir && options.Since.Unchanged(item) {
+            item.HSum = options.Since.Items[item.Path].HSum
+            return err
+        }
+        report.Entries += 1
+
+        attrs, err := readAttrs(filePath, fileMode)
+        if err != nil {
+            return err
+        }
+        if !attrs.Empty() {
+            headDescr.Attrs = attrs
+        }
 
         tailDescr := NewTailDescr()
 
@@ -57,10 +159,10 @@ func Pack(dirs []string, outWriter io.Writer) error {
 
                 var sysStat syscall.Stat_t
                 err = syscall.Stat(filePath, &sysStat)
-
-                headDescr.Mtime = sysStat.Mtimespec.Sec
-                headDescr.Atime = sysStat.Atimespec.Sec
-                headDescr.Ctime = sysStat.Ctimespec.Sec
+                if err != nil {
+                    return err
+                }
+                setStatTimes(headDescr, &sysStat)
 
                 headDescr.Uid = sysStat.Uid
                 headDescr.Gid = sysStat.Gid
@@ -92,6 +194,9 @@ func Pack(dirs []string, outWriter io.Writer) error {
                 if err != nil {
                     return err
                 }
+                if action == filterEmpty {
+                    return filepath.SkipDir
+                }
 
             case fileMode & fs.ModeSymlink != 0:
                 sLink, err := os.Readlink(filePath)
@@ -101,10 +206,10 @@ func Pack(dirs []string, outWriter io.Writer) error {
 
                 var sysStat syscall.Stat_t
                 err = syscall.Lstat(filePath, &sysStat)
-
-                headDescr.Mtime = sysStat.Mtimespec.Sec
-                headDescr.Atime = sysStat.Atimespec.Sec
-                headDescr.Ctime = sysStat.Ctimespec.Sec
+                if err != nil {
+                    return err
+                }
+                setStatTimes(headDescr, &sysStat)
 
                 headDescr.Uid = sysStat.Uid
                 headDescr.Gid = sysStat.Gid
@@ -139,20 +244,13 @@ func Pack(dirs []string, outWriter io.Writer) error {
                 if err != nil {
                     return err
                 }
-            default:
-
-                file, openErr := os.OpenFile(filePath, os.O_RDONLY, 0)
-                defer file.Close()
-                if openErr != nil {
+            case fileMode & (fs.ModeDevice|fs.ModeNamedPipe|fs.ModeSocket) != 0:
+                var sysStat syscall.Stat_t
+                err = syscall.Lstat(filePath, &sysStat)
+                if err != nil {
                     return err
                 }
-
-                var sysStat syscall.Stat_t
-                err = syscall.Stat(filePath, &sysStat)
-
-                headDescr.Mtime = sysStat.Mtimespec.Sec
-                headDescr.Atime = sysStat.Atimespec.Sec
-                headDescr.Ctime = sysStat.Ctimespec.Sec
+                setStatTimes(headDescr, &sysStat)
 
                 headDescr.Uid = sysStat.Uid
                 headDescr.Gid = sysStat.Gid
@@ -169,234 +267,241 @@ func Pack(dirs []string, outWriter io.Writer) error {
                     headDescr.Group = iGroup.Name
                 }
 
-                headDescr.Type  = DTypeFile
-                headDescr.Size  = sysStat.Size
+                headDescr.Type  = nodeType(fileMode)
+                headDescr.Size  = 0
                 headDescr.Mode  = uint32(fileMode)
+                if fileMode & fs.ModeDevice != 0 {
+                    headDescr.Major = unix.Major(uint64(sysStat.Rdev))
+                    headDescr.Minor = unix.Minor(uint64(sysStat.Rdev))
+                }
 
-                headDescr.HType = HashTypeHW
+                headDescr.HType = HashTypeNone
 
                 err = writer.WriteHeadDescr(headDescr)
                 if err != nil {
                     return err
                 }
-                _, err = writer.WriteBin(file, headDescr.Size)
-                if err != nil {
-                    return err
-                }
-
-                tailDescr.HSum = writer.hashSum
 
                 err = writer.WriteTailDescr(tailDescr)
                 if err != nil {
                     return err
                 }
-        }
-        return err
-    }
-
-    for _, dir := range dirs {
-        err = filepath.Walk(dir, packFunc)
-        if err != nil {
-            return err
-        }
-    }
-    return err
-}
-
-func Unpack(ioReader io.Reader, baseDir string) ([]*HeadDescr, error) {
-    var err error
-    descrs := make([]*HeadDescr, 0)
-
-    reader := NewReader(ioReader)
 
-    for {
-        headDescr, readerErr := reader.ReadHeadDescr()
-
-        if err == io.EOF {
-            return descrs, err
-        }
-        if err != nil {
-            return descrs, readerErr
-        }
-        if headDescr == nil {
-            return descrs, err
-        }
-
-        filePath := strings.TrimLeft(headDescr.Path, "/")
-        unpackPath := filepath.Join(baseDir, filePath)
-
-        file, err := os.OpenFile(unpackPath, os.O_RDONLY, 0)
-        if os.IsExist(err) {
-            //continue
-        }
-        file.Close()
-
-        switch headDescr.Type {
-            case DTypeFile:
-
-                dir := filepath.Dir(unpackPath)
-                os.MkdirAll(dir, 0700)
-
-                tmpPath := filepath.Join(unpackPath + ".tmp")
+            default:
 
-                file, err := os.OpenFile(tmpPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0640)
+                file, openErr := os.OpenFile(filePath, os.O_RDONLY, 0)
                 defer file.Close()
-                if err != nil {
-                    return descrs, err
-                }
-
-                _, readErr := reader.ReadBin(file, headDescr.Size)
-                if readErr == io.EOF {
-                    return descrs, err
-                }
-                if err != nil {
-                    return descrs, readErr
-                }
-
-                tailDescr, readErr := reader.ReadTailDescr()
-                if readErr == io.EOF {
-                    return descrs, err
-                }
-                if err != nil {
-                    return descrs, readErr
-                }
-                if tailDescr == nil {
-                    return descrs, err
-                }
-
-                if bytes.Compare(tailDescr.HSum, reader.hashSum) == 0 {
-                    headDescr.Match = true
-                }
-
-                mTime := time.Unix(headDescr.Mtime, 0)
-                aTime := time.Now()
-                err = os.Chtimes(tmpPath, mTime, aTime)
-                if err != nil {
-                    return descrs, err
-                }
-
-                if os.Getuid() == 0 {
-                    err = os.Chown(tmpPath, int(headDescr.Uid), int(headDescr.Gid))
-                    if err != nil {
-                        return descrs, err
+                if openErr != nil {
+                    delete(manifest.Items, item.Path)
+                    if options.Since != nil {
+                        options.Since.keep(manifest, item.Path)
                     }
+                    return err
                 }
 
-                err = os.Chmod(tmpPath, fs.FileMode(headDescr.Mode))
-                if err != nil {
-                    return descrs, err
-                }
-
-                err = os.Rename(tmpPath, unpackPath)
+                var sysStat syscall.Stat_t
+                err = syscall.Stat(filePath, &sysStat)
                 if err != nil {
-                    return descrs, err
+                    return err
                 }
+                setStatTimes(headDescr, &sysStat)
 
-            case DTypeSlink:
-                dir := filepath.Dir(unpackPath)
-                os.MkdirAll(dir, 0750)
+                headDescr.Uid = sysStat.Uid
+                headDescr.Gid = sysStat.Gid
 
-                err = os.Symlink(headDescr.SLink, unpackPath)
-                if err != nil {
-                    return descrs, err
-                }
+                uid := strconv.FormatUint(uint64(headDescr.Uid), 10)
+                gid := strconv.FormatUint(uint64(headDescr.Gid), 10)
 
-                tailDescr, readErr := reader.ReadTailDescr()
-                if readErr == io.EOF {
-                    return descrs, err
-                }
-                if err != nil {
-                    return descrs, readErr
+                iUser, err := user.LookupId(uid)
+                if err == nil && iUser != nil {
+                    headDescr.User = iUser.Username
                 }
-                if tailDescr == nil {
-                    return descrs, err
+                iGroup, _ := user.LookupGroupId(gid)
+                if err == nil && iGroup != nil {
+                    headDescr.Group = iGroup.Name
                 }
 
-                //err = syscall.Chmod(unpackPath, fs.FileMode(headDescr.Mode))
-                //if err != nil {
-                //    return descrs, err
-                //}
+                headDescr.Type  = DTypeFile
+                headDescr.Size  = sysStat.Size
+                headDescr.Mode  = uint32(fileMode)
 
-                if os.Getuid() == 0 {
-                    err = syscall.Lchown(unpackPath, int(headDescr.Uid), int(headDescr.Gid))
-                    if err != nil {
-                        return descrs, err
+                if uint64(sysStat.Nlink) > 1 {
+                    key := fileKey{ dev: uint64(sysStat.Dev), ino: uint64(sysStat.Ino) }
+                    firstPath, ok := links[key]
+                    if ok {
+                        headDescr.Type  = DTypeHlink
+                        headDescr.Size  = 0
+                        headDescr.HLink = firstPath
+                        headDescr.HType = HashTypeNone
+
+                        err = writer.WriteHeadDescr(headDescr)
+                        if err != nil {
+                            return err
+                        }
+                        err = writer.WriteTailDescr(tailDescr)
+                        if err != nil {
+                            return err
+                        }
+                        return err
                     }
+                    links[key] = headDescr.Path
                 }
 
-                //mTime := time.Unix(headDescr.Mtime, 0)
-                //aTime := time.Now()
-                //err = os.Chtimes(unpackPath, mTime, aTime)
-                //if err != nil {
-                //    return descrs, err
-                //}
-
-            case DTypeDir:
-                err = os.MkdirAll(unpackPath, 0750)
+                regions, sparse, err := sparseRegions(file, &sysStat)
                 if err != nil {
-                    return descrs, err
-                }
-
-                tailDescr, readErr := reader.ReadTailDescr()
-                if readErr == io.EOF {
-                    return descrs, err
-                }
-                if err != nil {
-                    return descrs, readErr
-                }
-                if tailDescr == nil {
-                    return descrs, err
+                    return err
                 }
-
-                //err = os.Chmod(unpackPath, fs.FileMode(headDescr.Mode))
-                //if err != nil {
-                //    return descrs, err
-                //}
-
-                if os.Getuid() == 0 {
-                    err = syscall.Chown(unpackPath, int(headDescr.Uid), int(headDescr.Gid))
+                if sparse {
+                    headDescr.FileSize = sysStat.Size
+                    headDescr.Sparse   = regions
+                    headDescr.Size     = sparseDataSize(regions)
+                    err = writer.WriteFile(headDescr, newRegionReader(file, regions))
                     if err != nil {
-                        return descrs, err
+                        return err
                     }
-                }
-                mTime := time.Unix(headDescr.Mtime, 0)
-                aTime := time.Now()
-                err = os.Chtimes(unpackPath, mTime, aTime)
-                if err != nil {
-                    return descrs, err
+                    item.HSum = writer.hashSum
+                    return err
                 }
 
-            default:
-                _, readErr := reader.ReadBin(io.Discard, headDescr.Size)
-                if readErr == io.EOF {
-                    return descrs, err
-                }
+                err = writer.WriteFile(headDescr, file)
                 if err != nil {
-                    return descrs, readErr
+                    return err
                 }
+                item.HSum = writer.hashSum
+        }
+        return err
+    }
 
-                tailDescr, readErr := reader.ReadTailDescr()
-                if readErr == io.EOF {
-                    return descrs, err
-                }
-                if err != nil {
-                    return descrs, readErr
-                }
-                if tailDescr == nil {
-                    return descrs, err
-                }
-                headDescr.Match = true
+    for i, dir := range dirs {
+        if options.NoRecursion {
+            if i == 0 {
+                filter.setRoot(dir)
+            }
+            fileInfo, statErr := os.Lstat(dir)
+            err = packFunc(dir, fileInfo, statErr)
+            if err == filepath.SkipDir {
+                err = nil
+            }
+            if err != nil {
+                return err
+            }
+            continue
+        }
+        filter.setRoot(dir)
+        err = filepath.Walk(dir, packFunc)
+        if err != nil {
+            return err
         }
-        descrs = append(descrs, headDescr)
     }
-    return descrs, err
+    if options.Since != nil {
+        for _, deletedPath := range options.Since.deleted(manifest, dirs) {
+            headDescr := NewHeadDescr()
+            headDescr.Path  = deletedPath
+            headDescr.Type  = DTypeDelete
+            headDescr.HType = HashTypeNone
+            err = writer.WriteHeadDescr(headDescr)
+            if err != nil {
+                return err
+            }
+            err = writer.WriteTailDescr(NewTailDescr())
+            if err != nil {
+                return err
+            }
+        }
+    }
+    err = writer.WriteIndex()
+    if err != nil {
+        return err
+    }
+    encWriter, ok := outWriter.(*EncryptWriter)
+    if ok {
+        err = encWriter.Close()
+        if err != nil {
+            return err
+        }
+    }
+    return err
 }
 
-func ListBG(ctx context.Context, wg *sync.WaitGroup, ioReader io.Reader, descrChan chan *HeadDescr, errChan chan error) {
+// ReadIndex reads the archive index with the footer at the end
+// of the seekable input. The archive begins at the input start.
+// The input position is restored on return.
+func ReadIndex(readSeeker io.ReadSeeker) ([]*IndexItem, error) {
     var err error
+    items := make([]*IndexItem, 0)
+
+    curPos, err := readSeeker.Seek(0, io.SeekCurrent)
+    if err != nil {
+        return items, err
+    }
+    defer readSeeker.Seek(curPos, io.SeekStart)
+
+    endPos, err := readSeeker.Seek(0, io.SeekEnd)
+    if err != nil {
+        return items, err
+    }
+    if endPos < footerSize {
+        err = errors.New("archive index not found")
+        return items, err
+    }
+    _, err = readSeeker.Seek(endPos - footerSize, io.SeekStart)
+    if err != nil {
+        return items, err
+    }
+    footerBin := make([]byte, footerSize)
+    _, err = io.ReadFull(readSeeker, footerBin)
+    if err != nil {
+        return items, err
+    }
+    footer, err := UnpackFooter(footerBin)
+    if err != nil {
+        err = fmt.Errorf("archive index not found: %v", err)
+        return items, err
+    }
+
+    _, err = readSeeker.Seek(footer.IndexOffset, io.SeekStart)
+    if err != nil {
+        return items, err
+    }
+    reader := NewReader(readSeeker)
+    headDescr, err := reader.ReadHeadDescr()
+    if err != nil {
+        return items, err
+    }
+    if headDescr.Type != DTypeIndex {
+        err = errors.New("wrong archive index type")
+        return items, err
+    }
+    indexDescr, err := reader.ReadIndexDescr(headDescr)
+    if err != nil {
+        return items, err
+    }
+    items = indexDescr.Items
+    return items, err
+}
+
+// listIndex returns descrs from the index of the seekable input.
+// Data of entries listed with the index are not verified.
+func listIndex(ioReader io.Reader) ([]*HeadDescr, bool) {
     descrs := make([]*HeadDescr, 0)
+    readSeeker, ok := ioReader.(io.ReadSeeker)
+    if !ok {
+        return descrs, false
+    }
+    items, err := ReadIndex(readSeeker)
+    if err != nil {
+        return descrs, false
+    }
+    for _, item := range items {
+        descrs = append(descrs, item.Descr)
+    }
+    return descrs, true
+}
 
-    reader := NewReader(ioReader)
+// ListBG sends descrs of the archive entries to the channel. The index
+// is used for seekable inputs, other inputs are read sequentially.
+func ListBG(ctx context.Context, wg *sync.WaitGroup, ioReader io.Reader, descrChan chan *HeadDescr, errChan chan error) {
+    var err error
 
     exitFunc := func() {
         errChan <- err
@@ -404,6 +509,20 @@ func ListBG(ctx context.Context, wg *sync.WaitGroup, ioReader io.Reader, descrCh
     }
     defer exitFunc()
 
+    descrs, ok := listIndex(ioReader)
+    if ok {
+        for _, headDescr := range descrs {
+            select {
+                case <-ctx.Done():
+                    return
+                case descrChan <- headDescr:
+            }
+        }
+        return
+    }
+
+    reader := NewReader(ioReader)
+
     for {
         select {
             case <-ctx.Done():
@@ -411,197 +530,116 @@ func ListBG(ctx context.Context, wg *sync.WaitGroup, ioReader io.Reader, descrCh
             default:
         }
 
-        headDescr, err := reader.ReadHeadDescr()
-        if err == io.EOF {
+        headDescr, readErr := reader.ReadHeadDescr()
+        if readErr == io.EOF {
+            err = errNoIndex
             return
         }
-        if err != nil {
+        if readErr != nil {
+            err = readErr
             return
         }
-        if headDescr == nil {
+        if headDescr.Type == DTypeIndex {
+            _, err = reader.ReadIndexDescr(headDescr)
             return
         }
-        reader.hashInit = headDescr.HInit
-
-        switch headDescr.Type {
-            case DTypeFile:
-
-                _, readErr := reader.ReadBin(io.Discard, headDescr.Size)
-                if readErr == io.EOF {
-                    return
-                }
-                if err != nil {
-                    return
-                }
-
-                tailDescr, readErr := reader.ReadTailDescr()
-                if readErr == io.EOF {
-                    return
-                }
-                if err != nil {
-                    return
-                }
-                if tailDescr == nil {
-                    return
-                }
-
-
-                if bytes.Compare(tailDescr.HSum, reader.hashSum) == 0 {
-                    headDescr.Match = true
-                }
-
-            case DTypeSlink, DTypeDir:
-
-                headDescr.Match = true
-
-                _, readErr := reader.ReadBin(io.Discard, headDescr.Size)
-                if readErr == io.EOF {
-                    return
-                }
-                if err != nil {
-                    return
-                }
-
-                tailDescr, readErr := reader.ReadTailDescr()
-                if readErr == io.EOF {
-                    return
-                }
-                if err != nil {
-                    return
-                }
-                if tailDescr == nil {
-                    return
-                }
-            default:
-
-                headDescr.Match = true
-
-                _, readErr := reader.ReadBin(io.Discard, headDescr.Size)
-                if readErr == io.EOF {
-                    return
-                }
-                if err != nil {
-                    return
-                }
-
-                tailDescr, readErr := reader.ReadTailDescr()
-                if readErr == io.EOF {
-                    return
-                }
-                if err != nil {
-                    return
-                }
-                if tailDescr == nil {
-                    return
-                }
-
+        err = skipEntry(reader, headDescr)
+        if err != nil {
+            return
         }
-        descrs = append(descrs, headDescr)
         descrChan <- headDescr
     }
     return
 }
 
-
-
+// List writes descrs of the archive entries as JSON. The index
+// is used for seekable inputs, other inputs are read sequentially.
 func List(ioReader io.Reader, outWriter io.Writer) ([]*HeadDescr, error) {
+    return ListSelected(ioReader, outWriter, NewUnpackOptions())
+}
+
+// ListSelected writes descrs of the archive entries selected
+// by the options as JSON.
+func ListSelected(ioReader io.Reader, outWriter io.Writer, options *UnpackOptions) ([]*HeadDescr, error) {
     var err error
+
+    if options.Keys != nil {
+        ioReader, err = NewDecryptReader(ioReader, options.Keys)
+        if err != nil {
+            return make([]*HeadDescr, 0), err
+        }
+    }
+
+    writeDescr := func(headDescr *HeadDescr) error {
+        headDescrJson, err := json.Marshal(headDescr)
+        if err != nil {
+            return err
+        }
+        _, err = outWriter.Write(headDescrJson)
+        return err
+    }
+
     descrs := make([]*HeadDescr, 0)
+    indexDescrs, ok := listIndex(ioReader)
+    if ok {
+        for _, headDescr := range indexDescrs {
+            if !options.Match(headDescr.Path) {
+                continue
+            }
+            descrs = append(descrs, headDescr)
+            err = writeDescr(headDescr)
+            if err != nil {
+                return descrs, err
+            }
+        }
+        return descrs, err
+    }
 
     reader := NewReader(ioReader)
 
     for {
-        headDescr, readerErr := reader.ReadHeadDescr()
+        headDescr, err := reader.ReadHeadDescr()
         if err == io.EOF {
-            return descrs, err
+            return descrs, errNoIndex
         }
         if err != nil {
-            return descrs, readerErr
+            return descrs, err
         }
-        if headDescr == nil {
+        if headDescr.Type == DTypeIndex {
+            _, err = reader.ReadIndexDescr(headDescr)
             return descrs, err
         }
-        reader.hashInit = headDescr.HInit
-
-        switch headDescr.Type {
-            case DTypeFile:
-
-                _, readErr := reader.ReadBin(io.Discard, headDescr.Size)
-                if readErr == io.EOF {
-                    return descrs, err
-                }
-                if err != nil {
-                    return descrs, readErr
-                }
-
-                tailDescr, readErr := reader.ReadTailDescr()
-                if readErr == io.EOF {
-                    return descrs, err
-                }
-                if err != nil {
-                    return descrs, readErr
-                }
-                if tailDescr == nil {
-                    return descrs, err
-                }
-
-
-                if bytes.Compare(tailDescr.HSum, reader.hashSum) == 0 {
-                    headDescr.Match = true
-                }
-
-            case DTypeSlink, DTypeDir:
-
-                headDescr.Match = true
-
-                _, readErr := reader.ReadBin(io.Discard, headDescr.Size)
-                if readErr == io.EOF {
-                    return descrs, err
-                }
-                if err != nil {
-                    return descrs, readErr
-                }
-
-                tailDescr, readErr := reader.ReadTailDescr()
-                if readErr == io.EOF {
-                    return descrs, err
-                }
-                if err != nil {
-                    return descrs, readErr
-                }
-                if tailDescr == nil {
-                    return descrs, err
-                }
-            default:
-
-                headDescr.Match = true
-
-                _, readErr := reader.ReadBin(io.Discard, headDescr.Size)
-                if readErr == io.EOF {
-                    return descrs, err
-                }
-                if err != nil {
-                    return descrs, readErr
-                }
-
-                tailDescr, readErr := reader.ReadTailDescr()
-                if readErr == io.EOF {
-                    return descrs, err
-                }
-                if err != nil {
-                    return descrs, readErr
-                }
-                if tailDescr == nil {
-                    return descrs, err
-                }
-
+        if !options.Match(headDescr.Path) {
+            err = reader.SkipData(headDescr)
+            if err != nil {
+                return descrs, err
+            }
+            _, err = reader.ReadTailDescr()
+            if err != nil {
+                return descrs, err
+            }
+            continue
+        }
+        err = skipEntry(reader, headDescr)
+        if err != nil {
+            return descrs, err
         }
         descrs = append(descrs, headDescr)
-        headDescrJson, err := json.Marshal(headDescr)
+        err = writeDescr(headDescr)
         if err != nil {
             return descrs, err
         }
-        outWriter.Write(headDescrJson)
     }
     return descrs, err
 }
+
+// descrTimes returns archived modification and access times,
+// the access time is current if it was not archived.
+func descrTimes(headDescr *HeadDescr) (time.Time, time.Time) {
+    mTime := time.Unix(headDescr.Mtime, headDescr.MtimeNsec)
+    aTime := time.Now()
+    if headDescr.Atime != 0 {
+        aTime = time.Unix(headDescr.Atime, headDescr.AtimeNsec)
+    }
+    return mTime, aTime
+}
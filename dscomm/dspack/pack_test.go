@@ -5,12 +5,23 @@
 package dspack
 
 import(
+    "archive/tar"
+    "bytes"
     "context"
+    "crypto/rand"
+    "fmt"
+    "io"
+    "io/fs"
+    "net"
     "path/filepath"
     "testing"
     "os"
+    "strings"
     "sync"
+    "syscall"
+    "time"
     "github.com/stretchr/testify/require"
+    "golang.org/x/crypto/chacha20poly1305"
 )
 
 
@@ -34,7 +45,8 @@ func TestPacker01(t *testing.T) {
     require.NoError(t, err)
 
     var wg sync.WaitGroup
-    ctx, _ := context.WithCancel(context.Background())
+    ctx, cancel := context.WithCancel(context.Background())
+    defer cancel()
     descrChan := make(chan *HeadDescr, 1000)
     errChan := make(chan error, 10)
 
@@ -58,8 +70,1557 @@ func TestPacker01(t *testing.T) {
     defer packFile.Close()
     require.NoError(t, err)
 
-    _, err = Unpack(packFile, "./xxx")
+    _, err = Unpack(packFile, filepath.Join(baseDir, "xxx"))
     require.NoError(t, err)
+}
+
+func TestPackRoundTrip(t *testing.T) {
+    var err error
+
+    srcDir := t.TempDir()
+    destDir := t.TempDir()
+
+    subDir := filepath.Join(srcDir, "sub")
+    err = os.Mkdir(subDir, 0750)
+    require.NoError(t, err)
+
+    data := []byte("round trip data")
+    filePath := filepath.Join(subDir, "file.txt")
+    err = os.WriteFile(filePath, data, 0640)
+    require.NoError(t, err)
+
+    mTime := time.Unix(1600000000, 123456789)
+    aTime := time.Unix(1600000100, 987654321)
+    err = os.Chtimes(filePath, aTime, mTime)
+    require.NoError(t, err)
+
+    linkPath := filepath.Join(subDir, "file.link")
+    err = os.Symlink("file.txt", linkPath)
+    require.NoError(t, err)
+
+    packPath := filepath.Join(destDir, "test.pack")
+    packFile, err := os.OpenFile(packPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+    require.NoError(t, err)
+    err = Pack([]string{ srcDir }, packFile)
+    require.NoError(t, err)
+    packFile.Close()
+
+    packFile, err = os.OpenFile(packPath, os.O_RDONLY, 0)
+    require.NoError(t, err)
+    defer packFile.Close()
+
+    unpackDir := filepath.Join(destDir, "unpack")
+    descrs, err := Unpack(packFile, unpackDir)
+    require.NoError(t, err)
+    require.Equal(t, 4, len(descrs))
+
+    for _, descr := range descrs {
+        if descr.Type != DTypeFile {
+            continue
+        }
+        require.Equal(t, mTime.Unix(), descr.Mtime)
+        require.Equal(t, int64(mTime.Nanosecond()), descr.MtimeNsec)
+        require.Equal(t, aTime.Unix(), descr.Atime)
+        require.Equal(t, int64(aTime.Nanosecond()), descr.AtimeNsec)
+        require.NotEqual(t, int64(0), descr.Ctime)
+    }
+
+    unpackPath := filepath.Join(unpackDir, filePath)
+    unpackData, err := os.ReadFile(unpackPath)
+    require.NoError(t, err)
+    require.Equal(t, data, unpackData)
+
+    fileInfo, err := os.Stat(unpackPath)
+    require.NoError(t, err)
+    require.Equal(t, fs.FileMode(0640), fileInfo.Mode().Perm())
+    require.True(t, fileInfo.ModTime().Equal(mTime))
+
+    sLink, err := os.Readlink(filepath.Join(unpackDir, linkPath))
+    require.NoError(t, err)
+    require.Equal(t, "file.txt", sLink)
+}
+
+func TestPackIndex(t *testing.T) {
+    var err error
+
+    srcDir := t.TempDir()
+    destDir := t.TempDir()
+
+    fileCount := 10
+    for i := 0; i < fileCount; i++ {
+        data := []byte(fmt.Sprintf("data of file %d", i))
+        err = os.WriteFile(filepath.Join(srcDir, fmt.Sprintf("file%d.txt", i)), data, 0640)
+        require.NoError(t, err)
+    }
+
+    packPath := filepath.Join(destDir, "test.pack")
+    packFile, err := os.OpenFile(packPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+    require.NoError(t, err)
+    err = Pack([]string{ srcDir }, packFile)
+    require.NoError(t, err)
+    packFile.Close()
+
+    packFile, err = os.OpenFile(packPath, os.O_RDONLY, 0)
+    require.NoError(t, err)
+    defer packFile.Close()
+
+    items, err := ReadIndex(packFile)
+    require.NoError(t, err)
+    require.Equal(t, fileCount + 1, len(items))
+
+    indexDescrs, err := List(packFile, io.Discard)
+    require.NoError(t, err)
+    require.Equal(t, fileCount + 1, len(indexDescrs))
+
+    streamReader := struct{ io.Reader }{ packFile }
+    streamDescrs, err := List(streamReader, io.Discard)
+    require.NoError(t, err)
+    require.Equal(t, len(indexDescrs), len(streamDescrs))
+    for i := range streamDescrs {
+        require.Equal(t, indexDescrs[i].Path, streamDescrs[i].Path)
+    }
+
+    _, err = packFile.Seek(0, io.SeekStart)
+    require.NoError(t, err)
+    descrs, err := Unpack(streamReader, filepath.Join(destDir, "all"))
+    require.NoError(t, err)
+    require.Equal(t, fileCount + 1, len(descrs))
+
+    filePath := filepath.Join(srcDir, "file7.txt")
+    unpackDir := filepath.Join(destDir, "one")
+    headDescr, err := UnpackFile(packFile, filePath, unpackDir)
+    require.NoError(t, err)
+    require.Equal(t, DTypeFile, headDescr.Type)
+
+    data, err := os.ReadFile(filepath.Join(unpackDir, filePath))
+    require.NoError(t, err)
+    require.Equal(t, []byte("data of file 7"), data)
+
+    entries, err := os.ReadDir(filepath.Join(unpackDir, srcDir))
+    require.NoError(t, err)
+    require.Equal(t, 1, len(entries))
+
+    _, err = UnpackFile(packFile, filepath.Join(srcDir, "missing.txt"), unpackDir)
+    require.Error(t, err)
+}
+
+func TestUnpackSelected(t *testing.T) {
+    var err error
+
+    srcDir := t.TempDir()
+    destDir := t.TempDir()
+
+    sshDir := filepath.Join(srcDir, "etc", "ssh")
+    err = os.MkdirAll(sshDir, 0750)
+    require.NoError(t, err)
+    err = os.MkdirAll(filepath.Join(srcDir, "var", "log"), 0750)
+    require.NoError(t, err)
+
+    configPath := filepath.Join(sshDir, "sshd_config")
+    err = os.WriteFile(configPath, []byte("PermitRootLogin no"), 0600)
+    require.NoError(t, err)
+    err = os.WriteFile(filepath.Join(srcDir, "etc", "hosts"), []byte("127.0.0.1 localhost"), 0644)
+    require.NoError(t, err)
+    err = os.WriteFile(filepath.Join(srcDir, "var", "log", "messages"), []byte("log"), 0644)
+    require.NoError(t, err)
+
+    err = os.Chmod(sshDir, 0700)
+    require.NoError(t, err)
+    dirTime := time.Unix(1500000000, 500)
+    err = os.Chtimes(sshDir, dirTime, dirTime)
+    require.NoError(t, err)
+
+    packPath := filepath.Join(destDir, "test.pack")
+    packFile, err := os.OpenFile(packPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+    require.NoError(t, err)
+    err = Pack([]string{ srcDir }, packFile)
+    require.NoError(t, err)
+    packFile.Close()
+
+    packFile, err = os.OpenFile(packPath, os.O_RDONLY, 0)
+    require.NoError(t, err)
+    defer packFile.Close()
+
+    options := NewUnpackOptions()
+    options.Include = []string{ configPath }
+
+    inputs := map[string]io.Reader{
+        "seek":     packFile,
+        "stream":   struct{ io.Reader }{ packFile },
+    }
+    for name, input := range inputs {
+        _, err = packFile.Seek(0, io.SeekStart)
+        require.NoError(t, err)
+
+        unpackDir := filepath.Join(destDir, name)
+        descrs, err := UnpackSelected(input, unpackDir, options)
+        require.NoError(t, err)
+        require.Equal(t, 1, len(descrs))
+
+        data, err := os.ReadFile(filepath.Join(unpackDir, configPath))
+        require.NoError(t, err)
+        require.Equal(t, []byte("PermitRootLogin no"), data)
+
+        _, err = os.Stat(filepath.Join(unpackDir, srcDir, "etc", "hosts"))
+        require.True(t, os.IsNotExist(err))
+        _, err = os.Stat(filepath.Join(unpackDir, srcDir, "var"))
+        require.True(t, os.IsNotExist(err))
+
+        dirInfo, err := os.Stat(filepath.Join(unpackDir, sshDir))
+        require.NoError(t, err)
+        require.Equal(t, fs.FileMode(0700), dirInfo.Mode().Perm())
+        require.True(t, dirInfo.ModTime().Equal(dirTime))
+    }
+
+    options = NewUnpackOptions()
+    options.Include = []string{ filepath.Join(srcDir, "etc", "*") }
+    options.Exclude = []string{ filepath.Join(srcDir, "etc", "hosts") }
+
+    _, err = packFile.Seek(0, io.SeekStart)
+    require.NoError(t, err)
+    descrs, err := ListSelected(struct{ io.Reader }{ packFile }, io.Discard, options)
+    require.NoError(t, err)
+    require.Equal(t, 2, len(descrs))
+    require.Equal(t, strings.TrimLeft(configPath, "/"), descrs[1].Path)
+
+    descrs, err = ListSelected(packFile, io.Discard, options)
+    require.NoError(t, err)
+    require.Equal(t, 2, len(descrs))
+}
+
+func TestPackCompress(t *testing.T) {
+    var err error
+
+    srcDir := t.TempDir()
+
+    textData := bytes.Repeat([]byte("INSERT INTO log VALUES (1, 'message');\n"), 4096)
+    randData := make([]byte, 1024 * 128)
+    rand.Read(randData)
+    files := map[string][]byte{
+        "dump.sql":     textData,
+        "random.bin":   randData,
+        "tiny.txt":     []byte("tiny"),
+        "text.gz":      textData,
+    }
+    for name, data := range files {
+        err = os.WriteFile(filepath.Join(srcDir, name), data, 0644)
+        require.NoError(t, err)
+    }
+
+    for _, codec := range []string{ CodecZstd, CodecGzip, CodecNone } {
+        destDir := t.TempDir()
+        packPath := filepath.Join(destDir, "test.pack")
+        packFile, err := os.OpenFile(packPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+        require.NoError(t, err)
+
+        options := NewPackOptions()
+        options.Codec = codec
+        err = PackWithOptions([]string{ srcDir }, packFile, options)
+        require.NoError(t, err)
+        packFile.Close()
+
+        packFile, err = os.OpenFile(packPath, os.O_RDONLY, 0)
+        require.NoError(t, err)
+        defer packFile.Close()
+
+        descrs, err := List(struct{ io.Reader }{ packFile }, io.Discard)
+        require.NoError(t, err)
+        require.Equal(t, len(files) + 1, len(descrs))
+        for _, descr := range descrs {
+            require.True(t, descr.Match)
+            if descr.Type != DTypeFile {
+                continue
+            }
+            name := filepath.Base(descr.Path)
+            switch {
+                case name == "dump.sql" && codec != CodecNone:
+                    require.Equal(t, codec, descr.Codec)
+                    require.Equal(t, int64(len(textData)), descr.OrigSize)
+                    require.True(t, descr.Framed)
+                default:
+                    require.Equal(t, CodecNone, descr.Codec)
+                    require.Equal(t, int64(len(files[name])), descr.Size)
+            }
+        }
+
+        _, err = packFile.Seek(0, io.SeekStart)
+        require.NoError(t, err)
+        unpackDir := filepath.Join(destDir, "unpack")
+        descrs, err = Unpack(packFile, unpackDir)
+        require.NoError(t, err)
+        for _, descr := range descrs {
+            require.True(t, descr.Match)
+        }
+        for name, data := range files {
+            unpackData, err := os.ReadFile(filepath.Join(unpackDir, srcDir, name))
+            require.NoError(t, err)
+            require.Equal(t, data, unpackData)
+        }
+    }
+}
+
+func TestPackCompressLarge(t *testing.T) {
+    var err error
+
+    srcDir := t.TempDir()
+    destDir := t.TempDir()
+
+    randData := make([]byte, 1024 * 1024 * 2)
+    rand.Read(randData)
+    largeData := []byte(fmt.Sprintf("%x", randData))
+    largePath := filepath.Join(srcDir, "large.sql")
+    err = os.WriteFile(largePath, largeData, 0644)
+    require.NoError(t, err)
+    line := []byte("INSERT INTO log VALUES (1, 'message');\n")
+    smallData := bytes.Repeat(line, 1024)
+    err = os.WriteFile(filepath.Join(srcDir, "small.sql"), smallData, 0644)
+    require.NoError(t, err)
+
+    options := NewPackOptions()
+    options.Codec = CodecZstd
+    packPath := filepath.Join(destDir, "test.pack")
+    packFile, err := os.Create(packPath)
+    require.NoError(t, err)
+    defer packFile.Close()
+    err = PackWithOptions([]string{ srcDir }, packFile, options)
+    require.NoError(t, err)
+
+    packInfo, err := packFile.Stat()
+    require.NoError(t, err)
+    require.Less(t, packInfo.Size(), int64(len(largeData)) * 3 / 4)
+
+    _, err = packFile.Seek(0, io.SeekStart)
+    require.NoError(t, err)
+    descrs, err := List(struct{ io.Reader }{ packFile }, io.Discard)
+    require.NoError(t, err)
+    require.Equal(t, 3, len(descrs))
+    for _, descr := range descrs {
+        require.True(t, descr.Match)
+        switch filepath.Base(descr.Path) {
+            case "large.sql":
+                require.Equal(t, CodecZstd, descr.Codec)
+                require.True(t, descr.Framed)
+                require.Equal(t, int64(len(largeData)), descr.OrigSize)
+            case "small.sql":
+                require.Equal(t, CodecZstd, descr.Codec)
+        }
+    }
+
+    _, err = packFile.Seek(0, io.SeekStart)
+    require.NoError(t, err)
+    unpackDir := filepath.Join(destDir, "unpack")
+    descrs, err = Unpack(packFile, unpackDir)
+    require.NoError(t, err)
+    unpackData, err := os.ReadFile(filepath.Join(unpackDir, largePath))
+    require.NoError(t, err)
+    require.Equal(t, largeData, unpackData)
+
+    selectOptions := NewUnpackOptions()
+    selectOptions.Include = []string{ filepath.Join(srcDir, "small.sql") }
+    _, err = packFile.Seek(0, io.SeekStart)
+    require.NoError(t, err)
+    descrs, err = ListSelected(struct{ io.Reader }{ packFile }, io.Discard, selectOptions)
+    require.NoError(t, err)
+    require.Equal(t, 1, len(descrs))
+
+    _, err = packFile.Seek(0, io.SeekStart)
+    require.NoError(t, err)
+    salvageDir := filepath.Join(destDir, "salvage")
+    _, damaged, err := Salvage(packFile, salvageDir, NewUnpackOptions())
+    require.NoError(t, err)
+    require.Equal(t, 0, len(damaged))
+    unpackData, err = os.ReadFile(filepath.Join(salvageDir, largePath))
+    require.NoError(t, err)
+    require.Equal(t, largeData, unpackData)
+
+    packBin, err := os.ReadFile(packPath)
+    require.NoError(t, err)
+    report, err := Verify(bytes.NewReader(packBin), nil)
+    require.NoError(t, err)
+    require.True(t, report.Ok())
+    items, err := ReadIndex(bytes.NewReader(packBin))
+    require.NoError(t, err)
+    require.Equal(t, 3, len(items))
+    packBin[items[1].Offset + binFrameSize + 1024] ^= 0xFF
+    report, err = Verify(bytes.NewReader(packBin), nil)
+    require.NoError(t, err)
+    require.Equal(t, 1, len(report.Problems))
+    require.Equal(t, items[1].Offset, report.Problems[0].Offset)
+    require.Equal(t, int64(3), report.Entries)
+    require.Equal(t, int64(2), report.Files)
+
+    tarFile, err := os.Create(filepath.Join(destDir, "test.tar"))
+    require.NoError(t, err)
+    defer tarFile.Close()
+    _, err = packFile.Seek(0, io.SeekStart)
+    require.NoError(t, err)
+    _, err = ExportTar(packFile, tarFile, nil)
+    require.NoError(t, err)
+    _, err = tarFile.Seek(0, io.SeekStart)
+    require.NoError(t, err)
+    var importBuffer bytes.Buffer
+    descrs, err = ImportTar(tarFile, &importBuffer, options)
+    require.NoError(t, err)
+    require.Equal(t, 3, len(descrs))
+    for _, descr := range descrs {
+        switch filepath.Base(descr.Path) {
+            case "large.sql", "small.sql":
+                require.Equal(t, CodecZstd, descr.Codec)
+        }
+    }
+    importDir := filepath.Join(destDir, "import")
+    descrs, err = Unpack(&importBuffer, importDir)
+    require.NoError(t, err)
+    for _, descr := range descrs {
+        require.True(t, descr.Match)
+    }
+    unpackData, err = os.ReadFile(filepath.Join(importDir, largePath))
+    require.NoError(t, err)
+    require.Equal(t, largeData, unpackData)
+}
+
+func TestPackHashMismatch(t *testing.T) {
+    var err error
+
+    srcDir := t.TempDir()
+    destDir := t.TempDir()
+
+    data := bytes.Repeat([]byte{ 0xAB }, 1024)
+    err = os.WriteFile(filepath.Join(srcDir, "data.bin"), data, 0644)
+    require.NoError(t, err)
+
+    var packBuffer bytes.Buffer
+    err = Pack([]string{ srcDir }, &packBuffer)
+    require.NoError(t, err)
+
+    packBin := packBuffer.Bytes()
+    pos := bytes.Index(packBin, data)
+    require.True(t, pos > 0)
+    packBin[pos + 100] ^= 0xFF
+
+    descrs, err := List(bytes.NewBuffer(packBin), io.Discard)
+    require.NoError(t, err)
+    require.Equal(t, 2, len(descrs))
+    require.False(t, descrs[1].Match)
+
+    descrs, err = Unpack(bytes.NewBuffer(packBin), destDir)
+    require.NoError(t, err)
+    require.False(t, descrs[1].Match)
+}
+
+func TestPackEncrypt(t *testing.T) {
+    var err error
+
+    srcDir := t.TempDir()
+    destDir := t.TempDir()
+
+    randData := make([]byte, 1024 * 200)
+    rand.Read(randData)
+    err = os.WriteFile(filepath.Join(srcDir, "random.bin"), randData, 0644)
+    require.NoError(t, err)
+    err = os.WriteFile(filepath.Join(srcDir, "secret.txt"), []byte("secret"), 0600)
+    require.NoError(t, err)
+
+    pubKey1, privKey1, err := GenerateKey()
+    require.NoError(t, err)
+    pubKey2, privKey2, err := GenerateKey()
+    require.NoError(t, err)
+    _, privKey3, err := GenerateKey()
+    require.NoError(t, err)
+
+    pubKey, err := PublicKey(privKey1)
+    require.NoError(t, err)
+    require.Equal(t, pubKey1, pubKey)
+
+    packOptions := NewPackOptions()
+    packOptions.Codec = CodecZstd
+    packOptions.Keys = NewCryptKeys()
+    packOptions.Keys.Recipients = []string{ pubKey1, pubKey2 }
+    packOptions.Keys.Passphrase = "pass phrase"
+
+    var packBuffer bytes.Buffer
+    err = PackWithOptions([]string{ srcDir }, &packBuffer, packOptions)
+    require.NoError(t, err)
+    packBin := packBuffer.Bytes()
+    require.False(t, bytes.Contains(packBin, []byte("secret.txt")))
+
+    packPath := filepath.Join(destDir, "test.pack")
+    err = os.WriteFile(packPath, packBin, 0644)
+    require.NoError(t, err)
+
+    keysList := [][]string{ { privKey1 }, { privKey3, privKey2 } }
+    for _, identities := range keysList {
+        options := NewUnpackOptions()
+        options.Keys = NewCryptKeys()
+        options.Keys.Identities = identities
+
+        descrs, err := ListSelected(bytes.NewReader(packBin), io.Discard, options)
+        require.NoError(t, err)
+        require.Equal(t, 3, len(descrs))
+
+        descrs, err = ListSelected(struct{ io.Reader }{ bytes.NewReader(packBin) }, io.Discard, options)
+        require.NoError(t, err)
+        require.Equal(t, 3, len(descrs))
+        for _, descr := range descrs {
+            require.True(t, descr.Match)
+        }
+    }
+
+    options := NewUnpackOptions()
+    options.Keys = NewCryptKeys()
+    options.Keys.Passphrase = "pass phrase"
+    unpackDir := filepath.Join(destDir, "unpack")
+    _, err = UnpackSelected(struct{ io.Reader }{ bytes.NewReader(packBin) }, unpackDir, options)
+    require.NoError(t, err)
+    data, err := os.ReadFile(filepath.Join(unpackDir, srcDir, "random.bin"))
+    require.NoError(t, err)
+    require.Equal(t, randData, data)
+
+    packFile, err := os.Open(packPath)
+    require.NoError(t, err)
+    defer packFile.Close()
+    decReader, err := NewDecryptReader(packFile, options.Keys)
+    require.NoError(t, err)
+    oneDir := filepath.Join(destDir, "one")
+    _, err = UnpackFile(decReader, filepath.Join(srcDir, "secret.txt"), oneDir)
+    require.NoError(t, err)
+    data, err = os.ReadFile(filepath.Join(oneDir, srcDir, "secret.txt"))
+    require.NoError(t, err)
+    require.Equal(t, []byte("secret"), data)
+
+    options.Keys.Passphrase = "wrong"
+    _, err = ListSelected(bytes.NewReader(packBin), io.Discard, options)
+    require.Error(t, err)
+
+    _, err = List(bytes.NewReader(packBin), io.Discard)
+    require.Error(t, err)
+    require.Contains(t, err.Error(), "encrypted")
+
+    options.Keys.Passphrase = "pass phrase"
+    tamperBin := append([]byte{}, packBin...)
+    tamperBin[len(tamperBin) / 2] ^= 0x01
+    _, err = ListSelected(struct{ io.Reader }{ bytes.NewReader(tamperBin) }, io.Discard, options)
+    require.Error(t, err)
+    require.Contains(t, err.Error(), "authentication failed")
+    _, err = UnpackSelected(bytes.NewReader(tamperBin), filepath.Join(destDir, "tamper"), options)
+    require.Error(t, err)
+
+    truncBin := packBin[0:len(packBin) - 100]
+    _, err = ListSelected(struct{ io.Reader }{ bytes.NewReader(truncBin) }, io.Discard, options)
+    require.Error(t, err)
+
+    // The archive cut at the chunk boundary lacks the last flag
+    decReader, err = NewDecryptReader(bytes.NewReader(packBin), options.Keys)
+    require.NoError(t, err)
+    sealedSize := cryptChunkSize + int64(chacha20poly1305.Overhead)
+    require.Greater(t, int64(len(packBin)), decReader.dataStart + sealedSize)
+    truncBin = packBin[0:decReader.dataStart + sealedSize]
+    decReader, err = NewDecryptReader(bytes.NewReader(truncBin), options.Keys)
+    require.NoError(t, err)
+    _, err = decReader.Seek(0, io.SeekEnd)
+    require.Error(t, err)
+    _, err = ReadIndex(decReader)
+    require.Error(t, err)
+    _, err = UnpackSelected(bytes.NewReader(truncBin), filepath.Join(destDir, "trunc"), options)
+    require.Error(t, err)
+
+    sizeBin := append([]byte{}, packBin...)
+    sizeBin[16] = 0x7F
+    _, err = NewDecryptReader(bytes.NewReader(sizeBin), options.Keys)
+    require.Error(t, err)
+    require.Contains(t, err.Error(), "crypt descr size")
+    _, err = ListSelected(bytes.NewReader(sizeBin), io.Discard, options)
+    require.Error(t, err)
+}
+
+func TestPackHardLink(t *testing.T) {
+    var err error
+
+    srcDir := t.TempDir()
+    destDir := t.TempDir()
+
+    data := bytes.Repeat([]byte("hard link data "), 1000)
+    firstPath := filepath.Join(srcDir, "a")
+    linkPath := filepath.Join(srcDir, "b")
+    err = os.WriteFile(firstPath, data, 0644)
+    require.NoError(t, err)
+    err = os.Link(firstPath, linkPath)
+    require.NoError(t, err)
+
+    packPath := filepath.Join(destDir, "test.pack")
+    packFile, err := os.OpenFile(packPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+    require.NoError(t, err)
+    options := NewPackOptions()
+    err = PackWithOptions([]string{ srcDir }, packFile, options)
+    require.NoError(t, err)
+    packFile.Close()
+
+    packInfo, err := os.Stat(packPath)
+    require.NoError(t, err)
+    require.Less(t, packInfo.Size(), int64(2 * len(data)))
+
+    packFile, err = os.OpenFile(packPath, os.O_RDONLY, 0)
+    require.NoError(t, err)
+    defer packFile.Close()
+
+    items, err := ReadIndex(packFile)
+    require.NoError(t, err)
+    linkDescr := items[len(items) - 1].Descr
+    require.Equal(t, DTypeHlink, linkDescr.Type)
+    require.Equal(t, strings.TrimLeft(firstPath, "/"), linkDescr.HLink)
+
+    unpackDir := filepath.Join(destDir, "all")
+    _, err = Unpack(packFile, unpackDir)
+    require.NoError(t, err)
+
+    firstInfo, err := os.Stat(filepath.Join(unpackDir, firstPath))
+    require.NoError(t, err)
+    linkInfo, err := os.Stat(filepath.Join(unpackDir, linkPath))
+    require.NoError(t, err)
+    require.True(t, os.SameFile(firstInfo, linkInfo))
+
+    unpackOptions := NewUnpackOptions()
+    unpackOptions.Include = []string{ linkPath }
+
+    _, err = packFile.Seek(0, io.SeekStart)
+    require.NoError(t, err)
+    unpackDir = filepath.Join(destDir, "link")
+    descrs, err := UnpackSelected(packFile, unpackDir, unpackOptions)
+    require.NoError(t, err)
+    require.Equal(t, 1, len(descrs))
+    require.True(t, descrs[0].Match)
+
+    linkData, err := os.ReadFile(filepath.Join(unpackDir, linkPath))
+    require.NoError(t, err)
+    require.Equal(t, data, linkData)
+    _, err = os.Stat(filepath.Join(unpackDir, firstPath))
+    require.True(t, os.IsNotExist(err))
+
+    _, err = packFile.Seek(0, io.SeekStart)
+    require.NoError(t, err)
+    unpackDir = filepath.Join(destDir, "stream")
+    _, err = UnpackSelected(struct{ io.Reader }{ packFile }, unpackDir, unpackOptions)
+    require.Error(t, err)
+}
+
+func TestPackNodes(t *testing.T) {
+    var err error
+
+    srcDir := t.TempDir()
+    destDir := t.TempDir()
+
+    fifoPath := filepath.Join(srcDir, "fifo")
+    err = syscall.Mkfifo(fifoPath, 0640)
+    require.NoError(t, err)
+
+    sockPath := filepath.Join(srcDir, "sock")
+    listener, err := net.Listen("unix", sockPath)
+    require.NoError(t, err)
+    defer listener.Close()
+
+    nullPath := filepath.Join(srcDir, "null")
+    if os.Getuid() == 0 {
+        var nullStat syscall.Stat_t
+        err = syscall.Stat("/dev/null", &nullStat)
+        require.NoError(t, err)
+        err = mknod(nullPath, syscall.S_IFCHR|0666, uint64(nullStat.Rdev))
+        require.NoError(t, err)
+    }
+
+    var packBuffer bytes.Buffer
+    err = Pack([]string{ srcDir }, &packBuffer)
+    require.NoError(t, err)
+
+    descrs, err := Unpack(bytes.NewReader(packBuffer.Bytes()), destDir)
+    require.NoError(t, err)
+
+    types := make(map[string]int64)
+    for _, descr := range descrs {
+        types[filepath.Base(descr.Path)] = descr.Type
+        require.Empty(t, descr.Error)
+    }
+    require.Equal(t, DTypeFifo, types["fifo"])
+    require.Equal(t, DTypeSocket, types["sock"])
+
+    fifoInfo, err := os.Lstat(filepath.Join(destDir, fifoPath))
+    require.NoError(t, err)
+    require.True(t, fifoInfo.Mode() & fs.ModeNamedPipe != 0)
+    require.Equal(t, fs.FileMode(0640), fifoInfo.Mode().Perm())
+
+    sockInfo, err := os.Lstat(filepath.Join(destDir, sockPath))
+    require.NoError(t, err)
+    require.True(t, sockInfo.Mode() & fs.ModeSocket != 0)
+
+    if os.Getuid() == 0 {
+        require.Equal(t, DTypeChar, types["null"])
+        nullInfo, err := os.Lstat(filepath.Join(destDir, nullPath))
+        require.NoError(t, err)
+        require.True(t, nullInfo.Mode() & fs.ModeCharDevice != 0)
+
+        var srcStat, destStat syscall.Stat_t
+        err = syscall.Stat(nullPath, &srcStat)
+        require.NoError(t, err)
+        err = syscall.Stat(filepath.Join(destDir, nullPath), &destStat)
+        require.NoError(t, err)
+        require.Equal(t, srcStat.Rdev, destStat.Rdev)
+    }
+}
+
+type changingFile struct {
+    *os.File
+    change  func(file *os.File)
+}
+
+func (file *changingFile) Read(buffer []byte) (int, error) {
+    if file.change != nil {
+        file.change(file.File)
+        file.change = nil
+    }
+    return file.File.Read(buffer)
+}
+
+func TestPackChangedFile(t *testing.T) {
+    var err error
+
+    srcDir := t.TempDir()
+    data := bytes.Repeat([]byte("log line\n"), 1000)
+    size := int64(len(data))
+
+    changes := map[string]func(file *os.File){
+        "": nil,
+        FileStatusTruncated: func(file *os.File) {
+            file.Truncate(size / 2)
+        },
+        FileStatusGrown: func(file *os.File) {
+            file.WriteAt([]byte("new line\n"), size)
+        },
+        FileStatusChanged: func(file *os.File) {
+            mTime := time.Unix(1500000000, 0)
+            os.Chtimes(file.Name(), mTime, mTime)
+        },
+    }
+    for status, change := range changes {
+        filePath := filepath.Join(srcDir, "file" + status)
+        err = os.WriteFile(filePath, data, 0644)
+        require.NoError(t, err)
+
+        file, err := os.OpenFile(filePath, os.O_RDWR, 0)
+        require.NoError(t, err)
+        defer file.Close()
+
+        var sysStat syscall.Stat_t
+        err = syscall.Stat(filePath, &sysStat)
+        require.NoError(t, err)
+
+        headDescr := NewHeadDescr()
+        headDescr.Path = filePath
+        headDescr.Type = DTypeFile
+        headDescr.Size = sysStat.Size
+        setStatTimes(headDescr, &sysStat)
+
+        var packBuffer bytes.Buffer
+        writer := NewWriter(&packBuffer)
+        err = writer.WriteFile(headDescr, &changingFile{ File: file, change: change })
+        require.NoError(t, err)
+        require.Equal(t, status, headDescr.Status)
+
+        reader := NewReader(&packBuffer)
+        readDescr, err := reader.ReadHeadDescr()
+        require.NoError(t, err)
+        var dataBuffer bytes.Buffer
+        match, err := reader.ReadData(readDescr, &dataBuffer)
+        require.NoError(t, err)
+        require.True(t, match)
+        require.Equal(t, status, readDescr.Status)
+        require.Equal(t, size, int64(dataBuffer.Len()))
+
+        if status == FileStatusTruncated {
+            require.Equal(t, data[0:size / 2], dataBuffer.Bytes()[0:size / 2])
+            require.Equal(t, make([]byte, size - size / 2), dataBuffer.Bytes()[size / 2:])
+        }
+    }
+}
+
+func TestPackIncremental(t *testing.T) {
+    var err error
+
+    srcDir := t.TempDir()
+    destDir := t.TempDir()
+
+    writeFile := func(name, data string) {
+        err := os.WriteFile(filepath.Join(srcDir, name), []byte(data), 0644)
+        require.NoError(t, err)
+    }
+    writeFile("a", "file a")
+    writeFile("b", "file b")
+    writeFile("c", "file c")
+
+    pack := func(since *Manifest) ([]byte, *Manifest) {
+        var packBuffer bytes.Buffer
+        options := NewPackOptions()
+        options.Since = since
+        options.Manifest = NewManifest()
+        err := PackWithOptions([]string{ srcDir }, &packBuffer, options)
+        require.NoError(t, err)
+        return packBuffer.Bytes(), options.Manifest
+    }
+    fullPack, fullManifest := pack(nil)
+    require.Equal(t, 4, len(fullManifest.Items))
+
+    manifestBin, err := fullManifest.Pack()
+    require.NoError(t, err)
+    fullManifest, err = UnpackManifest(manifestBin)
+    require.NoError(t, err)
+
+    writeFile("b", "file b changed")
+    writeFile("d", "file d")
+    err = os.Remove(filepath.Join(srcDir, "c"))
+    require.NoError(t, err)
+
+    incPack, incManifest := pack(fullManifest)
+    require.Equal(t, 4, len(incManifest.Items))
+
+    items, err := ReadIndex(bytes.NewReader(incPack))
+    require.NoError(t, err)
+    types := make(map[string]int64)
+    for _, item := range items {
+        types[filepath.Base(item.Descr.Path)] = item.Descr.Type
+    }
+    require.Equal(t, 4, len(types))
+    require.Equal(t, DTypeFile, types["b"])
+    require.Equal(t, DTypeFile, types["d"])
+    require.Equal(t, DTypeDelete, types["c"])
+    require.Equal(t, DTypeDir, types[filepath.Base(srcDir)])
+
+    emptyPack, _ := pack(incManifest)
+    items, err = ReadIndex(bytes.NewReader(emptyPack))
+    require.NoError(t, err)
+    require.Equal(t, 1, len(items))
+
+    readers := []io.Reader{
+        bytes.NewReader(fullPack),
+        bytes.NewReader(incPack),
+        bytes.NewReader(emptyPack),
+    }
+    descrs, err := RestoreChain(readers, destDir, NewUnpackOptions())
+    require.NoError(t, err)
+    require.Equal(t, 4, len(descrs))
+
+    for name, data := range map[string]string{ "a": "file a", "b": "file b changed", "d": "file d" } {
+        fileData, err := os.ReadFile(filepath.Join(destDir, srcDir, name))
+        require.NoError(t, err)
+        require.Equal(t, data, string(fileData))
+    }
+    _, err = os.Stat(filepath.Join(destDir, srcDir, "c"))
+    require.True(t, os.IsNotExist(err))
+}
+
+func TestPackIncrementalLinks(t *testing.T) {
+    var err error
+
+    srcDir := t.TempDir()
+    destDir := t.TempDir()
+
+    linkPath := filepath.Join(srcDir, "ln")
+    fifoPath := filepath.Join(srcDir, "fifo")
+    err = os.Symlink("a", linkPath)
+    require.NoError(t, err)
+    err = syscall.Mkfifo(fifoPath, 0600)
+    require.NoError(t, err)
+
+    pack := func(since *Manifest) ([]byte, *Manifest) {
+        var packBuffer bytes.Buffer
+        options := NewPackOptions()
+        options.Since = since
+        options.Manifest = NewManifest()
+        err := PackWithOptions([]string{ srcDir }, &packBuffer, options)
+        require.NoError(t, err)
+        return packBuffer.Bytes(), options.Manifest
+    }
+    fullPack, fullManifest := pack(nil)
+
+    time.Sleep(10 * time.Millisecond)
+    err = os.Remove(linkPath)
+    require.NoError(t, err)
+    err = os.Symlink("b", linkPath)
+    require.NoError(t, err)
+    err = os.Remove(fifoPath)
+    require.NoError(t, err)
+    err = syscall.Mkfifo(fifoPath, 0640)
+    require.NoError(t, err)
+
+    incPack, _ := pack(fullManifest)
+    items, err := ReadIndex(bytes.NewReader(incPack))
+    require.NoError(t, err)
+    require.Equal(t, 3, len(items))
+
+    readers := []io.Reader{
+        bytes.NewReader(fullPack),
+        bytes.NewReader(incPack),
+    }
+    descrs, err := RestoreChain(readers, destDir, NewUnpackOptions())
+    require.NoError(t, err)
+    require.Equal(t, 3, len(descrs))
+    for _, headDescr := range descrs {
+        require.True(t, headDescr.Match, headDescr.Path)
+    }
+
+    target, err := os.Readlink(filepath.Join(destDir, linkPath))
+    require.NoError(t, err)
+    require.Equal(t, "b", target)
+    fileInfo, err := os.Lstat(filepath.Join(destDir, fifoPath))
+    require.NoError(t, err)
+    require.Equal(t, fs.ModeNamedPipe, fileInfo.Mode().Type())
+    require.Equal(t, fs.FileMode(0640), fileInfo.Mode().Perm())
+}
+
+func TestPackIncrementalUnreadable(t *testing.T) {
+    var err error
+
+    if os.Getuid() == 0 {
+        t.Skip("root reads files without permissions")
+    }
+    srcDir := t.TempDir()
+    subDir := filepath.Join(srcDir, "sub")
+    err = os.MkdirAll(subDir, 0750)
+    require.NoError(t, err)
+    for _, name := range []string{ "a", "sub/b", "sub/c" } {
+        err = os.WriteFile(filepath.Join(srcDir, name), []byte(name), 0644)
+        require.NoError(t, err)
+    }
+
+    pack := func(since *Manifest) ([]byte, *Manifest) {
+        var packBuffer bytes.Buffer
+        options := NewPackOptions()
+        options.Since = since
+        options.Manifest = NewManifest()
+        err := PackWithOptions([]string{ srcDir }, &packBuffer, options)
+        require.NoError(t, err)
+        return packBuffer.Bytes(), options.Manifest
+    }
+    _, fullManifest := pack(nil)
+    require.Equal(t, 5, len(fullManifest.Items))
+
+    err = os.Chmod(filepath.Join(srcDir, "a"), 0)
+    require.NoError(t, err)
+    err = os.Chmod(subDir, 0)
+    require.NoError(t, err)
+    defer os.Chmod(subDir, 0750)
+
+    incPack, incManifest := pack(fullManifest)
+    require.Equal(t, 5, len(incManifest.Items))
+    items, err := ReadIndex(bytes.NewReader(incPack))
+    require.NoError(t, err)
+    for _, item := range items {
+        require.NotEqual(t, DTypeDelete, item.Descr.Type, item.Descr.Path)
+    }
+}
+
+func TestUnpackDeleteOutside(t *testing.T) {
+    var err error
+
+    rootDir := t.TempDir()
+    destDir := filepath.Join(rootDir, "dest")
+    victimDir := filepath.Join(rootDir, "victim")
+    err = os.MkdirAll(destDir, 0750)
+    require.NoError(t, err)
+    err = os.MkdirAll(victimDir, 0750)
+    require.NoError(t, err)
+    err = os.WriteFile(filepath.Join(victimDir, "a"), []byte("victim"), 0644)
+    require.NoError(t, err)
+    err = os.Symlink(victimDir, filepath.Join(destDir, "link"))
+    require.NoError(t, err)
+    err = os.WriteFile(filepath.Join(destDir, "b"), []byte("restored"), 0644)
+    require.NoError(t, err)
+
+    deletePack := func(deletedPath string) []byte {
+        var packBuffer bytes.Buffer
+        writer := NewWriter(&packBuffer)
+        headDescr := NewHeadDescr()
+        headDescr.Path  = deletedPath
+        headDescr.Type  = DTypeDelete
+        headDescr.HType = HashTypeNone
+        err := writer.WriteHeadDescr(headDescr)
+        require.NoError(t, err)
+        err = writer.WriteTailDescr(NewTailDescr())
+        require.NoError(t, err)
+        err = writer.WriteIndex()
+        require.NoError(t, err)
+        return packBuffer.Bytes()
+    }
+    for _, deletedPath := range []string{ "../victim", "x/../../victim", "link/a", "." } {
+        _, err = Unpack(bytes.NewBuffer(deletePack(deletedPath)), destDir)
+        require.Error(t, err, deletedPath)
+    }
+    _, err = os.Stat(filepath.Join(victimDir, "a"))
+    require.NoError(t, err)
+
+    _, err = Unpack(bytes.NewBuffer(deletePack("b")), destDir)
+    require.NoError(t, err)
+    _, err = os.Stat(filepath.Join(destDir, "b"))
+    require.True(t, os.IsNotExist(err))
+}
+
+func TestUnpackEscape(t *testing.T) {
+    var err error
+
+    rootDir := t.TempDir()
+    destDir := filepath.Join(rootDir, "dest")
+    victimDir := filepath.Join(rootDir, "victim")
+    err = os.MkdirAll(destDir, 0750)
+    require.NoError(t, err)
+    err = os.MkdirAll(victimDir, 0750)
+    require.NoError(t, err)
+    err = os.Symlink(victimDir, filepath.Join(destDir, "link"))
+    require.NoError(t, err)
+
+    entryPack := func(headDescrs ...*HeadDescr) []byte {
+        var packBuffer bytes.Buffer
+        writer := NewWriter(&packBuffer)
+        for _, headDescr := range headDescrs {
+            switch headDescr.Type {
+                case DTypeFile:
+                    data := []byte("evil")
+                    headDescr.Size = int64(len(data))
+                    err := writer.WriteStream(headDescr, bytes.NewReader(data))
+                    require.NoError(t, err)
+                default:
+                    headDescr.HType = HashTypeNone
+                    err := writer.WriteHeadDescr(headDescr)
+                    require.NoError(t, err)
+                    err = writer.WriteTailDescr(NewTailDescr())
+                    require.NoError(t, err)
+            }
+        }
+        err := writer.WriteIndex()
+        require.NoError(t, err)
+        return packBuffer.Bytes()
+    }
+    newDescr := func(entryPath string, entryType int64) *HeadDescr {
+        headDescr := NewHeadDescr()
+        headDescr.Path = entryPath
+        headDescr.Type = entryType
+        headDescr.Mode = 0644
+        return headDescr
+    }
+
+    // The symlink to the victim dir is restored first,
+    // the next entry must not be written through it
+    slinkDescr := newDescr("slink", DTypeSlink)
+    slinkDescr.SLink = victimDir
+    hlinkDescr := newDescr("hlink", DTypeHlink)
+    hlinkDescr.HLink = "../victim/a"
+    packs := [][]byte{
+        entryPack(newDescr("x/../../victim/a", DTypeFile)),
+        entryPack(newDescr("../victim/a", DTypeSlink)),
+        entryPack(newDescr("link/a", DTypeFile)),
+        entryPack(newDescr("link/dir/a", DTypeFile)),
+        entryPack(newDescr("link/dir", DTypeDir)),
+        entryPack(slinkDescr, newDescr("slink/a", DTypeFile)),
+        entryPack(hlinkDescr),
+    }
+    for i, packBin := range packs {
+        _, err = Unpack(bytes.NewReader(packBin), destDir)
+        require.Error(t, err, i)
+
+        _, damaged, err := Salvage(bytes.NewReader(packBin), destDir, NewUnpackOptions())
+        require.NoError(t, err, i)
+        require.NotEqual(t, 0, len(damaged), i)
+    }
+    victimFiles, err := os.ReadDir(victimDir)
+    require.NoError(t, err)
+    require.Equal(t, 0, len(victimFiles))
+
+    // The dir entry replaces the symlink instead of following it
+    err = os.Remove(filepath.Join(destDir, "slink"))
+    require.NoError(t, err)
+    err = os.Symlink(victimDir, filepath.Join(destDir, "slink"))
+    require.NoError(t, err)
+    dirDescr := newDescr("slink", DTypeDir)
+    dirDescr.Mode = 0700
+    _, err = Unpack(bytes.NewReader(entryPack(dirDescr, newDescr("slink/a", DTypeFile))), destDir)
+    require.NoError(t, err)
+    fileInfo, err := os.Lstat(filepath.Join(destDir, "slink"))
+    require.NoError(t, err)
+    require.True(t, fileInfo.IsDir())
+    victimInfo, err := os.Stat(victimDir)
+    require.NoError(t, err)
+    require.Equal(t, fs.FileMode(0750), victimInfo.Mode().Perm())
+    victimFiles, err = os.ReadDir(victimDir)
+    require.NoError(t, err)
+    require.Equal(t, 0, len(victimFiles))
+}
+
+func TestPackNoIndex(t *testing.T) {
+    var err error
+
+    srcDir := t.TempDir()
+    err = os.WriteFile(filepath.Join(srcDir, "a.txt"), []byte("qwerty"), 0644)
+    require.NoError(t, err)
+
+    var packBuffer bytes.Buffer
+    err = Pack([]string{ srcDir }, &packBuffer)
+    require.NoError(t, err)
+    packBin := packBuffer.Bytes()
+
+    // The archive is cut before the index chunk
+    indexPos := bytes.LastIndex(packBin, encoderI64(magicCodeA))
+    require.True(t, indexPos > 0)
+    truncBin := packBin[0:indexPos]
+
+    _, err = List(bytes.NewReader(truncBin), io.Discard)
+    require.ErrorIs(t, err, io.ErrUnexpectedEOF)
+    _, err = Unpack(bytes.NewReader(truncBin), t.TempDir())
+    require.ErrorIs(t, err, io.ErrUnexpectedEOF)
+    _, err = ExportTar(bytes.NewReader(truncBin), io.Discard, nil)
+    require.ErrorIs(t, err, io.ErrUnexpectedEOF)
+
+    _, err = List(bytes.NewReader(packBin), io.Discard)
+    require.NoError(t, err)
+}
+
+func TestPackVerify(t *testing.T) {
+    var err error
+
+    srcDir := t.TempDir()
+
+    data := bytes.Repeat([]byte{ 0xAB }, 1024)
+    err = os.WriteFile(filepath.Join(srcDir, "a.bin"), data, 0644)
+    require.NoError(t, err)
+    err = os.WriteFile(filepath.Join(srcDir, "b.txt"), []byte("qwerty"), 0644)
+    require.NoError(t, err)
+
+    var packBuffer bytes.Buffer
+    err = Pack([]string{ srcDir }, &packBuffer)
+    require.NoError(t, err)
+    packBin := packBuffer.Bytes()
+
+    report, err := Verify(bytes.NewReader(packBin), nil)
+    require.NoError(t, err)
+    require.True(t, report.Ok())
+    require.Equal(t, int64(3), report.Entries)
+    require.Equal(t, int64(2), report.Files)
+    require.Equal(t, int64(len(packBin)), report.Size)
+
+    items, err := ReadIndex(bytes.NewReader(packBin))
+    require.NoError(t, err)
+    require.Equal(t, 3, len(items))
+
+    mismatchBin := append([]byte(nil), packBin...)
+    pos := bytes.Index(mismatchBin, data)
+    require.True(t, pos > 0)
+    mismatchBin[pos + 100] ^= 0xFF
+    report, err = Verify(bytes.NewReader(mismatchBin), nil)
+    require.NoError(t, err)
+    require.False(t, report.Ok())
+    require.Equal(t, 1, len(report.Problems))
+    require.Equal(t, ProblemMismatch, report.Problems[0].Kind)
+    require.Equal(t, items[1].Offset, report.Problems[0].Offset)
+    require.Equal(t, int64(3), report.Entries)
+
+    corruptBin := append([]byte(nil), packBin...)
+    corruptBin[items[2].Offset] ^= 0xFF
+    report, err = Verify(bytes.NewReader(corruptBin), nil)
+    require.NoError(t, err)
+    require.Equal(t, 1, len(report.Problems))
+    require.Equal(t, ProblemCorrupt, report.Problems[0].Kind)
+    require.Equal(t, items[2].Offset, report.Problems[0].Offset)
+
+    truncBin := packBin[0:items[2].Offset + 10]
+    report, err = Verify(bytes.NewReader(truncBin), nil)
+    require.NoError(t, err)
+    require.Equal(t, 1, len(report.Problems))
+    require.Equal(t, ProblemTruncated, report.Problems[0].Kind)
+    require.Equal(t, items[2].Offset, report.Problems[0].Offset)
+
+    report, err = Verify(bytes.NewReader(packBin[0:items[2].Offset]), nil)
+    require.NoError(t, err)
+    require.Equal(t, 1, len(report.Problems))
+    require.Equal(t, ProblemTruncated, report.Problems[0].Kind)
+
+    packOptions := NewPackOptions()
+    packOptions.Keys = NewCryptKeys()
+    packOptions.Keys.Passphrase = "pass phrase"
+    packBuffer.Reset()
+    err = PackWithOptions([]string{ srcDir }, &packBuffer, packOptions)
+    require.NoError(t, err)
+
+    report, err = Verify(bytes.NewReader(packBuffer.Bytes()), packOptions.Keys)
+    require.NoError(t, err)
+    require.True(t, report.Ok())
+    require.Equal(t, int64(2), report.Files)
+}
+
+func TestPackVerifyDescrSize(t *testing.T) {
+    var err error
+
+    srcDir := t.TempDir()
+    err = os.WriteFile(filepath.Join(srcDir, "a.bin"), bytes.Repeat([]byte{ 0xAB }, 1024), 0644)
+    require.NoError(t, err)
+    err = os.WriteFile(filepath.Join(srcDir, "b.txt"), []byte("qwerty"), 0644)
+    require.NoError(t, err)
+
+    var packBuffer bytes.Buffer
+    err = Pack([]string{ srcDir }, &packBuffer)
+    require.NoError(t, err)
+    packBin := packBuffer.Bytes()
+    items, err := ReadIndex(bytes.NewReader(packBin))
+    require.NoError(t, err)
+    require.Equal(t, 3, len(items))
+
+    headBin := append([]byte(nil), packBin...)
+    headBin[items[2].Offset + 24] = 0x7F
+    tailBin := append([]byte(nil), packBin...)
+    tailPos := bytes.Index(tailBin[items[1].Offset:], encoderI64(magicCodeC))
+    require.True(t, tailPos > 0)
+    tailBin[items[1].Offset + int64(tailPos) + 24] = 0x7F
+
+    for _, corruptBin := range [][]byte{ headBin, tailBin } {
+        report, err := Verify(bytes.NewReader(corruptBin), nil)
+        require.NoError(t, err)
+        require.Equal(t, 1, len(report.Problems))
+        require.Equal(t, ProblemCorrupt, report.Problems[0].Kind)
+        require.Contains(t, report.Problems[0].Error, "descr size")
+
+        _, err = UnpackSelected(bytes.NewReader(corruptBin), t.TempDir(), NewUnpackOptions())
+        require.Error(t, err)
+        _, err = UnpackSelected(bytes.NewBuffer(corruptBin), t.TempDir(), NewUnpackOptions())
+        require.Error(t, err)
+        _, err = ListSelected(bytes.NewBuffer(corruptBin), io.Discard, NewUnpackOptions())
+        require.Error(t, err)
+    }
+}
+
+func TestPackSalvage(t *testing.T) {
+    var err error
+
+    srcDir := t.TempDir()
+
+    names := []string{ "a.txt", "b.txt", "c.txt" }
+    for _, name := range names {
+        err = os.WriteFile(filepath.Join(srcDir, name), []byte("data of " + name), 0644)
+        require.NoError(t, err)
+    }
+    var packBuffer bytes.Buffer
+    err = Pack([]string{ srcDir }, &packBuffer)
+    require.NoError(t, err)
+    packBin := packBuffer.Bytes()
+
+    items, err := ReadIndex(bytes.NewReader(packBin))
+    require.NoError(t, err)
+    require.Equal(t, 4, len(items))
+
+    checkRestored := func(destDir string) {
+        for _, name := range []string{ "a.txt", "c.txt" } {
+            data, err := os.ReadFile(filepath.Join(destDir, srcDir, name))
+            require.NoError(t, err)
+            require.Equal(t, "data of " + name, string(data))
+        }
+        _, err = os.Stat(filepath.Join(destDir, srcDir, "b.txt"))
+        require.True(t, os.IsNotExist(err))
+    }
+
+    headerBin := append([]byte(nil), packBin...)
+    headerBin[items[2].Offset + 3] ^= 0xFF
+
+    destDir := t.TempDir()
+    _, err = Unpack(bytes.NewReader(headerBin), destDir)
+    require.Error(t, err)
+
+    destDir = t.TempDir()
+    descrs, damaged, err := Salvage(bytes.NewReader(headerBin), destDir, NewUnpackOptions())
+    require.NoError(t, err)
+    require.Equal(t, 3, len(descrs))
+    require.Equal(t, 1, len(damaged))
+    require.Equal(t, items[2].Offset, damaged[0].Offset)
+    require.Equal(t, items[3].Offset - items[2].Offset, damaged[0].Size)
+    require.Equal(t, "", damaged[0].Path)
+    checkRestored(destDir)
+
+    tailBin := append([]byte(nil), packBin...)
+    tailPos := bytes.LastIndex(tailBin[0:items[3].Offset], encoderI64(magicCodeC))
+    require.True(t, tailPos > int(items[2].Offset))
+    tailBin[tailPos] ^= 0xFF
+
+    destDir = t.TempDir()
+    descrs, damaged, err = Salvage(bytes.NewReader(tailBin), destDir, NewUnpackOptions())
+    require.NoError(t, err)
+    require.Equal(t, 3, len(descrs))
+    require.Equal(t, 1, len(damaged))
+    require.Equal(t, items[2].Offset, damaged[0].Offset)
+    require.Equal(t, items[2].Descr.Path, damaged[0].Path)
+    checkRestored(destDir)
+
+    destDir = t.TempDir()
+    descrs, damaged, err = Salvage(bytes.NewReader(packBin), destDir, NewUnpackOptions())
+    require.NoError(t, err)
+    require.Equal(t, 4, len(descrs))
+    require.Equal(t, 0, len(damaged))
+}
+
+func TestPackSalvageEncrypt(t *testing.T) {
+    var err error
+
+    srcDir := t.TempDir()
+
+    names := []string{ "a.bin", "b.bin", "c.bin", "d.bin", "e.bin", "f.bin" }
+    files := make(map[string][]byte)
+    for _, name := range names {
+        data := make([]byte, 50 * 1024)
+        rand.Read(data)
+        files[name] = data
+        err = os.WriteFile(filepath.Join(srcDir, name), data, 0644)
+        require.NoError(t, err)
+    }
+    packOptions := NewPackOptions()
+    packOptions.Keys = NewCryptKeys()
+    packOptions.Keys.Passphrase = "pass phrase"
+    var packBuffer bytes.Buffer
+    err = PackWithOptions([]string{ srcDir }, &packBuffer, packOptions)
+    require.NoError(t, err)
+    packBin := packBuffer.Bytes()
+
+    decReader, err := NewDecryptReader(bytes.NewReader(packBin), packOptions.Keys)
+    require.NoError(t, err)
+    sealedSize := cryptChunkSize + int64(chacha20poly1305.Overhead)
+    corruptBin := append([]byte(nil), packBin...)
+    corruptBin[decReader.dataStart + sealedSize + 100] ^= 0xFF
+
+    options := NewUnpackOptions()
+    options.Keys = NewCryptKeys()
+    options.Keys.Passphrase = "pass phrase"
+
+    _, err = UnpackSelected(bytes.NewReader(corruptBin), t.TempDir(), options)
+    require.Error(t, err)
+
+    destDir := t.TempDir()
+    descrs, damaged, err := Salvage(bytes.NewReader(corruptBin), destDir, options)
+    require.NoError(t, err)
+    require.True(t, len(damaged) > 0)
+    for _, damagedRange := range damaged {
+        require.True(t, damagedRange.Offset < 2 * cryptChunkSize)
+        require.True(t, damagedRange.Offset + damagedRange.Size > cryptChunkSize)
+    }
+    restored := 0
+    for _, name := range names {
+        data, err := os.ReadFile(filepath.Join(destDir, srcDir, name))
+        if os.IsNotExist(err) {
+            continue
+        }
+        require.NoError(t, err)
+        require.Equal(t, files[name], data)
+        restored += 1
+    }
+    require.True(t, restored >= 3)
+    require.Equal(t, restored + 1, len(descrs))
+}
+
+func TestPackTar(t *testing.T) {
+    var err error
+
+    srcDir := t.TempDir()
+    destDir := t.TempDir()
+
+    err = os.MkdirAll(filepath.Join(srcDir, "etc"), 0750)
+    require.NoError(t, err)
+    err = os.WriteFile(filepath.Join(srcDir, "etc", "a.txt"), []byte("qwerty"), 0640)
+    require.NoError(t, err)
+    bigData := bytes.Repeat([]byte("compressible data "), 64 * 1024)
+    err = os.WriteFile(filepath.Join(srcDir, "big.txt"), bigData, 0644)
+    require.NoError(t, err)
+    err = os.Symlink("etc/a.txt", filepath.Join(srcDir, "a.link"))
+    require.NoError(t, err)
+    err = os.Link(filepath.Join(srcDir, "etc", "a.txt"), filepath.Join(srcDir, "b.txt"))
+    require.NoError(t, err)
+
+    packOptions := NewPackOptions()
+    packOptions.Codec = CodecZstd
+    var packBuffer bytes.Buffer
+    err = PackWithOptions([]string{ srcDir }, &packBuffer, packOptions)
+    require.NoError(t, err)
+    packBin := packBuffer.Bytes()
+
+    var tarBuffer bytes.Buffer
+    descrs, err := ExportTar(bytes.NewReader(packBin), &tarBuffer, nil)
+    require.NoError(t, err)
+    for _, descr := range descrs {
+        require.True(t, descr.Match)
+        require.Equal(t, "", descr.Error)
+    }
+    var streamBuffer bytes.Buffer
+    _, err = ExportTar(bytes.NewBuffer(packBin), &streamBuffer, nil)
+    require.NoError(t, err)
+    require.Equal(t, tarBuffer.Bytes(), streamBuffer.Bytes())
+
+    tarEntries := make(map[string]*tar.Header)
+    tarReader := tar.NewReader(bytes.NewReader(tarBuffer.Bytes()))
+    for {
+        tarHeader, err := tarReader.Next()
+        if err == io.EOF {
+            break
+        }
+        require.NoError(t, err)
+        tarEntries[tarHeader.Name] = tarHeader
+    }
+    tarSrcDir := strings.TrimLeft(srcDir, "/")
+    bigHeader := tarEntries[tarSrcDir + "/big.txt"]
+    require.NotNil(t, bigHeader)
+    require.Equal(t, int64(len(bigData)), bigHeader.Size)
+    require.NotEmpty(t, bigHeader.PAXRecords[paxHashSum])
+    require.Equal(t, byte(tar.TypeDir), tarEntries[tarSrcDir + "/etc/"].Typeflag)
+    require.Equal(t, "etc/a.txt", tarEntries[tarSrcDir + "/a.link"].Linkname)
+    require.Equal(t, byte(tar.TypeLink), tarEntries[tarSrcDir + "/etc/a.txt"].Typeflag)
+
+    var importBuffer bytes.Buffer
+    descrs, err = ImportTar(bytes.NewReader(tarBuffer.Bytes()), &importBuffer, packOptions)
+    require.NoError(t, err)
+    require.Equal(t, len(tarEntries), len(descrs))
+
+    descrs, err = Unpack(bytes.NewReader(importBuffer.Bytes()), destDir)
+    require.NoError(t, err)
+    for _, descr := range descrs {
+        require.True(t, descr.Match)
+    }
+    destSrcDir := filepath.Join(destDir, srcDir)
+    data, err := os.ReadFile(filepath.Join(destSrcDir, "big.txt"))
+    require.NoError(t, err)
+    require.Equal(t, bigData, data)
+    sLink, err := os.Readlink(filepath.Join(destSrcDir, "a.link"))
+    require.NoError(t, err)
+    require.Equal(t, "etc/a.txt", sLink)
+
+    srcInfo, err := os.Stat(filepath.Join(srcDir, "etc", "a.txt"))
+    require.NoError(t, err)
+    aInfo, err := os.Stat(filepath.Join(destSrcDir, "etc", "a.txt"))
+    require.NoError(t, err)
+    bInfo, err := os.Stat(filepath.Join(destSrcDir, "b.txt"))
+    require.NoError(t, err)
+    require.True(t, os.SameFile(aInfo, bInfo))
+    require.Equal(t, srcInfo.Mode(), aInfo.Mode())
+    require.Equal(t, srcInfo.ModTime(), aInfo.ModTime())
+}
+
+func TestPackNoRecursion(t *testing.T) {
+    var err error
+
+    srcDir := t.TempDir()
+    err = os.MkdirAll(filepath.Join(srcDir, "etc", "sub"), 0750)
+    require.NoError(t, err)
+    err = os.WriteFile(filepath.Join(srcDir, "etc", "a.txt"), []byte("qwerty"), 0640)
+    require.NoError(t, err)
+    err = os.WriteFile(filepath.Join(srcDir, "etc", "b.txt"), []byte("asdfgh"), 0640)
+    require.NoError(t, err)
+
+    fileList := []string{
+        filepath.Join(srcDir, "etc"),
+        filepath.Join(srcDir, "etc", "a.txt"),
+    }
+    packOptions := NewPackOptions()
+    packOptions.NoRecursion = true
+    var packBuffer bytes.Buffer
+    err = PackWithOptions(fileList, &packBuffer, packOptions)
+    require.NoError(t, err)
+
+    descrs, err := ListSelected(bytes.NewBuffer(packBuffer.Bytes()), io.Discard, NewUnpackOptions())
+    require.NoError(t, err)
+    require.Equal(t, 2, len(descrs))
+    require.Equal(t, DTypeDir, descrs[0].Type)
+    require.Equal(t, DTypeFile, descrs[1].Type)
+    require.Equal(t, strings.TrimLeft(fileList[1], "/"), descrs[1].Path)
+}
+
+func TestPackExclude(t *testing.T) {
+    var err error
+
+    srcDir := t.TempDir()
+    files := map[string]string{
+        "keep.txt":             "keep",
+        "app.log":              "log",
+        "cache/a.txt":          "cache",
+        "node_modules/m/b.txt": "module",
+        "sub/.fdumpignore":     "# temp files\n*.tmp\n!keep.tmp\n",
+        "sub/a.tmp":            "tmp",
+        "sub/keep.tmp":         "tmp",
+        "sub/b.txt":            "sub",
+        "empty/.fdumpignore":   "",
+        "empty/c.txt":          "empty",
+        "big.bin":              strings.Repeat("x", 2000),
+    }
+    for name, data := range files {
+        filePath := filepath.Join(srcDir, name)
+        err = os.MkdirAll(filepath.Dir(filePath), 0750)
+        require.NoError(t, err)
+        err = os.WriteFile(filePath, []byte(data), 0640)
+        require.NoError(t, err)
+    }
+    srcPath := strings.TrimLeft(srcDir, "/")
+
+    packOptions := NewPackOptions()
+    packOptions.Exclude = []string{ "*.log", "node_modules/", "/" + srcPath + "/cache" }
+    packOptions.MaxFileSize = 1000
+    packOptions.Report = NewPackReport()
+    var packBuffer bytes.Buffer
+    err = PackWithOptions([]string{ srcDir }, &packBuffer, packOptions)
+    require.NoError(t, err)
+
+    descrs, err := ListSelected(bytes.NewBuffer(packBuffer.Bytes()), io.Discard, NewUnpackOptions())
+    require.NoError(t, err)
+    paths := make([]string, 0)
+    for _, descr := range descrs {
+        paths = append(paths, strings.TrimPrefix(descr.Path, srcPath))
+    }
+    expected := []string{ "", "/empty", "/keep.txt", "/sub", "/sub/.fdumpignore", "/sub/b.txt", "/sub/keep.tmp" }
+    require.ElementsMatch(t, expected, paths)
+
+    report := packOptions.Report
+    require.Equal(t, int64(len(expected)), report.Entries)
+    require.Equal(t, int64(7), report.Skipped)
+    require.Equal(t, int64(6), report.Excluded)
+    require.Equal(t, int64(1), report.TooLarge)
+
+    fileList := []string{
+        filepath.Join(srcDir, "node_modules"),
+        filepath.Join(srcDir, "node_modules", "m", "b.txt"),
+        filepath.Join(srcDir, "keep.txt"),
+    }
+    packOptions = NewPackOptions()
+    packOptions.Exclude = []string{ "node_modules" }
+    packOptions.NoRecursion = true
+    packOptions.Report = NewPackReport()
+    packBuffer.Reset()
+    err = PackWithOptions(fileList, &packBuffer, packOptions)
+    require.NoError(t, err)
+    require.Equal(t, int64(1), packOptions.Report.Entries)
+    require.Equal(t, int64(2), packOptions.Report.Excluded)
+}
 
-    os.RemoveAll("./xxx")
+func TestExcludeRule(t *testing.T) {
+    cases := []struct {
+        base    string
+        pattern string
+        path    string
+        isDir   bool
+        match   bool
+    }{
+        { "", "*.o", "src/lib/a.o", false, true },
+        { "", "/src/*.o", "src/lib/a.o", false, false },
+        { "", "src/**/*.o", "src/lib/a.o", false, true },
+        { "", "**/lib", "src/lib", true, true },
+        { "", "build/", "src/build", false, false },
+        { "", "build/", "src/build", true, true },
+        { "src", "/lib", "src/lib", true, true },
+        { "src", "/lib", "lib", true, false },
+        { "src", "a/*.o", "src/a/b.o", false, true },
+        { "", "foo/**", "foo", true, false },
+        { "", "foo/**", "foo/a", false, true },
+        { "", "foo/**", "foo/a/b", false, true },
+        { "", "foo/**/bar", "foo/bar", false, true },
+    }
+    for _, c := range cases {
+        rule := parseExcludeRule(c.base, c.pattern)
+        require.NotNil(t, rule)
+        require.Equal(t, c.match, rule.match(c.path, c.isDir), c.pattern + " " + c.path)
+    }
+    require.Nil(t, parseExcludeRule("", "# comment"))
+    require.Nil(t, parseExcludeRule("", "  "))
 }
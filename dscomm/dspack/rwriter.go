@@ -5,7 +5,9 @@
 package dspack
 
 import (
+    "bytes"
     "errors"
+    "fmt"
     "encoding/binary"
     "hash"
     "math/rand"
@@ -17,11 +19,22 @@ import (
 
 const headerSize    int64   = 8 * 6
 const tailendSize   int64   = 8 * 5
+const footerSize    int64   = 8 * 4
 const sizeOfInt64   int     = 8
 
+// maxDescrSize bounds sizes of descrs read from the archive.
+const maxDescrSize  int64   = 64 * 1024 * 1024
+
 const DTypeFile     int64 = 1 << 0
 const DTypeSlink    int64 = 1 << 1
 const DTypeDir      int64 = 1 << 2
+const DTypeIndex    int64 = 1 << 3
+const DTypeHlink    int64 = 1 << 4
+const DTypeBlock    int64 = 1 << 5
+const DTypeChar     int64 = 1 << 6
+const DTypeFifo     int64 = 1 << 7
+const DTypeSocket   int64 = 1 << 8
+const DTypeDelete   int64 = 1 << 9
 
 const HWHashInitSize int64 = 32
 const HWHashSumSize  int64 = 32
@@ -29,8 +42,23 @@ const HWHashSumSize  int64 = 32
 const HashTypeHW    string = "hw"
 const HashTypeNone  string = "none"
 
+// Head descrs of entries with framed bins have version 4, of sparse
+// entries version 3, of compressed entries version 2, other head
+// descrs keep version 1.
+const headDescrVersion1     int64 = 1
+const headDescrVersion2     int64 = 2
+const headDescrVersion3     int64 = 3
+const headDescrVersion4     int64 = 4
+
+// errNoIndex is returned by sequential readers for the archive
+// ended before the index chunk and the footer.
+var errNoIndex = fmt.Errorf("archive is truncated, index and footer not found: %w", io.ErrUnexpectedEOF)
+
 type Writer struct {
     byteWriter  io.Writer
+    codec       string
+    pos         int64
+    index       []*IndexItem
     hashInit    []byte
     hashSum     []byte
     hasher      hash.Hash
@@ -44,10 +72,32 @@ func NewWriter(byteWriter io.Writer) *Writer {
     rand.Read(writer.hashInit)
     writer.hashSum   = make([]byte, HWHashSumSize)
     writer.hasher, _ = highwayhash.New(writer.hashInit)
+    writer.index     = make([]*IndexItem, 0)
+    writer.codec     = CodecNone
 
     return &writer
 }
 
+func (writer *Writer) SetCodec(codec string) error {
+    var err error
+    if !ValidCodec(codec) {
+        err = fmt.Errorf("unknown codec %s", codec)
+        return err
+    }
+    writer.codec = codec
+    return err
+}
+
+func (writer *Writer) write(data []byte) error {
+    var err error
+    written, err := writer.byteWriter.Write(data)
+    writer.pos += int64(written)
+    if err != nil {
+        return err
+    }
+    return err
+}
+
 func (writer *Writer) WriteHeadDescr(headDescr *HeadDescr) error {
     var err error
 
@@ -59,15 +109,30 @@ func (writer *Writer) WriteHeadDescr(headDescr *HeadDescr) error {
     header := NewHeader()
     header.HeadDescrSize = int64(len(headDescrBin))
     header.BinSize = headDescr.Size
+    if headDescr.Codec != "" && headDescr.Codec != CodecNone {
+        header.HeadDescrVersion = headDescrVersion2
+    }
+    if headDescr.IsSparse() {
+        header.HeadDescrVersion = headDescrVersion3
+    }
+    if headDescr.Framed {
+        header.HeadDescrVersion = headDescrVersion4
+    }
     headerBin, err := header.Pack()
     if err != nil {
         return err
     }
-    _, err = writer.byteWriter.Write(headerBin)
+    if headDescr.Type != DTypeIndex {
+        indexItem := NewIndexItem()
+        indexItem.Offset = writer.pos
+        indexItem.Descr  = headDescr
+        writer.index = append(writer.index, indexItem)
+    }
+    err = writer.write(headerBin)
     if err != nil {
         return err
     }
-    _, err = writer.byteWriter.Write(headDescrBin)
+    err = writer.write(headDescrBin)
     if err != nil {
         return err
     }
@@ -79,6 +144,7 @@ func (writer *Writer) WriteBin(reader io.Reader, binSize int64) (int64, error) {
 
     mWriter := io.MultiWriter(writer.byteWriter, writer.hasher)
     written, err := copy(reader, mWriter, binSize)
+    writer.pos += written
     if err != nil {
         return written, err
     }
@@ -86,6 +152,120 @@ func (writer *Writer) WriteBin(reader io.Reader, binSize int64) (int64, error) {
 }
 
 
+// WriteData writes the bin of the size, the bin is padded with zeros
+// if the reader ends early. The count of bytes read is returned.
+func (writer *Writer) WriteData(reader io.Reader, binSize int64) (int64, error) {
+    var err error
+
+    mWriter := io.MultiWriter(writer.byteWriter, writer.hasher)
+    read, err := copyPadded(reader, mWriter, binSize)
+    if err != nil {
+        return read, err
+    }
+    writer.pos += binSize
+    return read, err
+}
+
+// WriteFile writes the file entry with the head descr, the bin and
+// the tail descr. The bin is compressed with the writer codec into
+// the bin frames if the file is compressible, the hash sum is taken
+// over original data.
+// Files changed while reading keep the declared size, the tail descr
+// keeps the size of read data and the file status.
+func (writer *Writer) WriteFile(headDescr *HeadDescr, file io.ReadSeeker) error {
+    var err error
+
+    compress, err := compressible(writer.codec, headDescr.Path, headDescr.Size, file)
+    if err != nil {
+        return err
+    }
+    if compress {
+        return writer.writeFramed(headDescr, file)
+    }
+    return writer.WriteStream(headDescr, file)
+}
+
+// writeFramed writes the file entry of the head descr size from
+// the reader compressing the data into the bin frames.
+func (writer *Writer) writeFramed(headDescr *HeadDescr, reader io.Reader) error {
+    var err error
+
+    writer.hasher.Reset()
+    headDescr.HType = HashTypeHW
+    headDescr.HInit = writer.hashInit
+    dataSize := headDescr.Size
+
+    headDescr.Codec    = writer.codec
+    headDescr.OrigSize = dataSize
+    headDescr.Size     = 0
+    headDescr.Framed   = true
+    err = writer.WriteHeadDescr(headDescr)
+    if err != nil {
+        return err
+    }
+    frames := newFrameWriter(writer)
+    encoder, err := newEncoder(writer.codec, frames)
+    if err != nil {
+        return err
+    }
+    readSize, err := copyPadded(reader, io.MultiWriter(encoder, writer.hasher), dataSize)
+    if err != nil {
+        return err
+    }
+    err = encoder.Close()
+    if err != nil {
+        return err
+    }
+    err = frames.Close()
+    if err != nil {
+        return err
+    }
+    status := fileStatus(headDescr, dataSize, readSize, reader)
+    headDescr.Status = status
+    tailDescr := NewTailDescr()
+    tailDescr.HSum   = writer.hasher.Sum(nil)
+    tailDescr.Size   = readSize
+    tailDescr.Status = status
+    writer.hashSum = tailDescr.HSum
+    err = writer.WriteTailDescr(tailDescr)
+    if err != nil {
+        return err
+    }
+    return err
+}
+
+// WriteStream writes the file entry of the head descr size from
+// the reader without compression, the hash sum is taken while writing.
+func (writer *Writer) WriteStream(headDescr *HeadDescr, reader io.Reader) error {
+    var err error
+
+    writer.hasher.Reset()
+    headDescr.HType = HashTypeHW
+    headDescr.HInit = writer.hashInit
+    headDescr.Codec = CodecNone
+    dataSize := headDescr.Size
+
+    err = writer.WriteHeadDescr(headDescr)
+    if err != nil {
+        return err
+    }
+    readSize, err := writer.WriteData(reader, dataSize)
+    if err != nil {
+        return err
+    }
+    headDescr.Status = fileStatus(headDescr, dataSize, readSize, reader)
+    tailDescr := NewTailDescr()
+    tailDescr.HSum   = writer.hasher.Sum(nil)
+    tailDescr.Size   = readSize
+    tailDescr.Status = headDescr.Status
+    writer.hashSum = tailDescr.HSum
+    err = writer.WriteTailDescr(tailDescr)
+    if err != nil {
+        return err
+    }
+    return err
+}
+
 func (writer *Writer) WriteTailDescr(tailDescr *TailDescr) error {
     var err error
     tailDescrBin, err := tailDescr.Pack()
@@ -99,11 +279,53 @@ func (writer *Writer) WriteTailDescr(tailDescr *TailDescr) error {
     if err != nil {
         return err
     }
-    _, err = writer.byteWriter.Write(tailendBin)
+    err = writer.write(tailendBin)
+    if err != nil {
+        return err
+    }
+    err = writer.write(tailDescrBin)
+    if err != nil {
+        return err
+    }
+    return err
+}
+
+// WriteIndex writes the index chunk with offsets of all written
+// head descrs and the footer pointing to the chunk. The index
+// must be written last.
+func (writer *Writer) WriteIndex() error {
+    var err error
+
+    indexDescr := NewIndexDescr()
+    indexDescr.Items = writer.index
+    indexDescrBin, err := indexDescr.Pack()
     if err != nil {
         return err
     }
-    _, err = writer.byteWriter.Write(tailDescrBin)
+    footer := NewFooter()
+    footer.IndexOffset = writer.pos
+
+    headDescr := NewHeadDescr()
+    headDescr.Type  = DTypeIndex
+    headDescr.Size  = int64(len(indexDescrBin))
+    headDescr.HType = HashTypeNone
+    err = writer.WriteHeadDescr(headDescr)
+    if err != nil {
+        return err
+    }
+    err = writer.write(indexDescrBin)
+    if err != nil {
+        return err
+    }
+    err = writer.WriteTailDescr(NewTailDescr())
+    if err != nil {
+        return err
+    }
+    footerBin, err := footer.Pack()
+    if err != nil {
+        return err
+    }
+    err = writer.write(footerBin)
     if err != nil {
         return err
     }
@@ -117,6 +339,7 @@ type Reader struct {
     hashInit    []byte
     hashSum     []byte
     hasher      hash.Hash
+    frames      *frameReader
 }
 
 func NewReader(byteReader io.Reader) *Reader {
@@ -135,17 +358,29 @@ func (reader *Reader) ReadHeadDescr() (*HeadDescr, error) {
     var headDescr *HeadDescr
 
     headerBin := make([]byte, headerSize)
-    _, err = reader.byteReader.Read(headerBin)
+    _, err = io.ReadFull(reader.byteReader, headerBin)
     if err != nil {
         return headDescr, err
     }
 
     header, err := UnpackHeader(headerBin)
+    if err != nil && IsEncrypted(headerBin) {
+        err = errors.New("archive is encrypted, identity or passphrase required")
+        return headDescr, err
+    }
     if err != nil {
         return headDescr, err
     }
+    if header.HeadDescrVersion > headDescrVersion4 {
+        err = fmt.Errorf("unsupported head descr version %d", header.HeadDescrVersion)
+        return headDescr, err
+    }
+    if header.HeadDescrSize <= 0 || header.HeadDescrSize > maxDescrSize {
+        err = fmt.Errorf("wrong head descr size %d", header.HeadDescrSize)
+        return headDescr, err
+    }
     headDescrBin := make([]byte, header.HeadDescrSize)
-    _, err = reader.byteReader.Read(headDescrBin)
+    _, err = io.ReadFull(reader.byteReader, headDescrBin)
     if err != nil {
         return headDescr, err
     }
@@ -167,12 +402,117 @@ func (reader *Reader) ReadBin(writer io.Writer, binSize int64) (int64, error) {
     return read, err
 }
 
+// ReadData reads the bin of the file entry decompressing it into
+// the writer and the tail descr. The match is true if the hash sum of
+// the data is valid. Legacy archives have zero hash sums, the data
+// of them are not verified.
+func (reader *Reader) ReadData(headDescr *HeadDescr, writer io.Writer) (bool, error) {
+    _, match, err := reader.readData(headDescr, writer)
+    return match, err
+}
+
+// readData reads the file bin like ReadData and returns the tail descr.
+func (reader *Reader) readData(headDescr *HeadDescr, writer io.Writer) (*TailDescr, bool, error) {
+    var err error
+    var match bool
+    var tailDescr *TailDescr
+
+    hasher, err := highwayhash.New(headDescr.HInit)
+    if err != nil {
+        hasher, _ = highwayhash.New(make([]byte, HWHashInitSize))
+    }
+    mWriter := io.MultiWriter(writer, hasher)
+
+    switch headDescr.Codec {
+        case "", CodecNone:
+            _, err = copy(reader.byteReader, mWriter, headDescr.Size)
+            if err != nil {
+                return tailDescr, match, err
+            }
+        default:
+            binReader := io.LimitReader(reader.byteReader, headDescr.Size)
+            if headDescr.Framed {
+                reader.frames = newFrameReader(reader.byteReader)
+                binReader = reader.frames
+            }
+            decoder, err := newDecoder(headDescr.Codec, binReader)
+            if err != nil {
+                return tailDescr, match, err
+            }
+            defer decoder.Close()
+            _, err = io.CopyN(mWriter, decoder, headDescr.OrigSize)
+            if err != nil {
+                return tailDescr, match, err
+            }
+            _, err = io.Copy(io.Discard, binReader)
+            if err != nil {
+                return tailDescr, match, err
+            }
+    }
+    reader.frames = nil
+    tailDescr, err = reader.ReadTailDescr()
+    if err != nil {
+        return tailDescr, match, err
+    }
+    headDescr.Status = tailDescr.Status
+    hashSum := hasher.Sum(nil)
+    switch {
+        case headDescr.HType == HashTypeNone:
+            match = true
+        case bytes.Equal(tailDescr.HSum, make([]byte, HWHashSumSize)):
+            match = true
+        case bytes.Equal(tailDescr.HSum, hashSum):
+            match = true
+    }
+    return tailDescr, match, err
+}
+
+// SkipBin skips the bin seeking the seekable input
+// and reading other inputs.
+func (reader *Reader) SkipBin(binSize int64) error {
+    var err error
+    seeker, ok := reader.byteReader.(io.Seeker)
+    if ok {
+        _, err = seeker.Seek(binSize, io.SeekCurrent)
+        if err == nil {
+            return err
+        }
+    }
+    _, err = copy(reader.byteReader, io.Discard, binSize)
+    if err != nil {
+        return err
+    }
+    return err
+}
+
+// SkipData skips the bin of the entry like SkipBin,
+// framed bins are skipped frame by frame.
+func (reader *Reader) SkipData(headDescr *HeadDescr) error {
+    if headDescr.Framed {
+        return newFrameReader(reader.byteReader).skip()
+    }
+    return reader.SkipBin(headDescr.Size)
+}
+
+// skipFrames skips the rest of the framed bin which
+// reading was broken by the decoding error.
+func (reader *Reader) skipFrames() error {
+    var err error
+    if reader.frames == nil {
+        err = errors.New("framed bin is not read")
+        return err
+    }
+    err = reader.frames.skip()
+    reader.frames = nil
+    return err
+}
+
 func (reader *Reader) ReadTailDescr() (*TailDescr, error) {
     var err error
     var tailDescr *TailDescr
 
     tailendBin := make([]byte, tailendSize)
-    _, err = reader.byteReader.Read(tailendBin)
+    _, err = io.ReadFull(reader.byteReader, tailendBin)
     if err != nil {
         return tailDescr, err
     }
@@ -181,8 +521,12 @@ func (reader *Reader) ReadTailDescr() (*TailDescr, error) {
     if err != nil {
         return tailDescr, err
     }
+    if tailend.TailDescrSize <= 0 || tailend.TailDescrSize > maxDescrSize {
+        err = fmt.Errorf("wrong tail descr size %d", tailend.TailDescrSize)
+        return tailDescr, err
+    }
     tailDescrBin := make([]byte, tailend.TailDescrSize)
-    _, err = reader.byteReader.Read(tailDescrBin)
+    _, err = io.ReadFull(reader.byteReader, tailDescrBin)
     if err != nil {
         return tailDescr, err
     }
@@ -193,6 +537,44 @@ func (reader *Reader) ReadTailDescr() (*TailDescr, error) {
     return tailDescr, err
 }
 
+// ReadIndexDescr reads the bin of the index chunk with the tail
+// descr and the footer closing the archive.
+func (reader *Reader) ReadIndexDescr(headDescr *HeadDescr) (*IndexDescr, error) {
+    var err error
+    var indexDescr *IndexDescr
+
+    if headDescr.Size < 0 {
+        err = fmt.Errorf("wrong index size %d", headDescr.Size)
+        return indexDescr, err
+    }
+    indexDescrBin, err := io.ReadAll(io.LimitReader(reader.byteReader, headDescr.Size))
+    if err != nil {
+        return indexDescr, err
+    }
+    if int64(len(indexDescrBin)) != headDescr.Size {
+        err = io.ErrUnexpectedEOF
+        return indexDescr, err
+    }
+    indexDescr, err = UnpackIndexDescr(indexDescrBin)
+    if err != nil {
+        return indexDescr, err
+    }
+    _, err = reader.ReadTailDescr()
+    if err != nil {
+        return indexDescr, err
+    }
+    footerBin := make([]byte, footerSize)
+    _, err = io.ReadFull(reader.byteReader, footerBin)
+    if err != nil {
+        return indexDescr, err
+    }
+    _, err = UnpackFooter(footerBin)
+    if err != nil {
+        return indexDescr, err
+    }
+    return indexDescr, err
+}
+
 //func (reader *Reader) ReadHashSum() (bool, error) {
 //    var err error
 //    var match bool
@@ -242,6 +624,26 @@ func copy(reader io.Reader, writer io.Writer, size int64) (int64, error) {
     return total, err
 }
 
+// copyPadded copies the size of data and pads the data with zeros
+// if the reader ends early. The count of bytes read is returned.
+func copyPadded(reader io.Reader, writer io.Writer, size int64) (int64, error) {
+    var err error
+    read, err := io.CopyN(writer, reader, size)
+    if err == io.EOF || err == io.ErrUnexpectedEOF {
+        _, err = io.CopyN(writer, zeroReader{}, size - read)
+    }
+    return read, err
+}
+
+type zeroReader struct{}
+
+func (zeroReader) Read(buffer []byte) (int, error) {
+    for i := range buffer {
+        buffer[i] = 0
+    }
+    return len(buffer), nil
+}
+
 func encoderI64(i int64) []byte {
     buffer := make([]byte, sizeOfInt64)
     binary.BigEndian.PutUint64(buffer, uint64(i))
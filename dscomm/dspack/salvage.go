@@ -0,0 +1,261 @@
+/*
+ * Copyright 2022 Oleg Borodin  <borodin@unix7.org>
+ */
+
+package dspack
+
+import (
+    "bytes"
+    "errors"
+    "fmt"
+    "io"
+    "os"
+)
+
+const scanBlockSize int = 64 * 1024
+
+// DamagedRange is the range of the plain archive stream skipped
+// by salvaging. The path is set if the head descr of the chunk
+// at the offset is readable.
+type DamagedRange struct {
+    Offset  int64       `json:"offset"`
+    Size    int64       `json:"size"`
+    Path    string      `json:"path,omitempty"`
+    Error   string      `json:"error"`
+}
+
+// Salvage restores entries of the damaged archive selected by
+// the options into the base dir. Chunks are validated before
+// unpacking, after a damaged chunk the input is scanned for
+// the next valid chunk and unpacking goes on. Entries which cannot
+// be restored are skipped too. Skipped ranges are returned.
+func Salvage(readSeeker io.ReadSeeker, baseDir string, options *UnpackOptions) ([]*HeadDescr, []*DamagedRange, error) {
+    var err error
+    descrs := make([]*HeadDescr, 0)
+    damaged := make([]*DamagedRange, 0)
+
+    // The last chunk of the damaged encrypted archive may
+    // be lost, so the size is taken from the source size
+    var size int64
+    if options.Keys != nil {
+        decReader, err := NewDecryptReader(readSeeker, options.Keys)
+        if err != nil {
+            return descrs, damaged, err
+        }
+        size, err = decReader.estimateSize()
+        if err != nil {
+            return descrs, damaged, err
+        }
+        readSeeker = decReader
+    } else {
+        size, err = readSeeker.Seek(0, io.SeekEnd)
+        if err != nil {
+            return descrs, damaged, err
+        }
+    }
+    unpacker := newUnpacker(baseDir, options)
+    unpacker.readSeeker = readSeeker
+
+    addDamaged := func(offset, end int64, headDescr *HeadDescr, err error) {
+        damagedRange := &DamagedRange{
+            Offset: offset,
+            Size:   end - offset,
+            Error:  err.Error(),
+        }
+        if headDescr != nil {
+            damagedRange.Path = headDescr.Path
+        }
+        damaged = append(damaged, damagedRange)
+    }
+
+    var offset int64
+    for offset < size {
+        headDescr, end, err := checkChunk(readSeeker, offset)
+        if err != nil {
+            next, scanErr := scanChunk(readSeeker, offset + 1, size)
+            if scanErr != nil {
+                return descrs, damaged, scanErr
+            }
+            if next < 0 {
+                next = size
+            }
+            addDamaged(offset, next, headDescr, err)
+            offset = next
+            continue
+        }
+        if headDescr.Type == DTypeIndex {
+            break
+        }
+        if headDescr.Type == DTypeDir {
+            unpacker.archDirs[headDescr.Path] = headDescr
+        }
+        if !options.Match(headDescr.Path) {
+            offset = end
+            continue
+        }
+        _, err = readSeeker.Seek(offset, io.SeekStart)
+        if err != nil {
+            return descrs, damaged, err
+        }
+        reader := NewReader(readSeeker)
+        headDescr, err = reader.ReadHeadDescr()
+        if err == nil {
+            err = unpacker.unpackEntry(reader, headDescr)
+        }
+        if err != nil {
+            if headDescr != nil && headDescr.Type == DTypeFile {
+                _, unpackPath, pathErr := unpacker.entryPath(headDescr.Path)
+                if pathErr == nil {
+                    os.Remove(unpackPath + ".tmp")
+                }
+            }
+            addDamaged(offset, end, headDescr, err)
+            offset = end
+            continue
+        }
+        descrs = append(descrs, headDescr)
+        offset = end
+    }
+    err = unpacker.setDirsMeta()
+    if err != nil {
+        return descrs, damaged, err
+    }
+    return descrs, damaged, err
+}
+
+// checkChunk validates the header, the head descr and the tail
+// of the chunk at the offset and returns the end offset of the chunk.
+// The head descr is returned if it is readable.
+func checkChunk(readSeeker io.ReadSeeker, offset int64) (*HeadDescr, int64, error) {
+    var err error
+    var headDescr *HeadDescr
+    var end int64
+
+    _, err = readSeeker.Seek(offset, io.SeekStart)
+    if err != nil {
+        return headDescr, end, err
+    }
+    headerBin := make([]byte, headerSize)
+    _, err = io.ReadFull(readSeeker, headerBin)
+    if err != nil {
+        return headDescr, end, err
+    }
+    header, err := UnpackHeader(headerBin)
+    if err != nil {
+        return headDescr, end, err
+    }
+    if header.HeadDescrVersion < headDescrVersion1 || header.HeadDescrVersion > headDescrVersion4 {
+        err = fmt.Errorf("wrong head descr version %d", header.HeadDescrVersion)
+        return headDescr, end, err
+    }
+    if header.HeadDescrSize <= 0 || header.HeadDescrSize > maxDescrSize {
+        err = fmt.Errorf("wrong head descr size %d", header.HeadDescrSize)
+        return headDescr, end, err
+    }
+    headDescrBin := make([]byte, header.HeadDescrSize)
+    _, err = io.ReadFull(readSeeker, headDescrBin)
+    if err != nil {
+        return headDescr, end, err
+    }
+    headDescr, err = UnpackHeadDescr(headDescrBin)
+    if err != nil {
+        return nil, end, err
+    }
+    if !validType(headDescr.Type) || headDescr.Size < 0 {
+        err = errors.New("wrong head descr")
+        return nil, end, err
+    }
+    binEnd := offset + headerSize + header.HeadDescrSize + headDescr.Size
+    _, err = readSeeker.Seek(binEnd, io.SeekStart)
+    if err != nil {
+        return headDescr, end, err
+    }
+    if headDescr.Framed {
+        err = newFrameReader(readSeeker).skip()
+        if err != nil {
+            return headDescr, end, err
+        }
+        binEnd, err = readSeeker.Seek(0, io.SeekCurrent)
+        if err != nil {
+            return headDescr, end, err
+        }
+    }
+    tailendBin := make([]byte, tailendSize)
+    _, err = io.ReadFull(readSeeker, tailendBin)
+    if err != nil {
+        return headDescr, end, err
+    }
+    tailend, err := UnpackTailend(tailendBin)
+    if err != nil {
+        return headDescr, end, err
+    }
+    if tailend.TailDescrSize <= 0 || tailend.TailDescrSize > maxDescrSize {
+        err = fmt.Errorf("wrong tail descr size %d", tailend.TailDescrSize)
+        return headDescr, end, err
+    }
+    tailDescrBin := make([]byte, tailend.TailDescrSize)
+    _, err = io.ReadFull(readSeeker, tailDescrBin)
+    if err != nil {
+        return headDescr, end, err
+    }
+    _, err = UnpackTailDescr(tailDescrBin)
+    if err != nil {
+        return headDescr, end, err
+    }
+    end = binEnd + tailendSize + tailend.TailDescrSize
+    return headDescr, end, err
+}
+
+// scanChunk returns the offset of the next valid chunk starting
+// from the offset or -1 if the input has no valid chunks.
+// Encrypted chunks which cannot be opened are skipped.
+func scanChunk(readSeeker io.ReadSeeker, offset, size int64) (int64, error) {
+    var err error
+    magic := encoderI64(magicCodeA)
+    buffer := make([]byte, scanBlockSize)
+
+    for offset < size {
+        _, err = readSeeker.Seek(offset, io.SeekStart)
+        if err != nil {
+            return -1, err
+        }
+        read, err := io.ReadFull(readSeeker, buffer)
+        next := offset + int64(read - len(magic) + 1)
+        var authErr *chunkAuthError
+        if errors.As(err, &authErr) {
+            next = (authErr.chunkId + 1) * cryptChunkSize
+            err = nil
+        }
+        if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+            return -1, err
+        }
+        data := buffer[0:read]
+        start := 0
+        for {
+            index := bytes.Index(data[start:], magic)
+            if index < 0 {
+                break
+            }
+            candidate := offset + int64(start + index)
+            _, _, checkErr := checkChunk(readSeeker, candidate)
+            if checkErr == nil {
+                return candidate, nil
+            }
+            start += index + 1
+        }
+        if authErr == nil && read < len(buffer) {
+            break
+        }
+        offset = next
+    }
+    return -1, nil
+}
+
+func validType(dType int64) bool {
+    switch dType {
+        case DTypeFile, DTypeSlink, DTypeDir, DTypeIndex, DTypeHlink,
+                DTypeBlock, DTypeChar, DTypeFifo, DTypeSocket, DTypeDelete:
+            return true
+    }
+    return false
+}
@@ -0,0 +1,67 @@
+/*
+ * Copyright 2022 Oleg Borodin  <borodin@unix7.org>
+ */
+
+package dspack
+
+import (
+    "path/filepath"
+    "strings"
+)
+
+// UnpackOptions selects archive entries for unpacking and listing.
+// A pattern is a glob or a path prefix, the pattern matches the entry
+// if it matches the entry path or any parent dir of the entry.
+// Empty include list selects all entries, excludes take precedence.
+// Keys decrypt the encrypted archive. Extended attributes and file
+// flags are restored by root unless SkipAttrs is set.
+type UnpackOptions struct {
+    Include     []string
+    Exclude     []string
+    Keys        *CryptKeys
+    SkipAttrs   bool
+}
+
+func NewUnpackOptions() *UnpackOptions {
+    var options UnpackOptions
+    options.Include = make([]string, 0)
+    options.Exclude = make([]string, 0)
+    return &options
+}
+
+func (options *UnpackOptions) SelectAll() bool {
+    return len(options.Include) == 0 && len(options.Exclude) == 0
+}
+
+func (options *UnpackOptions) Match(entryPath string) bool {
+    for _, pattern := range options.Exclude {
+        if matchPattern(pattern, entryPath) {
+            return false
+        }
+    }
+    if len(options.Include) == 0 {
+        return true
+    }
+    for _, pattern := range options.Include {
+        if matchPattern(pattern, entryPath) {
+            return true
+        }
+    }
+    return false
+}
+
+func matchPattern(pattern, entryPath string) bool {
+    pattern = strings.TrimLeft(filepath.Clean(pattern), "/")
+    entryPath = strings.TrimLeft(filepath.Clean(entryPath), "/")
+    for entryPath != "." && entryPath != "" {
+        if entryPath == pattern {
+            return true
+        }
+        match, _ := filepath.Match(pattern, entryPath)
+        if match {
+            return true
+        }
+        entryPath = filepath.Dir(entryPath)
+    }
+    return false
+}
@@ -0,0 +1,150 @@
+/*
+ * Copyright 2022 Oleg Borodin  <borodin@unix7.org>
+ */
+
+package dspack
+
+import (
+    "errors"
+    "io"
+    "io/fs"
+    "os"
+)
+
+// SparseRegion is the data region of the sparse file,
+// the space between regions is the hole.
+type SparseRegion struct {
+    Offset  int64       `json:"offset"`
+    Size    int64       `json:"size"`
+}
+
+// sparseDataSize returns the total size of data regions.
+func sparseDataSize(regions []SparseRegion) int64 {
+    var size int64
+    for _, region := range regions {
+        size += region.Size
+    }
+    return size
+}
+
+// regionReader reads data regions of the file as the solid stream.
+type regionReader struct {
+    file        io.ReadSeeker
+    regions     []SparseRegion
+    index       int
+    regionPos   int64
+    pos         int64
+}
+
+func newRegionReader(file io.ReadSeeker, regions []SparseRegion) *regionReader {
+    var reader regionReader
+    reader.file    = file
+    reader.regions = regions
+    return &reader
+}
+
+func (reader *regionReader) Read(buffer []byte) (int, error) {
+    var err error
+    for reader.index < len(reader.regions) && reader.regionPos >= reader.regions[reader.index].Size {
+        reader.index++
+        reader.regionPos = 0
+    }
+    if reader.index >= len(reader.regions) {
+        return 0, io.EOF
+    }
+    region := reader.regions[reader.index]
+    _, err = reader.file.Seek(region.Offset + reader.regionPos, io.SeekStart)
+    if err != nil {
+        return 0, err
+    }
+    rest := region.Size - reader.regionPos
+    if int64(len(buffer)) > rest {
+        buffer = buffer[0:rest]
+    }
+    read, err := reader.file.Read(buffer)
+    reader.regionPos += int64(read)
+    reader.pos += int64(read)
+    if err == io.EOF {
+        err = io.ErrUnexpectedEOF
+    }
+    return read, err
+}
+
+// Stat returns the file info of the underlying file.
+func (reader *regionReader) Stat() (fs.FileInfo, error) {
+    var err error
+    var fileInfo fs.FileInfo
+    statFile, ok := reader.file.(interface{ Stat() (fs.FileInfo, error) })
+    if !ok {
+        err = errors.New("file stat is not supported")
+        return fileInfo, err
+    }
+    return statFile.Stat()
+}
+
+func (reader *regionReader) Seek(offset int64, whence int) (int64, error) {
+    var err error
+    switch whence {
+        case io.SeekCurrent:
+            offset += reader.pos
+        case io.SeekEnd:
+            offset += sparseDataSize(reader.regions)
+    }
+    if offset < 0 {
+        err = errors.New("negative seek position")
+        return reader.pos, err
+    }
+    reader.pos = offset
+    reader.index = 0
+    for reader.index < len(reader.regions) && offset >= reader.regions[reader.index].Size {
+        offset -= reader.regions[reader.index].Size
+        reader.index++
+    }
+    reader.regionPos = offset
+    return reader.pos, err
+}
+
+// regionWriter writes the solid stream into data regions of the file,
+// holes are left unwritten.
+type regionWriter struct {
+    file        *os.File
+    regions     []SparseRegion
+    index       int
+    regionPos   int64
+}
+
+func newRegionWriter(file *os.File, regions []SparseRegion) *regionWriter {
+    var writer regionWriter
+    writer.file    = file
+    writer.regions = regions
+    return &writer
+}
+
+func (writer *regionWriter) Write(data []byte) (int, error) {
+    var err error
+    var written int
+    for len(data) > 0 {
+        for writer.index < len(writer.regions) && writer.regionPos >= writer.regions[writer.index].Size {
+            writer.index++
+            writer.regionPos = 0
+        }
+        if writer.index >= len(writer.regions) {
+            err = errors.New("sparse data exceed file regions")
+            return written, err
+        }
+        region := writer.regions[writer.index]
+        chunk := data
+        rest := region.Size - writer.regionPos
+        if int64(len(chunk)) > rest {
+            chunk = chunk[0:rest]
+        }
+        wrote, err := writer.file.WriteAt(chunk, region.Offset + writer.regionPos)
+        written += wrote
+        writer.regionPos += int64(wrote)
+        if err != nil {
+            return written, err
+        }
+        data = data[wrote:]
+    }
+    return written, err
+}
@@ -0,0 +1,20 @@
+//go:build freebsd || netbsd || openbsd || darwin
+// +build freebsd netbsd openbsd darwin
+
+/*
+ * Copyright 2022 Oleg Borodin  <borodin@unix7.org>
+ */
+
+package dspack
+
+import (
+    "os"
+    "syscall"
+)
+
+// sparseRegions reports files as not sparse, files are packed as solid.
+func sparseRegions(file *os.File, sysStat *syscall.Stat_t) ([]SparseRegion, bool, error) {
+    var err error
+    regions := make([]SparseRegion, 0)
+    return regions, false, err
+}
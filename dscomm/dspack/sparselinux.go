@@ -0,0 +1,61 @@
+//go:build linux
+// +build linux
+
+/*
+ * Copyright 2022 Oleg Borodin  <borodin@unix7.org>
+ */
+
+package dspack
+
+import (
+    "errors"
+    "io"
+    "os"
+    "syscall"
+
+    "golang.org/x/sys/unix"
+)
+
+// sparseRegions returns data regions of the file with holes,
+// solid files and file systems without SEEK_DATA support
+// are reported as not sparse.
+func sparseRegions(file *os.File, sysStat *syscall.Stat_t) ([]SparseRegion, bool, error) {
+    var err error
+    regions := make([]SparseRegion, 0)
+
+    size := sysStat.Size
+    if size == 0 || sysStat.Blocks * 512 >= size {
+        return regions, false, err
+    }
+    defer file.Seek(0, io.SeekStart)
+
+    var offset int64
+    for offset < size {
+        dataOffset, err := file.Seek(offset, unix.SEEK_DATA)
+        if errors.Is(err, syscall.ENXIO) {
+            break
+        }
+        if errors.Is(err, syscall.EINVAL) {
+            return regions, false, nil
+        }
+        if err != nil {
+            return regions, false, err
+        }
+        holeOffset, err := file.Seek(dataOffset, unix.SEEK_HOLE)
+        if err != nil {
+            return regions, false, err
+        }
+        if holeOffset > size {
+            holeOffset = size
+        }
+        if holeOffset > dataOffset {
+            region := SparseRegion{ Offset: dataOffset, Size: holeOffset - dataOffset }
+            regions = append(regions, region)
+        }
+        offset = holeOffset
+    }
+    if sparseDataSize(regions) == size {
+        return regions, false, nil
+    }
+    return regions, true, nil
+}
@@ -0,0 +1,103 @@
+//go:build linux
+// +build linux
+
+/*
+ * Copyright 2022 Oleg Borodin  <borodin@unix7.org>
+ */
+
+package dspack
+
+import(
+    "archive/tar"
+    "bytes"
+    "crypto/sha256"
+    "io"
+    "os"
+    "path/filepath"
+    "syscall"
+    "testing"
+
+    "github.com/stretchr/testify/require"
+)
+
+func TestPackSparse(t *testing.T) {
+    var err error
+
+    srcDir := t.TempDir()
+    destDir := t.TempDir()
+
+    const fileSize int64 = 256 * 1024 * 1024
+    head := bytes.Repeat([]byte("sparse head "), 1000)
+    tail := bytes.Repeat([]byte{ 0xAB }, 100000)
+
+    filePath := filepath.Join(srcDir, "disk.img")
+    file, err := os.Create(filePath)
+    require.NoError(t, err)
+    _, err = file.Write(head)
+    require.NoError(t, err)
+    _, err = file.WriteAt(tail, 128 * 1024 * 1024)
+    require.NoError(t, err)
+    err = file.Truncate(fileSize)
+    require.NoError(t, err)
+    file.Close()
+
+    for _, codec := range []string{ CodecNone, CodecZstd } {
+        packPath := filepath.Join(destDir, codec + ".pack")
+        packFile, err := os.OpenFile(packPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+        require.NoError(t, err)
+        options := NewPackOptions()
+        options.Codec = codec
+        err = PackWithOptions([]string{ filePath }, packFile, options)
+        require.NoError(t, err)
+        packFile.Close()
+
+        packInfo, err := os.Stat(packPath)
+        require.NoError(t, err)
+        require.Less(t, packInfo.Size(), int64(1024 * 1024))
+
+        packFile, err = os.OpenFile(packPath, os.O_RDONLY, 0)
+        require.NoError(t, err)
+        defer packFile.Close()
+
+        unpackDir := filepath.Join(destDir, codec)
+        descrs, err := Unpack(packFile, unpackDir)
+        require.NoError(t, err)
+        require.Equal(t, 1, len(descrs))
+        require.True(t, descrs[0].IsSparse())
+        require.True(t, descrs[0].Match)
+
+        unpackPath := filepath.Join(unpackDir, filePath)
+        var sysStat syscall.Stat_t
+        err = syscall.Stat(unpackPath, &sysStat)
+        require.NoError(t, err)
+        require.Equal(t, fileSize, sysStat.Size)
+        require.Less(t, sysStat.Blocks * 512, int64(4 * 1024 * 1024))
+
+        data, err := os.ReadFile(unpackPath)
+        require.NoError(t, err)
+        require.Equal(t, head, data[0:len(head)])
+        require.Equal(t, tail, data[128 * 1024 * 1024:128 * 1024 * 1024 + len(tail)])
+        require.Equal(t, make([]byte, 4096), data[1024 * 1024:1024 * 1024 + 4096])
+
+        _, err = packFile.Seek(0, io.SeekStart)
+        require.NoError(t, err)
+        tarFile, err := os.Create(filepath.Join(destDir, codec + ".tar"))
+        require.NoError(t, err)
+        defer tarFile.Close()
+        _, err = ExportTar(packFile, tarFile, nil)
+        require.NoError(t, err)
+
+        _, err = tarFile.Seek(0, io.SeekStart)
+        require.NoError(t, err)
+        tarReader := tar.NewReader(tarFile)
+        tarHeader, err := tarReader.Next()
+        require.NoError(t, err)
+        require.Equal(t, fileSize, tarHeader.Size)
+        require.NotEmpty(t, tarHeader.PAXRecords[paxSparse])
+        hasher := sha256.New()
+        _, err = io.Copy(hasher, tarReader)
+        require.NoError(t, err)
+        dataSum := sha256.Sum256(data)
+        require.Equal(t, dataSum[:], hasher.Sum(nil))
+    }
+}
@@ -0,0 +1,19 @@
+//go:build freebsd || netbsd || darwin
+// +build freebsd netbsd darwin
+
+/*
+ * Copyright 2022 Oleg Borodin  <borodin@unix7.org>
+ */
+
+package dspack
+
+import (
+    "syscall"
+)
+
+// setStatTimes fills the descr times from the stat with nanoseconds.
+func setStatTimes(headDescr *HeadDescr, sysStat *syscall.Stat_t) {
+    headDescr.Mtime, headDescr.MtimeNsec = sysStat.Mtimespec.Unix()
+    headDescr.Atime, headDescr.AtimeNsec = sysStat.Atimespec.Unix()
+    headDescr.Ctime, headDescr.CtimeNsec = sysStat.Ctimespec.Unix()
+}
@@ -74,7 +74,7 @@ func UnpackTailend(tailendBytes []byte) (*Tailend, error) {
 
     descrVersionBytes := make([]byte, sizeOfInt64)
     tailendReader.Read(descrVersionBytes)
-    tailend.TailendVersion = decoderI64(descrVersionBytes)
+    tailend.TailDescrVersion = decoderI64(descrVersionBytes)
 
     descrSizeBytes := make([]byte, sizeOfInt64)
     tailendReader.Read(descrSizeBytes)
@@ -0,0 +1,498 @@
+/*
+ * Copyright 2022 Oleg Borodin  <borodin@unix7.org>
+ */
+
+package dspack
+
+import (
+    "archive/tar"
+    "bytes"
+    "encoding/hex"
+    "encoding/json"
+    "errors"
+    "io"
+    "io/fs"
+    "os"
+    "path/filepath"
+    "strconv"
+    "strings"
+    "syscall"
+    "time"
+)
+
+// PAX records of exported tar entries, the hash sum is taken over
+// the bin data, for sparse files over data regions only.
+const paxHashSum    string = "DSPACK.hsum"
+const paxHashType   string = "DSPACK.htype"
+const paxHashInit   string = "DSPACK.hinit"
+const paxStatus     string = "DSPACK.status"
+const paxSparse     string = "DSPACK.sparse"
+const paxFlags      string = "DSPACK.flags"
+const paxXAttr      string = "SCHILY.xattr."
+
+// ExportTar writes entries of the archive to the PAX tar stream.
+// Hash sums, statuses, attributes and sparse maps are kept in PAX
+// records. Sparse files are written with zero holes. Sockets and
+// deletion markers cannot be kept in tar, they are returned with
+// the descr error. Seekable inputs are read once, file data of other
+// inputs are spooled to temp files to write hash sums before the data.
+func ExportTar(ioReader io.Reader, outWriter io.Writer, keys *CryptKeys) ([]*HeadDescr, error) {
+    var err error
+    descrs := make([]*HeadDescr, 0)
+
+    if keys != nil {
+        ioReader, err = NewDecryptReader(ioReader, keys)
+        if err != nil {
+            return descrs, err
+        }
+    }
+    seeker, ok := ioReader.(io.Seeker)
+    if ok {
+        _, err = seeker.Seek(0, io.SeekCurrent)
+        if err != nil {
+            seeker = nil
+        }
+    }
+    reader := NewReader(ioReader)
+    tarWriter := tar.NewWriter(outWriter)
+
+    for {
+        headDescr, err := reader.ReadHeadDescr()
+        if err == io.EOF {
+            return descrs, errNoIndex
+        }
+        if err != nil {
+            return descrs, err
+        }
+        if headDescr.Type == DTypeIndex {
+            _, err = reader.ReadIndexDescr(headDescr)
+            if err != nil {
+                return descrs, err
+            }
+            break
+        }
+        descrs = append(descrs, headDescr)
+        tarHeader, ok := newTarHeader(headDescr)
+        if headDescr.Type == DTypeFile {
+            err = exportFile(reader, seeker, tarWriter, tarHeader, headDescr)
+            if err != nil {
+                return descrs, err
+            }
+            continue
+        }
+        err = skipEntry(reader, headDescr)
+        if err != nil {
+            return descrs, err
+        }
+        if !ok {
+            headDescr.Error = "entry type is not supported by tar"
+            continue
+        }
+        err = tarWriter.WriteHeader(tarHeader)
+        if err != nil {
+            return descrs, err
+        }
+    }
+    err = tarWriter.Close()
+    if err != nil {
+        return descrs, err
+    }
+    return descrs, err
+}
+
+// exportFile writes the tar header with the hash sum of the file entry
+// and the file data.
+func exportFile(reader *Reader, seeker io.Seeker, tarWriter *tar.Writer, tarHeader *tar.Header, headDescr *HeadDescr) error {
+    var err error
+
+    if seeker != nil {
+        binPos, err := seeker.Seek(0, io.SeekCurrent)
+        if err != nil {
+            return err
+        }
+        err = reader.SkipData(headDescr)
+        if err != nil {
+            return err
+        }
+        tailDescr, err := reader.ReadTailDescr()
+        if err != nil {
+            return err
+        }
+        _, err = seeker.Seek(binPos, io.SeekStart)
+        if err != nil {
+            return err
+        }
+        setTarSum(tarHeader, headDescr, tailDescr)
+        err = tarWriter.WriteHeader(tarHeader)
+        if err != nil {
+            return err
+        }
+        dataWriter := newHoleWriter(tarWriter, headDescr)
+        headDescr.Match, err = reader.ReadData(headDescr, dataWriter)
+        if err != nil {
+            return err
+        }
+        return dataWriter.finish()
+    }
+
+    tmpFile, err := os.CreateTemp("", "dspack")
+    if err != nil {
+        return err
+    }
+    defer os.Remove(tmpFile.Name())
+    defer tmpFile.Close()
+
+    dataWriter := newHoleWriter(tmpFile, headDescr)
+    tailDescr, match, err := reader.readData(headDescr, dataWriter)
+    if err != nil {
+        return err
+    }
+    err = dataWriter.finish()
+    if err != nil {
+        return err
+    }
+    headDescr.Match = match
+    setTarSum(tarHeader, headDescr, tailDescr)
+    err = tarWriter.WriteHeader(tarHeader)
+    if err != nil {
+        return err
+    }
+    _, err = tmpFile.Seek(0, io.SeekStart)
+    if err != nil {
+        return err
+    }
+    _, err = io.CopyN(tarWriter, tmpFile, tarHeader.Size)
+    if err != nil {
+        return err
+    }
+    return err
+}
+
+// newTarHeader returns the PAX tar header of the entry,
+// ok is false for entry types not supported by tar.
+func newTarHeader(headDescr *HeadDescr) (*tar.Header, bool) {
+    tarHeader := &tar.Header{
+        Format:     tar.FormatPAX,
+        Name:       strings.TrimLeft(headDescr.Path, "/"),
+        Mode:       tarMode(headDescr.Mode),
+        Uid:        int(headDescr.Uid),
+        Gid:        int(headDescr.Gid),
+        Uname:      headDescr.User,
+        Gname:      headDescr.Group,
+        ModTime:    time.Unix(headDescr.Mtime, headDescr.MtimeNsec),
+        PAXRecords: make(map[string]string),
+    }
+    if headDescr.Atime != 0 {
+        tarHeader.AccessTime = time.Unix(headDescr.Atime, headDescr.AtimeNsec)
+    }
+    if headDescr.Ctime != 0 {
+        tarHeader.ChangeTime = time.Unix(headDescr.Ctime, headDescr.CtimeNsec)
+    }
+    if headDescr.Attrs != nil {
+        for name, value := range headDescr.Attrs.XAttrs {
+            tarHeader.PAXRecords[paxXAttr + name] = string(value)
+        }
+        if headDescr.Attrs.Flags != 0 {
+            tarHeader.PAXRecords[paxFlags] = strconv.FormatUint(uint64(headDescr.Attrs.Flags), 10)
+        }
+    }
+    switch headDescr.Type {
+        case DTypeFile:
+            tarHeader.Typeflag = tar.TypeReg
+            tarHeader.Size = headDescr.Size
+            if headDescr.Codec != "" && headDescr.Codec != CodecNone {
+                tarHeader.Size = headDescr.OrigSize
+            }
+            if headDescr.IsSparse() {
+                tarHeader.Size = headDescr.FileSize
+            }
+        case DTypeDir:
+            tarHeader.Typeflag = tar.TypeDir
+            tarHeader.Name += "/"
+        case DTypeSlink:
+            tarHeader.Typeflag = tar.TypeSymlink
+            tarHeader.Linkname = headDescr.SLink
+        case DTypeHlink:
+            tarHeader.Typeflag = tar.TypeLink
+            tarHeader.Linkname = strings.TrimLeft(headDescr.HLink, "/")
+        case DTypeBlock, DTypeChar:
+            tarHeader.Typeflag = tar.TypeBlock
+            if headDescr.Type == DTypeChar {
+                tarHeader.Typeflag = tar.TypeChar
+            }
+            tarHeader.Devmajor = int64(headDescr.Major)
+            tarHeader.Devminor = int64(headDescr.Minor)
+        case DTypeFifo:
+            tarHeader.Typeflag = tar.TypeFifo
+        default:
+            return tarHeader, false
+    }
+    return tarHeader, true
+}
+
+// setTarSum adds PAX records of the hash sum and the file status.
+func setTarSum(tarHeader *tar.Header, headDescr *HeadDescr, tailDescr *TailDescr) {
+    if headDescr.HType != "" && headDescr.HType != HashTypeNone {
+        tarHeader.PAXRecords[paxHashType] = headDescr.HType
+        tarHeader.PAXRecords[paxHashInit] = hex.EncodeToString(headDescr.HInit)
+        tarHeader.PAXRecords[paxHashSum]  = hex.EncodeToString(tailDescr.HSum)
+    }
+    if tailDescr.Status != "" {
+        tarHeader.PAXRecords[paxStatus] = tailDescr.Status
+    }
+    if headDescr.IsSparse() {
+        sparseBin, err := json.Marshal(headDescr.Sparse)
+        if err == nil {
+            tarHeader.PAXRecords[paxSparse] = string(sparseBin)
+        }
+    }
+}
+
+// tarMode returns permissions with setuid, setgid and sticky bits.
+func tarMode(mode uint32) int64 {
+    fileMode := fs.FileMode(mode)
+    tarMode := int64(fileMode.Perm())
+    if fileMode & fs.ModeSetuid != 0 {
+        tarMode |= syscall.S_ISUID
+    }
+    if fileMode & fs.ModeSetgid != 0 {
+        tarMode |= syscall.S_ISGID
+    }
+    if fileMode & fs.ModeSticky != 0 {
+        tarMode |= syscall.S_ISVTX
+    }
+    return tarMode
+}
+
+// holeWriter writes data regions of the sparse file sequentially
+// filling holes with zeros, data of other files are written as is.
+type holeWriter struct {
+    writer      io.Writer
+    regions     []SparseRegion
+    fileSize    int64
+    sparse      bool
+    index       int
+    pos         int64
+}
+
+func newHoleWriter(writer io.Writer, headDescr *HeadDescr) *holeWriter {
+    var holeWriter holeWriter
+    holeWriter.writer   = writer
+    holeWriter.regions  = headDescr.Sparse
+    holeWriter.fileSize = headDescr.FileSize
+    holeWriter.sparse   = headDescr.IsSparse()
+    return &holeWriter
+}
+
+func (holeWriter *holeWriter) Write(data []byte) (int, error) {
+    var err error
+    if !holeWriter.sparse {
+        return holeWriter.writer.Write(data)
+    }
+    var written int
+    for len(data) > 0 {
+        if holeWriter.index >= len(holeWriter.regions) {
+            err = errors.New("sparse data beyond regions")
+            return written, err
+        }
+        region := holeWriter.regions[holeWriter.index]
+        regionEnd := region.Offset + region.Size
+        if holeWriter.pos >= regionEnd {
+            holeWriter.index += 1
+            continue
+        }
+        if holeWriter.pos < region.Offset {
+            err = holeWriter.fill(region.Offset)
+            if err != nil {
+                return written, err
+            }
+        }
+        size := int64(len(data))
+        if size > regionEnd - holeWriter.pos {
+            size = regionEnd - holeWriter.pos
+        }
+        wrote, err := holeWriter.writer.Write(data[0:size])
+        holeWriter.pos += int64(wrote)
+        written += wrote
+        if err != nil {
+            return written, err
+        }
+        data = data[size:]
+    }
+    return written, err
+}
+
+// fill writes zeros up to the offset.
+func (holeWriter *holeWriter) fill(offset int64) error {
+    var err error
+    _, err = io.CopyN(holeWriter.writer, zeroReader{}, offset - holeWriter.pos)
+    holeWriter.pos = offset
+    return err
+}
+
+// finish writes the trailing hole of the sparse file.
+func (holeWriter *holeWriter) finish() error {
+    var err error
+    if holeWriter.sparse && holeWriter.pos < holeWriter.fileSize {
+        err = holeWriter.fill(holeWriter.fileSize)
+    }
+    return err
+}
+
+// ImportTar converts the tar stream to the archive with the options
+// codec and keys. Hash sums of files are computed while writing,
+// files are compressed on the fly. Entry types not supported
+// by the archive are skipped.
+func ImportTar(ioReader io.Reader, outWriter io.Writer, options *PackOptions) ([]*HeadDescr, error) {
+    var err error
+    descrs := make([]*HeadDescr, 0)
+
+    if options.Keys != nil {
+        encWriter, err := NewEncryptWriter(outWriter, options.Keys)
+        if err != nil {
+            return descrs, err
+        }
+        outWriter = encWriter
+    }
+    writer := NewWriter(outWriter)
+    err = writer.SetCodec(options.Codec)
+    if err != nil {
+        return descrs, err
+    }
+    tarReader := tar.NewReader(ioReader)
+
+    for {
+        tarHeader, err := tarReader.Next()
+        if err == io.EOF {
+            break
+        }
+        if err != nil {
+            return descrs, err
+        }
+        headDescr, ok := newTarDescr(tarHeader)
+        if !ok {
+            continue
+        }
+        headDescr.HInit = writer.hashInit
+        if headDescr.Type == DTypeFile {
+            err = importFile(writer, tarReader, headDescr, options.Codec)
+            if err != nil {
+                return descrs, err
+            }
+            descrs = append(descrs, headDescr)
+            continue
+        }
+        err = writer.WriteHeadDescr(headDescr)
+        if err != nil {
+            return descrs, err
+        }
+        err = writer.WriteTailDescr(NewTailDescr())
+        if err != nil {
+            return descrs, err
+        }
+        descrs = append(descrs, headDescr)
+    }
+    err = writer.WriteIndex()
+    if err != nil {
+        return descrs, err
+    }
+    encWriter, ok := outWriter.(*EncryptWriter)
+    if ok {
+        err = encWriter.Close()
+        if err != nil {
+            return descrs, err
+        }
+    }
+    return descrs, err
+}
+
+// importFile writes the tar file as the file entry from the tar stream,
+// the file is compressed if the probe from the file beginning is.
+func importFile(writer *Writer, tarReader io.Reader, headDescr *HeadDescr, codec string) error {
+    var err error
+    if codec == "" || !compressCandidate(codec, headDescr.Path, headDescr.Size) {
+        return writer.WriteStream(headDescr, tarReader)
+    }
+    probe, err := readProbe(tarReader, headDescr.Size)
+    if err != nil {
+        return err
+    }
+    dataReader := io.MultiReader(bytes.NewReader(probe), tarReader)
+    compress, err := compressProbe(codec, probe)
+    if err != nil {
+        return err
+    }
+    if compress {
+        return writer.writeFramed(headDescr, dataReader)
+    }
+    return writer.WriteStream(headDescr, dataReader)
+}
+
+// newTarDescr returns the head descr of the tar entry, ok is false
+// for entry types not supported by the archive and for empty paths.
+func newTarDescr(tarHeader *tar.Header) (*HeadDescr, bool) {
+    headDescr := NewHeadDescr()
+    headDescr.Path  = tarPath(tarHeader.Name)
+    headDescr.HType = HashTypeNone
+    headDescr.Uid   = uint32(tarHeader.Uid)
+    headDescr.Gid   = uint32(tarHeader.Gid)
+    headDescr.User  = tarHeader.Uname
+    headDescr.Group = tarHeader.Gname
+    headDescr.Mtime, headDescr.MtimeNsec = tarHeader.ModTime.Unix(), int64(tarHeader.ModTime.Nanosecond())
+    if !tarHeader.AccessTime.IsZero() {
+        headDescr.Atime, headDescr.AtimeNsec = tarHeader.AccessTime.Unix(), int64(tarHeader.AccessTime.Nanosecond())
+    }
+    if !tarHeader.ChangeTime.IsZero() {
+        headDescr.Ctime, headDescr.CtimeNsec = tarHeader.ChangeTime.Unix(), int64(tarHeader.ChangeTime.Nanosecond())
+    }
+    if headDescr.Path == "" {
+        return headDescr, false
+    }
+    fileMode := tarHeader.FileInfo().Mode()
+
+    switch tarHeader.Typeflag {
+        case tar.TypeReg, tar.TypeRegA:
+            headDescr.Type = DTypeFile
+            headDescr.Size = tarHeader.Size
+            headDescr.Mode = uint32(fileMode)
+        case tar.TypeDir:
+            headDescr.Type = DTypeDir
+            headDescr.Mode = uint32(fileMode.Perm())
+        case tar.TypeSymlink:
+            headDescr.Type  = DTypeSlink
+            headDescr.Mode  = uint32(syscall.S_IFLNK) | uint32(fileMode.Perm())
+            headDescr.SLink = tarHeader.Linkname
+        case tar.TypeLink:
+            headDescr.Type  = DTypeHlink
+            headDescr.Mode  = uint32(fileMode)
+            headDescr.HLink = tarPath(tarHeader.Linkname)
+        case tar.TypeBlock, tar.TypeChar, tar.TypeFifo:
+            headDescr.Type  = nodeType(fileMode)
+            headDescr.Mode  = uint32(fileMode)
+            headDescr.Major = uint32(tarHeader.Devmajor)
+            headDescr.Minor = uint32(tarHeader.Devminor)
+        default:
+            return headDescr, false
+    }
+
+    attrs := NewAttrDescr()
+    for key, value := range tarHeader.PAXRecords {
+        if strings.HasPrefix(key, paxXAttr) {
+            attrs.XAttrs[strings.TrimPrefix(key, paxXAttr)] = []byte(value)
+        }
+    }
+    flags, err := strconv.ParseUint(tarHeader.PAXRecords[paxFlags], 10, 32)
+    if err == nil {
+        attrs.Flags = uint32(flags)
+    }
+    if !attrs.Empty() {
+        headDescr.Attrs = attrs
+    }
+    return headDescr, true
+}
+
+// tarPath cleans the tar entry name to the relative path
+// without parent dir references.
+func tarPath(name string) string {
+    return strings.TrimLeft(filepath.Clean("/" + name), "/")
+}
@@ -7,10 +7,20 @@ package dspack
 
 import (
     "encoding/json"
+    "io"
+    "io/fs"
+    "syscall"
 )
 
+// File statuses of files changed while packing.
+const FileStatusChanged     string = "changed"
+const FileStatusTruncated   string = "truncated"
+const FileStatusGrown       string = "grown"
+
 type TailDescr struct {
     HSum    []byte          `json:"hSum"`
+    Size    int64           `json:"size,omitempty"`
+    Status  string          `json:"status,omitempty"`
 }
 
 func NewTailDescr() *TailDescr {
@@ -30,3 +40,45 @@ func (descr *TailDescr) Pack() ([]byte, error) {
     descrBin, err := json.Marshal(descr)
     return descrBin, err
 }
+
+// fileStatus compares the file after reading with the head descr.
+// Files shorter than the data size are truncated, the status is empty
+// for unchanged files.
+func fileStatus(headDescr *HeadDescr, dataSize, readSize int64, file io.Reader) string {
+    var status string
+    if readSize < dataSize {
+        status = FileStatusTruncated
+        return status
+    }
+    statFile, ok := file.(interface{ Stat() (fs.FileInfo, error) })
+    if !ok {
+        return status
+    }
+    fileInfo, err := statFile.Stat()
+    if err != nil {
+        return status
+    }
+    fileSize := dataSize
+    if headDescr.IsSparse() {
+        fileSize = headDescr.FileSize
+    }
+    switch {
+        case fileInfo.Size() < fileSize:
+            status = FileStatusTruncated
+            return status
+        case fileInfo.Size() > fileSize:
+            status = FileStatusGrown
+            return status
+    }
+    sysStat, ok := fileInfo.Sys().(*syscall.Stat_t)
+    if !ok {
+        return status
+    }
+    statDescr := NewHeadDescr()
+    setStatTimes(statDescr, sysStat)
+    if statDescr.Mtime != headDescr.Mtime || statDescr.MtimeNsec != headDescr.MtimeNsec ||
+            statDescr.Ctime != headDescr.Ctime || statDescr.CtimeNsec != headDescr.CtimeNsec {
+        status = FileStatusChanged
+    }
+    return status
+}
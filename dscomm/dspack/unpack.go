@@ -0,0 +1,667 @@
+/*
+ * Copyright 2022 Oleg Borodin  <borodin@unix7.org>
+ */
+
+package dspack
+
+import (
+    "errors"
+    "fmt"
+    "io"
+    "io/fs"
+    "os"
+    "path/filepath"
+    "sort"
+    "strings"
+    "syscall"
+)
+
+type unpacker struct {
+    baseDir     string
+    options     *UnpackOptions
+    archDirs    map[string]*HeadDescr
+    madeDirs    map[string]*HeadDescr
+    linkPaths   map[string]string
+    readSeeker  io.ReadSeeker
+}
+
+func newUnpacker(baseDir string, options *UnpackOptions) *unpacker {
+    var unpacker unpacker
+    unpacker.baseDir  = baseDir
+    unpacker.options  = options
+    unpacker.archDirs = make(map[string]*HeadDescr)
+    unpacker.madeDirs = make(map[string]*HeadDescr)
+    unpacker.linkPaths = make(map[string]string)
+    return &unpacker
+}
+
+// Unpack restores all entries of the archive into the base dir.
+func Unpack(ioReader io.Reader, baseDir string) ([]*HeadDescr, error) {
+    return UnpackSelected(ioReader, baseDir, NewUnpackOptions())
+}
+
+// UnpackSelected restores entries of the archive selected by the options
+// into the base dir. Archived parent dirs of selected entries are restored
+// too. Seekable inputs with the index are read by the entry offsets,
+// other entries are skipped without reading.
+func UnpackSelected(ioReader io.Reader, baseDir string, options *UnpackOptions) ([]*HeadDescr, error) {
+    var err error
+    descrs := make([]*HeadDescr, 0)
+
+    if options.Keys != nil {
+        ioReader, err = NewDecryptReader(ioReader, options.Keys)
+        if err != nil {
+            return descrs, err
+        }
+    }
+    unpacker := newUnpacker(baseDir, options)
+
+    readSeeker, ok := ioReader.(io.ReadSeeker)
+    if ok {
+        unpacker.readSeeker = readSeeker
+    }
+    if ok && !options.SelectAll() {
+        items, err := ReadIndex(readSeeker)
+        if err == nil {
+            return unpacker.unpackItems(readSeeker, items)
+        }
+    }
+
+    reader := NewReader(ioReader)
+
+    for {
+        headDescr, err := reader.ReadHeadDescr()
+        if err == io.EOF {
+            return descrs, errNoIndex
+        }
+        if err != nil {
+            return descrs, err
+        }
+        if headDescr.Type == DTypeIndex {
+            _, err = reader.ReadIndexDescr(headDescr)
+            if err != nil {
+                return descrs, err
+            }
+            break
+        }
+        if headDescr.Type == DTypeDir {
+            unpacker.archDirs[headDescr.Path] = headDescr
+        }
+        if !options.Match(headDescr.Path) {
+            err = reader.SkipData(headDescr)
+            if err != nil {
+                return descrs, err
+            }
+            _, err = reader.ReadTailDescr()
+            if err != nil {
+                return descrs, err
+            }
+            continue
+        }
+        err = unpacker.unpackEntry(reader, headDescr)
+        if err != nil {
+            return descrs, err
+        }
+        descrs = append(descrs, headDescr)
+    }
+    err = unpacker.setDirsMeta()
+    if err != nil {
+        return descrs, err
+    }
+    return descrs, err
+}
+
+// RestoreChain restores the full archive and the chain of increments
+// in order into the base dir. Deletion markers of increments remove
+// paths restored from previous archives. Descrs of the resulting tree
+// are returned sorted by path.
+func RestoreChain(ioReaders []io.Reader, baseDir string, options *UnpackOptions) ([]*HeadDescr, error) {
+    var err error
+    descrs := make([]*HeadDescr, 0)
+
+    treeDescrs := make(map[string]*HeadDescr)
+    for _, ioReader := range ioReaders {
+        archDescrs, err := UnpackSelected(ioReader, baseDir, options)
+        if err != nil {
+            return descrs, err
+        }
+        for _, headDescr := range archDescrs {
+            if headDescr.Type != DTypeDelete {
+                treeDescrs[headDescr.Path] = headDescr
+                continue
+            }
+            for treePath := range treeDescrs {
+                if treePath == headDescr.Path || strings.HasPrefix(treePath, headDescr.Path + "/") {
+                    delete(treeDescrs, treePath)
+                }
+            }
+        }
+    }
+    treePaths := make([]string, 0, len(treeDescrs))
+    for treePath := range treeDescrs {
+        treePaths = append(treePaths, treePath)
+    }
+    sort.Strings(treePaths)
+    for _, treePath := range treePaths {
+        descrs = append(descrs, treeDescrs[treePath])
+    }
+    return descrs, err
+}
+
+// UnpackFile restores the single entry of the archive into the base dir
+// seeking to the entry with the archive index. Encrypted archives
+// are read with DecryptReader.
+func UnpackFile(readSeeker io.ReadSeeker, filePath, baseDir string) (*HeadDescr, error) {
+    var err error
+    var headDescr *HeadDescr
+
+    items, err := ReadIndex(readSeeker)
+    if err != nil {
+        return headDescr, err
+    }
+    filePath = strings.TrimLeft(filepath.Clean(filePath), "/")
+    for _, item := range items {
+        if item.Descr.Path != filePath {
+            continue
+        }
+        unpacker := newUnpacker(baseDir, NewUnpackOptions())
+        descrs, err := unpacker.unpackItems(readSeeker, []*IndexItem{ item })
+        if err != nil {
+            return headDescr, err
+        }
+        headDescr = descrs[0]
+        return headDescr, err
+    }
+    err = fmt.Errorf("file %s not found in archive", filePath)
+    return headDescr, err
+}
+
+// unpackItems restores selected entries of the items by their offsets.
+func (unpacker *unpacker) unpackItems(readSeeker io.ReadSeeker, items []*IndexItem) ([]*HeadDescr, error) {
+    var err error
+    descrs := make([]*HeadDescr, 0)
+
+    unpacker.readSeeker = readSeeker
+    archItems, err := ReadIndex(readSeeker)
+    if err != nil {
+        return descrs, err
+    }
+    for _, item := range archItems {
+        if item.Descr.Type == DTypeDir {
+            unpacker.archDirs[item.Descr.Path] = item.Descr
+        }
+    }
+    for _, item := range items {
+        if !unpacker.options.Match(item.Descr.Path) {
+            continue
+        }
+        _, err = readSeeker.Seek(item.Offset, io.SeekStart)
+        if err != nil {
+            return descrs, err
+        }
+        reader := NewReader(readSeeker)
+        headDescr, err := reader.ReadHeadDescr()
+        if err != nil {
+            return descrs, err
+        }
+        err = unpacker.unpackEntry(reader, headDescr)
+        if err != nil {
+            return descrs, err
+        }
+        descrs = append(descrs, headDescr)
+    }
+    err = unpacker.setDirsMeta()
+    if err != nil {
+        return descrs, err
+    }
+    return descrs, err
+}
+
+// restoreAttrs reports whether extended attributes and flags
+// of the entry are restored.
+func (unpacker *unpacker) restoreAttrs(headDescr *HeadDescr) bool {
+    return headDescr.Attrs != nil && !unpacker.options.SkipAttrs && os.Getuid() == 0
+}
+
+// makeParents creates parent dirs of the entry, archived dirs
+// get their metadata at the end of unpacking.
+func (unpacker *unpacker) makeParents(entryPath string) error {
+    var err error
+    dirPath := filepath.Dir(entryPath)
+    for dirPath != "." && dirPath != "/" {
+        dirDescr, ok := unpacker.archDirs[dirPath]
+        if ok {
+            unpacker.madeDirs[dirPath] = dirDescr
+        }
+        dirPath = filepath.Dir(dirPath)
+    }
+    dir := filepath.Dir(filepath.Join(unpacker.baseDir, entryPath))
+    err = os.MkdirAll(dir, 0750)
+    if err != nil {
+        return err
+    }
+    return err
+}
+
+// setDirsMeta sets metadata of restored dirs, deeper dirs first,
+// after all dir entries are written.
+func (unpacker *unpacker) setDirsMeta() error {
+    var err error
+    dirPaths := make([]string, 0, len(unpacker.madeDirs))
+    for dirPath := range unpacker.madeDirs {
+        dirPaths = append(dirPaths, dirPath)
+    }
+    sort.Sort(sort.Reverse(sort.StringSlice(dirPaths)))
+    for _, dirPath := range dirPaths {
+        headDescr := unpacker.madeDirs[dirPath]
+        unpackPath := filepath.Join(unpacker.baseDir, dirPath)
+
+        if os.Getuid() == 0 {
+            err = syscall.Chown(unpackPath, int(headDescr.Uid), int(headDescr.Gid))
+            if err != nil {
+                return err
+            }
+        }
+        if headDescr.Mode != 0 {
+            err = os.Chmod(unpackPath, fs.FileMode(headDescr.Mode))
+            if err != nil {
+                return err
+            }
+        }
+        if unpacker.restoreAttrs(headDescr) {
+            err = writeXAttrs(unpackPath, headDescr.Attrs)
+            if err != nil {
+                return err
+            }
+        }
+        mTime, aTime := descrTimes(headDescr)
+        err = os.Chtimes(unpackPath, aTime, mTime)
+        if err != nil {
+            return err
+        }
+        if unpacker.restoreAttrs(headDescr) {
+            err = writeFlags(unpackPath, fs.ModeDir, headDescr.Attrs)
+            if err != nil {
+                return err
+            }
+        }
+    }
+    return err
+}
+
+// entryPath returns the cleaned archive path of the entry and its path
+// in the base dir. Paths leaving the base dir with .. or through
+// symlinks of existing parent dirs are refused.
+func (unpacker *unpacker) entryPath(archPath string) (string, string, error) {
+    var err error
+    var unpackPath string
+    relPath, err := cleanEntryPath(archPath)
+    if err != nil {
+        return relPath, unpackPath, err
+    }
+    unpackPath = filepath.Join(unpacker.baseDir, relPath)
+    baseDir, err := filepath.EvalSymlinks(unpacker.baseDir)
+    if errors.Is(err, fs.ErrNotExist) {
+        return relPath, unpackPath, nil
+    }
+    if err != nil {
+        return relPath, unpackPath, err
+    }
+    dirPath := filepath.Dir(unpackPath)
+    for {
+        parentDir, err := filepath.EvalSymlinks(dirPath)
+        if errors.Is(err, fs.ErrNotExist) && filepath.Dir(dirPath) != dirPath {
+            dirPath = filepath.Dir(dirPath)
+            continue
+        }
+        if errors.Is(err, fs.ErrNotExist) {
+            return relPath, unpackPath, nil
+        }
+        if err != nil {
+            return relPath, unpackPath, err
+        }
+        if baseDir != "/" && parentDir != baseDir && !strings.HasPrefix(parentDir, baseDir + "/") {
+            err = fmt.Errorf("entry path %s is out of the base dir", archPath)
+            return relPath, unpackPath, err
+        }
+        return relPath, unpackPath, err
+    }
+}
+
+// cleanEntryPath returns the archive path relative to the base dir,
+// paths with .. leaving the base dir are refused.
+func cleanEntryPath(archPath string) (string, error) {
+    var err error
+    relPath := filepath.Clean(strings.TrimLeft(archPath, "/"))
+    if relPath == "." || relPath == ".." || strings.HasPrefix(relPath, "../") {
+        err = fmt.Errorf("wrong entry path %s", archPath)
+        return relPath, err
+    }
+    return relPath, err
+}
+
+func (unpacker *unpacker) unpackEntry(reader *Reader, headDescr *HeadDescr) error {
+    var err error
+
+    filePath, unpackPath, err := unpacker.entryPath(headDescr.Path)
+    if err != nil {
+        return err
+    }
+
+    if headDescr.Type == DTypeDelete {
+        _, err = reader.ReadTailDescr()
+        if err != nil {
+            return err
+        }
+        err = os.RemoveAll(unpackPath)
+        if err != nil {
+            return err
+        }
+        headDescr.Match = true
+        return err
+    }
+
+    err = unpacker.makeParents(filePath)
+    if err != nil {
+        return err
+    }
+
+    switch headDescr.Type {
+        case DTypeFile:
+
+            tmpPath := filepath.Join(unpackPath + ".tmp")
+
+            err = removeNonDir(tmpPath)
+            if err != nil {
+                return err
+            }
+            file, err := os.OpenFile(tmpPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0640)
+            if err != nil {
+                return err
+            }
+            defer file.Close()
+
+            if headDescr.IsSparse() {
+                regionWriter := newRegionWriter(file, headDescr.Sparse)
+                headDescr.Match, err = reader.ReadData(headDescr, regionWriter)
+                if err != nil {
+                    return err
+                }
+                err = file.Truncate(headDescr.FileSize)
+                if err != nil {
+                    return err
+                }
+            } else {
+                headDescr.Match, err = reader.ReadData(headDescr, file)
+                if err != nil {
+                    return err
+                }
+            }
+
+            mTime, aTime := descrTimes(headDescr)
+            err = os.Chtimes(tmpPath, aTime, mTime)
+            if err != nil {
+                return err
+            }
+
+            if os.Getuid() == 0 {
+                err = os.Chown(tmpPath, int(headDescr.Uid), int(headDescr.Gid))
+                if err != nil {
+                    return err
+                }
+            }
+
+            err = os.Chmod(tmpPath, fs.FileMode(headDescr.Mode))
+            if err != nil {
+                return err
+            }
+
+            if unpacker.restoreAttrs(headDescr) {
+                err = writeXAttrs(tmpPath, headDescr.Attrs)
+                if err != nil {
+                    return err
+                }
+            }
+
+            err = os.Rename(tmpPath, unpackPath)
+            if err != nil {
+                return err
+            }
+            unpacker.linkPaths[filePath] = unpackPath
+
+            if unpacker.restoreAttrs(headDescr) {
+                err = writeFlags(unpackPath, fs.FileMode(headDescr.Mode), headDescr.Attrs)
+                if err != nil {
+                    return err
+                }
+            }
+
+        case DTypeSlink:
+            err = removeNonDir(unpackPath)
+            if err != nil {
+                return err
+            }
+            err = os.Symlink(headDescr.SLink, unpackPath)
+            if err != nil {
+                return err
+            }
+
+            _, err = reader.ReadTailDescr()
+            if err != nil {
+                return err
+            }
+            headDescr.Match = true
+
+            //err = syscall.Chmod(unpackPath, fs.FileMode(headDescr.Mode))
+            //if err != nil {
+            //    return err
+            //}
+
+            if os.Getuid() == 0 {
+                err = syscall.Lchown(unpackPath, int(headDescr.Uid), int(headDescr.Gid))
+                if err != nil {
+                    return err
+                }
+            }
+
+            if unpacker.restoreAttrs(headDescr) {
+                err = writeXAttrs(unpackPath, headDescr.Attrs)
+                if err != nil {
+                    return err
+                }
+            }
+
+        case DTypeDir:
+            err = removeNonDir(unpackPath)
+            if err != nil {
+                return err
+            }
+            err = os.MkdirAll(unpackPath, 0750)
+            if err != nil {
+                return err
+            }
+
+            _, err = reader.ReadTailDescr()
+            if err != nil {
+                return err
+            }
+            unpacker.madeDirs[filePath] = headDescr
+            headDescr.Match = true
+
+        case DTypeBlock, DTypeChar, DTypeFifo, DTypeSocket:
+            _, err = reader.ReadTailDescr()
+            if err != nil {
+                return err
+            }
+            err = unpacker.unpackNode(headDescr, unpackPath)
+            if err != nil {
+                return err
+            }
+
+        case DTypeHlink:
+            _, err = reader.ReadTailDescr()
+            if err != nil {
+                return err
+            }
+            err = unpacker.unpackLink(headDescr, filePath, unpackPath)
+            if err != nil {
+                return err
+            }
+
+        default:
+            err = skipEntry(reader, headDescr)
+            if err != nil {
+                return err
+            }
+    }
+    return err
+}
+
+// removeNonDir removes the existing entry of the path
+// unless it is a dir, symlinks are not followed.
+func removeNonDir(unpackPath string) error {
+    var err error
+    fileInfo, err := os.Lstat(unpackPath)
+    if errors.Is(err, fs.ErrNotExist) {
+        return nil
+    }
+    if err != nil {
+        return err
+    }
+    if fileInfo.IsDir() {
+        return err
+    }
+    return os.Remove(unpackPath)
+}
+
+// unpackNode creates the device, fifo or socket of the entry.
+// Nodes which cannot be created are reported with the entry error
+// and the match flag unset, unpacking goes on.
+func (unpacker *unpacker) unpackNode(headDescr *HeadDescr, unpackPath string) error {
+    var err error
+
+    err = removeNonDir(unpackPath)
+    if err != nil {
+        return err
+    }
+    err = makeNode(unpackPath, headDescr)
+    if err != nil {
+        headDescr.Error = err.Error()
+        return nil
+    }
+    if os.Getuid() == 0 {
+        err = syscall.Lchown(unpackPath, int(headDescr.Uid), int(headDescr.Gid))
+        if err != nil {
+            return err
+        }
+    }
+    err = os.Chmod(unpackPath, fs.FileMode(headDescr.Mode))
+    if err != nil {
+        return err
+    }
+    if unpacker.restoreAttrs(headDescr) {
+        err = writeXAttrs(unpackPath, headDescr.Attrs)
+        if err != nil {
+            return err
+        }
+    }
+    mTime, aTime := descrTimes(headDescr)
+    err = os.Chtimes(unpackPath, aTime, mTime)
+    if err != nil {
+        return err
+    }
+    headDescr.Match = true
+    return err
+}
+
+// unpackLink links the entry path to the restored target file.
+// If the target is not selected its data are restored into the entry
+// path from the archive index and later links of the target refer
+// to the entry path.
+func (unpacker *unpacker) unpackLink(headDescr *HeadDescr, filePath, unpackPath string) error {
+    var err error
+
+    targetPath, err := cleanEntryPath(headDescr.HLink)
+    if err != nil {
+        return err
+    }
+    linkPath, ok := unpacker.linkPaths[targetPath]
+    if ok {
+        err = os.Remove(unpackPath)
+        if err != nil && !errors.Is(err, fs.ErrNotExist) {
+            return err
+        }
+        err = os.Link(linkPath, unpackPath)
+        if err != nil {
+            return err
+        }
+        headDescr.Match = true
+        return err
+    }
+
+    if unpacker.readSeeker == nil {
+        err = fmt.Errorf("hard link target %s is not restored", targetPath)
+        return err
+    }
+    curPos, err := unpacker.readSeeker.Seek(0, io.SeekCurrent)
+    if err != nil {
+        return err
+    }
+    defer unpacker.readSeeker.Seek(curPos, io.SeekStart)
+
+    items, err := ReadIndex(unpacker.readSeeker)
+    if err != nil {
+        err = fmt.Errorf("hard link target %s is not restored: %v", targetPath, err)
+        return err
+    }
+    for _, item := range items {
+        if item.Descr.Path != targetPath || item.Descr.Type != DTypeFile {
+            continue
+        }
+        _, err = unpacker.readSeeker.Seek(item.Offset, io.SeekStart)
+        if err != nil {
+            return err
+        }
+        reader := NewReader(unpacker.readSeeker)
+        targetDescr, err := reader.ReadHeadDescr()
+        if err != nil {
+            return err
+        }
+        targetDescr.Path = filePath
+        err = unpacker.unpackEntry(reader, targetDescr)
+        if err != nil {
+            return err
+        }
+        unpacker.linkPaths[targetPath] = unpackPath
+        headDescr.Match = targetDescr.Match
+        return err
+    }
+    err = fmt.Errorf("hard link target %s not found in archive", targetPath)
+    return err
+}
+
+
+// skipEntry reads the entry bin and the tail descr, the match flag
+// of the file is set if the hash sum of the data is valid.
+func skipEntry(reader *Reader, headDescr *HeadDescr) error {
+    var err error
+
+    switch headDescr.Type {
+        case DTypeFile:
+            headDescr.Match, err = reader.ReadData(headDescr, io.Discard)
+            if err != nil {
+                return err
+            }
+        default:
+            _, err = reader.ReadBin(io.Discard, headDescr.Size)
+            if err != nil {
+                return err
+            }
+            _, err = reader.ReadTailDescr()
+            if err != nil {
+                return err
+            }
+            headDescr.Match = true
+    }
+    return err
+}
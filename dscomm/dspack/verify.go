@@ -0,0 +1,235 @@
+/*
+ * Copyright 2022 Oleg Borodin  <borodin@unix7.org>
+ */
+
+package dspack
+
+import (
+    "errors"
+    "fmt"
+    "io"
+)
+
+const ProblemCorrupt    string = "corrupt"
+const ProblemTruncated  string = "truncated"
+const ProblemMismatch   string = "mismatch"
+
+// VerifyProblem is the problem of the chunk at the offset of
+// the plain archive stream.
+type VerifyProblem struct {
+    Offset  int64       `json:"offset"`
+    Path    string      `json:"path,omitempty"`
+    Kind    string      `json:"kind"`
+    Error   string      `json:"error"`
+}
+
+type VerifyReport struct {
+    Entries     int64               `json:"entries"`
+    Files       int64               `json:"files"`
+    Size        int64               `json:"size"`
+    Problems    []*VerifyProblem    `json:"problems"`
+}
+
+func NewVerifyReport() *VerifyReport {
+    var report VerifyReport
+    report.Problems = make([]*VerifyProblem, 0)
+    return &report
+}
+
+func (report *VerifyReport) Ok() bool {
+    return len(report.Problems) == 0
+}
+
+func (report *VerifyReport) addProblem(offset int64, path, kind string, err error) {
+    problem := &VerifyProblem{
+        Offset: offset,
+        Path:   path,
+        Kind:   kind,
+        Error:  err.Error(),
+    }
+    report.Problems = append(report.Problems, problem)
+}
+
+// countReader counts bytes read from the archive stream.
+type countReader struct {
+    reader  io.Reader
+    pos     int64
+}
+
+func (reader *countReader) Read(data []byte) (int, error) {
+    read, err := reader.reader.Read(data)
+    reader.pos += int64(read)
+    return read, err
+}
+
+// problemKind returns truncated for read errors at the end of data.
+func problemKind(err error) string {
+    if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+        return ProblemTruncated
+    }
+    return ProblemCorrupt
+}
+
+// Verify reads the archive sequentially without extracting it, checks
+// magic codes of all chunks, hash sums of files, the index and
+// the footer. Data mismatches are reported and verifying goes on,
+// structural problems stop it. The error is returned only if
+// the encrypted archive cannot be opened with the keys.
+func Verify(ioReader io.Reader, keys *CryptKeys) (*VerifyReport, error) {
+    var err error
+    report := NewVerifyReport()
+
+    if keys != nil {
+        ioReader, err = NewDecryptReader(ioReader, keys)
+        if err != nil {
+            return report, err
+        }
+    }
+    counter := &countReader{ reader: ioReader }
+    reader := NewReader(counter)
+    defer func() {
+        report.Size = counter.pos
+    }()
+
+    entryOffsets := make([]int64, 0)
+    for {
+        offset := counter.pos
+        headDescr, err := reader.ReadHeadDescr()
+        if err == io.EOF {
+            report.addProblem(offset, "", ProblemTruncated, errNoIndex)
+            return report, nil
+        }
+        if err != nil {
+            report.addProblem(offset, "", problemKind(err), err)
+            return report, nil
+        }
+        if headDescr.Type == DTypeIndex {
+            verifyIndex(reader, counter, headDescr, offset, entryOffsets, report)
+            return report, nil
+        }
+        entryOffsets = append(entryOffsets, offset)
+        report.Entries += 1
+
+        if headDescr.Type != DTypeFile {
+            err = skipEntry(reader, headDescr)
+            if err != nil {
+                report.addProblem(offset, headDescr.Path, problemKind(err), err)
+                return report, nil
+            }
+            continue
+        }
+        report.Files += 1
+        binStart := counter.pos
+        match, err := reader.ReadData(headDescr, io.Discard)
+        if err != nil && problemKind(err) == ProblemTruncated {
+            report.addProblem(offset, headDescr.Path, ProblemTruncated, err)
+            return report, nil
+        }
+        if err != nil {
+            report.addProblem(offset, headDescr.Path, ProblemCorrupt, err)
+            if !headDescr.Framed && counter.pos > binStart + headDescr.Size {
+                return report, nil
+            }
+            err = skipCorruptBin(reader, counter, headDescr, binStart + headDescr.Size)
+            if err != nil {
+                report.addProblem(offset, headDescr.Path, problemKind(err), err)
+                return report, nil
+            }
+            continue
+        }
+        if !match {
+            err = errors.New("hash sum mismatch")
+            report.addProblem(offset, headDescr.Path, ProblemMismatch, err)
+        }
+    }
+}
+
+// skipCorruptBin skips the rest of the bin which cannot be decoded
+// and reads the tail descr, so the next chunk can be verified.
+// Framed bins are skipped from the broken frame to the closing one.
+func skipCorruptBin(reader *Reader, counter *countReader, headDescr *HeadDescr, binEnd int64) error {
+    var err error
+    if headDescr.Framed {
+        err = reader.skipFrames()
+        if err != nil {
+            return err
+        }
+        binEnd = counter.pos
+    }
+    if counter.pos < binEnd {
+        _, err = io.CopyN(io.Discard, counter, binEnd - counter.pos)
+        if err != nil {
+            return err
+        }
+    }
+    if counter.pos > binEnd {
+        err = errors.New("bin is read over its size")
+        return err
+    }
+    _, err = reader.ReadTailDescr()
+    if err != nil {
+        return err
+    }
+    return err
+}
+
+// verifyIndex checks that the index lists all entries at their
+// offsets, the footer points to the index and the archive ends
+// after the footer.
+func verifyIndex(reader *Reader, counter *countReader, headDescr *HeadDescr, offset int64, entryOffsets []int64, report *VerifyReport) {
+    var err error
+
+    indexDescrBin, err := io.ReadAll(io.LimitReader(counter, headDescr.Size))
+    if err != nil {
+        report.addProblem(offset, "", problemKind(err), err)
+        return
+    }
+    if int64(len(indexDescrBin)) != headDescr.Size {
+        report.addProblem(offset, "", ProblemTruncated, io.ErrUnexpectedEOF)
+        return
+    }
+    _, err = reader.ReadTailDescr()
+    if err != nil {
+        report.addProblem(offset, "", problemKind(err), err)
+        return
+    }
+    footerOffset := counter.pos
+    footerBin := make([]byte, footerSize)
+    _, err = io.ReadFull(counter, footerBin)
+    if err != nil {
+        report.addProblem(footerOffset, "", problemKind(err), err)
+        return
+    }
+    footer, err := UnpackFooter(footerBin)
+    if err != nil {
+        report.addProblem(footerOffset, "", ProblemCorrupt, err)
+        return
+    }
+    if footer.IndexOffset != offset {
+        err = fmt.Errorf("footer points to index at %d", footer.IndexOffset)
+        report.addProblem(footerOffset, "", ProblemCorrupt, err)
+    }
+    tailBin := make([]byte, 1)
+    _, err = io.ReadFull(counter, tailBin)
+    if err != io.EOF {
+        err = errors.New("data after footer")
+        report.addProblem(footerOffset + footerSize, "", ProblemCorrupt, err)
+    }
+
+    indexDescr, err := UnpackIndexDescr(indexDescrBin)
+    if err != nil {
+        report.addProblem(offset, "", ProblemCorrupt, err)
+        return
+    }
+    if len(indexDescr.Items) != len(entryOffsets) {
+        err = fmt.Errorf("index has %d items for %d entries", len(indexDescr.Items), len(entryOffsets))
+        report.addProblem(offset, "", ProblemCorrupt, err)
+        return
+    }
+    for i, item := range indexDescr.Items {
+        if item.Offset != entryOffsets[i] {
+            err = fmt.Errorf("index item %d points to %d", i, item.Offset)
+            report.addProblem(entryOffsets[i], "", ProblemCorrupt, err)
+        }
+    }
+}
@@ -5,6 +5,8 @@
 package dsrpc
 
 import (
+    "crypto/sha256"
+    "crypto/tls"
     "errors"
     "fmt"
     "io"
@@ -18,12 +20,7 @@ import (
 func Put(address string, method string, reader io.Reader, size int64, param, result any, auth *Auth) error {
     var err error
 
-    addr, err := net.ResolveTCPAddr("tcp", address)
-    if err != nil {
-        err = fmt.Errorf("unable to resolve adddress: %s", err)
-        return Err(err)
-    }
-    conn, err := net.DialTCP("tcp", nil, addr)
+    conn, err := dial(address)
     if err != nil {
         return Err(err)
     }
@@ -45,6 +42,33 @@ func Put(address string, method string, reader io.Reader, size int64, param, res
 }
 
 
+func dial(address string) (net.Conn, error) {
+    var err error
+    var conn net.Conn
+
+    addr, err := net.ResolveTCPAddr("tcp", address)
+    if err != nil {
+        err = fmt.Errorf("unable to resolve adddress: %s", err)
+        return conn, Err(err)
+    }
+    tcpConn, err := net.DialTCP("tcp", nil, addr)
+    if err != nil {
+        return conn, Err(err)
+    }
+    tlsConfig := getClientTLSConfig()
+    if tlsConfig == nil {
+        return tcpConn, Err(err)
+    }
+    tlsConn := tls.Client(tcpConn, tlsConfig)
+    err = tlsConn.Handshake()
+    if err != nil {
+        tcpConn.Close()
+        err = fmt.Errorf("tls handshake error: %s", err)
+        return conn, Err(err)
+    }
+    return tlsConn, Err(err)
+}
+
 func ConnPut(conn net.Conn, method string, reader io.Reader, size int64, param, result any, auth *Auth) error {
     var err error
     context := CreateContext(conn)
@@ -62,6 +86,10 @@ func ConnPut(conn net.Conn, method string, reader io.Reader, size int64, param,
         context.reqRPC.Params = NewEmpty()
     }
 
+    err = context.signRequest(conn, auth)
+    if err != nil {
+        return Err(err)
+    }
     err = context.CreateRequest()
     if err != nil {
         return Err(err)
@@ -95,12 +123,7 @@ func ConnPut(conn net.Conn, method string, reader io.Reader, size int64, param,
 func Get(address string, method string, writer io.Writer, param, result any, auth *Auth) error {
     var err error
 
-    addr, err := net.ResolveTCPAddr("tcp", address)
-    if err != nil {
-        err = fmt.Errorf("unable to resolve adddress: %s", err)
-        return Err(err)
-    }
-    conn, err := net.DialTCP("tcp", nil, addr)
+    conn, err := dial(address)
     if err != nil {
         return Err(err)
     }
@@ -136,6 +159,10 @@ func ConnGet(conn net.Conn, method string, writer io.Writer, param, result any,
     if context.reqRPC.Params == nil {
         context.reqRPC.Params = NewEmpty()
     }
+    err = context.signRequest(conn, auth)
+    if err != nil {
+        return Err(err)
+    }
     err = context.CreateRequest()
     if err != nil {
         return Err(err)
@@ -162,12 +189,7 @@ func ConnGet(conn net.Conn, method string, writer io.Writer, param, result any,
 func Exec(address, method string, param any, result any, auth *Auth) error {
     var err error
 
-    addr, err := net.ResolveTCPAddr("tcp", address)
-    if err != nil {
-        err = fmt.Errorf("unable to resolve adddress: %s", err)
-        return Err(err)
-    }
-    conn, err := net.DialTCP("tcp", nil, addr)
+    conn, err := dial(address)
     if err != nil {
         return Err(err)
     }
@@ -205,6 +227,10 @@ func ConnExec(conn net.Conn, method string, param any, result any, auth *Auth) e
         context.reqRPC.Params = NewEmpty()
     }
 
+    err = context.signRequest(conn, auth)
+    if err != nil {
+        return Err(err)
+    }
     err = context.CreateRequest()
     if err != nil {
         return Err(err)
@@ -225,6 +251,52 @@ func ConnExec(conn net.Conn, method string, param any, result any, auth *Auth) e
 }
 
 
+// signRequest asks the server for the challenge on the connection and
+// signs the request with it. The params are sent as they were packed
+// for the signature.
+func (context *Context) signRequest(conn net.Conn, auth *Auth) error {
+    var err error
+    if auth == nil || !auth.needChallenge() {
+        return Err(err)
+    }
+    params := NewChallengeParams()
+    params.Ident = auth.Ident
+    challenge := NewChallengeResult()
+
+    challContext := CreateContext(conn)
+    challContext.reqRPC.Method = ChallengeMethod
+    challContext.reqRPC.Params = params
+    challContext.resRPC.Result = challenge
+    err = challContext.CreateRequest()
+    if err != nil {
+        return Err(err)
+    }
+    err = challContext.WriteRequest()
+    if err != nil {
+        return Err(err)
+    }
+    err = challContext.ReadResponse()
+    if err != nil {
+        return Err(err)
+    }
+    err = challContext.BindResponse()
+    if err != nil {
+        return Err(err)
+    }
+
+    paramsBin, err := encoder.Marshal(context.reqRPC.Params)
+    if err != nil {
+        return Err(err)
+    }
+    context.reqRPC.Params = encoder.RawMessage(paramsBin)
+    paramsSum := sha256.Sum256(paramsBin)
+    context.reqRPC.Auth, err = auth.signed(challenge, context.reqRPC.Method, paramsSum[:], context.reqHeader.binSize)
+    if err != nil {
+        return Err(err)
+    }
+    return Err(err)
+}
+
 func (context *Context) CreateRequest() error {
     var err error
 
@@ -5,9 +5,14 @@
 package dsrpc
 
 import (
+    "bytes"
+    "crypto/hmac"
+    "crypto/sha256"
     "io"
     "net"
     "time"
+
+    encoder "github.com/vmihailenco/msgpack/v5"
 )
 
 type Context struct {
@@ -26,6 +31,9 @@ type Context struct {
 
     binReader   io.Reader
     binWriter   io.Writer
+
+    challenge       *ChallengeResult
+    challengeIdent  []byte
 }
 
 
@@ -151,6 +159,59 @@ func (context *Context) AuthHash() []byte {
     return context.reqRPC.Auth.Hash
 }
 
+func (context *Context) AuthNonce() []byte {
+    return context.reqRPC.Auth.Nonce
+}
+
+func (context *Context) AuthStamp() int64 {
+    return context.reqRPC.Auth.Stamp
+}
+
+func (context *Context) AuthMac() []byte {
+    return context.reqRPC.Auth.Mac
+}
+
 func (context *Context) Auth() *Auth {
     return context.reqRPC.Auth
 }
+
+// CheckAuth verifies the HMAC auth of the request with the stored key.
+// The auth must answer the challenge issued on the request connection
+// within the auth window.
+func (context *Context) CheckAuth(storedKey []byte) bool {
+    auth := context.reqRPC.Auth
+    challenge := context.challenge
+    if auth == nil || challenge == nil {
+        return false
+    }
+    if !bytes.Equal(auth.Ident, context.challengeIdent) {
+        return false
+    }
+    if !hmac.Equal(auth.Nonce, challenge.Nonce) || auth.Stamp != challenge.Stamp {
+        return false
+    }
+    if time.Now().Unix() - challenge.Stamp > int64(DefaultAuthWindow / time.Second) {
+        return false
+    }
+    paramsSum, err := context.paramsSum()
+    if err != nil {
+        return false
+    }
+    message := authMessage(auth.Ident, auth.Nonce, auth.Stamp, context.reqRPC.Method,
+                                        paramsSum, context.reqHeader.binSize)
+    return checkMac(auth, storedKey, message)
+}
+
+// paramsSum returns the sum of the params packed by the client.
+func (context *Context) paramsSum() ([]byte, error) {
+    var err error
+    var request struct {
+        Params  encoder.RawMessage  `msgpack:"params"`
+    }
+    err = encoder.Unmarshal(context.reqPacket.rcpPayload, &request)
+    if err != nil {
+        return nil, Err(err)
+    }
+    sum := sha256.Sum256(request.Params)
+    return sum[:], Err(err)
+}
@@ -203,6 +203,7 @@ func testServ(quiet bool) error {
     serv.Handler(SaveMethod, saveHandler)
     serv.Handler(LoadMethod, loadHandler)
 
+    serv.SetSaltFunc(testSalt)
     serv.PreMiddleware(LogRequest)
     serv.PreMiddleware(auth)
 
@@ -216,19 +217,25 @@ func testServ(quiet bool) error {
     return err
 }
 
+var testRecord = HashPass([]byte("12345"))
+
+func testSalt(ident []byte) ([]byte, *PassParams, error) {
+    params, salt, _, err := ParsePassHash(testRecord)
+    return salt, params, err
+}
+
 func auth(context *Context) error {
     var err error
-    reqIdent := context.AuthIdent()
-    reqSalt := context.AuthSalt()
-    reqHash := context.AuthHash()
-
-    ident := reqIdent
-    pass := []byte("12345")
 
     auth := context.Auth()
     logDebug("auth ", string(auth.JSON()))
 
-    ok := CheckHash(ident, pass, reqSalt, reqHash)
+    _, _, storedKey, err := ParsePassHash(testRecord)
+    if err != nil {
+        context.SendError(err)
+        return err
+    }
+    ok := context.CheckAuth(storedKey)
     logDebug("auth ok:", ok)
     if !ok {
         err = errors.New("auth ident or pass missmatch")
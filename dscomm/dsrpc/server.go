@@ -6,6 +6,7 @@ package dsrpc
 
 import (
     "context"
+    "crypto/tls"
     "errors"
     "fmt"
     "io"
@@ -16,8 +17,14 @@ import (
     encoder "github.com/vmihailenco/msgpack/v5"
 )
 
+const tlsHandshakeTimeout = 10 * time.Second
+
 type HandlerFunc =  func(*Context) error
 
+// SaltFunc returns the salt and the params of the password
+// record of the ident for the auth challenge.
+type SaltFunc = func(ident []byte) ([]byte, *PassParams, error)
+
 type Service struct {
     handlers    map[string]HandlerFunc
     ctx         context.Context
@@ -28,6 +35,8 @@ type Service struct {
     keepalive   bool
     kaTime      time.Duration
     kaMtx       sync.Mutex
+    tlsConfig   *tls.Config
+    saltFunc    SaltFunc
 }
 
 func NewService() *Service {
@@ -68,9 +77,20 @@ func (svc *Service) SetKeepAlivePeriod(interval time.Duration) {
     svc.kaTime = interval
 }
 
+func (svc *Service) SetTLSConfig(config *tls.Config) {
+    svc.tlsConfig = config
+}
+
+func (svc *Service) SetSaltFunc(saltFunc SaltFunc) {
+    svc.saltFunc = saltFunc
+}
+
 func (svc *Service) Listen(address string) error {
     var err error
     logInfo("server listen:", address)
+    if svc.tlsConfig != nil {
+        logInfo("server tls enabled")
+    }
 
     addr, err := net.ResolveTCPAddr("tcp", address)
     if err != nil {
@@ -133,17 +153,32 @@ func (svc *Service) handleConn(conn *net.TCPConn, wg *sync.WaitGroup) {
             }
         }
     }
-    context := CreateContext(conn)
+    var netConn net.Conn = conn
+    if svc.tlsConfig != nil {
+        tlsConn := tls.Server(conn, svc.tlsConfig)
+        conn.SetDeadline(time.Now().Add(tlsHandshakeTimeout))
+        err = tlsConn.Handshake()
+        conn.SetDeadline(time.Time{})
+        if err != nil {
+            err = fmt.Errorf("tls handshake error: %s", err)
+            conn.Close()
+            wg.Done()
+            logError("conn handler err:", err)
+            return
+        }
+        netConn = tlsConn
+    }
+    context := CreateContext(netConn)
 
     remoteAddr := conn.RemoteAddr().String()
     remoteHost, _, _ := net.SplitHostPort(remoteAddr)
     context.remoteHost = remoteHost
 
-    context.binReader = conn
+    context.binReader = netConn
     context.binWriter = io.Discard
 
     exitFunc := func() {
-            conn.Close()
+            netConn.Close()
             wg.Done()
             if err != nil {
                 logError("conn handler err:", err)
@@ -170,6 +205,13 @@ func (svc *Service) handleConn(conn *net.TCPConn, wg *sync.WaitGroup) {
         err = Err(err)
         return
     }
+    if context.reqRPC.Method == ChallengeMethod {
+        context, err = svc.challenge(context, netConn)
+        if err != nil {
+            err = Err(err)
+            return
+        }
+    }
     for _, mw := range svc.preMw {
         err = mw(context)
         if err != nil {
@@ -192,6 +234,47 @@ func (svc *Service) handleConn(conn *net.TCPConn, wg *sync.WaitGroup) {
     return
 }
 
+// challenge answers the auth challenge and reads the next request
+// of the connection, the challenge is valid for this request only.
+func (svc *Service) challenge(context *Context, conn net.Conn) (*Context, error) {
+    var err error
+    params := NewChallengeParams()
+    err = context.BindParams(params)
+    if err != nil {
+        return context, Err(err)
+    }
+    result := NewChallengeResult()
+    result.Nonce = CreateNonce()
+    result.Stamp = time.Now().Unix()
+    if svc.saltFunc != nil {
+        result.Salt, result.Params, err = svc.saltFunc(params.Ident)
+        if err != nil {
+            context.SendError(errors.New("auth error"))
+            return context, Err(err)
+        }
+    }
+    err = context.SendResult(result, 0)
+    if err != nil {
+        return context, Err(err)
+    }
+    next := CreateContext(conn)
+    next.remoteHost = context.remoteHost
+    next.binReader = context.binReader
+    next.binWriter = context.binWriter
+    next.challenge = result
+    next.challengeIdent = params.Ident
+
+    err = next.ReadRequest()
+    if err != nil {
+        return next, Err(err)
+    }
+    err = next.BindMethod()
+    if err != nil {
+        return next, Err(err)
+    }
+    return next, Err(err)
+}
+
 func (svc *Service) Route(context *Context) error {
     handler, ok := svc.handlers[context.reqRPC.Method]
     if ok {
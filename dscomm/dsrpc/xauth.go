@@ -6,20 +6,42 @@ package dsrpc
 
 import (
     "encoding/json"
+    "errors"
     "bytes"
-    "math/rand"
-    "time"
+    "crypto/hmac"
+    "crypto/rand"
     "crypto/sha256"
+    "sync"
+    "time"
 )
 
-func init() {
-    rand.Seed(time.Now().UnixNano())
-}
+const nonceSize             = 16
+const DefaultAuthWindow     = time.Minute
+
+// ChallengeMethod is the first request of the connection made by the
+// client with the HMAC auth. The server answers with the nonce and the
+// stamp which are valid for the next request of the same connection only,
+// and with the salt and the params of the password record.
+const ChallengeMethod       string = "authChallenge"
 
 type Auth struct {
     Ident   []byte      `msgpack:"ident"    json:"ident"`
     Salt    []byte      `msgpack:"salt"     json:"salt"`
     Hash    []byte      `msgpack:"hash"     json:"hash"`
+    Nonce   []byte      `msgpack:"nonce"    json:"nonce"`
+    Stamp   int64       `msgpack:"stamp"    json:"stamp"`
+    Mac     []byte      `msgpack:"mac"      json:"mac"`
+    pass    []byte
+    key     *authKey
+}
+
+// authKey keeps the client key derived for the salt
+// and the params of the server record.
+type authKey struct {
+    salt        []byte
+    params      PassParams
+    clientKey   []byte
+    mtx         sync.Mutex
 }
 
 func NewAuth() *Auth {
@@ -31,7 +53,134 @@ func (this *Auth) JSON() []byte {
     return jBytes
 }
 
+// IsLegacy reports that the auth was made by an old client
+// with the salt+pass hash scheme.
+func (this *Auth) IsLegacy() bool {
+    return len(this.Mac) == 0 && len(this.Hash) > 0
+}
+
+// CreateAuth makes the HMAC auth of the client. The auth may be used
+// for many requests, each request is signed with the challenge issued
+// by the server on the request connection. The client key is derived
+// once and reused while the server sends the same salt and params.
 func CreateAuth(ident, pass []byte) *Auth {
+    auth := &Auth{}
+    auth.Ident = ident
+    auth.pass = pass
+    auth.key = &authKey{}
+    return auth
+}
+
+// needChallenge reports that requests with the auth are signed.
+func (this *Auth) needChallenge() bool {
+    return this.pass != nil
+}
+
+// signed returns the auth of the single request. The mac is the client
+// key masked by the HMAC keyed with the stored key, the server unmasks
+// it and compares the hash with the stored key.
+func (this *Auth) signed(challenge *ChallengeResult, method string, paramsSum []byte, binSize int64) (*Auth, error) {
+    var err error
+    auth := &Auth{}
+    if challenge.Params == nil {
+        err = errors.New("auth challenge without password params")
+        return auth, Err(err)
+    }
+    clientKey, err := this.clientKey(challenge.Salt, challenge.Params)
+    if err != nil {
+        return auth, Err(err)
+    }
+    auth.Ident = this.Ident
+    auth.Nonce = challenge.Nonce
+    auth.Stamp = challenge.Stamp
+    message := authMessage(auth.Ident, auth.Nonce, auth.Stamp, method, paramsSum, binSize)
+    auth.Mac = xorBytes(clientKey, CreateMac(StoredKey(clientKey), message))
+    return auth, Err(err)
+}
+
+func (this *Auth) clientKey(salt []byte, params *PassParams) ([]byte, error) {
+    var err error
+    this.key.mtx.Lock()
+    defer this.key.mtx.Unlock()
+
+    key := this.key
+    if key.clientKey != nil && bytes.Equal(key.salt, salt) && key.params == *params {
+        return key.clientKey, Err(err)
+    }
+    err = params.Check()
+    if err != nil {
+        return nil, Err(err)
+    }
+    key.clientKey = DeriveKey(this.pass, salt, params)
+    key.salt = salt
+    key.params = *params
+    return key.clientKey, Err(err)
+}
+
+type ChallengeParams struct {
+    Ident   []byte      `msgpack:"ident"    json:"ident"`
+}
+
+func NewChallengeParams() *ChallengeParams {
+    return &ChallengeParams{}
+}
+
+type ChallengeResult struct {
+    Nonce   []byte      `msgpack:"nonce"    json:"nonce"`
+    Stamp   int64       `msgpack:"stamp"    json:"stamp"`
+    Salt    []byte      `msgpack:"salt"     json:"salt"`
+    Params  *PassParams `msgpack:"params"   json:"params"`
+}
+
+func NewChallengeResult() *ChallengeResult {
+    return &ChallengeResult{}
+}
+
+func CreateNonce() []byte {
+    randBytes := make([]byte, nonceSize)
+    rand.Read(randBytes)
+    return randBytes
+}
+
+func CreateMac(key, message []byte) []byte {
+    mac := hmac.New(sha256.New, key)
+    mac.Write(message)
+    return mac.Sum(nil)
+}
+
+func checkMac(auth *Auth, storedKey, message []byte) bool {
+    signature := CreateMac(storedKey, message)
+    if len(auth.Mac) != len(signature) {
+        return false
+    }
+    clientKey := xorBytes(auth.Mac, signature)
+    return hmac.Equal(StoredKey(clientKey), storedKey)
+}
+
+func xorBytes(a, b []byte) []byte {
+    res := make([]byte, len(a))
+    for i := range a {
+        res[i] = a[i] ^ b[i]
+    }
+    return res
+}
+
+// authMessage is the request data covered by the mac. The bin data
+// is not covered, handlers check it with sums sent in the params.
+func authMessage(ident, nonce []byte, stamp int64, method string, paramsSum []byte, binSize int64) []byte {
+    msg := make([]byte, 0, 6 * sizeOfInt64 + len(ident) + len(nonce) + len(method) + len(paramsSum))
+    for _, field := range [][]byte{ ident, nonce, []byte(method), paramsSum } {
+        msg = append(msg, encoderI64(int64(len(field)))...)
+        msg = append(msg, field...)
+    }
+    msg = append(msg, encoderI64(stamp)...)
+    msg = append(msg, encoderI64(binSize)...)
+    return msg
+}
+
+// CreateLegacyAuth makes the salt+pass auth of old clients.
+// Use only for servers not yet migrated to the HMAC scheme.
+func CreateLegacyAuth(ident, pass []byte) *Auth {
     salt := CreateSalt()
     hash := CreateHash(ident, pass, salt)
     auth := &Auth{}
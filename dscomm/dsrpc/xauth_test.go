@@ -0,0 +1,214 @@
+/*
+ * Copyright 2022 Oleg Borodin  <borodin@unix7.org>
+ */
+
+package dsrpc
+
+import (
+    "crypto/sha256"
+    "encoding/base64"
+    "fmt"
+    "testing"
+    "time"
+
+    encoder "github.com/vmihailenco/msgpack/v5"
+    "github.com/stretchr/testify/require"
+)
+
+func TestAuthMac(t *testing.T) {
+    var err error
+    ident := []byte("qwert")
+    pass := []byte("12345")
+
+    salt := CreateSalt()
+    params := DefaultPassParams()
+    storedKey := StoredKey(DeriveKey(pass, salt, params))
+    wrongKey := StoredKey(DeriveKey([]byte("54321"), salt, params))
+
+    challenge := NewChallengeResult()
+    challenge.Nonce = CreateNonce()
+    challenge.Stamp = time.Now().Unix()
+    challenge.Salt = salt
+    challenge.Params = params
+    paramsSum := sha256.Sum256([]byte("params"))
+
+    auth, err := CreateAuth(ident, pass).signed(challenge, HelloMethod, paramsSum[:], 16)
+    require.NoError(t, err)
+    require.False(t, auth.IsLegacy())
+
+    message := authMessage(ident, challenge.Nonce, challenge.Stamp, HelloMethod, paramsSum[:], 16)
+    require.True(t, checkMac(auth, storedKey, message))
+    require.False(t, checkMac(auth, wrongKey, message))
+
+    // The password never travels with the request
+    require.NotContains(t, string(auth.JSON()), string(pass))
+
+    // The mac covers the method, the params and the bin size
+    message = authMessage(ident, challenge.Nonce, challenge.Stamp, SaveMethod, paramsSum[:], 16)
+    require.False(t, checkMac(auth, storedKey, message))
+    otherSum := sha256.Sum256([]byte("other params"))
+    message = authMessage(ident, challenge.Nonce, challenge.Stamp, HelloMethod, otherSum[:], 16)
+    require.False(t, checkMac(auth, storedKey, message))
+    message = authMessage(ident, challenge.Nonce, challenge.Stamp, HelloMethod, paramsSum[:], 17)
+    require.False(t, checkMac(auth, storedKey, message))
+    message = authMessage(ident, challenge.Nonce, challenge.Stamp + 1, HelloMethod, paramsSum[:], 16)
+    require.False(t, checkMac(auth, storedKey, message))
+
+    legacy := CreateLegacyAuth(ident, pass)
+    require.True(t, legacy.IsLegacy())
+    require.True(t, CheckHash(ident, pass, legacy.Salt, legacy.Hash))
+}
+
+func TestPassHash(t *testing.T) {
+    var err error
+    pass := []byte("12345")
+
+    record := HashPass(pass)
+    require.True(t, IsPassHash(record))
+    require.False(t, IsPassHash(string(pass)))
+    require.NotContains(t, record, string(pass))
+
+    // Salts are random, equal passwords make different records
+    require.NotEqual(t, record, HashPass(pass))
+
+    ok, err := CheckPass(pass, record)
+    require.NoError(t, err)
+    require.True(t, ok)
+
+    ok, err = CheckPass([]byte("54321"), record)
+    require.NoError(t, err)
+    require.False(t, ok)
+
+    // The key is derived with the params of the record
+    params := &PassParams{ Memory: 8 * 1024, Time: 1, Threads: 1 }
+    salt := CreateSalt()
+    storedKey := StoredKey(DeriveKey(pass, salt, params))
+    encoder := base64.RawStdEncoding
+    record = fmt.Sprintf("$%s$m=%d,t=%d,p=%d$%s$%s", PassHashScheme,
+                    params.Memory, params.Time, params.Threads,
+                    encoder.EncodeToString(salt), encoder.EncodeToString(storedKey))
+    ok, err = CheckPass(pass, record)
+    require.NoError(t, err)
+    require.True(t, ok)
+
+    recParams, recSalt, recKey, err := ParsePassHash(record)
+    require.NoError(t, err)
+    require.Equal(t, params, recParams)
+    require.Equal(t, salt, recSalt)
+    require.Equal(t, storedKey, recKey)
+}
+
+func TestAuthKey(t *testing.T) {
+    var err error
+    auth := CreateAuth([]byte("qwert"), []byte("12345"))
+    salt := CreateSalt()
+    params := DefaultPassParams()
+
+    clientKey, err := auth.clientKey(salt, params)
+    require.NoError(t, err)
+    require.Equal(t, DeriveKey([]byte("12345"), salt, params), clientKey)
+
+    // The key is derived once for the same salt and params
+    auth.key.clientKey = []byte("cached")
+    clientKey, err = auth.clientKey(salt, params)
+    require.NoError(t, err)
+    require.Equal(t, []byte("cached"), clientKey)
+
+    clientKey, err = auth.clientKey(CreateSalt(), params)
+    require.NoError(t, err)
+    require.NotEqual(t, []byte("cached"), clientKey)
+
+    // The client refuses too expensive params of the server
+    _, err = auth.clientKey(salt, &PassParams{ Memory: 4 * 1024 * 1024, Time: 1, Threads: 1 })
+    require.Error(t, err)
+    _, err = auth.clientKey(salt, &PassParams{ Memory: 8 * 1024, Time: 1000, Threads: 1 })
+    require.Error(t, err)
+}
+
+// signedHello makes the hello request signed with
+// the challenge of the connection.
+func signedHello(t *testing.T, auth *Auth) *Context {
+    conn, err := dial("127.0.0.1:8081")
+    require.NoError(t, err)
+    t.Cleanup(func() { conn.Close() })
+
+    context := CreateContext(conn)
+    context.reqRPC.Method = HelloMethod
+    context.reqRPC.Params = NewHelloParams()
+    context.resRPC.Result = NewHelloResult()
+    err = context.signRequest(conn, auth)
+    require.NoError(t, err)
+    return context
+}
+
+func sendRequest(context *Context) error {
+    var err error
+    err = context.CreateRequest()
+    if err != nil {
+        return err
+    }
+    err = context.WriteRequest()
+    if err != nil {
+        return err
+    }
+    err = context.ReadResponse()
+    if err != nil {
+        return err
+    }
+    return context.BindResponse()
+}
+
+func TestNetReplay(t *testing.T) {
+    var err error
+    go testServ(false)
+    time.Sleep(10 * time.Millisecond)
+
+    params := NewHelloParams()
+    result := NewHelloResult()
+    auth := CreateAuth([]byte("qwert"), []byte("12345"))
+
+    // The auth is reused, every request gets its own challenge
+    err = Exec("127.0.0.1:8081", HelloMethod, params, result, auth)
+    require.NoError(t, err)
+    err = Exec("127.0.0.1:8081", HelloMethod, params, result, auth)
+    require.NoError(t, err)
+
+    err = Exec("127.0.0.1:8081", HelloMethod, params, result, CreateAuth([]byte("qwert"), []byte("54321")))
+    require.Error(t, err)
+
+    // The signed request is rejected on another connection
+    context := signedHello(t, auth)
+    err = context.CreateRequest()
+    require.NoError(t, err)
+    replayConn, err := dial("127.0.0.1:8081")
+    require.NoError(t, err)
+    defer replayConn.Close()
+    replay := CreateContext(replayConn)
+    replay.reqPacket = context.reqPacket
+    replay.resRPC.Result = NewHelloResult()
+    err = replay.WriteRequest()
+    require.NoError(t, err)
+    err = replay.ReadResponse()
+    require.NoError(t, err)
+    err = replay.BindResponse()
+    require.Error(t, err)
+
+    // The params cannot be replaced under the signature
+    context = signedHello(t, auth)
+    otherParams := NewHelloParams()
+    otherParams.Message = "other message"
+    paramsBin, err := encoder.Marshal(otherParams)
+    require.NoError(t, err)
+    context.reqRPC.Params = encoder.RawMessage(paramsBin)
+    err = sendRequest(context)
+    require.Error(t, err)
+
+    context = signedHello(t, auth)
+    context.reqRPC.Method = SaveMethod
+    err = sendRequest(context)
+    require.Error(t, err)
+
+    context = signedHello(t, auth)
+    err = sendRequest(context)
+    require.NoError(t, err)
+}
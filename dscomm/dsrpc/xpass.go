@@ -0,0 +1,146 @@
+/*
+ * Copyright 2022 Oleg Borodin  <borodin@unix7.org>
+ */
+
+package dsrpc
+
+import (
+    "crypto/hmac"
+    "crypto/sha256"
+    "crypto/subtle"
+    "encoding/base64"
+    "errors"
+    "fmt"
+    "strings"
+
+    "golang.org/x/crypto/argon2"
+)
+
+// The password record keeps only the stored key, so the server
+// verifies the client proof without knowing the password:
+//
+//  clientKey = argon2id(pass, salt, params)
+//  storedKey = sha256(clientKey)
+//  record    = $fdauth1$m=...,t=...,p=...$salt$storedKey
+//
+// The salt is random, the server sends the salt and the params
+// of the record to the client with the auth challenge.
+
+const PassHashScheme    string = "fdauth1"
+
+const passKeySize       uint32 = 32
+const passSaltSize      int    = 16
+
+const maxPassMemory     uint32 = 1024 * 1024
+const maxPassTime       uint32 = 16
+const maxPassThreads    uint8  = 16
+
+type PassParams struct {
+    Memory      uint32      `msgpack:"memory"   json:"memory"`
+    Time        uint32      `msgpack:"time"     json:"time"`
+    Threads     uint8       `msgpack:"threads"  json:"threads"`
+}
+
+func DefaultPassParams() *PassParams {
+    return &PassParams{
+        Memory:     19 * 1024,
+        Time:       2,
+        Threads:    1,
+    }
+}
+
+// Check bounds the params received from the server,
+// the key derivation must not exhaust the client.
+func (params *PassParams) Check() error {
+    var err error
+    if params.Memory < 8 * uint32(params.Threads) || params.Memory > maxPassMemory {
+        err = fmt.Errorf("wrong password params memory %d", params.Memory)
+        return Err(err)
+    }
+    if params.Time < 1 || params.Time > maxPassTime {
+        err = fmt.Errorf("wrong password params time %d", params.Time)
+        return Err(err)
+    }
+    if params.Threads < 1 || params.Threads > maxPassThreads {
+        err = fmt.Errorf("wrong password params threads %d", params.Threads)
+        return Err(err)
+    }
+    return Err(err)
+}
+
+// DeriveKey makes the client key with the salt and the params
+// of the password record.
+func DeriveKey(pass, salt []byte, params *PassParams) []byte {
+    return argon2.IDKey(pass, salt, params.Time, params.Memory, params.Threads, passKeySize)
+}
+
+func StoredKey(clientKey []byte) []byte {
+    sum := sha256.Sum256(clientKey)
+    return sum[:]
+}
+
+// HashPass makes the password record for the registry
+// with a random salt and default parameters.
+func HashPass(pass []byte) string {
+    params := DefaultPassParams()
+    salt := CreateSalt()
+    storedKey := StoredKey(DeriveKey(pass, salt, params))
+    encoder := base64.RawStdEncoding
+    return fmt.Sprintf("$%s$m=%d,t=%d,p=%d$%s$%s", PassHashScheme,
+                    params.Memory, params.Time, params.Threads,
+                    encoder.EncodeToString(salt), encoder.EncodeToString(storedKey))
+}
+
+// DummySalt makes the salt sent for unknown idents,
+// it is stable for the ident while the secret is the same.
+func DummySalt(secret, ident []byte) []byte {
+    mac := hmac.New(sha256.New, secret)
+    mac.Write(ident)
+    return mac.Sum(nil)[0:passSaltSize]
+}
+
+// IsPassHash distinguishes password records from plaintext passwords
+// left by previous versions.
+func IsPassHash(record string) bool {
+    return strings.HasPrefix(record, "$" + PassHashScheme + "$")
+}
+
+func ParsePassHash(record string) (*PassParams, []byte, []byte, error) {
+    var err error
+    var salt, storedKey []byte
+    params := &PassParams{}
+
+    parts := strings.Split(record, "$")
+    if len(parts) != 5 || parts[1] != PassHashScheme {
+        err = errors.New("wrong password record format")
+        return params, salt, storedKey, Err(err)
+    }
+    _, err = fmt.Sscanf(parts[2], "m=%d,t=%d,p=%d", &params.Memory, &params.Time, &params.Threads)
+    if err != nil {
+        err = fmt.Errorf("wrong password record params: %s", err)
+        return params, salt, storedKey, Err(err)
+    }
+    encoder := base64.RawStdEncoding
+    salt, err = encoder.DecodeString(parts[3])
+    if err != nil {
+        return params, salt, storedKey, Err(err)
+    }
+    storedKey, err = encoder.DecodeString(parts[4])
+    if err != nil {
+        return params, salt, storedKey, Err(err)
+    }
+    return params, salt, storedKey, Err(err)
+}
+
+// CheckPass verifies the password against the record.
+func CheckPass(pass []byte, record string) (bool, error) {
+    var err error
+    var ok bool
+    params, salt, storedKey, err := ParsePassHash(record)
+    if err != nil {
+        return ok, Err(err)
+    }
+    localKey := StoredKey(DeriveKey(pass, salt, params))
+    ok = subtle.ConstantTimeCompare(localKey, storedKey) == 1
+    return ok, Err(err)
+}
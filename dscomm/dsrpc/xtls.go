@@ -0,0 +1,101 @@
+/*
+ * Copyright 2022 Oleg Borodin  <borodin@unix7.org>
+ */
+
+package dsrpc
+
+import (
+    "crypto/tls"
+    "crypto/x509"
+    "errors"
+    "fmt"
+    "os"
+    "sync"
+)
+
+var clientTLSConfig *tls.Config
+var clientTLSMtx sync.Mutex
+
+// SetClientTLSConfig enables TLS for Exec, Put and Get calls.
+// A nil config returns the client to plain TCP.
+func SetClientTLSConfig(config *tls.Config) {
+    clientTLSMtx.Lock()
+    defer clientTLSMtx.Unlock()
+    clientTLSConfig = config
+}
+
+func getClientTLSConfig() *tls.Config {
+    clientTLSMtx.Lock()
+    defer clientTLSMtx.Unlock()
+    return clientTLSConfig
+}
+
+// NewServerTLSConfig loads the server certificate and key.
+// If clientCAFile is not empty, clients must present a certificate
+// signed by one of the CAs from this file (mutual TLS).
+func NewServerTLSConfig(certFile, keyFile, clientCAFile string) (*tls.Config, error) {
+    var err error
+    config := &tls.Config{
+        MinVersion: tls.VersionTLS12,
+    }
+    cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+    if err != nil {
+        err = fmt.Errorf("unable to load server key pair: %s", err)
+        return config, Err(err)
+    }
+    config.Certificates = []tls.Certificate{ cert }
+
+    if len(clientCAFile) > 0 {
+        pool, err := loadCertPool(clientCAFile)
+        if err != nil {
+            return config, Err(err)
+        }
+        config.ClientCAs = pool
+        config.ClientAuth = tls.RequireAndVerifyClientCert
+    }
+    return config, Err(err)
+}
+
+// NewClientTLSConfig builds a client config verifying the server
+// against CAs from caFile and the expected serverName. Empty caFile
+// means the system pool. The optional key pair is sent to the server
+// when it requires mutual TLS.
+func NewClientTLSConfig(caFile, serverName, certFile, keyFile string) (*tls.Config, error) {
+    var err error
+    config := &tls.Config{
+        MinVersion: tls.VersionTLS12,
+        ServerName: serverName,
+    }
+    if len(caFile) > 0 {
+        pool, err := loadCertPool(caFile)
+        if err != nil {
+            return config, Err(err)
+        }
+        config.RootCAs = pool
+    }
+    if len(certFile) > 0 || len(keyFile) > 0 {
+        cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+        if err != nil {
+            err = fmt.Errorf("unable to load client key pair: %s", err)
+            return config, Err(err)
+        }
+        config.Certificates = []tls.Certificate{ cert }
+    }
+    return config, Err(err)
+}
+
+func loadCertPool(caFile string) (*x509.CertPool, error) {
+    var err error
+    pool := x509.NewCertPool()
+    caBytes, err := os.ReadFile(caFile)
+    if err != nil {
+        err = fmt.Errorf("unable to read ca file: %s", err)
+        return pool, Err(err)
+    }
+    ok := pool.AppendCertsFromPEM(caBytes)
+    if !ok {
+        err = errors.New("no certificates found in ca file")
+        return pool, Err(err)
+    }
+    return pool, Err(err)
+}
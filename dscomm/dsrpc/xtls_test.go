@@ -0,0 +1,105 @@
+/*
+ * Copyright 2022 Oleg Borodin  <borodin@unix7.org>
+ */
+
+package dsrpc
+
+import (
+    "crypto/ecdsa"
+    "crypto/elliptic"
+    "crypto/rand"
+    "crypto/x509"
+    "crypto/x509/pkix"
+    "encoding/pem"
+    "math/big"
+    "os"
+    "path/filepath"
+    "testing"
+    "time"
+
+    "github.com/stretchr/testify/require"
+)
+
+func TestNetTLSExec(t *testing.T) {
+    var err error
+    certDir := t.TempDir()
+
+    caCert, caKey := writeTestCert(t, certDir, "ca", nil, nil)
+    writeTestCert(t, certDir, "server", caCert, caKey)
+    writeTestCert(t, certDir, "client", caCert, caKey)
+
+    caFile := filepath.Join(certDir, "ca.crt")
+
+    srvConfig, err := NewServerTLSConfig(filepath.Join(certDir, "server.crt"),
+                            filepath.Join(certDir, "server.key"), caFile)
+    require.NoError(t, err)
+
+    serv := NewService()
+    serv.SetTLSConfig(srvConfig)
+    serv.SetSaltFunc(testSalt)
+    serv.PreMiddleware(auth)
+    serv.Handler(HelloMethod, helloHandler)
+    go serv.Listen("127.0.0.1:8082")
+    time.Sleep(10 * time.Millisecond)
+    defer SetClientTLSConfig(nil)
+
+    params := NewHelloParams()
+    result := NewHelloResult()
+    auth := CreateAuth([]byte("qwert"), []byte("12345"))
+
+    // Plain client must not pass the handshake
+    SetClientTLSConfig(nil)
+    err = Exec("127.0.0.1:8082", HelloMethod, params, result, auth)
+    require.Error(t, err)
+
+    // Client without certificate is rejected by mutual TLS
+    cliConfig, err := NewClientTLSConfig(caFile, "localhost", "", "")
+    require.NoError(t, err)
+    SetClientTLSConfig(cliConfig)
+    err = Exec("127.0.0.1:8082", HelloMethod, params, result, auth)
+    require.Error(t, err)
+
+    cliConfig, err = NewClientTLSConfig(caFile, "localhost",
+                            filepath.Join(certDir, "client.crt"), filepath.Join(certDir, "client.key"))
+    require.NoError(t, err)
+    SetClientTLSConfig(cliConfig)
+    err = Exec("127.0.0.1:8082", HelloMethod, params, result, auth)
+    require.NoError(t, err)
+    require.Equal(t, "hello, client!", result.Message)
+}
+
+func writeTestCert(t *testing.T, dir, name string, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+    key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+    require.NoError(t, err)
+
+    template := &x509.Certificate{
+        SerialNumber:   big.NewInt(time.Now().UnixNano()),
+        Subject:        pkix.Name{ CommonName: name },
+        DNSNames:       []string{ "localhost" },
+        NotBefore:      time.Now().Add(-time.Hour),
+        NotAfter:       time.Now().Add(time.Hour),
+        KeyUsage:       x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+        ExtKeyUsage:    []x509.ExtKeyUsage{ x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth },
+    }
+    if parent == nil {
+        template.IsCA = true
+        template.BasicConstraintsValid = true
+        parent = template
+        parentKey = key
+    }
+    certBin, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+    require.NoError(t, err)
+    cert, err := x509.ParseCertificate(certBin)
+    require.NoError(t, err)
+
+    keyBin, err := x509.MarshalECPrivateKey(key)
+    require.NoError(t, err)
+
+    certPem := pem.EncodeToMemory(&pem.Block{ Type: "CERTIFICATE", Bytes: certBin })
+    err = os.WriteFile(filepath.Join(dir, name + ".crt"), certPem, 0644)
+    require.NoError(t, err)
+    keyPem := pem.EncodeToMemory(&pem.Block{ Type: "EC PRIVATE KEY", Bytes: keyBin })
+    err = os.WriteFile(filepath.Join(dir, name + ".key"), keyPem, 0600)
+    require.NoError(t, err)
+    return cert, key
+}
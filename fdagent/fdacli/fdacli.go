@@ -30,6 +30,7 @@ func main() {
 type Util struct {
     aLogin      string
     aPass       string
+    aLegacy     bool
 
     Port        string
     Address     string
@@ -40,6 +41,12 @@ type Util struct {
     Login       string
     Pass        string
 
+    TLS         bool
+    TLSCA       string
+    TLSName     string
+    TLSCert     string
+    TLSKey      string
+
     bPort       string
     bAddress    string
 
@@ -87,6 +94,13 @@ func (util *Util) GetOpt() error {
     flag.StringVar(&util.Address, "address", util.Address, "service address")
     flag.StringVar(&util.aLogin, "aLogin", util.aLogin, "access login")
     flag.StringVar(&util.aPass, "aPass", util.aPass, "access password")
+    flag.BoolVar(&util.aLegacy, "aLegacy", util.aLegacy, "use legacy auth for not migrated servers")
+
+    flag.BoolVar(&util.TLS, "tls", util.TLS, "use tls")
+    flag.StringVar(&util.TLSCA, "tlsCA", util.TLSCA, "tls ca bundle file, system pool if empty")
+    flag.StringVar(&util.TLSName, "tlsName", util.TLSName, "tls server name, address if empty")
+    flag.StringVar(&util.TLSCert, "tlsCert", util.TLSCert, "tls client certificate file")
+    flag.StringVar(&util.TLSKey, "tlsKey", util.TLSKey, "tls client key file")
 
     help := func() {
         fmt.Println("")
@@ -206,7 +220,21 @@ func (util *Util) Exec() error {
         return err
     }
     util.URI = fmt.Sprintf("%s:%s", util.Address, util.Port)
+    if util.TLS {
+        serverName := util.TLSName
+        if len(serverName) == 0 {
+            serverName = util.Address
+        }
+        tlsConfig, err := dsrpc.NewClientTLSConfig(util.TLSCA, serverName, util.TLSCert, util.TLSKey)
+        if err != nil {
+            return err
+        }
+        dsrpc.SetClientTLSConfig(tlsConfig)
+    }
     auth := dsrpc.CreateAuth([]byte(util.aLogin), []byte(util.aPass))
+    if util.aLegacy {
+        auth = dsrpc.CreateLegacyAuth([]byte(util.aLogin), []byte(util.aPass))
+    }
 
     resp := NewResponse(nil, nil)
     var result interface{}
@@ -13,13 +13,13 @@ import (
 
 type Store struct {
     dataDir     string
-    reg         dsinter.BStoreReg
+    reg         dsinter.UserReg
     dirPerm     fs.FileMode
     filePerm    fs.FileMode
     startTime   int64
 }
 
-func NewStore(dataDir string, reg dsinter.BStoreReg) (*Store, error) {
+func NewStore(dataDir string, reg dsinter.UserReg) (*Store, error) {
     var err error
     var store Store
     store.dataDir   = dataDir
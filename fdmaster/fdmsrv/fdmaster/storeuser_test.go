@@ -10,7 +10,8 @@ import (
 
     "fdump/dscomm/dskvdb"
     "fdump/dscomm/dsdescr"
-    "fdump/fdmaster/fdasrv/fdareg"
+    "fdump/dscomm/dsrpc"
+    "fdump/fdmaster/fdmsrv/fdmreg"
 )
 
 
@@ -23,7 +24,7 @@ func TestUser01(t *testing.T) {
     defer db.Close()
     require.NoError(t, err)
 
-    reg, err := bsreg.NewReg(db)
+    reg, err := fdmreg.NewReg(db)
     require.NoError(t, err)
 
     store, err := NewStore(dataDir, reg)
@@ -32,9 +33,10 @@ func TestUser01(t *testing.T) {
     err = store.SeedUsers()
     require.NoError(t, err)
 
+    pass0 := "123456"
     descr0 := dsdescr.NewUser()
     descr0.Login    = "qwerty"
-    descr0.Pass     = "123456"
+    descr0.Pass     = pass0
 
     adminLogin   := "admin"
     wrongLogin   := "wrong"
@@ -49,13 +51,18 @@ func TestUser01(t *testing.T) {
     require.NoError(t, err)
     require.Equal(t, has, true)
     require.Equal(t, descr0, descr1)
+    require.NotEqual(t, pass0, descr1.Pass)
 
     var ok bool
-    ok, err = store.CheckUser(adminLogin, descr0.Login, descr0.Pass)
+    ok, err = store.CheckUser(adminLogin, descr0.Login, pass0)
     require.NoError(t, err)
     require.Equal(t, true, ok)
 
-    ok, err = store.CheckUser(wrongLogin, descr0.Login, descr0.Pass)
+    ok, err = store.CheckUser(adminLogin, descr0.Login, "654321")
+    require.NoError(t, err)
+    require.Equal(t, false, ok)
+
+    ok, err = store.CheckUser(wrongLogin, descr0.Login, pass0)
     require.Error(t, err)
     require.Equal(t, false, ok)
 
@@ -76,3 +83,48 @@ func TestUser01(t *testing.T) {
     require.Equal(t, len(descrs), 2)
 
 }
+
+func TestUserUpgrade(t *testing.T) {
+    var err error
+
+    dataDir := t.TempDir()
+
+    db, err := dskvdb.OpenDB(dataDir, "storedb")
+    defer db.Close()
+    require.NoError(t, err)
+
+    reg, err := fdmreg.NewReg(db)
+    require.NoError(t, err)
+
+    store, err := NewStore(dataDir, reg)
+    require.NoError(t, err)
+
+    // Plaintext record of previous versions
+    descr0 := dsdescr.NewUser()
+    descr0.Login    = "qwerty"
+    descr0.Pass     = "123456"
+    descr0.Role     = dsdescr.URoleUser
+    descr0.State    = dsdescr.UStateEnabled
+    err = reg.PutUser(descr0)
+    require.NoError(t, err)
+
+    ok, err := store.CheckUser(descr0.Login, descr0.Login, "654321")
+    require.NoError(t, err)
+    require.Equal(t, false, ok)
+
+    _, descr1, err := store.GetUser(descr0.Login)
+    require.NoError(t, err)
+    require.Equal(t, "123456", descr1.Pass)
+
+    ok, err = store.CheckUser(descr0.Login, descr0.Login, "123456")
+    require.NoError(t, err)
+    require.Equal(t, true, ok)
+
+    _, descr1, err = store.GetUser(descr0.Login)
+    require.NoError(t, err)
+    require.True(t, dsrpc.IsPassHash(descr1.Pass))
+
+    ok, err = store.CheckUser(descr0.Login, descr0.Login, "123456")
+    require.NoError(t, err)
+    require.Equal(t, true, ok)
+}
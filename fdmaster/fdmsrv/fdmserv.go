@@ -23,6 +23,7 @@ import (
 
     "fdump/dscomm/dskvdb"
     "fdump/dscomm/dslog"
+    "fdump/dscomm/dsauth"
     "fdump/dscomm/dsrpc"
     "fdump/dscomm/dserr"
 )
@@ -128,6 +129,12 @@ func (server *Server) GetOptions() error {
     flag.StringVar(&server.Params.Port, "port", server.Params.Port, "listen port")
     flag.BoolVar(&server.Backgr, "daemon", server.Backgr, "run as daemon")
 
+    flag.BoolVar(&server.Params.TLSEnable, "tls", server.Params.TLSEnable, "enable tls")
+    flag.StringVar(&server.Params.TLSCert, "tlsCert", server.Params.TLSCert, "tls certificate file")
+    flag.StringVar(&server.Params.TLSKey, "tlsKey", server.Params.TLSKey, "tls key file")
+    flag.StringVar(&server.Params.TLSClientCA, "tlsClientCA", server.Params.TLSClientCA, "tls client ca file, enables mutual tls")
+    flag.BoolVar(&server.Params.AuthLegacy, "authLegacy", server.Params.AuthLegacy, "accept legacy salt+pass auth of old clients")
+
     help := func() {
         fmt.Println("")
         fmt.Printf("usage: %s [option]\n", exeName)
@@ -460,6 +467,11 @@ func (server *Server) RunService() error {
     if err != nil {
         return err
     }
+    auth := dsauth.NewAuth(store)
+    auth.SetLegacyAuth(server.Params.AuthLegacy)
+    if server.Params.AuthLegacy {
+        dslog.LogWarning("legacy auth enabled")
+    }
 
     dslog.LogInfof("dataDir is %s", server.Params.DataDir)
     dslog.LogInfof("logDir is %s", server.Params.LogDir)
@@ -467,10 +479,21 @@ func (server *Server) RunService() error {
 
     serv := dsrpc.NewService()
 
+    if server.Params.TLSEnable {
+        tlsConfig, err := dsrpc.NewServerTLSConfig(server.Params.TLSCert,
+                                server.Params.TLSKey, server.Params.TLSClientCA)
+        if err != nil {
+            return err
+        }
+        serv.SetTLSConfig(tlsConfig)
+        dslog.LogInfof("tls cert is %s", server.Params.TLSCert)
+    }
+
     if debugMode || develMode {
         serv.PreMiddleware(dsrpc.LogRequest)
     }
-    serv.PreMiddleware(contr.AuthMidware(debugMode))
+    serv.PreMiddleware(auth.Midware(debugMode))
+    serv.SetSaltFunc(auth.Salt)
 
     serv.Handler(fdmapi.AddUserMethod, contr.AddUserHandler)
     serv.Handler(fdmapi.CheckUserMethod, contr.CheckUserHandler)
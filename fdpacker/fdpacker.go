@@ -5,6 +5,8 @@
 package main
 
 import (
+    "bufio"
+    "bytes"
     "encoding/json"
     "fmt"
     "io/fs"
@@ -12,6 +14,9 @@ import (
     "os"
     "path/filepath"
     "errors"
+    "strconv"
+    "io"
+    "strings"
 
     "fdump/dscomm/dspack"
 )
@@ -30,21 +35,50 @@ func main() {
 type Util struct {
     SubCmd      string
     PackPath    string
+    TarPath     string
+    Report      string
 
     DestDir     string
     FileList    []string
+    FilesFrom   string
+    NoRecursion bool
+    ExcludeFrom string
+    IgnoreFile  string
+    OneFS       bool
+    MaxFileSize string
+    Exclude     string
+    Codec       string
+
+    EncryptTo   string
+    Identity    string
+    PassFile    string
+    SkipAttrs   bool
+    Salvage     bool
+
+    Since       string
+    Manifest    string
 }
 
 func NewUtil() *Util {
     var util Util
+    util.Codec = dspack.CodecNone
+    util.IgnoreFile = dspack.IgnoreFileName
     return &util
 }
 
 const packCmd      string = "pack"
 const unpackCmd    string = "unpack"
 const listCmd      string = "list"
+const keygenCmd    string = "keygen"
+const chainCmd     string = "restore-chain"
+const verifyCmd    string = "verify"
+const exportTarCmd string = "export-tar"
+const importTarCmd string = "import-tar"
 const helpCmd      string = "help"
 
+// stdPath is the pack or tar file name for stdin and stdout.
+const stdPath      string = "-"
+
 
 func (util *Util) GetOpt() error {
     var err error
@@ -55,13 +89,15 @@ func (util *Util) GetOpt() error {
         fmt.Println("")
         fmt.Printf("Usage: %s [option] command [command option]\n", exeName)
         fmt.Printf("\n")
-        fmt.Printf("Command list: help, pack, unpack, list\n")
+        fmt.Printf("Command list: help, pack, unpack, list, verify, keygen, restore-chain, \n")
+        fmt.Printf("    export-tar, import-tar\n")
 
-        //fmt.Printf("\n")
-        //fmt.Printf("Global options:\n")
-        //flag.PrintDefaults()
-        //fmt.Printf("\n")
+        fmt.Printf("\n")
+        fmt.Printf("Global options:\n")
+        flag.PrintDefaults()
+        fmt.Printf("\n")
     }
+    flag.StringVar(&util.Report, "report", util.Report, "file name to write the response, by default stdout or stderr if stdout is the pack")
     flag.Usage = help
     flag.Parse()
 
@@ -83,12 +119,24 @@ func (util *Util) GetOpt() error {
             util.SubCmd = subCmd
         case packCmd:
             flagSet := flag.NewFlagSet(packCmd, flag.ExitOnError)
-            flagSet.StringVar(&util.PackPath, "pack", util.PackPath, "pack file name")
+            flagSet.StringVar(&util.PackPath, "pack", util.PackPath, "pack file name, - for stdout")
+            flagSet.StringVar(&util.Codec, "codec", util.Codec, "file codec: none, zstd, gzip")
+            flagSet.StringVar(&util.EncryptTo, "encrypt-to", util.EncryptTo, "comma separated recipient public keys or key files")
+            flagSet.StringVar(&util.PassFile, "passfile", util.PassFile, "encryption passphrase file")
+            flagSet.StringVar(&util.Since, "since", util.Since, "previous manifest file for incremental pack")
+            flagSet.StringVar(&util.Manifest, "manifest", util.Manifest, "manifest file to write")
+            flagSet.StringVar(&util.FilesFrom, "files0-from", util.FilesFrom, "file with NUL separated sources, - for stdin")
+            flagSet.BoolVar(&util.NoRecursion, "no-recursion", util.NoRecursion, "do not pack contents of source dirs")
+            flagSet.StringVar(&util.Exclude, "exclude", util.Exclude, "comma separated gitignore-style exclude patterns")
+            flagSet.StringVar(&util.ExcludeFrom, "exclude-from", util.ExcludeFrom, "file with exclude patterns, one pattern per line")
+            flagSet.StringVar(&util.IgnoreFile, "ignore-file", util.IgnoreFile, "name of ignore files with exclude patterns in dirs, empty to disable")
+            flagSet.BoolVar(&util.OneFS, "one-file-system", util.OneFS, "pack mount points of other file systems without contents")
+            flagSet.StringVar(&util.MaxFileSize, "max-file-size", util.MaxFileSize, "skip files larger than the size, with K, M or G suffix")
             flagSet.Usage = func() {
                 fmt.Printf("\n")
                 fmt.Printf("Usage: %s [global options] %s [command options] sources\n", exeName, subCmd)
                 fmt.Printf("\n")
-                fmt.Printf("The command options: none\n")
+                fmt.Printf("The command options:\n")
                 flagSet.PrintDefaults()
                 fmt.Printf("\n")
             }
@@ -98,12 +146,17 @@ func (util *Util) GetOpt() error {
 
         case unpackCmd:
             flagSet := flag.NewFlagSet(unpackCmd, flag.ExitOnError)
-            flagSet.StringVar(&util.PackPath, "pack", util.PackPath, "pack file name")
-            flagSet.StringVar(&util.PackPath, "dest", util.DestDir, "destination directory")
+            flagSet.StringVar(&util.PackPath, "pack", util.PackPath, "pack file name, - for stdin")
+            flagSet.StringVar(&util.DestDir, "dest", util.DestDir, "destination directory")
+            flagSet.StringVar(&util.Exclude, "exclude", util.Exclude, "comma separated exclude patterns")
+            flagSet.BoolVar(&util.SkipAttrs, "skip-attrs", util.SkipAttrs, "do not restore extended attributes and file flags")
+            flagSet.StringVar(&util.Identity, "identity", util.Identity, "comma separated identity files")
+            flagSet.StringVar(&util.PassFile, "passfile", util.PassFile, "encryption passphrase file")
+            flagSet.BoolVar(&util.Salvage, "salvage", util.Salvage, "skip damaged ranges of the archive and go on")
 
             flagSet.Usage = func() {
                 fmt.Printf("\n")
-                fmt.Printf("Usage: %s [global options] %s [command options]\n", exeName, subCmd)
+                fmt.Printf("Usage: %s [global options] %s [command options] [patterns]\n", exeName, subCmd)
                 fmt.Printf("\n")
                 fmt.Printf("The command options:\n")
                 flagSet.PrintDefaults()
@@ -115,13 +168,89 @@ func (util *Util) GetOpt() error {
 
         case listCmd:
             flagSet := flag.NewFlagSet(listCmd, flag.ExitOnError)
-            flagSet.StringVar(&util.PackPath, "pack", util.PackPath, "pack file name")
+            flagSet.StringVar(&util.PackPath, "pack", util.PackPath, "pack file name, - for stdin")
+            flagSet.StringVar(&util.Exclude, "exclude", util.Exclude, "comma separated exclude patterns")
+            flagSet.StringVar(&util.Identity, "identity", util.Identity, "comma separated identity files")
+            flagSet.StringVar(&util.PassFile, "passfile", util.PassFile, "encryption passphrase file")
+
+            flagSet.Usage = func() {
+                fmt.Printf("\n")
+                fmt.Printf("Usage: %s [global options] %s [command options] [patterns]\n", exeName, subCmd)
+                fmt.Printf("\n")
+                fmt.Printf("The command options:\n")
+                flagSet.PrintDefaults()
+                fmt.Printf("\n")
+            }
+            flagSet.Parse(subArgs)
+            util.SubCmd = subCmd
+            util.FileList = flagSet.Args()
+
+        case verifyCmd:
+            flagSet := flag.NewFlagSet(verifyCmd, flag.ExitOnError)
+            flagSet.StringVar(&util.PackPath, "pack", util.PackPath, "pack file name, - for stdin")
+            flagSet.StringVar(&util.Identity, "identity", util.Identity, "comma separated identity files")
+            flagSet.StringVar(&util.PassFile, "passfile", util.PassFile, "encryption passphrase file")
+
+            flagSet.Usage = func() {
+                fmt.Printf("\n")
+                fmt.Printf("Usage: %s [global options] %s [command options]\n", exeName, subCmd)
+                fmt.Printf("\n")
+                fmt.Printf("The command options:\n")
+                flagSet.PrintDefaults()
+                fmt.Printf("\n")
+            }
+            flagSet.Parse(subArgs)
+            util.SubCmd = subCmd
+
+        case exportTarCmd:
+            flagSet := flag.NewFlagSet(exportTarCmd, flag.ExitOnError)
+            flagSet.StringVar(&util.PackPath, "pack", util.PackPath, "pack file name, - for stdin")
+            flagSet.StringVar(&util.TarPath, "tar", util.TarPath, "tar file name to write, - for stdout")
+            flagSet.StringVar(&util.Identity, "identity", util.Identity, "comma separated identity files")
+            flagSet.StringVar(&util.PassFile, "passfile", util.PassFile, "encryption passphrase file")
 
             flagSet.Usage = func() {
                 fmt.Printf("\n")
                 fmt.Printf("Usage: %s [global options] %s [command options]\n", exeName, subCmd)
                 fmt.Printf("\n")
-                fmt.Printf("The command options: none\n")
+                fmt.Printf("The command options:\n")
+                flagSet.PrintDefaults()
+                fmt.Printf("\n")
+            }
+            flagSet.Parse(subArgs)
+            util.SubCmd = subCmd
+
+        case importTarCmd:
+            flagSet := flag.NewFlagSet(importTarCmd, flag.ExitOnError)
+            flagSet.StringVar(&util.TarPath, "tar", util.TarPath, "tar file name to read, - for stdin")
+            flagSet.StringVar(&util.PackPath, "pack", util.PackPath, "pack file name to write, - for stdout")
+            flagSet.StringVar(&util.Codec, "codec", util.Codec, "file codec: none, zstd, gzip")
+            flagSet.StringVar(&util.EncryptTo, "encrypt-to", util.EncryptTo, "comma separated recipient public keys or key files")
+            flagSet.StringVar(&util.PassFile, "passfile", util.PassFile, "encryption passphrase file")
+
+            flagSet.Usage = func() {
+                fmt.Printf("\n")
+                fmt.Printf("Usage: %s [global options] %s [command options]\n", exeName, subCmd)
+                fmt.Printf("\n")
+                fmt.Printf("The command options:\n")
+                flagSet.PrintDefaults()
+                fmt.Printf("\n")
+            }
+            flagSet.Parse(subArgs)
+            util.SubCmd = subCmd
+
+        case chainCmd:
+            flagSet := flag.NewFlagSet(chainCmd, flag.ExitOnError)
+            flagSet.StringVar(&util.DestDir, "dest", util.DestDir, "destination directory")
+            flagSet.BoolVar(&util.SkipAttrs, "skip-attrs", util.SkipAttrs, "do not restore extended attributes and file flags")
+            flagSet.StringVar(&util.Identity, "identity", util.Identity, "comma separated identity files")
+            flagSet.StringVar(&util.PassFile, "passfile", util.PassFile, "encryption passphrase file")
+
+            flagSet.Usage = func() {
+                fmt.Printf("\n")
+                fmt.Printf("Usage: %s [global options] %s [command options] full-pack [increment-packs]\n", exeName, subCmd)
+                fmt.Printf("\n")
+                fmt.Printf("The command options:\n")
                 flagSet.PrintDefaults()
                 fmt.Printf("\n")
             }
@@ -129,6 +258,21 @@ func (util *Util) GetOpt() error {
             util.SubCmd = subCmd
             util.FileList = flagSet.Args()
 
+        case keygenCmd:
+            flagSet := flag.NewFlagSet(keygenCmd, flag.ExitOnError)
+            flagSet.StringVar(&util.Identity, "identity", util.Identity, "identity file to create")
+
+            flagSet.Usage = func() {
+                fmt.Printf("\n")
+                fmt.Printf("Usage: %s [global options] %s [command options]\n", exeName, subCmd)
+                fmt.Printf("\n")
+                fmt.Printf("The command options:\n")
+                flagSet.PrintDefaults()
+                fmt.Printf("\n")
+            }
+            flagSet.Parse(subArgs)
+            util.SubCmd = subCmd
+
         default:
             help()
             return errors.New("unknown command")
@@ -170,9 +314,19 @@ func (util *Util) Exec() error {
         case packCmd:
             result, err = util.PackCmd(util.PackPath, util.FileList)
         case unpackCmd:
-            result, err = util.UnpackCmd(util.PackPath, util.DestDir, util.FileList)
+            result, err = util.UnpackCmd(util.PackPath, util.DestDir)
         case listCmd:
-            result, err = util.ListCmd(util.PackPath, util.FileList)
+            result, err = util.ListCmd(util.PackPath)
+        case verifyCmd:
+            result, err = util.VerifyCmd(util.PackPath)
+        case exportTarCmd:
+            result, err = util.ExportTarCmd(util.PackPath, util.TarPath)
+        case importTarCmd:
+            result, err = util.ImportTarCmd(util.TarPath, util.PackPath)
+        case keygenCmd:
+            result, err = util.KeygenCmd(util.Identity)
+        case chainCmd:
+            result, err = util.RestoreChainCmd(util.FileList, util.DestDir)
         case helpCmd:
             return err
         default:
@@ -180,69 +334,494 @@ func (util *Util) Exec() error {
     }
     resp = NewResponse(result, err)
     respJSON, _ := json.MarshalIndent(resp, "", "  ")
-    fmt.Printf("%s\n", string(respJSON))
+    respJSON = append(respJSON, '\n')
+    switch {
+        case len(util.Report) > 0:
+            err = os.WriteFile(util.Report, respJSON, filePerm)
+            if err != nil {
+                return err
+            }
+        case util.streamOut():
+            os.Stderr.Write(respJSON)
+        default:
+            os.Stdout.Write(respJSON)
+    }
+    if util.SubCmd == verifyCmd && resp.Error {
+        os.Exit(1)
+    }
     err = nil
     return err
 }
 
+// streamOut returns true if the command writes the pack or the tar
+// to stdout, so the response cannot be written there.
+func (util *Util) streamOut() bool {
+    switch util.SubCmd {
+        case packCmd, importTarCmd:
+            return util.PackPath == stdPath
+        case exportTarCmd:
+            return util.TarPath == stdPath
+    }
+    return false
+}
+
+// openInput opens the file or stdin for the "-" name. Stdin is not
+// seekable, so packs are read from it sequentially.
+func openInput(path string) (io.ReadCloser, error) {
+    if path == stdPath {
+        return io.NopCloser(bufio.NewReader(os.Stdin)), nil
+    }
+    return os.OpenFile(path, os.O_RDONLY, 0)
+}
+
+// stdoutWriter buffers stdout and flushes it on closing.
+type stdoutWriter struct {
+    *bufio.Writer
+}
+
+func (writer *stdoutWriter) Close() error {
+    return writer.Flush()
+}
+
+// createOutput creates the file or returns stdout for the "-" name.
+// The output must be closed to flush written data.
+func createOutput(path string) (io.WriteCloser, error) {
+    if path == stdPath {
+        return &stdoutWriter{ bufio.NewWriter(os.Stdout) }, nil
+    }
+    return os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, filePerm)
+}
+
+// readFiles0 reads the NUL separated file list as written
+// by find -print0.
+func readFiles0(path string) ([]string, error) {
+    var err error
+    fileList := make([]string, 0)
+    listFile, err := openInput(path)
+    if err != nil {
+        return fileList, err
+    }
+    defer listFile.Close()
+    listBin, err := io.ReadAll(listFile)
+    if err != nil {
+        return fileList, err
+    }
+    for _, item := range bytes.Split(listBin, []byte{0}) {
+        if len(item) > 0 {
+            fileList = append(fileList, string(item))
+        }
+    }
+    return fileList, err
+}
+
 const dirPerm   fs.FileMode = 0755
 const filePerm  fs.FileMode = 0644
 
 type PackResult struct {
     PackList    []*dspack.HeadDescr
+    Report      *dspack.PackReport  `json:",omitempty"`
 }
 
 type UnpackResult struct {
     PackList    []*dspack.HeadDescr
+    NotRestored []string            `json:",omitempty"`
+    Damaged     []*dspack.DamagedRange  `json:",omitempty"`
 }
 
 type ListResult struct {
     PackList    []*dspack.HeadDescr
 }
 
+type TarResult struct {
+    PackList    []*dspack.HeadDescr
+    NotExported []string            `json:",omitempty"`
+}
+
+type KeygenResult struct {
+    PublicKey   string
+}
+
 
 func (util *Util) PackCmd(packPath string, fileList []string) (*PackResult, error) {
     var err error
     var result PackResult
 
-    packFile, err := os.OpenFile(packPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, filePerm)
+    options := dspack.NewPackOptions()
+    options.Codec = util.Codec
+    options.Keys, err = util.cryptKeys()
+    if err != nil {
+        return &result, err
+    }
+    if len(util.Since) > 0 {
+        manifestBin, err := os.ReadFile(util.Since)
+        if err != nil {
+            return &result, err
+        }
+        options.Since, err = dspack.UnpackManifest(manifestBin)
+        if err != nil {
+            return &result, err
+        }
+    }
+    options.Manifest = dspack.NewManifest()
+    options.NoRecursion = util.NoRecursion
+    options.Exclude = append(options.Exclude, splitList(util.Exclude)...)
+    if len(util.ExcludeFrom) > 0 {
+        patterns, err := dspack.ReadExcludeFile(util.ExcludeFrom)
+        if err != nil {
+            return &result, err
+        }
+        options.Exclude = append(options.Exclude, patterns...)
+    }
+    options.IgnoreFile = util.IgnoreFile
+    options.OneFileSystem = util.OneFS
+    options.MaxFileSize, err = parseSize(util.MaxFileSize)
+    if err != nil {
+        return &result, err
+    }
+    options.Report = dspack.NewPackReport()
+    result.Report = options.Report
+
+    if len(util.FilesFrom) > 0 {
+        filesFrom, err := readFiles0(util.FilesFrom)
+        if err != nil {
+            return &result, err
+        }
+        fileList = append(fileList, filesFrom...)
+    }
+    packFile, err := createOutput(packPath)
     if err != nil {
         return &result, err
     }
     defer packFile.Close()
 
-    err = dspack.Pack(fileList, packFile)
-
+    err = dspack.PackWithOptions(fileList, packFile, options)
+    if err != nil {
+        return &result, err
+    }
+    err = packFile.Close()
+    if err != nil {
+        return &result, err
+    }
+    if len(util.Manifest) > 0 {
+        manifestBin, err := options.Manifest.Pack()
+        if err != nil {
+            return &result, err
+        }
+        err = os.WriteFile(util.Manifest, manifestBin, filePerm)
+        if err != nil {
+            return &result, err
+        }
+    }
     return &result, err
 }
 
-func (util *Util) UnpackCmd(packPath, destDir string, fileList  []string) (*UnpackResult, error) {
+// unpackOptions selects archive entries with the file list patterns
+// and the exclude patterns.
+func (util *Util) unpackOptions() (*dspack.UnpackOptions, error) {
+    var err error
+    options := dspack.NewUnpackOptions()
+    options.Include = append(options.Include, util.FileList...)
+    options.Exclude = append(options.Exclude, splitList(util.Exclude)...)
+    options.SkipAttrs = util.SkipAttrs
+    options.Keys, err = util.cryptKeys()
+    if err != nil {
+        return options, err
+    }
+    return options, err
+}
+
+// cryptKeys returns nil keys if neither recipients, identities
+// nor the passphrase file are given.
+func (util *Util) cryptKeys() (*dspack.CryptKeys, error) {
+    var err error
+    var keys *dspack.CryptKeys
+    if len(util.EncryptTo) == 0 && len(util.Identity) == 0 && len(util.PassFile) == 0 {
+        return keys, err
+    }
+    keys = dspack.NewCryptKeys()
+    for _, recipient := range splitList(util.EncryptTo) {
+        _, statErr := os.Stat(recipient)
+        if statErr != nil {
+            keys.Recipients = append(keys.Recipients, recipient)
+            continue
+        }
+        fileKeys, err := dspack.ReadKeyFile(recipient)
+        if err != nil {
+            return keys, err
+        }
+        keys.Recipients = append(keys.Recipients, fileKeys...)
+    }
+    for _, identity := range splitList(util.Identity) {
+        fileKeys, err := dspack.ReadKeyFile(identity)
+        if err != nil {
+            return keys, err
+        }
+        keys.Identities = append(keys.Identities, fileKeys...)
+    }
+    if len(util.PassFile) > 0 {
+        passBin, err := os.ReadFile(util.PassFile)
+        if err != nil {
+            return keys, err
+        }
+        keys.Passphrase = strings.TrimRight(string(passBin), "\r\n")
+        if len(keys.Passphrase) == 0 {
+            err = errors.New("empty passphrase")
+            return keys, err
+        }
+    }
+    return keys, err
+}
+
+// parseSize parses the size in bytes with optional K, M or G suffix,
+// the empty size is zero.
+func parseSize(size string) (int64, error) {
+    var err error
+    var value int64
+    number := strings.ToUpper(strings.TrimSpace(size))
+    if len(number) == 0 {
+        return value, err
+    }
+    var unit int64 = 1
+    switch number[len(number) - 1] {
+        case 'K':
+            unit = 1024
+        case 'M':
+            unit = 1024 * 1024
+        case 'G':
+            unit = 1024 * 1024 * 1024
+    }
+    if unit > 1 {
+        number = number[:len(number) - 1]
+    }
+    value, err = strconv.ParseInt(number, 10, 64)
+    if err != nil || value < 0 {
+        err = fmt.Errorf("wrong size %s", size)
+        return value, err
+    }
+    value *= unit
+    return value, err
+}
+
+func splitList(list string) []string {
+    items := make([]string, 0)
+    for _, item := range strings.Split(list, ",") {
+        item = strings.TrimSpace(item)
+        if len(item) > 0 {
+            items = append(items, item)
+        }
+    }
+    return items
+}
+
+func (util *Util) UnpackCmd(packPath, destDir string) (*UnpackResult, error) {
     var err error
     var result UnpackResult
 
-    packFile, err := os.OpenFile(packPath, os.O_RDONLY, 0)
+    options, err := util.unpackOptions()
+    if err != nil {
+        return &result, err
+    }
+
+    packFile, err := openInput(packPath)
     if err != nil {
         return &result, err
     }
     defer packFile.Close()
 
-    result.PackList, err = dspack.Unpack(packFile, destDir)
+    if util.Salvage {
+        readSeeker, ok := packFile.(io.ReadSeeker)
+        if !ok {
+            err = errors.New("salvage requires seekable pack file")
+            return &result, err
+        }
+        result.PackList, result.Damaged, err = dspack.Salvage(readSeeker, destDir, options)
+    } else {
+        result.PackList, err = dspack.UnpackSelected(packFile, destDir, options)
+    }
+    for _, descr := range result.PackList {
+        if len(descr.Error) > 0 {
+            result.NotRestored = append(result.NotRestored, descr.Path)
+        }
+    }
+    return &result, err
+}
+
+// RestoreChainCmd restores the full pack and increment packs in order.
+func (util *Util) RestoreChainCmd(packPaths []string, destDir string) (*UnpackResult, error) {
+    var err error
+    var result UnpackResult
+
+    if len(packPaths) == 0 {
+        err = errors.New("pack files not specified")
+        return &result, err
+    }
+    options := dspack.NewUnpackOptions()
+    options.SkipAttrs = util.SkipAttrs
+    options.Keys, err = util.cryptKeys()
+    if err != nil {
+        return &result, err
+    }
 
+    readers := make([]io.Reader, 0, len(packPaths))
+    for _, packPath := range packPaths {
+        packFile, err := os.OpenFile(packPath, os.O_RDONLY, 0)
+        if err != nil {
+            return &result, err
+        }
+        defer packFile.Close()
+        readers = append(readers, packFile)
+    }
+    result.PackList, err = dspack.RestoreChain(readers, destDir, options)
+    for _, descr := range result.PackList {
+        if len(descr.Error) > 0 {
+            result.NotRestored = append(result.NotRestored, descr.Path)
+        }
+    }
     return &result, err
 }
 
-func (util *Util) ListCmd(packPath string, fileList []string) (*ListResult, error) {
+func (util *Util) ListCmd(packPath string) (*ListResult, error) {
     var err error
     var result ListResult
 
+    options, err := util.unpackOptions()
+    if err != nil {
+        return &result, err
+    }
 
-    packFile, err := os.OpenFile(packPath, os.O_RDONLY, 0)
+
+    packFile, err := openInput(packPath)
     if err != nil {
         return &result, err
     }
     defer packFile.Close()
 
-    result.PackList, err = dspack.List(packFile)
+    result.PackList, err = dspack.ListSelected(packFile, io.Discard, options)
+
+    return &result, err
+}
+
+// VerifyCmd checks the archive without extracting it, the error
+// is returned if the archive has any problem.
+func (util *Util) VerifyCmd(packPath string) (*dspack.VerifyReport, error) {
+    var err error
+    report := dspack.NewVerifyReport()
+
+    keys, err := util.cryptKeys()
+    if err != nil {
+        return report, err
+    }
+    packFile, err := openInput(packPath)
+    if err != nil {
+        return report, err
+    }
+    defer packFile.Close()
+
+    report, err = dspack.Verify(packFile, keys)
+    if err != nil {
+        return report, err
+    }
+    if !report.Ok() {
+        err = fmt.Errorf("archive has %d problems", len(report.Problems))
+        return report, err
+    }
+    return report, err
+}
 
+// ExportTarCmd writes entries of the pack to the PAX tar file.
+func (util *Util) ExportTarCmd(packPath, tarPath string) (*TarResult, error) {
+    var err error
+    var result TarResult
+
+    keys, err := util.cryptKeys()
+    if err != nil {
+        return &result, err
+    }
+    packFile, err := openInput(packPath)
+    if err != nil {
+        return &result, err
+    }
+    defer packFile.Close()
+
+    tarFile, err := createOutput(tarPath)
+    if err != nil {
+        return &result, err
+    }
+    defer tarFile.Close()
+
+    result.PackList, err = dspack.ExportTar(packFile, tarFile, keys)
+    for _, descr := range result.PackList {
+        if len(descr.Error) > 0 {
+            result.NotExported = append(result.NotExported, descr.Path)
+        }
+    }
+    if err != nil {
+        return &result, err
+    }
+    err = tarFile.Close()
+    return &result, err
+}
+
+// ImportTarCmd converts the tar file to the pack.
+func (util *Util) ImportTarCmd(tarPath, packPath string) (*TarResult, error) {
+    var err error
+    var result TarResult
+
+    options := dspack.NewPackOptions()
+    options.Codec = util.Codec
+    options.Keys, err = util.cryptKeys()
+    if err != nil {
+        return &result, err
+    }
+    tarFile, err := openInput(tarPath)
+    if err != nil {
+        return &result, err
+    }
+    defer tarFile.Close()
+
+    packFile, err := createOutput(packPath)
+    if err != nil {
+        return &result, err
+    }
+    defer packFile.Close()
+
+    result.PackList, err = dspack.ImportTar(tarFile, packFile, options)
+    if err != nil {
+        return &result, err
+    }
+    err = packFile.Close()
+    return &result, err
+}
+
+// KeygenCmd writes a new private key to the identity file and
+// the public key for -encrypt-to to the identity file with .pub suffix.
+func (util *Util) KeygenCmd(identityPath string) (*KeygenResult, error) {
+    var err error
+    var result KeygenResult
+
+    if len(identityPath) == 0 {
+        err = errors.New("identity file not specified")
+        return &result, err
+    }
+    pubKey, privKey, err := dspack.GenerateKey()
+    if err != nil {
+        return &result, err
+    }
+    keyData := fmt.Sprintf("# public key: %s\n%s\n", pubKey, privKey)
+    keyFile, err := os.OpenFile(identityPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+    if err != nil {
+        return &result, err
+    }
+    defer keyFile.Close()
+    _, err = keyFile.Write([]byte(keyData))
+    if err != nil {
+        return &result, err
+    }
+    pubData := fmt.Sprintf("%s\n", pubKey)
+    err = os.WriteFile(identityPath + ".pub", []byte(pubData), filePerm)
+    if err != nil {
+        return &result, err
+    }
+    result.PublicKey = pubKey
     return &result, err
 }
@@ -0,0 +1,132 @@
+/*
+ * Copyright 2022 Oleg Borodin  <borodin@unix7.org>
+ */
+
+package main
+
+import (
+    "archive/tar"
+    "bytes"
+    "encoding/json"
+    "io"
+    "os"
+    "os/exec"
+    "path/filepath"
+    "testing"
+
+    "github.com/stretchr/testify/require"
+
+    "fdump/dscomm/dspack"
+)
+
+const execEnv string = "FDPACKER_TEST_EXEC"
+
+// TestMain runs the utility instead of tests if the test binary
+// is started by runPacker.
+func TestMain(m *testing.M) {
+    if os.Getenv(execEnv) == "1" {
+        main()
+        os.Exit(0)
+    }
+    os.Exit(m.Run())
+}
+
+// runPacker runs the utility with stdin, stdout and stderr
+// connected to pipes and returns the output streams.
+func runPacker(t *testing.T, stdin []byte, args ...string) ([]byte, []byte) {
+    var stdout, stderr bytes.Buffer
+    cmd := exec.Command(os.Args[0], args...)
+    cmd.Env = append(os.Environ(), execEnv + "=1")
+    cmd.Stdin = bytes.NewReader(stdin)
+    cmd.Stdout = &stdout
+    cmd.Stderr = &stderr
+    err := cmd.Run()
+    require.NoError(t, err, stderr.String())
+    return stdout.Bytes(), stderr.Bytes()
+}
+
+func checkResponse(t *testing.T, respJSON []byte) {
+    var resp Response
+    err := json.Unmarshal(respJSON, &resp)
+    require.NoError(t, err, string(respJSON))
+    require.False(t, resp.Error, resp.ErrorMsg)
+}
+
+func newTestSource(t *testing.T) string {
+    srcDir := t.TempDir()
+    for _, name := range []string{ "a.txt", "b.txt" } {
+        err := os.WriteFile(filepath.Join(srcDir, name), []byte("data of " + name), 0644)
+        require.NoError(t, err)
+    }
+    return srcDir
+}
+
+func TestPackStdout(t *testing.T) {
+    var err error
+
+    srcDir := newTestSource(t)
+    destDir := t.TempDir()
+
+    packBin, respJSON := runPacker(t, nil, "pack", "-pack", "-", srcDir)
+    checkResponse(t, respJSON)
+    items, err := dspack.ReadIndex(bytes.NewReader(packBin))
+    require.NoError(t, err)
+    require.Equal(t, 3, len(items))
+
+    reportPath := filepath.Join(destDir, "report.json")
+    packBin, stderrBin := runPacker(t, nil, "-report", reportPath, "pack", "-pack", "-", srcDir)
+    require.Equal(t, 0, len(stderrBin))
+    items, err = dspack.ReadIndex(bytes.NewReader(packBin))
+    require.NoError(t, err)
+    require.Equal(t, 3, len(items))
+    respJSON, err = os.ReadFile(reportPath)
+    require.NoError(t, err)
+    checkResponse(t, respJSON)
+
+    unpackDir := filepath.Join(destDir, "unpack")
+    respJSON, stderrBin = runPacker(t, packBin, "unpack", "-pack", "-", "-dest", unpackDir)
+    require.Equal(t, 0, len(stderrBin))
+    checkResponse(t, respJSON)
+    data, err := os.ReadFile(filepath.Join(unpackDir, srcDir, "b.txt"))
+    require.NoError(t, err)
+    require.Equal(t, "data of b.txt", string(data))
+
+    tarBin, respJSON := runPacker(t, packBin, "export-tar", "-pack", "-", "-tar", "-")
+    checkResponse(t, respJSON)
+    tarReader := tar.NewReader(bytes.NewReader(tarBin))
+    count := 0
+    for {
+        _, err = tarReader.Next()
+        if err == io.EOF {
+            break
+        }
+        require.NoError(t, err)
+        count += 1
+    }
+    require.Equal(t, 3, count)
+
+    packBin, respJSON = runPacker(t, tarBin, "import-tar", "-tar", "-", "-pack", "-")
+    checkResponse(t, respJSON)
+    items, err = dspack.ReadIndex(bytes.NewReader(packBin))
+    require.NoError(t, err)
+    require.Equal(t, 3, len(items))
+}
+
+func TestPackFilesFrom(t *testing.T) {
+    var err error
+
+    srcDir := newTestSource(t)
+    packPath := filepath.Join(t.TempDir(), "test.pack")
+
+    fileList := filepath.Join(srcDir, "a.txt") + "\x00" + filepath.Join(srcDir, "b.txt") + "\x00"
+    respJSON, stderrBin := runPacker(t, []byte(fileList), "pack", "-pack", packPath, "-files0-from", "-")
+    require.Equal(t, 0, len(stderrBin))
+    checkResponse(t, respJSON)
+
+    packFile, err := os.Open(packPath)
+    require.NoError(t, err)
+    defer packFile.Close()
+    items, err := dspack.ReadIndex(packFile)
+    require.NoError(t, err)
+    require.Equal(t, 2, len(items))
+}
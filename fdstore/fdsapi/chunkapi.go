@@ -0,0 +1,134 @@
+/*
+ * Copyright 2022 Oleg Borodin  <borodin@unix7.org>
+ */
+
+package fdsapi
+
+import (
+    "fdump/dscomm/dsdescr"
+)
+
+const HasChunksMethod string = "hasChunks"
+type HasChunksParams struct {
+    ChunkIds    []string    `msgpack:"chunkIds"   json:"chunkIds"`
+}
+type HasChunksResult struct {
+    Has         []bool      `msgpack:"has"        json:"has"`
+}
+func NewHasChunksResult() *HasChunksResult {
+    return &HasChunksResult{}
+}
+func NewHasChunksParams() *HasChunksParams {
+    return &HasChunksParams{}
+}
+
+const SaveChunkMethod string = "saveChunk"
+type SaveChunkParams struct {
+    ChunkId     string      `msgpack:"chunkId"    json:"chunkId"`
+}
+type SaveChunkResult struct {
+}
+func NewSaveChunkResult() *SaveChunkResult {
+    return &SaveChunkResult{}
+}
+func NewSaveChunkParams() *SaveChunkParams {
+    return &SaveChunkParams{}
+}
+
+const LoadChunkMethod string = "loadChunk"
+type LoadChunkParams struct {
+    ChunkId     string      `msgpack:"chunkId"    json:"chunkId"`
+}
+type LoadChunkResult struct {
+    Chunk       *dsdescr.Chunk  `msgpack:"chunk"  json:"chunk"`
+}
+func NewLoadChunkResult() *LoadChunkResult {
+    return &LoadChunkResult{}
+}
+func NewLoadChunkParams() *LoadChunkParams {
+    return &LoadChunkParams{}
+}
+
+const SaveChunkFileMethod string = "saveChunkFile"
+type SaveChunkFileParams struct {
+    FilePath    string      `msgpack:"filePath"   json:"filePath"`
+    ChunkIds    []string    `msgpack:"chunkIds"   json:"chunkIds"`
+}
+type SaveChunkFileResult struct {
+    File        *dsdescr.ChunkFile  `msgpack:"file"  json:"file"`
+}
+func NewSaveChunkFileResult() *SaveChunkFileResult {
+    return &SaveChunkFileResult{}
+}
+func NewSaveChunkFileParams() *SaveChunkFileParams {
+    return &SaveChunkFileParams{}
+}
+
+const StatChunkFileMethod string = "statChunkFile"
+type StatChunkFileParams struct {
+    FilePath    string      `msgpack:"filePath"   json:"filePath"`
+}
+type StatChunkFileResult struct {
+    File        *dsdescr.ChunkFile  `msgpack:"file"  json:"file"`
+}
+func NewStatChunkFileResult() *StatChunkFileResult {
+    return &StatChunkFileResult{}
+}
+func NewStatChunkFileParams() *StatChunkFileParams {
+    return &StatChunkFileParams{}
+}
+
+const LoadChunkFileMethod string = "loadChunkFile"
+type LoadChunkFileParams struct {
+    FilePath    string      `msgpack:"filePath"   json:"filePath"`
+}
+type LoadChunkFileResult struct {
+    File        *dsdescr.ChunkFile  `msgpack:"file"  json:"file"`
+}
+func NewLoadChunkFileResult() *LoadChunkFileResult {
+    return &LoadChunkFileResult{}
+}
+func NewLoadChunkFileParams() *LoadChunkFileParams {
+    return &LoadChunkFileParams{}
+}
+
+const ListChunkFilesMethod string = "listChunkFiles"
+type ListChunkFilesParams struct {
+    Prefix      string      `msgpack:"prefix"     json:"prefix"`
+}
+type ListChunkFilesResult struct {
+    Files       []*dsdescr.ChunkFile  `msgpack:"files"  json:"files"`
+}
+func NewListChunkFilesResult() *ListChunkFilesResult {
+    return &ListChunkFilesResult{}
+}
+func NewListChunkFilesParams() *ListChunkFilesParams {
+    return &ListChunkFilesParams{}
+}
+
+const DeleteChunkFileMethod string = "deleteChunkFile"
+type DeleteChunkFileParams struct {
+    FilePath    string      `msgpack:"filePath"   json:"filePath"`
+}
+type DeleteChunkFileResult struct {
+}
+func NewDeleteChunkFileResult() *DeleteChunkFileResult {
+    return &DeleteChunkFileResult{}
+}
+func NewDeleteChunkFileParams() *DeleteChunkFileParams {
+    return &DeleteChunkFileParams{}
+}
+
+const PurgeChunksMethod string = "purgeChunks"
+type PurgeChunksParams struct {
+    Age         int64       `msgpack:"age"        json:"age"`
+}
+type PurgeChunksResult struct {
+    Count       int64       `msgpack:"count"      json:"count"`
+}
+func NewPurgeChunksResult() *PurgeChunksResult {
+    return &PurgeChunksResult{}
+}
+func NewPurgeChunksParams() *PurgeChunksParams {
+    return &PurgeChunksParams{}
+}
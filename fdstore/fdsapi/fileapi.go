@@ -0,0 +1,108 @@
+/*
+ * Copyright 2022 Oleg Borodin  <borodin@unix7.org>
+ */
+
+package fdsapi
+
+import (
+    "fdump/dscomm/dsdescr"
+)
+
+const CreateFileMethod string = "createFile"
+type CreateFileParams struct {
+    FilePath    string      `msgpack:"filePath"   json:"filePath"`
+    BatchSize   int64       `msgpack:"batchSize"  json:"batchSize"`
+    BlockSize   int64       `msgpack:"blockSize"  json:"blockSize"`
+    RecoCount   int64       `msgpack:"recoCount"  json:"recoCount"`
+}
+type CreateFileResult struct {
+    File        *dsdescr.File   `msgpack:"file"  json:"file"`
+}
+func NewCreateFileResult() *CreateFileResult {
+    return &CreateFileResult{}
+}
+func NewCreateFileParams() *CreateFileParams {
+    return &CreateFileParams{}
+}
+
+const AddBatchMethod string = "addBatch"
+type AddBatchParams struct {
+    FileId      int64       `msgpack:"fileId"     json:"fileId"`
+}
+type AddBatchResult struct {
+    Batch       *dsdescr.Batch  `msgpack:"batch"  json:"batch"`
+}
+func NewAddBatchResult() *AddBatchResult {
+    return &AddBatchResult{}
+}
+func NewAddBatchParams() *AddBatchParams {
+    return &AddBatchParams{}
+}
+
+const CloseFileMethod string = "closeFile"
+type CloseFileParams struct {
+    FileId      int64       `msgpack:"fileId"     json:"fileId"`
+}
+type CloseFileResult struct {
+}
+func NewCloseFileResult() *CloseFileResult {
+    return &CloseFileResult{}
+}
+func NewCloseFileParams() *CloseFileParams {
+    return &CloseFileParams{}
+}
+
+const StatFileMethod string = "statFile"
+type StatFileParams struct {
+    FileId      int64       `msgpack:"fileId"     json:"fileId"`
+}
+type StatFileResult struct {
+    File        *dsdescr.File   `msgpack:"file"  json:"file"`
+}
+func NewStatFileResult() *StatFileResult {
+    return &StatFileResult{}
+}
+func NewStatFileParams() *StatFileParams {
+    return &StatFileParams{}
+}
+
+const ListFilesMethod string = "listFiles"
+type ListFilesParams struct {
+    Prefix      string      `msgpack:"prefix"     json:"prefix"`
+}
+type ListFilesResult struct {
+    Files       []*dsdescr.File `msgpack:"files"  json:"files"`
+}
+func NewListFilesResult() *ListFilesResult {
+    return &ListFilesResult{}
+}
+func NewListFilesParams() *ListFilesParams {
+    return &ListFilesParams{}
+}
+
+const DeleteFileMethod string = "deleteFile"
+type DeleteFileParams struct {
+    FileId      int64       `msgpack:"fileId"     json:"fileId"`
+}
+type DeleteFileResult struct {
+}
+func NewDeleteFileResult() *DeleteFileResult {
+    return &DeleteFileResult{}
+}
+func NewDeleteFileParams() *DeleteFileParams {
+    return &DeleteFileParams{}
+}
+
+const LoadFileMethod string = "loadFile"
+type LoadFileParams struct {
+    FileId      int64       `msgpack:"fileId"     json:"fileId"`
+}
+type LoadFileResult struct {
+    File        *dsdescr.File   `msgpack:"file"  json:"file"`
+}
+func NewLoadFileResult() *LoadFileResult {
+    return &LoadFileResult{}
+}
+func NewLoadFileParams() *LoadFileParams {
+    return &LoadFileParams{}
+}
@@ -0,0 +1,38 @@
+/*
+ * Copyright 2022 Oleg Borodin  <borodin@unix7.org>
+ */
+
+package fdsapi
+
+import (
+    "fdump/dscomm/dsdescr"
+)
+
+const SetQuotaMethod string = "setQuota"
+type SetQuotaParams struct {
+    Login       string      `msgpack:"login"      json:"login"`
+    MaxBytes    int64       `msgpack:"maxBytes"   json:"maxBytes"`
+    MaxFiles    int64       `msgpack:"maxFiles"   json:"maxFiles"`
+}
+type SetQuotaResult struct {
+}
+func NewSetQuotaResult() *SetQuotaResult {
+    return &SetQuotaResult{}
+}
+func NewSetQuotaParams() *SetQuotaParams {
+    return &SetQuotaParams{}
+}
+
+const GetQuotaMethod string = "getQuota"
+type GetQuotaParams struct {
+    Login       string      `msgpack:"login"      json:"login"`
+}
+type GetQuotaResult struct {
+    Quota       *dsdescr.Quota  `msgpack:"quota"  json:"quota"`
+}
+func NewGetQuotaResult() *GetQuotaResult {
+    return &GetQuotaResult{}
+}
+func NewGetQuotaParams() *GetQuotaParams {
+    return &GetQuotaParams{}
+}
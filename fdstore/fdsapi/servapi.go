@@ -23,3 +23,30 @@ func NewGetStatusResult() *GetStatusResult {
 func NewGetStatusParams() *GetStatusParams {
     return &GetStatusParams{}
 }
+
+const GetScrubStatusMethod string = "getScrubStatus"
+
+type GetScrubStatusParams struct {
+}
+
+type GetScrubStatusResult struct {
+    Enabled         bool        `json:"enabled"         msgpack:"enabled"`
+    Running         bool        `json:"running"         msgpack:"running"`
+    Rate            int64       `json:"rate"            msgpack:"rate"`
+    Passes          int64       `json:"passes"          msgpack:"passes"`
+    StartedAt       int64       `json:"startedAt"       msgpack:"startedAt"`
+    FinishedAt      int64       `json:"finishedAt"      msgpack:"finishedAt"`
+    TotalBlocks     int64       `json:"totalBlocks"     msgpack:"totalBlocks"`
+    CheckedBlocks   int64       `json:"checkedBlocks"   msgpack:"checkedBlocks"`
+    CheckedBytes    int64       `json:"checkedBytes"    msgpack:"checkedBytes"`
+    Corrupted       int64       `json:"corrupted"       msgpack:"corrupted"`
+    Repaired        int64       `json:"repaired"        msgpack:"repaired"`
+    Failed          []string    `json:"failed"          msgpack:"failed"`
+}
+
+func NewGetScrubStatusResult() *GetScrubStatusResult {
+    return &GetScrubStatusResult{}
+}
+func NewGetScrubStatusParams() *GetScrubStatusParams {
+    return &GetScrubStatusParams{}
+}
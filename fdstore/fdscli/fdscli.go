@@ -5,15 +5,23 @@
 package main
 
 import (
+    "bytes"
+    "crypto/rand"
+    "encoding/hex"
     "encoding/json"
     "fmt"
     "flag"
+    "io"
     "os"
     "path/filepath"
     "errors"
 
+    "github.com/minio/highwayhash"
+
     "fdump/fdstore/fdsapi"
+    "fdump/dscomm/dsdescr"
     "fdump/dscomm/dsrpc"
+    "fdump/dscomm/dschunk"
 )
 
 type any = interface{}
@@ -30,6 +38,8 @@ func main() {
 type Util struct {
     aLogin      string
     aPass       string
+    aLegacy     bool
+    auth        *dsrpc.Auth
 
     Port        string
     Address     string
@@ -40,6 +50,12 @@ type Util struct {
     Login       string
     Pass        string
 
+    TLS         bool
+    TLSCA       string
+    TLSName     string
+    TLSCert     string
+    TLSKey      string
+
     bPort       string
     bAddress    string
 
@@ -48,7 +64,17 @@ type Util struct {
     BlockId     int64
     BlockType   int64
 
-    FilePath   string
+    FilePath    string
+    RemotePath  string
+    Prefix      string
+    BatchSize   int64
+    BlockSize   int64
+    RecoCount   int64
+
+    MaxBytes    int64
+    MaxFiles    int64
+
+    ChunkAge    int64
 }
 
 func NewUtil() *Util {
@@ -58,16 +84,35 @@ func NewUtil() *Util {
     util.Message    = "hello"
     util.aLogin     = "admin"
     util.aPass      = "admin"
+    util.BatchSize  = 16
+    util.BlockSize  = 4 * 1024 * 1024
     return &util
 }
 
 const getStatusCmd      string = "getStatus"
+const getScrubStatusCmd string = "getScrubStatus"
+
+const saveFileCmd       string = "saveFile"
+const loadFileCmd       string = "loadFile"
+const statFileCmd       string = "statFile"
+const listFilesCmd      string = "listFiles"
+const deleteFileCmd     string = "deleteFile"
 
 const saveBlockCmd      string = "saveBlock"
 const loadBlockCmd      string = "loadBlock"
 const listBlocksCmd     string = "listBlocks"
 const deleteBlockCmd    string = "deleteBlock"
 
+const saveChunkFileCmd      string = "saveChunkFile"
+const loadChunkFileCmd      string = "loadChunkFile"
+const statChunkFileCmd      string = "statChunkFile"
+const listChunkFilesCmd     string = "listChunkFiles"
+const deleteChunkFileCmd    string = "deleteChunkFile"
+const purgeChunksCmd        string = "purgeChunks"
+
+const setQuotaCmd       string = "setQuota"
+const getQuotaCmd       string = "getQuota"
+
 const addUserCmd        string = "addUser"
 const checkUserCmd      string = "checkUser"
 const updateUserCmd     string = "updateUser"
@@ -87,12 +132,24 @@ func (util *Util) GetOpt() error {
     flag.StringVar(&util.Address, "address", util.Address, "service address")
     flag.StringVar(&util.aLogin, "aLogin", util.aLogin, "access login")
     flag.StringVar(&util.aPass, "aPass", util.aPass, "access password")
+    flag.BoolVar(&util.aLegacy, "aLegacy", util.aLegacy, "use legacy auth for not migrated servers")
+
+    flag.BoolVar(&util.TLS, "tls", util.TLS, "use tls")
+    flag.StringVar(&util.TLSCA, "tlsCA", util.TLSCA, "tls ca bundle file, system pool if empty")
+    flag.StringVar(&util.TLSName, "tlsName", util.TLSName, "tls server name, address if empty")
+    flag.StringVar(&util.TLSCert, "tlsCert", util.TLSCert, "tls client certificate file")
+    flag.StringVar(&util.TLSKey, "tlsKey", util.TLSKey, "tls client key file")
 
     help := func() {
         fmt.Println("")
         fmt.Printf("Usage: %s [option] command [command option]\n", exeName)
         fmt.Printf("\n")
-        fmt.Printf("Command list: help, getStatus, \n")
+        fmt.Printf("Command list: help, getStatus, getScrubStatus, \n")
+        fmt.Printf("    saveFile, loadFile, statFile, listFiles, deleteFile, \n")
+        fmt.Printf("    saveBlock, loadBlock, listBlocks, deleteBlock, \n")
+        fmt.Printf("    saveChunkFile, loadChunkFile, statChunkFile, listChunkFiles, \n")
+        fmt.Printf("    deleteChunkFile, purgeChunks, \n")
+        fmt.Printf("    setQuota, getQuota, \n")
         fmt.Printf("    addUser, checkUser, updateUser, listUsers, deleteUser \n")
 
         fmt.Printf("\n")
@@ -119,8 +176,8 @@ func (util *Util) GetOpt() error {
         case helpCmd:
             help()
             return errors.New("unknown command")
-        case getStatusCmd:
-            flagSet := flag.NewFlagSet(getStatusCmd, flag.ExitOnError)
+        case getStatusCmd, getScrubStatusCmd:
+            flagSet := flag.NewFlagSet(subCmd, flag.ExitOnError)
             flagSet.Usage = func() {
                 fmt.Printf("\n")
                 fmt.Printf("Usage: %s [global options] %s [command options]\n", exeName, subCmd)
@@ -132,6 +189,194 @@ func (util *Util) GetOpt() error {
             flagSet.Parse(subArgs)
             util.SubCmd = subCmd
 
+        case saveFileCmd:
+            flagSet := flag.NewFlagSet(saveFileCmd, flag.ExitOnError)
+            flagSet.StringVar(&util.FilePath, "file", util.FilePath, "local file path")
+            flagSet.StringVar(&util.RemotePath, "path", util.RemotePath, "store file path, local file name if empty")
+            flagSet.Int64Var(&util.BatchSize, "batchSize", util.BatchSize, "data blocks per batch")
+            flagSet.Int64Var(&util.BlockSize, "blockSize", util.BlockSize, "block size")
+            flagSet.Int64Var(&util.RecoCount, "recoCount", util.RecoCount, "recovery blocks per batch, 0 for store default, -1 for none")
+            flagSet.Usage = func() {
+                fmt.Printf("\n")
+                fmt.Printf("Usage: %s [global options] %s [command options]\n", exeName, subCmd)
+                fmt.Printf("\n")
+                fmt.Printf("The command options:\n")
+                flagSet.PrintDefaults()
+                fmt.Printf("\n")
+            }
+            flagSet.Parse(subArgs)
+            util.SubCmd = subCmd
+        case loadFileCmd:
+            flagSet := flag.NewFlagSet(loadFileCmd, flag.ExitOnError)
+            flagSet.Int64Var(&util.FileId, "fileId", util.FileId, "file id")
+            flagSet.StringVar(&util.FilePath, "file", util.FilePath, "local file path")
+            flagSet.Usage = func() {
+                fmt.Printf("\n")
+                fmt.Printf("Usage: %s [global options] %s [command options]\n", exeName, subCmd)
+                fmt.Printf("\n")
+                fmt.Printf("The command options:\n")
+                flagSet.PrintDefaults()
+                fmt.Printf("\n")
+            }
+            flagSet.Parse(subArgs)
+            util.SubCmd = subCmd
+        case statFileCmd, deleteFileCmd:
+            flagSet := flag.NewFlagSet(subCmd, flag.ExitOnError)
+            flagSet.Int64Var(&util.FileId, "fileId", util.FileId, "file id")
+            flagSet.Usage = func() {
+                fmt.Printf("\n")
+                fmt.Printf("Usage: %s [global options] %s [command options]\n", exeName, subCmd)
+                fmt.Printf("\n")
+                fmt.Printf("The command options:\n")
+                flagSet.PrintDefaults()
+                fmt.Printf("\n")
+            }
+            flagSet.Parse(subArgs)
+            util.SubCmd = subCmd
+        case listFilesCmd:
+            flagSet := flag.NewFlagSet(listFilesCmd, flag.ExitOnError)
+            flagSet.StringVar(&util.Prefix, "prefix", util.Prefix, "file path prefix")
+            flagSet.Usage = func() {
+                fmt.Printf("\n")
+                fmt.Printf("Usage: %s [global options] %s [command options]\n", exeName, subCmd)
+                fmt.Printf("\n")
+                fmt.Printf("The command options:\n")
+                flagSet.PrintDefaults()
+                fmt.Printf("\n")
+            }
+            flagSet.Parse(subArgs)
+            util.SubCmd = subCmd
+
+        case saveBlockCmd, loadBlockCmd:
+            flagSet := flag.NewFlagSet(subCmd, flag.ExitOnError)
+            flagSet.Int64Var(&util.FileId, "fileId", util.FileId, "file id")
+            flagSet.Int64Var(&util.BatchId, "batchId", util.BatchId, "batch id")
+            flagSet.Int64Var(&util.BlockType, "blockType", util.BlockType, "block type")
+            flagSet.Int64Var(&util.BlockId, "blockId", util.BlockId, "block id")
+            flagSet.StringVar(&util.FilePath, "file", util.FilePath, "local file path")
+            flagSet.Usage = func() {
+                fmt.Printf("\n")
+                fmt.Printf("Usage: %s [global options] %s [command options]\n", exeName, subCmd)
+                fmt.Printf("\n")
+                fmt.Printf("The command options:\n")
+                flagSet.PrintDefaults()
+                fmt.Printf("\n")
+            }
+            flagSet.Parse(subArgs)
+            util.SubCmd = subCmd
+        case deleteBlockCmd:
+            flagSet := flag.NewFlagSet(deleteBlockCmd, flag.ExitOnError)
+            flagSet.Int64Var(&util.FileId, "fileId", util.FileId, "file id")
+            flagSet.Int64Var(&util.BatchId, "batchId", util.BatchId, "batch id")
+            flagSet.Int64Var(&util.BlockType, "blockType", util.BlockType, "block type")
+            flagSet.Int64Var(&util.BlockId, "blockId", util.BlockId, "block id")
+            flagSet.Usage = func() {
+                fmt.Printf("\n")
+                fmt.Printf("Usage: %s [global options] %s [command options]\n", exeName, subCmd)
+                fmt.Printf("\n")
+                fmt.Printf("The command options:\n")
+                flagSet.PrintDefaults()
+                fmt.Printf("\n")
+            }
+            flagSet.Parse(subArgs)
+            util.SubCmd = subCmd
+        case listBlocksCmd:
+            flagSet := flag.NewFlagSet(listBlocksCmd, flag.ExitOnError)
+            flagSet.Int64Var(&util.FileId, "fileId", util.FileId, "file id")
+            flagSet.Usage = func() {
+                fmt.Printf("\n")
+                fmt.Printf("Usage: %s [global options] %s [command options]\n", exeName, subCmd)
+                fmt.Printf("\n")
+                fmt.Printf("The command options:\n")
+                flagSet.PrintDefaults()
+                fmt.Printf("\n")
+            }
+            flagSet.Parse(subArgs)
+            util.SubCmd = subCmd
+
+        case saveChunkFileCmd, loadChunkFileCmd:
+            flagSet := flag.NewFlagSet(subCmd, flag.ExitOnError)
+            flagSet.StringVar(&util.FilePath, "file", util.FilePath, "local file path")
+            flagSet.StringVar(&util.RemotePath, "path", util.RemotePath, "store file path, local file name if empty")
+            flagSet.Usage = func() {
+                fmt.Printf("\n")
+                fmt.Printf("Usage: %s [global options] %s [command options]\n", exeName, subCmd)
+                fmt.Printf("\n")
+                fmt.Printf("The command options:\n")
+                flagSet.PrintDefaults()
+                fmt.Printf("\n")
+            }
+            flagSet.Parse(subArgs)
+            util.SubCmd = subCmd
+        case statChunkFileCmd, deleteChunkFileCmd:
+            flagSet := flag.NewFlagSet(subCmd, flag.ExitOnError)
+            flagSet.StringVar(&util.RemotePath, "path", util.RemotePath, "store file path")
+            flagSet.Usage = func() {
+                fmt.Printf("\n")
+                fmt.Printf("Usage: %s [global options] %s [command options]\n", exeName, subCmd)
+                fmt.Printf("\n")
+                fmt.Printf("The command options:\n")
+                flagSet.PrintDefaults()
+                fmt.Printf("\n")
+            }
+            flagSet.Parse(subArgs)
+            util.SubCmd = subCmd
+        case listChunkFilesCmd:
+            flagSet := flag.NewFlagSet(listChunkFilesCmd, flag.ExitOnError)
+            flagSet.StringVar(&util.Prefix, "prefix", util.Prefix, "file path prefix")
+            flagSet.Usage = func() {
+                fmt.Printf("\n")
+                fmt.Printf("Usage: %s [global options] %s [command options]\n", exeName, subCmd)
+                fmt.Printf("\n")
+                fmt.Printf("The command options:\n")
+                flagSet.PrintDefaults()
+                fmt.Printf("\n")
+            }
+            flagSet.Parse(subArgs)
+            util.SubCmd = subCmd
+        case purgeChunksCmd:
+            flagSet := flag.NewFlagSet(purgeChunksCmd, flag.ExitOnError)
+            flagSet.Int64Var(&util.ChunkAge, "age", util.ChunkAge, "min age of unreferenced chunks in seconds")
+            flagSet.Usage = func() {
+                fmt.Printf("\n")
+                fmt.Printf("Usage: %s [global options] %s [command options]\n", exeName, subCmd)
+                fmt.Printf("\n")
+                fmt.Printf("The command options:\n")
+                flagSet.PrintDefaults()
+                fmt.Printf("\n")
+            }
+            flagSet.Parse(subArgs)
+            util.SubCmd = subCmd
+
+        case setQuotaCmd:
+            flagSet := flag.NewFlagSet(setQuotaCmd, flag.ExitOnError)
+            flagSet.StringVar(&util.Login, "login", util.Login, "login")
+            flagSet.Int64Var(&util.MaxBytes, "maxBytes", util.MaxBytes, "bytes limit, 0 for no limit")
+            flagSet.Int64Var(&util.MaxFiles, "maxFiles", util.MaxFiles, "files limit, 0 for no limit")
+            flagSet.Usage = func() {
+                fmt.Printf("\n")
+                fmt.Printf("Usage: %s [global options] %s [command options]\n", exeName, subCmd)
+                fmt.Printf("\n")
+                fmt.Printf("The command options:\n")
+                flagSet.PrintDefaults()
+                fmt.Printf("\n")
+            }
+            flagSet.Parse(subArgs)
+            util.SubCmd = subCmd
+        case getQuotaCmd:
+            flagSet := flag.NewFlagSet(getQuotaCmd, flag.ExitOnError)
+            flagSet.StringVar(&util.Login, "login", util.Login, "login, access login if empty")
+            flagSet.Usage = func() {
+                fmt.Printf("\n")
+                fmt.Printf("Usage: %s [global options] %s [command options]\n", exeName, subCmd)
+                fmt.Printf("\n")
+                fmt.Printf("The command options:\n")
+                flagSet.PrintDefaults()
+                fmt.Printf("\n")
+            }
+            flagSet.Parse(subArgs)
+            util.SubCmd = subCmd
+
         case addUserCmd, checkUserCmd, updateUserCmd:
             flagSet := flag.NewFlagSet(addUserCmd, flag.ExitOnError)
             flagSet.StringVar(&util.Login, "login", util.Login, "login")
@@ -206,7 +451,19 @@ func (util *Util) Exec() error {
         return err
     }
     util.URI = fmt.Sprintf("%s:%s", util.Address, util.Port)
-    auth := dsrpc.CreateAuth([]byte(util.aLogin), []byte(util.aPass))
+    if util.TLS {
+        serverName := util.TLSName
+        if len(serverName) == 0 {
+            serverName = util.Address
+        }
+        tlsConfig, err := dsrpc.NewClientTLSConfig(util.TLSCA, serverName, util.TLSCert, util.TLSKey)
+        if err != nil {
+            return err
+        }
+        dsrpc.SetClientTLSConfig(tlsConfig)
+    }
+    util.auth = util.newAuth()
+    auth := util.auth
 
     resp := NewResponse(nil, nil)
     var result interface{}
@@ -214,6 +471,46 @@ func (util *Util) Exec() error {
     switch util.SubCmd {
         case getStatusCmd:
             result, err = util.GetStatusCmd(auth)
+        case getScrubStatusCmd:
+            result, err = util.GetScrubStatusCmd(auth)
+
+        case saveFileCmd:
+            result, err = util.SaveFileCmd()
+        case loadFileCmd:
+            result, err = util.LoadFileCmd(auth)
+        case statFileCmd:
+            result, err = util.StatFileCmd(auth)
+        case listFilesCmd:
+            result, err = util.ListFilesCmd(auth)
+        case deleteFileCmd:
+            result, err = util.DeleteFileCmd(auth)
+
+        case saveBlockCmd:
+            result, err = util.SaveBlockCmd(auth)
+        case loadBlockCmd:
+            result, err = util.LoadBlockCmd(auth)
+        case listBlocksCmd:
+            result, err = util.ListBlocksCmd(auth)
+        case deleteBlockCmd:
+            result, err = util.DeleteBlockCmd(auth)
+
+        case saveChunkFileCmd:
+            result, err = util.SaveChunkFileCmd()
+        case loadChunkFileCmd:
+            result, err = util.LoadChunkFileCmd(auth)
+        case statChunkFileCmd:
+            result, err = util.StatChunkFileCmd(auth)
+        case listChunkFilesCmd:
+            result, err = util.ListChunkFilesCmd(auth)
+        case deleteChunkFileCmd:
+            result, err = util.DeleteChunkFileCmd(auth)
+        case purgeChunksCmd:
+            result, err = util.PurgeChunksCmd(auth)
+
+        case setQuotaCmd:
+            result, err = util.SetQuotaCmd(auth)
+        case getQuotaCmd:
+            result, err = util.GetQuotaCmd(auth)
 
         case addUserCmd:
             result, err = util.AddUserCmd(auth)
@@ -236,6 +533,15 @@ func (util *Util) Exec() error {
     return err
 }
 
+// newAuth makes the auth of the util requests, the client
+// key is derived once for all of them.
+func (util *Util) newAuth() *dsrpc.Auth {
+    if util.aLegacy {
+        return dsrpc.CreateLegacyAuth([]byte(util.aLogin), []byte(util.aPass))
+    }
+    return dsrpc.CreateAuth([]byte(util.aLogin), []byte(util.aPass))
+}
+
 func (util *Util) GetStatusCmd(auth *dsrpc.Auth) (*fdsapi.GetStatusResult, error) {
     var err error
     params := fdsapi.NewGetStatusParams()
@@ -247,6 +553,451 @@ func (util *Util) GetStatusCmd(auth *dsrpc.Auth) (*fdsapi.GetStatusResult, error
     return result, err
 }
 
+// SaveFileCmd uploads the local file as a sequence of blocks,
+// each request has own auth.
+func (util *Util) SaveFileCmd() (*fdsapi.StatFileResult, error) {
+    var err error
+    statResult := fdsapi.NewStatFileResult()
+
+    file, err := os.OpenFile(util.FilePath, os.O_RDONLY, 0)
+    if err != nil {
+        return statResult, err
+    }
+    defer file.Close()
+
+    remotePath := util.RemotePath
+    if len(remotePath) == 0 {
+        remotePath = filepath.Base(util.FilePath)
+    }
+    createParams := fdsapi.NewCreateFileParams()
+    createParams.FilePath   = remotePath
+    createParams.BatchSize  = util.BatchSize
+    createParams.BlockSize  = util.BlockSize
+    createParams.RecoCount  = util.RecoCount
+    createResult := fdsapi.NewCreateFileResult()
+    err = dsrpc.Exec(util.URI, fdsapi.CreateFileMethod, createParams, createResult, util.auth)
+    if err != nil {
+        return statResult, err
+    }
+    fileId := createResult.File.FileId
+
+    buffer := make([]byte, util.BlockSize)
+    var batchId, blockId int64
+    for {
+        size, err := io.ReadFull(file, buffer)
+        if err == io.EOF {
+            break
+        }
+        if err != nil && err != io.ErrUnexpectedEOF {
+            return statResult, err
+        }
+        data := buffer[0:size]
+        if blockId == 0 {
+            batchParams := fdsapi.NewAddBatchParams()
+            batchParams.FileId = fileId
+            batchResult := fdsapi.NewAddBatchResult()
+            err = dsrpc.Exec(util.URI, fdsapi.AddBatchMethod, batchParams, batchResult, util.auth)
+            if err != nil {
+                return statResult, err
+            }
+            batchId = batchResult.Batch.BatchId
+        }
+        hashInit, hashSum, _, err := blockHash(bytes.NewReader(data))
+        if err != nil {
+            return statResult, err
+        }
+        params := fdsapi.NewSaveBlockParams()
+        params.FileId       = fileId
+        params.BatchId      = batchId
+        params.BlockType    = dsdescr.BTData
+        params.BlockId      = blockId
+        params.BlockSize    = util.BlockSize
+        params.HashInit     = hashInit
+        params.HashSum      = hashSum
+        result := fdsapi.NewSaveBlockResult()
+        err = dsrpc.Put(util.URI, fdsapi.SaveBlockMethod, bytes.NewReader(data), int64(size),
+                                                params, result, util.auth)
+        if err != nil {
+            return statResult, err
+        }
+        blockId += 1
+        if blockId == util.BatchSize {
+            blockId = 0
+        }
+    }
+    closeParams := fdsapi.NewCloseFileParams()
+    closeParams.FileId = fileId
+    closeResult := fdsapi.NewCloseFileResult()
+    err = dsrpc.Exec(util.URI, fdsapi.CloseFileMethod, closeParams, closeResult, util.auth)
+    if err != nil {
+        return statResult, err
+    }
+    statParams := fdsapi.NewStatFileParams()
+    statParams.FileId = fileId
+    err = dsrpc.Exec(util.URI, fdsapi.StatFileMethod, statParams, statResult, util.auth)
+    if err != nil {
+        return statResult, err
+    }
+    return statResult, err
+}
+
+func (util *Util) LoadFileCmd(auth *dsrpc.Auth) (*fdsapi.LoadFileResult, error) {
+    var err error
+    params := fdsapi.NewLoadFileParams()
+    params.FileId       = util.FileId
+    result := fdsapi.NewLoadFileResult()
+
+    file, err := os.OpenFile(util.FilePath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+    if err != nil {
+        return result, err
+    }
+    defer file.Close()
+
+    err = dsrpc.Get(util.URI, fdsapi.LoadFileMethod, file, params, result, auth)
+    if err != nil {
+        return result, err
+    }
+    return result, err
+}
+
+func (util *Util) StatFileCmd(auth *dsrpc.Auth) (*fdsapi.StatFileResult, error) {
+    var err error
+    params := fdsapi.NewStatFileParams()
+    params.FileId       = util.FileId
+    result := fdsapi.NewStatFileResult()
+    err = dsrpc.Exec(util.URI, fdsapi.StatFileMethod, params, result, auth)
+    if err != nil {
+        return result, err
+    }
+    return result, err
+}
+
+func (util *Util) ListFilesCmd(auth *dsrpc.Auth) (*fdsapi.ListFilesResult, error) {
+    var err error
+    params := fdsapi.NewListFilesParams()
+    params.Prefix       = util.Prefix
+    result := fdsapi.NewListFilesResult()
+    err = dsrpc.Exec(util.URI, fdsapi.ListFilesMethod, params, result, auth)
+    if err != nil {
+        return result, err
+    }
+    return result, err
+}
+
+func (util *Util) DeleteFileCmd(auth *dsrpc.Auth) (*fdsapi.DeleteFileResult, error) {
+    var err error
+    params := fdsapi.NewDeleteFileParams()
+    params.FileId       = util.FileId
+    result := fdsapi.NewDeleteFileResult()
+    err = dsrpc.Exec(util.URI, fdsapi.DeleteFileMethod, params, result, auth)
+    if err != nil {
+        return result, err
+    }
+    return result, err
+}
+
+func (util *Util) SaveBlockCmd(auth *dsrpc.Auth) (*fdsapi.SaveBlockResult, error) {
+    var err error
+    params := fdsapi.NewSaveBlockParams()
+    result := fdsapi.NewSaveBlockResult()
+
+    file, err := os.OpenFile(util.FilePath, os.O_RDONLY, 0)
+    if err != nil {
+        return result, err
+    }
+    defer file.Close()
+
+    hashInit, hashSum, size, err := blockHash(file)
+    if err != nil {
+        return result, err
+    }
+    _, err = file.Seek(0, io.SeekStart)
+    if err != nil {
+        return result, err
+    }
+    params.FileId       = util.FileId
+    params.BatchId      = util.BatchId
+    params.BlockType    = util.BlockType
+    params.BlockId      = util.BlockId
+    params.BlockSize    = size
+    params.HashInit     = hashInit
+    params.HashSum      = hashSum
+
+    err = dsrpc.Put(util.URI, fdsapi.SaveBlockMethod, file, size, params, result, auth)
+    if err != nil {
+        return result, err
+    }
+    return result, err
+}
+
+func (util *Util) LoadBlockCmd(auth *dsrpc.Auth) (*fdsapi.LoadBlockResult, error) {
+    var err error
+    params := fdsapi.NewLoadBlockParams()
+    params.FileId       = util.FileId
+    params.BatchId      = util.BatchId
+    params.BlockType    = util.BlockType
+    params.BlockId      = util.BlockId
+    result := fdsapi.NewLoadBlockResult()
+
+    file, err := os.OpenFile(util.FilePath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+    if err != nil {
+        return result, err
+    }
+    defer file.Close()
+
+    err = dsrpc.Get(util.URI, fdsapi.LoadBlockMethod, file, params, result, auth)
+    if err != nil {
+        return result, err
+    }
+    return result, err
+}
+
+func (util *Util) ListBlocksCmd(auth *dsrpc.Auth) (*fdsapi.ListBlocksResult, error) {
+    var err error
+    params := fdsapi.NewListBlocksParams()
+    params.FileId       = util.FileId
+    result := fdsapi.NewListBlocksResult()
+    err = dsrpc.Exec(util.URI, fdsapi.ListBlocksMethod, params, result, auth)
+    if err != nil {
+        return result, err
+    }
+    return result, err
+}
+
+func (util *Util) DeleteBlockCmd(auth *dsrpc.Auth) (*fdsapi.DeleteBlockResult, error) {
+    var err error
+    params := fdsapi.NewDeleteBlockParams()
+    params.FileId       = util.FileId
+    params.BatchId      = util.BatchId
+    params.BlockType    = util.BlockType
+    params.BlockId      = util.BlockId
+    result := fdsapi.NewDeleteBlockResult()
+    err = dsrpc.Exec(util.URI, fdsapi.DeleteBlockMethod, params, result, auth)
+    if err != nil {
+        return result, err
+    }
+    return result, err
+}
+
+type SaveChunkFileResult struct {
+    File        *dsdescr.ChunkFile  `json:"file"`
+    ChunkCount  int64               `json:"chunkCount"`
+    SentCount   int64               `json:"sentCount"`
+    SentSize    int64               `json:"sentSize"`
+}
+
+// hasChunksBatch and hasChunksSize limit chunks kept in memory
+// for one hasChunks request.
+const hasChunksBatch int = 4096
+const hasChunksSize  int = 64 * 1024 * 1024
+
+// SaveChunkFileCmd splits the local file to content defined chunks,
+// asks the store for known chunks and uploads only missing ones,
+// each request has own auth.
+func (util *Util) SaveChunkFileCmd() (*SaveChunkFileResult, error) {
+    var err error
+    res := &SaveChunkFileResult{}
+
+    file, err := os.OpenFile(util.FilePath, os.O_RDONLY, 0)
+    if err != nil {
+        return res, err
+    }
+    defer file.Close()
+
+    remotePath := util.RemotePath
+    if len(remotePath) == 0 {
+        remotePath = filepath.Base(util.FilePath)
+    }
+
+    chunkIds := make([]string, 0)
+    sentIds := make(map[string]bool)
+    chunks := make([][]byte, 0, hasChunksBatch)
+    chunksSize := 0
+
+    sendChunks := func() error {
+        var err error
+        if len(chunks) == 0 {
+            return err
+        }
+        ids := chunkIds[len(chunkIds) - len(chunks):]
+        hasParams := fdsapi.NewHasChunksParams()
+        hasParams.ChunkIds = ids
+        hasResult := fdsapi.NewHasChunksResult()
+        err = dsrpc.Exec(util.URI, fdsapi.HasChunksMethod, hasParams, hasResult, util.auth)
+        if err != nil {
+            return err
+        }
+        if len(hasResult.Has) != len(ids) {
+            return errors.New("wrong size of has chunks result")
+        }
+        for i, data := range chunks {
+            if hasResult.Has[i] || sentIds[ids[i]] {
+                continue
+            }
+            params := fdsapi.NewSaveChunkParams()
+            params.ChunkId = ids[i]
+            result := fdsapi.NewSaveChunkResult()
+            err = dsrpc.Put(util.URI, fdsapi.SaveChunkMethod, bytes.NewReader(data), int64(len(data)),
+                                                params, result, util.auth)
+            if err != nil {
+                return err
+            }
+            sentIds[ids[i]] = true
+            res.SentCount += 1
+            res.SentSize += int64(len(data))
+        }
+        chunks = chunks[:0]
+        chunksSize = 0
+        return err
+    }
+
+    chunker, err := dschunk.NewChunker(file, dschunk.DefMinSize, dschunk.DefAvgSize, dschunk.DefMaxSize)
+    if err != nil {
+        return res, err
+    }
+    for {
+        data, err := chunker.Next()
+        if err == io.EOF {
+            break
+        }
+        if err != nil {
+            return res, err
+        }
+        chunkIds = append(chunkIds, dschunk.ChunkId(data))
+        chunks = append(chunks, append([]byte(nil), data...))
+        chunksSize += len(data)
+        if len(chunks) == hasChunksBatch || chunksSize >= hasChunksSize {
+            err = sendChunks()
+            if err != nil {
+                return res, err
+            }
+        }
+    }
+    err = sendChunks()
+    if err != nil {
+        return res, err
+    }
+    res.ChunkCount = int64(len(chunkIds))
+
+    params := fdsapi.NewSaveChunkFileParams()
+    params.FilePath = remotePath
+    params.ChunkIds = chunkIds
+    result := fdsapi.NewSaveChunkFileResult()
+    err = dsrpc.Exec(util.URI, fdsapi.SaveChunkFileMethod, params, result, util.auth)
+    if err != nil {
+        return res, err
+    }
+    res.File = result.File
+    return res, err
+}
+
+func (util *Util) LoadChunkFileCmd(auth *dsrpc.Auth) (*fdsapi.LoadChunkFileResult, error) {
+    var err error
+    params := fdsapi.NewLoadChunkFileParams()
+    params.FilePath     = util.RemotePath
+    if len(params.FilePath) == 0 {
+        params.FilePath = filepath.Base(util.FilePath)
+    }
+    result := fdsapi.NewLoadChunkFileResult()
+
+    file, err := os.OpenFile(util.FilePath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+    if err != nil {
+        return result, err
+    }
+    defer file.Close()
+
+    err = dsrpc.Get(util.URI, fdsapi.LoadChunkFileMethod, file, params, result, auth)
+    if err != nil {
+        return result, err
+    }
+    return result, err
+}
+
+func (util *Util) StatChunkFileCmd(auth *dsrpc.Auth) (*fdsapi.StatChunkFileResult, error) {
+    var err error
+    params := fdsapi.NewStatChunkFileParams()
+    params.FilePath     = util.RemotePath
+    result := fdsapi.NewStatChunkFileResult()
+    err = dsrpc.Exec(util.URI, fdsapi.StatChunkFileMethod, params, result, auth)
+    if err != nil {
+        return result, err
+    }
+    return result, err
+}
+
+func (util *Util) ListChunkFilesCmd(auth *dsrpc.Auth) (*fdsapi.ListChunkFilesResult, error) {
+    var err error
+    params := fdsapi.NewListChunkFilesParams()
+    params.Prefix       = util.Prefix
+    result := fdsapi.NewListChunkFilesResult()
+    err = dsrpc.Exec(util.URI, fdsapi.ListChunkFilesMethod, params, result, auth)
+    if err != nil {
+        return result, err
+    }
+    return result, err
+}
+
+func (util *Util) DeleteChunkFileCmd(auth *dsrpc.Auth) (*fdsapi.DeleteChunkFileResult, error) {
+    var err error
+    params := fdsapi.NewDeleteChunkFileParams()
+    params.FilePath     = util.RemotePath
+    result := fdsapi.NewDeleteChunkFileResult()
+    err = dsrpc.Exec(util.URI, fdsapi.DeleteChunkFileMethod, params, result, auth)
+    if err != nil {
+        return result, err
+    }
+    return result, err
+}
+
+func (util *Util) PurgeChunksCmd(auth *dsrpc.Auth) (*fdsapi.PurgeChunksResult, error) {
+    var err error
+    params := fdsapi.NewPurgeChunksParams()
+    params.Age          = util.ChunkAge
+    result := fdsapi.NewPurgeChunksResult()
+    err = dsrpc.Exec(util.URI, fdsapi.PurgeChunksMethod, params, result, auth)
+    if err != nil {
+        return result, err
+    }
+    return result, err
+}
+
+func (util *Util) GetScrubStatusCmd(auth *dsrpc.Auth) (*fdsapi.GetScrubStatusResult, error) {
+    var err error
+    params := fdsapi.NewGetScrubStatusParams()
+    result := fdsapi.NewGetScrubStatusResult()
+    err = dsrpc.Exec(util.URI, fdsapi.GetScrubStatusMethod, params, result, auth)
+    if err != nil {
+        return result, err
+    }
+    return result, err
+}
+
+func (util *Util) SetQuotaCmd(auth *dsrpc.Auth) (*fdsapi.SetQuotaResult, error) {
+    var err error
+    params := fdsapi.NewSetQuotaParams()
+    params.Login        = util.Login
+    params.MaxBytes     = util.MaxBytes
+    params.MaxFiles     = util.MaxFiles
+    result := fdsapi.NewSetQuotaResult()
+    err = dsrpc.Exec(util.URI, fdsapi.SetQuotaMethod, params, result, auth)
+    if err != nil {
+        return result, err
+    }
+    return result, err
+}
+
+func (util *Util) GetQuotaCmd(auth *dsrpc.Auth) (*fdsapi.GetQuotaResult, error) {
+    var err error
+    params := fdsapi.NewGetQuotaParams()
+    params.Login        = util.Login
+    result := fdsapi.NewGetQuotaResult()
+    err = dsrpc.Exec(util.URI, fdsapi.GetQuotaMethod, params, result, auth)
+    if err != nil {
+        return result, err
+    }
+    return result, err
+}
+
 func (util *Util) AddUserCmd(auth *dsrpc.Auth) (*fdsapi.AddUserResult, error) {
     var err error
     params := fdsapi.NewAddUserParams()
@@ -308,3 +1059,27 @@ func (util *Util) ListUsersCmd(auth *dsrpc.Auth) (*fdsapi.ListUsersResult, error
     }
     return result, err
 }
+
+// blockHash returns the random hash init and the block sum
+// in the hex form expected by the store.
+func blockHash(reader io.Reader) (string, string, int64, error) {
+    var err error
+    var hashInit, hashSum string
+    var size int64
+    initBin := make([]byte, 32)
+    _, err = rand.Read(initBin)
+    if err != nil {
+        return hashInit, hashSum, size, err
+    }
+    hasher, err := highwayhash.New(initBin)
+    if err != nil {
+        return hashInit, hashSum, size, err
+    }
+    size, err = io.Copy(hasher, reader)
+    if err != nil {
+        return hashInit, hashSum, size, err
+    }
+    hashInit = hex.EncodeToString(initBin)
+    hashSum = hex.EncodeToString(hasher.Sum(nil))
+    return hashInit, hashSum, size, err
+}
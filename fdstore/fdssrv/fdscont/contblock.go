@@ -0,0 +1,120 @@
+/*
+ * Copyright 2022 Oleg Borodin  <borodin@unix7.org>
+ */
+
+package fdscont
+
+import (
+    "fdump/fdstore/fdsapi"
+    "fdump/dscomm/dsrpc"
+    "fdump/dscomm/dserr"
+    "fdump/dscomm/dsdescr"
+)
+
+func (contr *Contr) SaveBlockHandler(context *dsrpc.Context) error {
+    var err error
+    params := fdsapi.NewSaveBlockParams()
+    err = context.BindParams(params)
+    if err != nil {
+        context.SendError(err)
+        return dserr.Err(err)
+    }
+    descr := dsdescr.NewBlock()
+    descr.FileId    = params.FileId
+    descr.BatchId   = params.BatchId
+    descr.BlockType = params.BlockType
+    descr.BlockId   = params.BlockId
+    descr.BlockSize = params.BlockSize
+    descr.HashInit  = params.HashInit
+    descr.HashSum   = params.HashSum
+
+    authLogin := string(context.AuthIdent())
+    binSize := context.BinSize()
+    err = contr.store.SaveBlock(authLogin, descr, context.BinReader(), binSize)
+    if err != nil {
+        context.SendError(err)
+        return dserr.Err(err)
+    }
+    result := fdsapi.NewSaveBlockResult()
+    err = context.SendResult(result, 0)
+    if err != nil {
+        return dserr.Err(err)
+    }
+    return dserr.Err(err)
+}
+
+func (contr *Contr) LoadBlockHandler(context *dsrpc.Context) error {
+    var err error
+    params := fdsapi.NewLoadBlockParams()
+    err = context.BindParams(params)
+    if err != nil {
+        context.SendError(err)
+        return dserr.Err(err)
+    }
+    authLogin := string(context.AuthIdent())
+    descr, file, err := contr.store.LoadBlock(authLogin, params.FileId, params.BatchId,
+                                                params.BlockType, params.BlockId)
+    if err != nil {
+        context.SendError(err)
+        return dserr.Err(err)
+    }
+    defer file.Close()
+
+    result := fdsapi.NewLoadBlockResult()
+    result.Block = descr
+    err = context.SendResult(result, descr.DataSize)
+    if err != nil {
+        return dserr.Err(err)
+    }
+    _, err = dsrpc.CopyBytes(file, context.BinWriter(), descr.DataSize)
+    if err != nil {
+        return dserr.Err(err)
+    }
+    return dserr.Err(err)
+}
+
+func (contr *Contr) ListBlocksHandler(context *dsrpc.Context) error {
+    var err error
+    params := fdsapi.NewListBlocksParams()
+    err = context.BindParams(params)
+    if err != nil {
+        context.SendError(err)
+        return dserr.Err(err)
+    }
+    authLogin := string(context.AuthIdent())
+    blocks, err := contr.store.ListBlocks(authLogin, params.FileId)
+    if err != nil {
+        context.SendError(err)
+        return dserr.Err(err)
+    }
+    result := fdsapi.NewListBlocksResult()
+    result.Blocks = blocks
+    err = context.SendResult(result, 0)
+    if err != nil {
+        return dserr.Err(err)
+    }
+    return dserr.Err(err)
+}
+
+func (contr *Contr) DeleteBlockHandler(context *dsrpc.Context) error {
+    var err error
+    params := fdsapi.NewDeleteBlockParams()
+    err = context.BindParams(params)
+    if err != nil {
+        context.SendError(err)
+        return dserr.Err(err)
+    }
+    authLogin := string(context.AuthIdent())
+    err = contr.store.DeleteBlock(authLogin, params.FileId, params.BatchId,
+                                                params.BlockType, params.BlockId)
+    if err != nil {
+        context.SendError(err)
+        return dserr.Err(err)
+    }
+    result := fdsapi.NewDeleteBlockResult()
+    err = context.SendResult(result, 0)
+    if err != nil {
+        return dserr.Err(err)
+    }
+    return dserr.Err(err)
+}
@@ -0,0 +1,227 @@
+/*
+ * Copyright 2022 Oleg Borodin  <borodin@unix7.org>
+ */
+
+package fdscont
+
+import (
+    "fdump/fdstore/fdsapi"
+    "fdump/dscomm/dsrpc"
+    "fdump/dscomm/dserr"
+)
+
+func (contr *Contr) HasChunksHandler(context *dsrpc.Context) error {
+    var err error
+    params := fdsapi.NewHasChunksParams()
+    err = context.BindParams(params)
+    if err != nil {
+        context.SendError(err)
+        return dserr.Err(err)
+    }
+    authLogin := string(context.AuthIdent())
+    hasList, err := contr.store.HasChunks(authLogin, params.ChunkIds)
+    if err != nil {
+        context.SendError(err)
+        return dserr.Err(err)
+    }
+    result := fdsapi.NewHasChunksResult()
+    result.Has = hasList
+    err = context.SendResult(result, 0)
+    if err != nil {
+        return dserr.Err(err)
+    }
+    return dserr.Err(err)
+}
+
+func (contr *Contr) SaveChunkHandler(context *dsrpc.Context) error {
+    var err error
+    params := fdsapi.NewSaveChunkParams()
+    err = context.BindParams(params)
+    if err != nil {
+        context.SendError(err)
+        return dserr.Err(err)
+    }
+    authLogin := string(context.AuthIdent())
+    binSize := context.BinSize()
+    err = contr.store.SaveChunk(authLogin, params.ChunkId, context.BinReader(), binSize)
+    if err != nil {
+        context.SendError(err)
+        return dserr.Err(err)
+    }
+    result := fdsapi.NewSaveChunkResult()
+    err = context.SendResult(result, 0)
+    if err != nil {
+        return dserr.Err(err)
+    }
+    return dserr.Err(err)
+}
+
+func (contr *Contr) LoadChunkHandler(context *dsrpc.Context) error {
+    var err error
+    params := fdsapi.NewLoadChunkParams()
+    err = context.BindParams(params)
+    if err != nil {
+        context.SendError(err)
+        return dserr.Err(err)
+    }
+    authLogin := string(context.AuthIdent())
+    descr, file, err := contr.store.LoadChunk(authLogin, params.ChunkId)
+    if err != nil {
+        context.SendError(err)
+        return dserr.Err(err)
+    }
+    defer file.Close()
+
+    result := fdsapi.NewLoadChunkResult()
+    result.Chunk = descr
+    err = context.SendResult(result, descr.DataSize)
+    if err != nil {
+        return dserr.Err(err)
+    }
+    _, err = dsrpc.CopyBytes(file, context.BinWriter(), descr.DataSize)
+    if err != nil {
+        return dserr.Err(err)
+    }
+    return dserr.Err(err)
+}
+
+func (contr *Contr) SaveChunkFileHandler(context *dsrpc.Context) error {
+    var err error
+    params := fdsapi.NewSaveChunkFileParams()
+    err = context.BindParams(params)
+    if err != nil {
+        context.SendError(err)
+        return dserr.Err(err)
+    }
+    authLogin := string(context.AuthIdent())
+    file, err := contr.store.SaveChunkFile(authLogin, params.FilePath, params.ChunkIds)
+    if err != nil {
+        context.SendError(err)
+        return dserr.Err(err)
+    }
+    result := fdsapi.NewSaveChunkFileResult()
+    result.File = file
+    err = context.SendResult(result, 0)
+    if err != nil {
+        return dserr.Err(err)
+    }
+    return dserr.Err(err)
+}
+
+func (contr *Contr) StatChunkFileHandler(context *dsrpc.Context) error {
+    var err error
+    params := fdsapi.NewStatChunkFileParams()
+    err = context.BindParams(params)
+    if err != nil {
+        context.SendError(err)
+        return dserr.Err(err)
+    }
+    authLogin := string(context.AuthIdent())
+    file, err := contr.store.StatChunkFile(authLogin, params.FilePath)
+    if err != nil {
+        context.SendError(err)
+        return dserr.Err(err)
+    }
+    result := fdsapi.NewStatChunkFileResult()
+    result.File = file
+    err = context.SendResult(result, 0)
+    if err != nil {
+        return dserr.Err(err)
+    }
+    return dserr.Err(err)
+}
+
+func (contr *Contr) LoadChunkFileHandler(context *dsrpc.Context) error {
+    var err error
+    params := fdsapi.NewLoadChunkFileParams()
+    err = context.BindParams(params)
+    if err != nil {
+        context.SendError(err)
+        return dserr.Err(err)
+    }
+    authLogin := string(context.AuthIdent())
+    file, err := contr.store.StatChunkFile(authLogin, params.FilePath)
+    if err != nil {
+        context.SendError(err)
+        return dserr.Err(err)
+    }
+    result := fdsapi.NewLoadChunkFileResult()
+    result.File = file
+    err = context.SendResult(result, file.DataSize)
+    if err != nil {
+        return dserr.Err(err)
+    }
+    err = contr.store.LoadChunkFile(authLogin, params.FilePath, context.BinWriter())
+    if err != nil {
+        return dserr.Err(err)
+    }
+    return dserr.Err(err)
+}
+
+func (contr *Contr) ListChunkFilesHandler(context *dsrpc.Context) error {
+    var err error
+    params := fdsapi.NewListChunkFilesParams()
+    err = context.BindParams(params)
+    if err != nil {
+        context.SendError(err)
+        return dserr.Err(err)
+    }
+    authLogin := string(context.AuthIdent())
+    files, err := contr.store.ListChunkFiles(authLogin, params.Prefix)
+    if err != nil {
+        context.SendError(err)
+        return dserr.Err(err)
+    }
+    result := fdsapi.NewListChunkFilesResult()
+    result.Files = files
+    err = context.SendResult(result, 0)
+    if err != nil {
+        return dserr.Err(err)
+    }
+    return dserr.Err(err)
+}
+
+func (contr *Contr) DeleteChunkFileHandler(context *dsrpc.Context) error {
+    var err error
+    params := fdsapi.NewDeleteChunkFileParams()
+    err = context.BindParams(params)
+    if err != nil {
+        context.SendError(err)
+        return dserr.Err(err)
+    }
+    authLogin := string(context.AuthIdent())
+    err = contr.store.DeleteChunkFile(authLogin, params.FilePath)
+    if err != nil {
+        context.SendError(err)
+        return dserr.Err(err)
+    }
+    result := fdsapi.NewDeleteChunkFileResult()
+    err = context.SendResult(result, 0)
+    if err != nil {
+        return dserr.Err(err)
+    }
+    return dserr.Err(err)
+}
+
+func (contr *Contr) PurgeChunksHandler(context *dsrpc.Context) error {
+    var err error
+    params := fdsapi.NewPurgeChunksParams()
+    err = context.BindParams(params)
+    if err != nil {
+        context.SendError(err)
+        return dserr.Err(err)
+    }
+    authLogin := string(context.AuthIdent())
+    count, err := contr.store.PurgeChunks(authLogin, params.Age)
+    if err != nil {
+        context.SendError(err)
+        return dserr.Err(err)
+    }
+    result := fdsapi.NewPurgeChunksResult()
+    result.Count = count
+    err = context.SendResult(result, 0)
+    if err != nil {
+        return dserr.Err(err)
+    }
+    return dserr.Err(err)
+}
@@ -0,0 +1,178 @@
+/*
+ * Copyright 2022 Oleg Borodin  <borodin@unix7.org>
+ */
+
+package fdscont
+
+import (
+    "fdump/fdstore/fdsapi"
+    "fdump/dscomm/dsrpc"
+    "fdump/dscomm/dserr"
+)
+
+func (contr *Contr) CreateFileHandler(context *dsrpc.Context) error {
+    var err error
+    params := fdsapi.NewCreateFileParams()
+    err = context.BindParams(params)
+    if err != nil {
+        context.SendError(err)
+        return dserr.Err(err)
+    }
+    authLogin := string(context.AuthIdent())
+    file, err := contr.store.CreateFile(authLogin, params.FilePath, params.BatchSize,
+                                                params.BlockSize, params.RecoCount)
+    if err != nil {
+        context.SendError(err)
+        return dserr.Err(err)
+    }
+    result := fdsapi.NewCreateFileResult()
+    result.File = file
+    err = context.SendResult(result, 0)
+    if err != nil {
+        return dserr.Err(err)
+    }
+    return dserr.Err(err)
+}
+
+func (contr *Contr) AddBatchHandler(context *dsrpc.Context) error {
+    var err error
+    params := fdsapi.NewAddBatchParams()
+    err = context.BindParams(params)
+    if err != nil {
+        context.SendError(err)
+        return dserr.Err(err)
+    }
+    authLogin := string(context.AuthIdent())
+    batch, err := contr.store.AddBatch(authLogin, params.FileId)
+    if err != nil {
+        context.SendError(err)
+        return dserr.Err(err)
+    }
+    result := fdsapi.NewAddBatchResult()
+    result.Batch = batch
+    err = context.SendResult(result, 0)
+    if err != nil {
+        return dserr.Err(err)
+    }
+    return dserr.Err(err)
+}
+
+func (contr *Contr) CloseFileHandler(context *dsrpc.Context) error {
+    var err error
+    params := fdsapi.NewCloseFileParams()
+    err = context.BindParams(params)
+    if err != nil {
+        context.SendError(err)
+        return dserr.Err(err)
+    }
+    authLogin := string(context.AuthIdent())
+    err = contr.store.CloseFile(authLogin, params.FileId)
+    if err != nil {
+        context.SendError(err)
+        return dserr.Err(err)
+    }
+    result := fdsapi.NewCloseFileResult()
+    err = context.SendResult(result, 0)
+    if err != nil {
+        return dserr.Err(err)
+    }
+    return dserr.Err(err)
+}
+
+func (contr *Contr) StatFileHandler(context *dsrpc.Context) error {
+    var err error
+    params := fdsapi.NewStatFileParams()
+    err = context.BindParams(params)
+    if err != nil {
+        context.SendError(err)
+        return dserr.Err(err)
+    }
+    authLogin := string(context.AuthIdent())
+    file, err := contr.store.StatFile(authLogin, params.FileId)
+    if err != nil {
+        context.SendError(err)
+        return dserr.Err(err)
+    }
+    result := fdsapi.NewStatFileResult()
+    result.File = file
+    err = context.SendResult(result, 0)
+    if err != nil {
+        return dserr.Err(err)
+    }
+    return dserr.Err(err)
+}
+
+func (contr *Contr) ListFilesHandler(context *dsrpc.Context) error {
+    var err error
+    params := fdsapi.NewListFilesParams()
+    err = context.BindParams(params)
+    if err != nil {
+        context.SendError(err)
+        return dserr.Err(err)
+    }
+    authLogin := string(context.AuthIdent())
+    files, err := contr.store.ListFiles(authLogin, params.Prefix)
+    if err != nil {
+        context.SendError(err)
+        return dserr.Err(err)
+    }
+    result := fdsapi.NewListFilesResult()
+    result.Files = files
+    err = context.SendResult(result, 0)
+    if err != nil {
+        return dserr.Err(err)
+    }
+    return dserr.Err(err)
+}
+
+func (contr *Contr) DeleteFileHandler(context *dsrpc.Context) error {
+    var err error
+    params := fdsapi.NewDeleteFileParams()
+    err = context.BindParams(params)
+    if err != nil {
+        context.SendError(err)
+        return dserr.Err(err)
+    }
+    authLogin := string(context.AuthIdent())
+    err = contr.store.DeleteFile(authLogin, params.FileId)
+    if err != nil {
+        context.SendError(err)
+        return dserr.Err(err)
+    }
+    result := fdsapi.NewDeleteFileResult()
+    err = context.SendResult(result, 0)
+    if err != nil {
+        return dserr.Err(err)
+    }
+    return dserr.Err(err)
+}
+
+// LoadFileHandler streams all data blocks of the file. A block failed
+// after the result is sent breaks the connection, the client gets
+// a short read.
+func (contr *Contr) LoadFileHandler(context *dsrpc.Context) error {
+    var err error
+    params := fdsapi.NewLoadFileParams()
+    err = context.BindParams(params)
+    if err != nil {
+        context.SendError(err)
+        return dserr.Err(err)
+    }
+    authLogin := string(context.AuthIdent())
+    file, err := contr.store.StatFile(authLogin, params.FileId)
+    if err != nil {
+        context.SendError(err)
+        return dserr.Err(err)
+    }
+    result := fdsapi.NewLoadFileResult()
+    result.File = file
+    err = context.SendResult(result, file.DataSize)
+    if err != nil {
+        return dserr.Err(err)
+    }
+    err = contr.store.LoadFile(authLogin, params.FileId, context.BinWriter())
+    if err != nil {
+        return dserr.Err(err)
+    }
+    return dserr.Err(err)
+}
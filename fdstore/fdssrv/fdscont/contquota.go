@@ -0,0 +1,60 @@
+/*
+ * Copyright 2022 Oleg Borodin  <borodin@unix7.org>
+ */
+
+package fdscont
+
+import (
+    "fdump/fdstore/fdsapi"
+    "fdump/dscomm/dsrpc"
+    "fdump/dscomm/dserr"
+)
+
+func (contr *Contr) SetQuotaHandler(context *dsrpc.Context) error {
+    var err error
+    params := fdsapi.NewSetQuotaParams()
+    err = context.BindParams(params)
+    if err != nil {
+        context.SendError(err)
+        return dserr.Err(err)
+    }
+    authLogin := string(context.AuthIdent())
+    err = contr.store.SetQuota(authLogin, params.Login, params.MaxBytes, params.MaxFiles)
+    if err != nil {
+        context.SendError(err)
+        return dserr.Err(err)
+    }
+    result := fdsapi.NewSetQuotaResult()
+    err = context.SendResult(result, 0)
+    if err != nil {
+        return dserr.Err(err)
+    }
+    return dserr.Err(err)
+}
+
+func (contr *Contr) GetQuotaHandler(context *dsrpc.Context) error {
+    var err error
+    params := fdsapi.NewGetQuotaParams()
+    err = context.BindParams(params)
+    if err != nil {
+        context.SendError(err)
+        return dserr.Err(err)
+    }
+    authLogin := string(context.AuthIdent())
+    login := params.Login
+    if len(login) == 0 {
+        login = authLogin
+    }
+    quota, err := contr.store.GetQuota(authLogin, login)
+    if err != nil {
+        context.SendError(err)
+        return dserr.Err(err)
+    }
+    result := fdsapi.NewGetQuotaResult()
+    result.Quota = quota
+    err = context.SendResult(result, 0)
+    if err != nil {
+        return dserr.Err(err)
+    }
+    return dserr.Err(err)
+}
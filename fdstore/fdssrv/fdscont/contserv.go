@@ -41,3 +41,38 @@ func (contr *Contr) GetStatusHandler(context *dsrpc.Context) error {
     }
     return dserr.Err(err)
 }
+
+func (contr *Contr) GetScrubStatusHandler(context *dsrpc.Context) error {
+    var err error
+    params := fdsapi.NewGetScrubStatusParams()
+    err = context.BindParams(params)
+    if err != nil {
+        context.SendError(err)
+        return dserr.Err(err)
+    }
+    authLogin := string(context.AuthIdent())
+    status, err := contr.store.GetScrubStatus(authLogin)
+    if err != nil {
+        context.SendError(err)
+        return dserr.Err(err)
+    }
+    result := fdsapi.NewGetScrubStatusResult()
+    result.Enabled          = status.Enabled
+    result.Running          = status.Running
+    result.Rate             = status.Rate
+    result.Passes           = status.Passes
+    result.StartedAt        = status.StartedAt
+    result.FinishedAt       = status.FinishedAt
+    result.TotalBlocks      = status.TotalBlocks
+    result.CheckedBlocks    = status.CheckedBlocks
+    result.CheckedBytes     = status.CheckedBytes
+    result.Corrupted        = status.Corrupted
+    result.Repaired         = status.Repaired
+    result.Failed           = status.Failed
+
+    err = context.SendResult(result, 0)
+    if err != nil {
+        return dserr.Err(err)
+    }
+    return dserr.Err(err)
+}
@@ -0,0 +1,76 @@
+package fdsreg
+
+import (
+    "strconv"
+    "strings"
+    "fdump/dscomm/dsdescr"
+)
+
+func (reg *Reg) batchKey(fileId, batchId int64) []byte {
+    keyArr := []string{ reg.batchBase,
+                    strconv.FormatInt(fileId, 10),
+                    strconv.FormatInt(batchId, 10) }
+    return []byte(strings.Join(keyArr, reg.sep))
+}
+
+func (reg *Reg) PutBatch(descr *dsdescr.Batch) error {
+    var err error
+    keyBin := reg.batchKey(descr.FileId, descr.BatchId)
+    valBin, _ := descr.Pack()
+    err = reg.db.Put(keyBin, valBin)
+    return err
+}
+
+func (reg *Reg) HasBatch(fileId, batchId int64) (bool, error) {
+    var err error
+    has, err := reg.db.Has(reg.batchKey(fileId, batchId))
+    if err != nil {
+        return has, err
+    }
+    return has, err
+}
+
+func (reg *Reg) GetBatch(fileId, batchId int64) (*dsdescr.Batch, error) {
+    var err error
+    var descr *dsdescr.Batch
+    valBin, err := reg.db.Get(reg.batchKey(fileId, batchId))
+    if err != nil {
+        return descr, err
+    }
+    descr, err = dsdescr.UnpackBatch(valBin)
+    if err != nil {
+        return descr, err
+    }
+    return descr, err
+}
+
+func (reg *Reg) DeleteBatch(fileId, batchId int64) error {
+    var err error
+    err = reg.db.Delete(reg.batchKey(fileId, batchId))
+    if err != nil {
+        return err
+    }
+    return err
+}
+
+func (reg *Reg) ListBatches(fileId int64) ([]*dsdescr.Batch, error) {
+    var err error
+    descrs := make([]*dsdescr.Batch, 0)
+    cb := func(key []byte, val []byte) (bool, error) {
+        var err error
+        var interr bool
+        descr, err := dsdescr.UnpackBatch(val)
+        if err != nil {
+            return interr, err
+        }
+        descrs = append(descrs, descr)
+        return interr, err
+    }
+    keyArr := []string{ reg.batchBase, strconv.FormatInt(fileId, 10), "" }
+    batchKeyBaseBin := []byte(strings.Join(keyArr, reg.sep))
+    err = reg.db.Iter(batchKeyBaseBin, cb)
+    if err != nil {
+        return descrs, err
+    }
+    return descrs, err
+}
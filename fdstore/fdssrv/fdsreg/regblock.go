@@ -0,0 +1,81 @@
+package fdsreg
+
+import (
+    "strconv"
+    "strings"
+    "fdump/dscomm/dsdescr"
+)
+
+func (reg *Reg) blockKey(fileId, batchId, blockType, blockId int64) []byte {
+    keyArr := []string{ reg.blockBase,
+                    strconv.FormatInt(fileId, 10),
+                    strconv.FormatInt(batchId, 10),
+                    strconv.FormatInt(blockType, 10),
+                    strconv.FormatInt(blockId, 10) }
+    return []byte(strings.Join(keyArr, reg.sep))
+}
+
+func (reg *Reg) PutBlock(descr *dsdescr.Block) error {
+    var err error
+    keyBin := reg.blockKey(descr.FileId, descr.BatchId, descr.BlockType, descr.BlockId)
+    valBin, _ := descr.Pack()
+    err = reg.db.Put(keyBin, valBin)
+    return err
+}
+
+func (reg *Reg) HasBlock(fileId, batchId, blockType, blockId int64) (bool, error) {
+    var err error
+    keyBin := reg.blockKey(fileId, batchId, blockType, blockId)
+    has, err := reg.db.Has(keyBin)
+    if err != nil {
+        return has, err
+    }
+    return has, err
+}
+
+func (reg *Reg) GetBlock(fileId, batchId, blockType, blockId int64) (*dsdescr.Block, error) {
+    var err error
+    var descr *dsdescr.Block
+    keyBin := reg.blockKey(fileId, batchId, blockType, blockId)
+    valBin, err := reg.db.Get(keyBin)
+    if err != nil {
+        return descr, err
+    }
+    descr, err = dsdescr.UnpackBlock(valBin)
+    if err != nil {
+        return descr, err
+    }
+    return descr, err
+}
+
+func (reg *Reg) DeleteBlock(fileId, batchId, blockType, blockId int64) error {
+    var err error
+    keyBin := reg.blockKey(fileId, batchId, blockType, blockId)
+    err = reg.db.Delete(keyBin)
+    if err != nil {
+        return err
+    }
+    return err
+}
+
+func (reg *Reg) ListBlocks(fileId int64) ([]*dsdescr.Block, error) {
+    var err error
+    descrs := make([]*dsdescr.Block, 0)
+    cb := func(key []byte, val []byte) (bool, error) {
+        var err error
+        var interr bool
+        descr, err := dsdescr.UnpackBlock(val)
+        if err != nil {
+            return interr, err
+        }
+        descrs = append(descrs, descr)
+        return interr, err
+    }
+    keyArr := []string{ reg.blockBase, strconv.FormatInt(fileId, 10), "" }
+    blockKeyBaseBin := []byte(strings.Join(keyArr, reg.sep))
+    err = reg.db.Iter(blockKeyBaseBin, cb)
+    if err != nil {
+        return descrs, err
+    }
+    return descrs, err
+}
@@ -0,0 +1,63 @@
+/*
+ * Copyright 2022 Oleg Borodin  <borodin@unix7.org>
+ */
+
+package fdsreg
+
+import(
+    "testing"
+    "github.com/stretchr/testify/require"
+
+    "fdump/dscomm/dsdescr"
+    "fdump/dscomm/dskvdb"
+)
+
+func TestBlock01(t *testing.T) {
+    var err error
+    var has bool
+
+    dataDir := t.TempDir()
+
+    db, err := dskvdb.OpenDB(dataDir, "tmp.db")
+    defer db.Close()
+    require.NoError(t, err)
+
+    reg, err := NewReg(db)
+    require.NoError(t, err)
+
+    descr0 := dsdescr.NewBlock()
+    descr0.FileId    = 1
+    descr0.BatchId   = 2
+    descr0.BlockType = dsdescr.BTData
+    descr0.BlockId   = 3
+    descr0.DataSize  = 1024
+
+    err = reg.PutBlock(descr0)
+    require.NoError(t, err)
+
+    descr1 := dsdescr.NewBlock()
+    descr1.FileId    = 10
+    descr1.BlockType = dsdescr.BTData
+
+    err = reg.PutBlock(descr1)
+    require.NoError(t, err)
+
+    has, err = reg.HasBlock(1, 2, dsdescr.BTData, 3)
+    require.NoError(t, err)
+    require.Equal(t, true, has)
+
+    descr2, err := reg.GetBlock(1, 2, dsdescr.BTData, 3)
+    require.NoError(t, err)
+    require.Equal(t, descr0, descr2)
+
+    descrs, err := reg.ListBlocks(1)
+    require.NoError(t, err)
+    require.Equal(t, 1, len(descrs))
+
+    err = reg.DeleteBlock(1, 2, dsdescr.BTData, 3)
+    require.NoError(t, err)
+
+    has, err = reg.HasBlock(1, 2, dsdescr.BTData, 3)
+    require.NoError(t, err)
+    require.Equal(t, false, has)
+}
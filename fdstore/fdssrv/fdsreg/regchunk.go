@@ -0,0 +1,137 @@
+package fdsreg
+
+import (
+    "strings"
+    "fdump/dscomm/dsdescr"
+)
+
+func (reg *Reg) chunkKey(login, chunkId string) []byte {
+    keyArr := []string{ reg.chunkBase, login, chunkId }
+    return []byte(strings.Join(keyArr, reg.sep))
+}
+
+func (reg *Reg) PutChunk(descr *dsdescr.Chunk) error {
+    var err error
+    keyBin := reg.chunkKey(descr.Login, descr.ChunkId)
+    valBin, _ := descr.Pack()
+    err = reg.db.Put(keyBin, valBin)
+    return err
+}
+
+func (reg *Reg) HasChunk(login, chunkId string) (bool, error) {
+    var err error
+    has, err := reg.db.Has(reg.chunkKey(login, chunkId))
+    if err != nil {
+        return has, err
+    }
+    return has, err
+}
+
+func (reg *Reg) GetChunk(login, chunkId string) (*dsdescr.Chunk, error) {
+    var err error
+    var descr *dsdescr.Chunk
+    valBin, err := reg.db.Get(reg.chunkKey(login, chunkId))
+    if err != nil {
+        return descr, err
+    }
+    descr, err = dsdescr.UnpackChunk(valBin)
+    if err != nil {
+        return descr, err
+    }
+    return descr, err
+}
+
+func (reg *Reg) ListChunks(login string) ([]*dsdescr.Chunk, error) {
+    var err error
+    descrs := make([]*dsdescr.Chunk, 0)
+    cb := func(key []byte, val []byte) (bool, error) {
+        var err error
+        var interr bool
+        descr, err := dsdescr.UnpackChunk(val)
+        if err != nil {
+            return interr, err
+        }
+        descrs = append(descrs, descr)
+        return interr, err
+    }
+    err = reg.db.Iter(reg.chunkKey(login, ""), cb)
+    if err != nil {
+        return descrs, err
+    }
+    return descrs, err
+}
+
+func (reg *Reg) DeleteChunk(login, chunkId string) error {
+    var err error
+    err = reg.db.Delete(reg.chunkKey(login, chunkId))
+    if err != nil {
+        return err
+    }
+    return err
+}
+
+func (reg *Reg) chunkFileKey(login, filePath string) []byte {
+    keyArr := []string{ reg.cfileBase, login, filePath }
+    return []byte(strings.Join(keyArr, reg.sep))
+}
+
+func (reg *Reg) PutChunkFile(descr *dsdescr.ChunkFile) error {
+    var err error
+    keyBin := reg.chunkFileKey(descr.Login, descr.FilePath)
+    valBin, _ := descr.Pack()
+    err = reg.db.Put(keyBin, valBin)
+    return err
+}
+
+func (reg *Reg) HasChunkFile(login, filePath string) (bool, error) {
+    var err error
+    has, err := reg.db.Has(reg.chunkFileKey(login, filePath))
+    if err != nil {
+        return has, err
+    }
+    return has, err
+}
+
+func (reg *Reg) GetChunkFile(login, filePath string) (*dsdescr.ChunkFile, error) {
+    var err error
+    var descr *dsdescr.ChunkFile
+    valBin, err := reg.db.Get(reg.chunkFileKey(login, filePath))
+    if err != nil {
+        return descr, err
+    }
+    descr, err = dsdescr.UnpackChunkFile(valBin)
+    if err != nil {
+        return descr, err
+    }
+    return descr, err
+}
+
+// ListChunkFiles returns chunk files of the login with the path prefix.
+func (reg *Reg) ListChunkFiles(login, pathPrefix string) ([]*dsdescr.ChunkFile, error) {
+    var err error
+    descrs := make([]*dsdescr.ChunkFile, 0)
+    cb := func(key []byte, val []byte) (bool, error) {
+        var err error
+        var interr bool
+        descr, err := dsdescr.UnpackChunkFile(val)
+        if err != nil {
+            return interr, err
+        }
+        descrs = append(descrs, descr)
+        return interr, err
+    }
+    err = reg.db.Iter(reg.chunkFileKey(login, pathPrefix), cb)
+    if err != nil {
+        return descrs, err
+    }
+    return descrs, err
+}
+
+func (reg *Reg) DeleteChunkFile(login, filePath string) error {
+    var err error
+    err = reg.db.Delete(reg.chunkFileKey(login, filePath))
+    if err != nil {
+        return err
+    }
+    return err
+}
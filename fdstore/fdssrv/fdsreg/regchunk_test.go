@@ -0,0 +1,120 @@
+/*
+ * Copyright 2022 Oleg Borodin  <borodin@unix7.org>
+ */
+
+package fdsreg
+
+import(
+    "testing"
+    "github.com/stretchr/testify/require"
+
+    "fdump/dscomm/dsdescr"
+    "fdump/dscomm/dskvdb"
+)
+
+func TestChunk01(t *testing.T) {
+    var err error
+    var has bool
+
+    dataDir := t.TempDir()
+
+    db, err := dskvdb.OpenDB(dataDir, "tmp.db")
+    defer db.Close()
+    require.NoError(t, err)
+
+    reg, err := NewReg(db)
+    require.NoError(t, err)
+
+    descr0 := dsdescr.NewChunk()
+    descr0.Login    = "qwerty"
+    descr0.ChunkId  = "aa01"
+    descr0.DataSize = 1024
+    descr0.RefCount = 1
+
+    err = reg.PutChunk(descr0)
+    require.NoError(t, err)
+
+    descr1 := dsdescr.NewChunk()
+    descr1.Login    = "qwerty2"
+    descr1.ChunkId  = "aa02"
+
+    err = reg.PutChunk(descr1)
+    require.NoError(t, err)
+
+    has, err = reg.HasChunk("qwerty", "aa01")
+    require.NoError(t, err)
+    require.Equal(t, true, has)
+
+    has, err = reg.HasChunk("qwerty2", "aa01")
+    require.NoError(t, err)
+    require.Equal(t, false, has)
+
+    descr2, err := reg.GetChunk("qwerty", "aa01")
+    require.NoError(t, err)
+    require.Equal(t, descr0, descr2)
+
+    descrs, err := reg.ListChunks("qwerty")
+    require.NoError(t, err)
+    require.Equal(t, 1, len(descrs))
+
+    err = reg.DeleteChunk("qwerty", "aa01")
+    require.NoError(t, err)
+
+    has, err = reg.HasChunk("qwerty", "aa01")
+    require.NoError(t, err)
+    require.Equal(t, false, has)
+}
+
+func TestChunkFile01(t *testing.T) {
+    var err error
+    var has bool
+
+    dataDir := t.TempDir()
+
+    db, err := dskvdb.OpenDB(dataDir, "tmp.db")
+    defer db.Close()
+    require.NoError(t, err)
+
+    reg, err := NewReg(db)
+    require.NoError(t, err)
+
+    descr0 := dsdescr.NewChunkFile()
+    descr0.Login    = "qwerty"
+    descr0.FilePath = "data/test.bin"
+    descr0.ChunkIds = []string{ "aa01", "aa02" }
+    descr0.DataSize = 2048
+
+    err = reg.PutChunkFile(descr0)
+    require.NoError(t, err)
+
+    descr1 := dsdescr.NewChunkFile()
+    descr1.Login    = "qwerty"
+    descr1.FilePath = "etc/test.conf"
+    descr1.ChunkIds = []string{ "aa01" }
+
+    err = reg.PutChunkFile(descr1)
+    require.NoError(t, err)
+
+    has, err = reg.HasChunkFile("qwerty", "data/test.bin")
+    require.NoError(t, err)
+    require.Equal(t, true, has)
+
+    descr2, err := reg.GetChunkFile("qwerty", "data/test.bin")
+    require.NoError(t, err)
+    require.Equal(t, descr0, descr2)
+
+    descrs, err := reg.ListChunkFiles("qwerty", "data/")
+    require.NoError(t, err)
+    require.Equal(t, 1, len(descrs))
+
+    descrs, err = reg.ListChunkFiles("qwerty", "")
+    require.NoError(t, err)
+    require.Equal(t, 2, len(descrs))
+
+    err = reg.DeleteChunkFile("qwerty", "data/test.bin")
+    require.NoError(t, err)
+
+    has, err = reg.HasChunkFile("qwerty", "data/test.bin")
+    require.NoError(t, err)
+    require.Equal(t, false, has)
+}
@@ -2,6 +2,7 @@
 package fdsreg
 
 import (
+    "fdump/dscomm/dsalloc"
     "fdump/dscomm/dsinter"
 )
 
@@ -13,6 +14,11 @@ type Reg struct {
     blockBase   string
     batchBase   string
     fileBase    string
+    pathBase    string
+    quotaBase   string
+    chunkBase   string
+    cfileBase   string
+    fileAlloc   dsinter.Alloc
 }
 
 func NewReg(db dsinter.DB) (*Reg, error) {
@@ -25,5 +31,15 @@ func NewReg(db dsinter.DB) (*Reg, error) {
     reg.blockBase   = "block"
     reg.batchBase   = "batch"
     reg.fileBase    = "file"
+    reg.pathBase    = "fpath"
+    reg.quotaBase   = "quota"
+    reg.chunkBase   = "chunk"
+    reg.cfileBase   = "cfile"
+
+    fileAlloc, err := dsalloc.OpenAlloc(db, []byte("fileids"))
+    if err != nil {
+        return &reg, err
+    }
+    reg.fileAlloc   = fileAlloc
     return &reg, err
 }
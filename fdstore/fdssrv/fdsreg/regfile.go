@@ -0,0 +1,142 @@
+package fdsreg
+
+import (
+    "strconv"
+    "strings"
+    "fdump/dscomm/dsdescr"
+)
+
+func (reg *Reg) NewFileId() (int64, error) {
+    return reg.fileAlloc.NewId()
+}
+
+func (reg *Reg) FreeFileId(fileId int64) error {
+    return reg.fileAlloc.FreeId(fileId)
+}
+
+func (reg *Reg) fileKey(fileId int64) []byte {
+    keyArr := []string{ reg.fileBase, strconv.FormatInt(fileId, 10) }
+    return []byte(strings.Join(keyArr, reg.sep))
+}
+
+func (reg *Reg) pathKey(login, filePath string) []byte {
+    keyArr := []string{ reg.pathBase, login, filePath }
+    return []byte(strings.Join(keyArr, reg.sep))
+}
+
+// PutFile stores the file descr and the login:path index to the file id.
+func (reg *Reg) PutFile(descr *dsdescr.File) error {
+    var err error
+    valBin, _ := descr.Pack()
+    err = reg.db.Put(reg.fileKey(descr.FileId), valBin)
+    if err != nil {
+        return err
+    }
+    idBin := []byte(strconv.FormatInt(descr.FileId, 10))
+    err = reg.db.Put(reg.pathKey(descr.Login, descr.FilePath), idBin)
+    if err != nil {
+        return err
+    }
+    return err
+}
+
+func (reg *Reg) HasFile(fileId int64) (bool, error) {
+    var err error
+    has, err := reg.db.Has(reg.fileKey(fileId))
+    if err != nil {
+        return has, err
+    }
+    return has, err
+}
+
+func (reg *Reg) GetFile(fileId int64) (*dsdescr.File, error) {
+    var err error
+    var descr *dsdescr.File
+    valBin, err := reg.db.Get(reg.fileKey(fileId))
+    if err != nil {
+        return descr, err
+    }
+    descr, err = dsdescr.UnpackFile(valBin)
+    if err != nil {
+        return descr, err
+    }
+    return descr, err
+}
+
+func (reg *Reg) HasFilePath(login, filePath string) (bool, error) {
+    var err error
+    has, err := reg.db.Has(reg.pathKey(login, filePath))
+    if err != nil {
+        return has, err
+    }
+    return has, err
+}
+
+func (reg *Reg) DeleteFile(fileId int64) error {
+    var err error
+    descr, err := reg.GetFile(fileId)
+    if err != nil {
+        return err
+    }
+    err = reg.db.Delete(reg.pathKey(descr.Login, descr.FilePath))
+    if err != nil {
+        return err
+    }
+    err = reg.db.Delete(reg.fileKey(fileId))
+    if err != nil {
+        return err
+    }
+    return err
+}
+
+// ListFiles returns files of the login with the path prefix.
+func (reg *Reg) ListFiles(login, pathPrefix string) ([]*dsdescr.File, error) {
+    var err error
+    descrs := make([]*dsdescr.File, 0)
+    fileIds := make([]int64, 0)
+    cb := func(key []byte, val []byte) (bool, error) {
+        var err error
+        var interr bool
+        fileId, err := strconv.ParseInt(string(val), 10, 64)
+        if err != nil {
+            return interr, err
+        }
+        fileIds = append(fileIds, fileId)
+        return interr, err
+    }
+    err = reg.db.Iter(reg.pathKey(login, pathPrefix), cb)
+    if err != nil {
+        return descrs, err
+    }
+    for _, fileId := range fileIds {
+        descr, err := reg.GetFile(fileId)
+        if err != nil {
+            return descrs, err
+        }
+        descrs = append(descrs, descr)
+    }
+    return descrs, err
+}
+
+// ListFileIds returns ids of all files of all logins.
+func (reg *Reg) ListFileIds() ([]int64, error) {
+    var err error
+    fileIds := make([]int64, 0)
+    cb := func(key []byte, val []byte) (bool, error) {
+        var err error
+        var interr bool
+        keyArr := strings.Split(string(key), reg.sep)
+        fileId, err := strconv.ParseInt(keyArr[len(keyArr) - 1], 10, 64)
+        if err != nil {
+            return interr, err
+        }
+        fileIds = append(fileIds, fileId)
+        return interr, err
+    }
+    fileKeyBaseBin := []byte(reg.fileBase + reg.sep)
+    err = reg.db.Iter(fileKeyBaseBin, cb)
+    if err != nil {
+        return fileIds, err
+    }
+    return fileIds, err
+}
@@ -0,0 +1,78 @@
+/*
+ * Copyright 2022 Oleg Borodin  <borodin@unix7.org>
+ */
+
+package fdsreg
+
+import(
+    "testing"
+    "github.com/stretchr/testify/require"
+
+    "fdump/dscomm/dsdescr"
+    "fdump/dscomm/dskvdb"
+)
+
+func TestFile01(t *testing.T) {
+    var err error
+    var has bool
+
+    dataDir := t.TempDir()
+
+    db, err := dskvdb.OpenDB(dataDir, "tmp.db")
+    defer db.Close()
+    require.NoError(t, err)
+
+    reg, err := NewReg(db)
+    require.NoError(t, err)
+
+    fileId, err := reg.NewFileId()
+    require.NoError(t, err)
+
+    descr0 := dsdescr.NewFile()
+    descr0.FileId   = fileId
+    descr0.Login    = "qwerty"
+    descr0.FilePath = "dump/test.bin"
+
+    err = reg.PutFile(descr0)
+    require.NoError(t, err)
+
+    has, err = reg.HasFilePath("qwerty", "dump/test.bin")
+    require.NoError(t, err)
+    require.Equal(t, true, has)
+
+    descr1, err := reg.GetFile(fileId)
+    require.NoError(t, err)
+    require.Equal(t, descr0, descr1)
+
+    descrs, err := reg.ListFiles("qwerty", "dump/")
+    require.NoError(t, err)
+    require.Equal(t, 1, len(descrs))
+
+    descrs, err = reg.ListFiles("qwerty", "tmp/")
+    require.NoError(t, err)
+    require.Equal(t, 0, len(descrs))
+
+    batch := dsdescr.NewBatch()
+    batch.FileId    = fileId
+    batch.BatchId   = 0
+    err = reg.PutBatch(batch)
+    require.NoError(t, err)
+
+    batches, err := reg.ListBatches(fileId)
+    require.NoError(t, err)
+    require.Equal(t, 1, len(batches))
+
+    err = reg.DeleteBatch(fileId, 0)
+    require.NoError(t, err)
+
+    err = reg.DeleteFile(fileId)
+    require.NoError(t, err)
+
+    has, err = reg.HasFile(fileId)
+    require.NoError(t, err)
+    require.Equal(t, false, has)
+
+    has, err = reg.HasFilePath("qwerty", "dump/test.bin")
+    require.NoError(t, err)
+    require.Equal(t, false, has)
+}
@@ -0,0 +1,41 @@
+package fdsreg
+
+import (
+    "strings"
+    "fdump/dscomm/dsdescr"
+)
+
+func (reg *Reg) quotaKey(login string) []byte {
+    keyArr := []string{ reg.quotaBase, login }
+    return []byte(strings.Join(keyArr, reg.sep))
+}
+
+func (reg *Reg) PutQuota(descr *dsdescr.Quota) error {
+    var err error
+    valBin, _ := descr.Pack()
+    err = reg.db.Put(reg.quotaKey(descr.Login), valBin)
+    return err
+}
+
+func (reg *Reg) HasQuota(login string) (bool, error) {
+    var err error
+    has, err := reg.db.Has(reg.quotaKey(login))
+    if err != nil {
+        return has, err
+    }
+    return has, err
+}
+
+func (reg *Reg) GetQuota(login string) (*dsdescr.Quota, error) {
+    var err error
+    var descr *dsdescr.Quota
+    valBin, err := reg.db.Get(reg.quotaKey(login))
+    if err != nil {
+        return descr, err
+    }
+    descr, err = dsdescr.UnpackQuota(valBin)
+    if err != nil {
+        return descr, err
+    }
+    return descr, err
+}
@@ -14,6 +14,7 @@ import (
     "path/filepath"
     "strconv"
     "syscall"
+    "time"
     "io"
 
     "fdump/fdstore/fdsapi"
@@ -23,6 +24,7 @@ import (
 
     "fdump/dscomm/dskvdb"
     "fdump/dscomm/dslog"
+    "fdump/dscomm/dsauth"
     "fdump/dscomm/dsrpc"
     "fdump/dscomm/dserr"
 )
@@ -47,6 +49,7 @@ func main() {
 type Server struct {
     Params  *Config
     Backgr  bool
+    store   *fdstore.Store
 }
 
 func (server *Server) Execute() error {
@@ -128,6 +131,16 @@ func (server *Server) GetOptions() error {
     flag.StringVar(&server.Params.Port, "port", server.Params.Port, "listen port")
     flag.BoolVar(&server.Backgr, "daemon", server.Backgr, "run as daemon")
 
+    flag.BoolVar(&server.Params.TLSEnable, "tls", server.Params.TLSEnable, "enable tls")
+    flag.StringVar(&server.Params.TLSCert, "tlsCert", server.Params.TLSCert, "tls certificate file")
+    flag.StringVar(&server.Params.TLSKey, "tlsKey", server.Params.TLSKey, "tls key file")
+    flag.StringVar(&server.Params.TLSClientCA, "tlsClientCA", server.Params.TLSClientCA, "tls client ca file, enables mutual tls")
+    flag.BoolVar(&server.Params.AuthLegacy, "authLegacy", server.Params.AuthLegacy, "accept legacy salt+pass auth of old clients")
+    flag.Int64Var(&server.Params.RecoCount, "recoCount", server.Params.RecoCount, "default recovery blocks per batch")
+    flag.BoolVar(&server.Params.ScrubEnable, "scrub", server.Params.ScrubEnable, "enable background block scrubber")
+    flag.Int64Var(&server.Params.ScrubRate, "scrubRate", server.Params.ScrubRate, "scrubber read rate limit, bytes per second")
+    flag.Int64Var(&server.Params.ScrubInterval, "scrubInterval", server.Params.ScrubInterval, "interval between scrub passes, seconds")
+
     help := func() {
         fmt.Println("")
         fmt.Printf("usage: %s [option]\n", exeName)
@@ -416,6 +429,9 @@ func (server *Server) SetSHandler() error {
 func (server *Server) StopAll() error {
     var err error
     dslog.LogInfo("stop processes")
+    if server.store != nil {
+        server.store.StopScrubber()
+    }
     return err
 }
 
@@ -451,6 +467,13 @@ func (server *Server) RunService() error {
     }
     store.SetFilePerm(filePerm)
     store.SetDirPerm(dirPerm)
+    store.SetRecoCount(server.Params.RecoCount)
+    server.store = store
+    if server.Params.ScrubEnable {
+        scrubInterval := time.Duration(server.Params.ScrubInterval) * time.Second
+        store.StartScrubber(server.Params.ScrubRate, scrubInterval)
+        defer store.StopScrubber()
+    }
 
     err = store.SeedUsers()
     if err != nil {
@@ -460,6 +483,11 @@ func (server *Server) RunService() error {
     if err != nil {
         return err
     }
+    auth := dsauth.NewAuth(store)
+    auth.SetLegacyAuth(server.Params.AuthLegacy)
+    if server.Params.AuthLegacy {
+        dslog.LogWarning("legacy auth enabled")
+    }
 
     dslog.LogInfof("dataDir is %s", server.Params.DataDir)
     dslog.LogInfof("logDir is %s", server.Params.LogDir)
@@ -467,10 +495,21 @@ func (server *Server) RunService() error {
 
     serv := dsrpc.NewService()
 
+    if server.Params.TLSEnable {
+        tlsConfig, err := dsrpc.NewServerTLSConfig(server.Params.TLSCert,
+                                server.Params.TLSKey, server.Params.TLSClientCA)
+        if err != nil {
+            return err
+        }
+        serv.SetTLSConfig(tlsConfig)
+        dslog.LogInfof("tls cert is %s", server.Params.TLSCert)
+    }
+
     if debugMode || develMode {
         serv.PreMiddleware(dsrpc.LogRequest)
     }
-    serv.PreMiddleware(contr.AuthMidware(debugMode))
+    serv.PreMiddleware(auth.Midware(debugMode))
+    serv.SetSaltFunc(auth.Salt)
 
     serv.Handler(fdsapi.AddUserMethod, contr.AddUserHandler)
     serv.Handler(fdsapi.CheckUserMethod, contr.CheckUserHandler)
@@ -478,7 +517,34 @@ func (server *Server) RunService() error {
     serv.Handler(fdsapi.ListUsersMethod, contr.ListUsersHandler)
     serv.Handler(fdsapi.DeleteUserMethod, contr.DeleteUserHandler)
 
+    serv.Handler(fdsapi.SetQuotaMethod, contr.SetQuotaHandler)
+    serv.Handler(fdsapi.GetQuotaMethod, contr.GetQuotaHandler)
+
+    serv.Handler(fdsapi.CreateFileMethod, contr.CreateFileHandler)
+    serv.Handler(fdsapi.AddBatchMethod, contr.AddBatchHandler)
+    serv.Handler(fdsapi.CloseFileMethod, contr.CloseFileHandler)
+    serv.Handler(fdsapi.StatFileMethod, contr.StatFileHandler)
+    serv.Handler(fdsapi.ListFilesMethod, contr.ListFilesHandler)
+    serv.Handler(fdsapi.DeleteFileMethod, contr.DeleteFileHandler)
+    serv.Handler(fdsapi.LoadFileMethod, contr.LoadFileHandler)
+
+    serv.Handler(fdsapi.SaveBlockMethod, contr.SaveBlockHandler)
+    serv.Handler(fdsapi.LoadBlockMethod, contr.LoadBlockHandler)
+    serv.Handler(fdsapi.ListBlocksMethod, contr.ListBlocksHandler)
+    serv.Handler(fdsapi.DeleteBlockMethod, contr.DeleteBlockHandler)
+
+    serv.Handler(fdsapi.HasChunksMethod, contr.HasChunksHandler)
+    serv.Handler(fdsapi.SaveChunkMethod, contr.SaveChunkHandler)
+    serv.Handler(fdsapi.LoadChunkMethod, contr.LoadChunkHandler)
+    serv.Handler(fdsapi.SaveChunkFileMethod, contr.SaveChunkFileHandler)
+    serv.Handler(fdsapi.StatChunkFileMethod, contr.StatChunkFileHandler)
+    serv.Handler(fdsapi.LoadChunkFileMethod, contr.LoadChunkFileHandler)
+    serv.Handler(fdsapi.ListChunkFilesMethod, contr.ListChunkFilesHandler)
+    serv.Handler(fdsapi.DeleteChunkFileMethod, contr.DeleteChunkFileHandler)
+    serv.Handler(fdsapi.PurgeChunksMethod, contr.PurgeChunksHandler)
+
     serv.Handler(fdsapi.GetStatusMethod, contr.GetStatusHandler)
+    serv.Handler(fdsapi.GetScrubStatusMethod, contr.GetScrubStatusHandler)
 
 
     if debugMode || develMode {
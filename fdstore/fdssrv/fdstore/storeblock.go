@@ -0,0 +1,449 @@
+/*
+ * Copyright 2022 Oleg Borodin  <borodin@unix7.org>
+ */
+
+package fdstore
+
+import (
+    "encoding/hex"
+    "errors"
+    "fmt"
+    "hash"
+    "io"
+    "os"
+    "path/filepath"
+    "strconv"
+    "time"
+
+    "github.com/minio/highwayhash"
+
+    "fdump/dscomm/dsdescr"
+    "fdump/dscomm/dserr"
+)
+
+const blocksDir     string  = "blocks"
+const maxBlockSize  int64   = 64 * 1024 * 1024
+
+func (store *Store) SaveBlock(authLogin string, descr *dsdescr.Block, reader io.Reader, binSize int64) error {
+    var err error
+
+    ok, err := validateBlock(descr, binSize)
+    if !ok {
+        return dserr.Err(err)
+    }
+    if descr.BlockType == dsdescr.BTReco {
+        err = errors.New("recovery blocks are made by the store")
+        return dserr.Err(err)
+    }
+    file, err := store.getFile(authLogin, descr.FileId)
+    if err != nil {
+        return dserr.Err(err)
+    }
+    ok, err = validateFileBlock(file, descr, binSize)
+    if !ok {
+        return dserr.Err(err)
+    }
+    has, err := store.reg.HasBatch(descr.FileId, descr.BatchId)
+    if err != nil {
+        return dserr.Err(err)
+    }
+    if !has {
+        err = fmt.Errorf("batch %d:%d not exists", descr.FileId, descr.BatchId)
+        return dserr.Err(err)
+    }
+    oldSize, err := store.blockDataSize(descr)
+    if err != nil {
+        return dserr.Err(err)
+    }
+    store.fileMtx.Lock()
+    err = store.checkQuota(file.Login, binSize - oldSize, 0)
+    store.fileMtx.Unlock()
+    if err != nil {
+        return dserr.Err(err)
+    }
+    hasher, err := newBlockHasher(descr.HashInit)
+    if err != nil {
+        return dserr.Err(err)
+    }
+
+    blockPath := blockFilePath(descr.FileId, descr.BatchId, descr.BlockType, descr.BlockId)
+    blockFile, err := store.createBlockTemp(blockPath)
+    if err != nil {
+        return dserr.Err(err)
+    }
+    tmpPath := blockFile.Name()
+    defer os.Remove(tmpPath)
+    defer blockFile.Close()
+
+    writer := io.MultiWriter(blockFile, hasher)
+    _, err = io.CopyN(writer, reader, binSize)
+    if err != nil {
+        return dserr.Err(err)
+    }
+    hashSum := hex.EncodeToString(hasher.Sum(nil))
+    if hashSum != descr.HashSum {
+        err = errors.New("block checksum mismatch")
+        return dserr.Err(err)
+    }
+    err = blockFile.Sync()
+    if err != nil {
+        return dserr.Err(err)
+    }
+
+    complete, err := store.putDataBlock(authLogin, descr, tmpPath, binSize)
+    if err != nil {
+        return dserr.Err(err)
+    }
+    if complete {
+        err = store.encodeBatch(descr.FileId, descr.BatchId, file.BatchSize)
+        if err != nil {
+            return dserr.Err(err)
+        }
+    }
+    return dserr.Err(err)
+}
+
+// putDataBlock renames the uploaded temp file to the block path and
+// puts the block descr under the file lock. It reports whether
+// the batch is complete and can be encoded.
+func (store *Store) putDataBlock(authLogin string, descr *dsdescr.Block, tmpPath string, binSize int64) (bool, error) {
+    var err error
+    var complete bool
+
+    store.fileMtx.Lock()
+    defer store.fileMtx.Unlock()
+
+    file, err := store.getFile(authLogin, descr.FileId)
+    if err != nil {
+        return complete, dserr.Err(err)
+    }
+    oldSize, err := store.blockDataSize(descr)
+    if err != nil {
+        return complete, dserr.Err(err)
+    }
+    err = store.checkQuota(file.Login, binSize - oldSize, 0)
+    if err != nil {
+        return complete, dserr.Err(err)
+    }
+    err = store.checkBlockOrder(file, descr, binSize)
+    if err != nil {
+        return complete, dserr.Err(err)
+    }
+    blockPath := blockFilePath(descr.FileId, descr.BatchId, descr.BlockType, descr.BlockId)
+    err = os.Rename(tmpPath, filepath.Join(store.dataDir, blockPath))
+    if err != nil {
+        return complete, dserr.Err(err)
+    }
+
+    descr.BlockSize = file.BlockSize
+    descr.DataSize  = binSize
+    descr.FilePath  = blockPath
+    descr.HasLocal  = true
+    descr.CreatedAt = time.Now().Unix()
+    descr.UpdatedAt = descr.CreatedAt
+
+    err = store.reg.PutBlock(descr)
+    if err != nil {
+        return complete, dserr.Err(err)
+    }
+    file.DataSize += binSize - oldSize
+    file.UpdatedAt = descr.UpdatedAt
+    err = store.reg.PutFile(file)
+    if err != nil {
+        return complete, dserr.Err(err)
+    }
+    err = store.addUsage(file.Login, binSize - oldSize, 0)
+    if err != nil {
+        return complete, dserr.Err(err)
+    }
+    complete, err = store.resetBatchReco(file, descr.BatchId)
+    if err != nil {
+        return complete, dserr.Err(err)
+    }
+    return complete, dserr.Err(err)
+}
+
+// checkBlockOrder allows the block shorter than the file block size
+// only at the end of the file, the caller holds the file lock.
+func (store *Store) checkBlockOrder(file *dsdescr.File, descr *dsdescr.Block, binSize int64) error {
+    var err error
+    last, err := store.lastDataBlock(file)
+    if err != nil {
+        return dserr.Err(err)
+    }
+    if last == nil {
+        return dserr.Err(err)
+    }
+    position := descr.BatchId * file.BatchSize + descr.BlockId
+    lastPosition := last.BatchId * file.BatchSize + last.BlockId
+    switch {
+        case position > lastPosition && last.DataSize < file.BlockSize:
+            err = fmt.Errorf("block follows short block %d:%d", last.BatchId, last.BlockId)
+        case position < lastPosition && binSize < file.BlockSize:
+            err = fmt.Errorf("only the last block may be shorter than %d", file.BlockSize)
+    }
+    return dserr.Err(err)
+}
+
+// lastDataBlock returns the stored data block with the highest
+// position in the file or nil if the file has no blocks.
+func (store *Store) lastDataBlock(file *dsdescr.File) (*dsdescr.Block, error) {
+    var err error
+    var block *dsdescr.Block
+    for batchId := file.BatchCount - 1; batchId >= 0; batchId-- {
+        for blockId := file.BatchSize - 1; blockId >= 0; blockId-- {
+            has, err := store.reg.HasBlock(file.FileId, batchId, dsdescr.BTData, blockId)
+            if err != nil {
+                return block, dserr.Err(err)
+            }
+            if !has {
+                continue
+            }
+            block, err = store.reg.GetBlock(file.FileId, batchId, dsdescr.BTData, blockId)
+            if err != nil {
+                return block, dserr.Err(err)
+            }
+            return block, dserr.Err(err)
+        }
+    }
+    return block, dserr.Err(err)
+}
+
+// LoadBlock verifies the block checksum and returns the block
+// file positioned at the data start. The caller closes the file.
+// A damaged data block is repaired from recovery blocks.
+func (store *Store) LoadBlock(authLogin string, fileId, batchId, blockType, blockId int64) (*dsdescr.Block, *os.File, error) {
+    var err error
+    var descr *dsdescr.Block
+    var file *os.File
+
+    fileDescr, err := store.getFile(authLogin, fileId)
+    if err != nil {
+        return descr, file, dserr.Err(err)
+    }
+    if blockType == dsdescr.BTData {
+        descr, file, err = store.openDataBlock(fileDescr, batchId, blockId)
+        if err != nil {
+            return descr, file, dserr.Err(err)
+        }
+        return descr, file, dserr.Err(err)
+    }
+    descr, file, err = store.openBlock(fileId, batchId, blockType, blockId)
+    if err != nil {
+        return descr, file, dserr.Err(err)
+    }
+    return descr, file, dserr.Err(err)
+}
+
+func (store *Store) ListBlocks(authLogin string, fileId int64) ([]*dsdescr.Block, error) {
+    var err error
+    blocks := make([]*dsdescr.Block, 0)
+    _, err = store.getFile(authLogin, fileId)
+    if err != nil {
+        return blocks, dserr.Err(err)
+    }
+    blocks, err = store.reg.ListBlocks(fileId)
+    if err != nil {
+        return blocks, dserr.Err(err)
+    }
+    return blocks, dserr.Err(err)
+}
+
+func (store *Store) DeleteBlock(authLogin string, fileId, batchId, blockType, blockId int64) error {
+    var err error
+
+    store.fileMtx.Lock()
+    defer store.fileMtx.Unlock()
+
+    file, err := store.getFile(authLogin, fileId)
+    if err != nil {
+        return dserr.Err(err)
+    }
+    has, err := store.reg.HasBlock(fileId, batchId, blockType, blockId)
+    if err != nil {
+        return dserr.Err(err)
+    }
+    if !has {
+        return dserr.Err(err)
+    }
+    descr, err := store.reg.GetBlock(fileId, batchId, blockType, blockId)
+    if err != nil {
+        return dserr.Err(err)
+    }
+    err = store.deleteBlock(descr)
+    if err != nil {
+        return dserr.Err(err)
+    }
+    if descr.BlockType == dsdescr.BTData {
+        file.DataSize -= descr.DataSize
+        file.UpdatedAt = time.Now().Unix()
+        err = store.reg.PutFile(file)
+        if err != nil {
+            return dserr.Err(err)
+        }
+        err = store.addUsage(file.Login, -descr.DataSize, 0)
+        if err != nil {
+            return dserr.Err(err)
+        }
+        _, err = store.resetBatchReco(file, batchId)
+        if err != nil {
+            return dserr.Err(err)
+        }
+    }
+    return dserr.Err(err)
+}
+
+// blockDataSize returns the data size of the stored block
+// or zero if the block not exists.
+func (store *Store) blockDataSize(descr *dsdescr.Block) (int64, error) {
+    var err error
+    var size int64
+    has, err := store.reg.HasBlock(descr.FileId, descr.BatchId, descr.BlockType, descr.BlockId)
+    if err != nil {
+        return size, dserr.Err(err)
+    }
+    if !has {
+        return size, dserr.Err(err)
+    }
+    block, err := store.reg.GetBlock(descr.FileId, descr.BatchId, descr.BlockType, descr.BlockId)
+    if err != nil {
+        return size, dserr.Err(err)
+    }
+    size = block.DataSize
+    return size, dserr.Err(err)
+}
+
+func (store *Store) openBlock(fileId, batchId, blockType, blockId int64) (*dsdescr.Block, *os.File, error) {
+    var err error
+    var descr *dsdescr.Block
+    var file *os.File
+
+    has, err := store.reg.HasBlock(fileId, batchId, blockType, blockId)
+    if err != nil {
+        return descr, file, dserr.Err(err)
+    }
+    if !has {
+        err = fmt.Errorf("block %d:%d:%d:%d not exists", fileId, batchId, blockType, blockId)
+        return descr, file, dserr.Err(err)
+    }
+    descr, err = store.reg.GetBlock(fileId, batchId, blockType, blockId)
+    if err != nil {
+        return descr, file, dserr.Err(err)
+    }
+    file, err = store.openBlockFile(descr)
+    if err != nil {
+        return descr, file, dserr.Err(err)
+    }
+    return descr, file, dserr.Err(err)
+}
+
+// openBlockFile verifies the checksum of the block file and returns
+// the file positioned at the data start.
+func (store *Store) openBlockFile(descr *dsdescr.Block) (*os.File, error) {
+    var err error
+    file, err := os.OpenFile(filepath.Join(store.dataDir, descr.FilePath), os.O_RDONLY, 0)
+    if err != nil {
+        return file, dserr.Err(err)
+    }
+    ok, err := checkBlockFile(descr, file)
+    if err != nil {
+        file.Close()
+        return file, dserr.Err(err)
+    }
+    if !ok {
+        file.Close()
+        err = fmt.Errorf("block %d:%d:%d:%d checksum mismatch", descr.FileId, descr.BatchId,
+                                                descr.BlockType, descr.BlockId)
+        return file, dserr.Err(err)
+    }
+    _, err = file.Seek(0, io.SeekStart)
+    if err != nil {
+        file.Close()
+        return file, dserr.Err(err)
+    }
+    return file, dserr.Err(err)
+}
+
+func (store *Store) deleteBlock(descr *dsdescr.Block) error {
+    var err error
+    err = os.Remove(filepath.Join(store.dataDir, descr.FilePath))
+    if err != nil && !os.IsNotExist(err) {
+        return dserr.Err(err)
+    }
+    err = store.reg.DeleteBlock(descr.FileId, descr.BatchId, descr.BlockType, descr.BlockId)
+    if err != nil {
+        return dserr.Err(err)
+    }
+    return dserr.Err(err)
+}
+
+func blockFilePath(fileId, batchId, blockType, blockId int64) string {
+    fileName := fmt.Sprintf("%d-%d-%d.blk", batchId, blockType, blockId)
+    return filepath.Join(blocksDir, strconv.FormatInt(fileId, 10), fileName)
+}
+
+func newBlockHasher(hashInit string) (hash.Hash, error) {
+    var err error
+    var hasher hash.Hash
+    initBin, err := hex.DecodeString(hashInit)
+    if err != nil {
+        err = fmt.Errorf("wrong block hash init: %v", err)
+        return hasher, dserr.Err(err)
+    }
+    hasher, err = highwayhash.New(initBin)
+    if err != nil {
+        err = fmt.Errorf("wrong block hash init: %v", err)
+        return hasher, dserr.Err(err)
+    }
+    return hasher, dserr.Err(err)
+}
+
+func checkBlockFile(descr *dsdescr.Block, reader io.Reader) (bool, error) {
+    var err error
+    var ok bool
+    hasher, err := newBlockHasher(descr.HashInit)
+    if err != nil {
+        return ok, dserr.Err(err)
+    }
+    size, err := io.Copy(hasher, reader)
+    if err != nil {
+        return ok, dserr.Err(err)
+    }
+    if size != descr.DataSize {
+        return ok, dserr.Err(err)
+    }
+    ok = hex.EncodeToString(hasher.Sum(nil)) == descr.HashSum
+    return ok, dserr.Err(err)
+}
+
+func validateBlock(descr *dsdescr.Block, binSize int64) (bool, error) {
+    var err error
+    var ok bool
+    switch {
+        case descr.BlockType != dsdescr.BTData && descr.BlockType != dsdescr.BTReco:
+            err = errors.New("irrelevant block type")
+        case descr.FileId < 0 || descr.BatchId < 0 || descr.BlockId < 0:
+            err = errors.New("negative block id")
+        case binSize < 0 || binSize > maxBlockSize:
+            err = fmt.Errorf("block size out of range 0..%d", maxBlockSize)
+        case descr.BlockSize > 0 && binSize > descr.BlockSize:
+            err = errors.New("data size exceeds block size")
+        default:
+            ok = true
+    }
+    return ok, dserr.Err(err)
+}
+
+func validateFileBlock(file *dsdescr.File, descr *dsdescr.Block, binSize int64) (bool, error) {
+    var err error
+    var ok bool
+    switch {
+        case binSize > file.BlockSize:
+            err = fmt.Errorf("data size exceeds file block size %d", file.BlockSize)
+        case descr.BlockType == dsdescr.BTData && descr.BlockId >= file.BatchSize:
+            err = fmt.Errorf("block id out of batch size %d", file.BatchSize)
+        default:
+            ok = true
+    }
+    return ok, dserr.Err(err)
+}
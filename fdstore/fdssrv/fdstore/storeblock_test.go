@@ -0,0 +1,185 @@
+/*
+ * Copyright 2022 Oleg Borodin  <borodin@unix7.org>
+ */
+
+package fdstore
+
+import (
+    "bytes"
+    "encoding/hex"
+    "io"
+    "os"
+    "path/filepath"
+    "testing"
+    "math/rand"
+
+    "github.com/minio/highwayhash"
+    "github.com/stretchr/testify/require"
+
+    "fdump/dscomm/dskvdb"
+    "fdump/dscomm/dsdescr"
+    "fdump/fdstore/fdssrv/fdsreg"
+)
+
+func newTestBlock(t *testing.T, data []byte) *dsdescr.Block {
+    hashInit := make([]byte, 32)
+    for i := range hashInit {
+        hashInit[i] = byte(i)
+    }
+    hasher, err := highwayhash.New(hashInit)
+    require.NoError(t, err)
+    hasher.Write(data)
+
+    descr := dsdescr.NewBlock()
+    descr.FileId    = 1
+    descr.BatchId   = 0
+    descr.BlockType = dsdescr.BTData
+    descr.BlockId   = 2
+    descr.BlockSize = int64(len(data))
+    descr.HashInit  = hex.EncodeToString(hashInit)
+    descr.HashSum   = hex.EncodeToString(hasher.Sum(nil))
+    return descr
+}
+
+func TestBlock01(t *testing.T) {
+    var err error
+
+    dataDir := t.TempDir()
+
+    db, err := dskvdb.OpenDB(dataDir, "storedb")
+    defer db.Close()
+    require.NoError(t, err)
+
+    reg, err := fdsreg.NewReg(db)
+    require.NoError(t, err)
+
+    store, err := NewStore(dataDir, reg)
+    require.NoError(t, err)
+
+    err = store.SeedUsers()
+    require.NoError(t, err)
+
+    authLogin := "admin"
+    data := []byte("qwerty123456")
+
+    fileDescr, err := store.CreateFile(authLogin, "test.bin", 4, 1024, 0)
+    require.NoError(t, err)
+    _, err = store.AddBatch(authLogin, fileDescr.FileId)
+    require.NoError(t, err)
+
+    descr0 := newTestBlock(t, data)
+    descr0.HashSum = hex.EncodeToString(make([]byte, 32))
+    err = store.SaveBlock(authLogin, descr0, bytes.NewReader(data), int64(len(data)))
+    require.Error(t, err)
+
+    descr0 = newTestBlock(t, data)
+    err = store.SaveBlock(authLogin, descr0, bytes.NewReader(data), int64(len(data)))
+    require.NoError(t, err)
+
+    descr1, file, err := store.LoadBlock(authLogin, 1, 0, dsdescr.BTData, 2)
+    require.NoError(t, err)
+    require.Equal(t, int64(len(data)), descr1.DataSize)
+    data1, err := io.ReadAll(file)
+    file.Close()
+    require.NoError(t, err)
+    require.Equal(t, data, data1)
+
+    blocks, err := store.ListBlocks(authLogin, 1)
+    require.NoError(t, err)
+    require.Equal(t, 1, len(blocks))
+
+    err = os.WriteFile(filepath.Join(dataDir, descr1.FilePath), []byte("qwerty654321"), 0644)
+    require.NoError(t, err)
+    _, _, err = store.LoadBlock(authLogin, 1, 0, dsdescr.BTData, 2)
+    require.Error(t, err)
+
+    err = store.DeleteBlock(authLogin, 1, 0, dsdescr.BTData, 2)
+    require.NoError(t, err)
+
+    _, _, err = store.LoadBlock(authLogin, 1, 0, dsdescr.BTData, 2)
+    require.Error(t, err)
+
+    blocks, err = store.ListBlocks(authLogin, 1)
+    require.NoError(t, err)
+    require.Equal(t, 0, len(blocks))
+}
+
+func TestBlock02(t *testing.T) {
+    var err error
+
+    dataDir := t.TempDir()
+
+    db, err := dskvdb.OpenDB(dataDir, "storedb")
+    defer db.Close()
+    require.NoError(t, err)
+
+    reg, err := fdsreg.NewReg(db)
+    require.NoError(t, err)
+
+    store, err := NewStore(dataDir, reg)
+    require.NoError(t, err)
+
+    err = store.SeedUsers()
+    require.NoError(t, err)
+
+    authLogin := "admin"
+    const blockSize int64 = 1024
+
+    file, err := store.CreateFile(authLogin, "test.bin", 4, blockSize, -1)
+    require.NoError(t, err)
+    _, err = store.AddBatch(authLogin, file.FileId)
+    require.NoError(t, err)
+
+    newBlock := func(blockId int64, data []byte) *dsdescr.Block {
+        descr := newTestBlock(t, data)
+        descr.FileId    = file.FileId
+        descr.BlockId   = blockId
+        descr.BlockSize = blockSize
+        return descr
+    }
+
+    // Concurrent uploads of the same block do not share the temp file
+    dataA := make([]byte, blockSize)
+    dataB := make([]byte, blockSize)
+    rand.Read(dataA)
+    rand.Read(dataB)
+    pipeReader, pipeWriter := io.Pipe()
+    done := make(chan error)
+    go func() {
+        done <- store.SaveBlock(authLogin, newBlock(0, dataA), pipeReader, blockSize)
+    }()
+    _, err = pipeWriter.Write(dataA[0:blockSize / 2])
+    require.NoError(t, err)
+    err = store.SaveBlock(authLogin, newBlock(0, dataB), bytes.NewReader(dataB), blockSize)
+    require.NoError(t, err)
+    _, err = pipeWriter.Write(dataA[blockSize / 2:])
+    require.NoError(t, err)
+    err = <-done
+    require.NoError(t, err)
+
+    _, blockFile, err := store.LoadBlock(authLogin, file.FileId, 0, dsdescr.BTData, 0)
+    require.NoError(t, err)
+    blockData, err := io.ReadAll(blockFile)
+    blockFile.Close()
+    require.NoError(t, err)
+    require.Equal(t, dataA, blockData)
+
+    tmpFiles, err := filepath.Glob(filepath.Join(dataDir, blocksDir, "*", "*.tmp"))
+    require.NoError(t, err)
+    require.Equal(t, 0, len(tmpFiles))
+
+    // Only the last block of the file may be shorter
+    shortData := dataA[0:100]
+    err = store.SaveBlock(authLogin, newBlock(2, shortData), bytes.NewReader(shortData), int64(len(shortData)))
+    require.NoError(t, err)
+    err = store.SaveBlock(authLogin, newBlock(3, dataB), bytes.NewReader(dataB), blockSize)
+    require.Error(t, err)
+    err = store.SaveBlock(authLogin, newBlock(1, shortData), bytes.NewReader(shortData), int64(len(shortData)))
+    require.Error(t, err)
+    err = store.SaveBlock(authLogin, newBlock(1, dataB), bytes.NewReader(dataB), blockSize)
+    require.NoError(t, err)
+    err = store.SaveBlock(authLogin, newBlock(2, dataB), bytes.NewReader(dataB), blockSize)
+    require.NoError(t, err)
+    err = store.SaveBlock(authLogin, newBlock(3, shortData), bytes.NewReader(shortData), int64(len(shortData)))
+    require.NoError(t, err)
+}
@@ -0,0 +1,499 @@
+/*
+ * Copyright 2022 Oleg Borodin  <borodin@unix7.org>
+ */
+
+package fdstore
+
+import (
+    "crypto/sha256"
+    "encoding/hex"
+    "errors"
+    "fmt"
+    "io"
+    "os"
+    "path/filepath"
+    "time"
+
+    "fdump/dscomm/dschunk"
+    "fdump/dscomm/dsdescr"
+    "fdump/dscomm/dserr"
+)
+
+const chunksDir     string  = "chunks"
+const maxChunkSize  int64   = 64 * 1024 * 1024
+const maxChunkIds   int     = 1024 * 1024
+
+// HasChunks reports for each chunk id whether the chunk of the login
+// is already stored, so the client uploads only missing chunks.
+func (store *Store) HasChunks(authLogin string, chunkIds []string) ([]bool, error) {
+    var err error
+    hasList := make([]bool, 0, len(chunkIds))
+
+    _, err = store.getUserRole(authLogin)
+    if err != nil {
+        return hasList, dserr.Err(err)
+    }
+    ok, err := validateChunkIds(chunkIds)
+    if !ok {
+        return hasList, dserr.Err(err)
+    }
+    for _, chunkId := range chunkIds {
+        has, err := store.reg.HasChunk(authLogin, chunkId)
+        if err != nil {
+            return hasList, dserr.Err(err)
+        }
+        hasList = append(hasList, has)
+    }
+    return hasList, dserr.Err(err)
+}
+
+// SaveChunk stores the chunk data of the login with the recovery
+// file if the chunk is not stored yet. The chunk id must be the sha256
+// sum of the data. New chunks are not referenced until a chunk file
+// is saved.
+func (store *Store) SaveChunk(authLogin, chunkId string, reader io.Reader, binSize int64) error {
+    var err error
+
+    _, err = store.getUserRole(authLogin)
+    if err != nil {
+        return dserr.Err(err)
+    }
+    ok, err := validateChunk(chunkId, binSize)
+    if !ok {
+        return dserr.Err(err)
+    }
+    chunkPath := chunkFilePath(authLogin, chunkId)
+    recoPath := chunkRecoFilePath(authLogin, chunkId)
+    tmpFile, err := store.createBlockTemp(chunkPath)
+    if err != nil {
+        return dserr.Err(err)
+    }
+    defer os.Remove(tmpFile.Name())
+    defer tmpFile.Close()
+
+    hasher := sha256.New()
+    writer := io.MultiWriter(tmpFile, hasher)
+    _, err = io.CopyN(writer, reader, binSize)
+    if err != nil {
+        return dserr.Err(err)
+    }
+    if hex.EncodeToString(hasher.Sum(nil)) != chunkId {
+        err = errors.New("chunk checksum mismatch")
+        return dserr.Err(err)
+    }
+    err = tmpFile.Sync()
+    if err != nil {
+        return dserr.Err(err)
+    }
+    recoFile, err := store.createBlockTemp(recoPath)
+    if err != nil {
+        return dserr.Err(err)
+    }
+    defer os.Remove(recoFile.Name())
+    defer recoFile.Close()
+    shardSums, err := writeChunkReco(tmpFile, recoFile, binSize)
+    if err != nil {
+        return dserr.Err(err)
+    }
+    err = recoFile.Sync()
+    if err != nil {
+        return dserr.Err(err)
+    }
+
+    store.fileMtx.Lock()
+    defer store.fileMtx.Unlock()
+
+    has, err := store.reg.HasChunk(authLogin, chunkId)
+    if err != nil {
+        return dserr.Err(err)
+    }
+    if has {
+        return dserr.Err(err)
+    }
+    err = store.checkQuota(authLogin, binSize, 0)
+    if err != nil {
+        return dserr.Err(err)
+    }
+    err = os.Rename(tmpFile.Name(), filepath.Join(store.dataDir, chunkPath))
+    if err != nil {
+        return dserr.Err(err)
+    }
+    err = os.Rename(recoFile.Name(), filepath.Join(store.dataDir, recoPath))
+    if err != nil {
+        return dserr.Err(err)
+    }
+    descr := dsdescr.NewChunk()
+    descr.Login     = authLogin
+    descr.ChunkId   = chunkId
+    descr.DataSize  = binSize
+    descr.FilePath  = chunkPath
+    descr.RecoPath  = recoPath
+    descr.ShardSums = shardSums
+    descr.CreatedAt = time.Now().Unix()
+    descr.UpdatedAt = descr.CreatedAt
+    err = store.reg.PutChunk(descr)
+    if err != nil {
+        return dserr.Err(err)
+    }
+    err = store.addUsage(authLogin, binSize, 0)
+    if err != nil {
+        return dserr.Err(err)
+    }
+    return dserr.Err(err)
+}
+
+// LoadChunk verifies the chunk checksum and returns the chunk
+// file positioned at the data start. The missing or corrupted chunk
+// is repaired from its recovery file. The caller closes the file.
+func (store *Store) LoadChunk(authLogin, chunkId string) (*dsdescr.Chunk, *os.File, error) {
+    var err error
+    var descr *dsdescr.Chunk
+    var file *os.File
+
+    _, err = store.getUserRole(authLogin)
+    if err != nil {
+        return descr, file, dserr.Err(err)
+    }
+    descr, file, err = store.openChunk(authLogin, chunkId)
+    if err == nil {
+        return descr, file, dserr.Err(err)
+    }
+    openErr := err
+    err = store.repairChunk(authLogin, chunkId)
+    if err != nil {
+        err = fmt.Errorf("%v, %v", openErr, err)
+        return descr, file, dserr.Err(err)
+    }
+    descr, file, err = store.openChunk(authLogin, chunkId)
+    if err != nil {
+        return descr, file, dserr.Err(err)
+    }
+    return descr, file, dserr.Err(err)
+}
+
+// openChunk opens the chunk file and verifies the chunk checksum.
+func (store *Store) openChunk(login, chunkId string) (*dsdescr.Chunk, *os.File, error) {
+    var err error
+    var file *os.File
+
+    store.fileMtx.Lock()
+    descr, err := store.getChunk(login, chunkId)
+    store.fileMtx.Unlock()
+    if err != nil {
+        return descr, file, dserr.Err(err)
+    }
+    file, err = os.OpenFile(filepath.Join(store.dataDir, descr.FilePath), os.O_RDONLY, 0)
+    if err != nil {
+        return descr, file, dserr.Err(err)
+    }
+    ok, err := checkChunkFile(descr, file)
+    if err != nil {
+        file.Close()
+        return descr, file, dserr.Err(err)
+    }
+    if !ok {
+        file.Close()
+        err = fmt.Errorf("chunk %s checksum mismatch", chunkId)
+        return descr, file, dserr.Err(err)
+    }
+    return descr, file, dserr.Err(err)
+}
+
+// SaveChunkFile stores the file of the login as the list of stored
+// chunks and references the chunks. The previous file with the path
+// is replaced and its chunks are released.
+func (store *Store) SaveChunkFile(authLogin, filePath string, chunkIds []string) (*dsdescr.ChunkFile, error) {
+    var err error
+    descr := dsdescr.NewChunkFile()
+
+    _, err = store.getUserRole(authLogin)
+    if err != nil {
+        return descr, dserr.Err(err)
+    }
+    if len(filePath) == 0 {
+        err = errors.New("zero len file path")
+        return descr, dserr.Err(err)
+    }
+    ok, err := validateChunkIds(chunkIds)
+    if !ok {
+        return descr, dserr.Err(err)
+    }
+
+    store.fileMtx.Lock()
+    defer store.fileMtx.Unlock()
+
+    var dataSize int64
+    for _, chunkId := range chunkIds {
+        has, err := store.reg.HasChunk(authLogin, chunkId)
+        if err != nil {
+            return descr, dserr.Err(err)
+        }
+        if !has {
+            err = fmt.Errorf("chunk %s not exists", chunkId)
+            return descr, dserr.Err(err)
+        }
+        chunk, err := store.reg.GetChunk(authLogin, chunkId)
+        if err != nil {
+            return descr, dserr.Err(err)
+        }
+        dataSize += chunk.DataSize
+    }
+    has, err := store.reg.HasChunkFile(authLogin, filePath)
+    if err != nil {
+        return descr, dserr.Err(err)
+    }
+    var oldFile *dsdescr.ChunkFile
+    if has {
+        oldFile, err = store.reg.GetChunkFile(authLogin, filePath)
+        if err != nil {
+            return descr, dserr.Err(err)
+        }
+    } else {
+        err = store.checkQuota(authLogin, 0, 1)
+        if err != nil {
+            return descr, dserr.Err(err)
+        }
+    }
+    err = store.refChunks(authLogin, chunkIds, 1)
+    if err != nil {
+        return descr, dserr.Err(err)
+    }
+    descr.Login     = authLogin
+    descr.FilePath  = filePath
+    descr.ChunkIds  = chunkIds
+    descr.DataSize  = dataSize
+    descr.CreatedAt = time.Now().Unix()
+    descr.UpdatedAt = descr.CreatedAt
+    if oldFile != nil {
+        descr.CreatedAt = oldFile.CreatedAt
+    }
+    err = store.reg.PutChunkFile(descr)
+    if err != nil {
+        return descr, dserr.Err(err)
+    }
+    if oldFile != nil {
+        err = store.refChunks(authLogin, oldFile.ChunkIds, -1)
+        if err != nil {
+            return descr, dserr.Err(err)
+        }
+        return descr, dserr.Err(err)
+    }
+    err = store.addUsage(authLogin, 0, 1)
+    if err != nil {
+        return descr, dserr.Err(err)
+    }
+    return descr, dserr.Err(err)
+}
+
+func (store *Store) StatChunkFile(authLogin, filePath string) (*dsdescr.ChunkFile, error) {
+    var err error
+    var descr *dsdescr.ChunkFile
+    _, err = store.getUserRole(authLogin)
+    if err != nil {
+        return descr, dserr.Err(err)
+    }
+    has, err := store.reg.HasChunkFile(authLogin, filePath)
+    if err != nil {
+        return descr, dserr.Err(err)
+    }
+    if !has {
+        err = fmt.Errorf("chunk file %s not exists", filePath)
+        return descr, dserr.Err(err)
+    }
+    descr, err = store.reg.GetChunkFile(authLogin, filePath)
+    if err != nil {
+        return descr, dserr.Err(err)
+    }
+    return descr, dserr.Err(err)
+}
+
+func (store *Store) ListChunkFiles(authLogin, pathPrefix string) ([]*dsdescr.ChunkFile, error) {
+    var err error
+    descrs := make([]*dsdescr.ChunkFile, 0)
+    _, err = store.getUserRole(authLogin)
+    if err != nil {
+        return descrs, dserr.Err(err)
+    }
+    descrs, err = store.reg.ListChunkFiles(authLogin, pathPrefix)
+    if err != nil {
+        return descrs, dserr.Err(err)
+    }
+    return descrs, dserr.Err(err)
+}
+
+// LoadChunkFile writes the file data chunk by chunk, each chunk
+// checksum is verified before the chunk is written.
+func (store *Store) LoadChunkFile(authLogin, filePath string, writer io.Writer) error {
+    var err error
+    descr, err := store.StatChunkFile(authLogin, filePath)
+    if err != nil {
+        return dserr.Err(err)
+    }
+    for _, chunkId := range descr.ChunkIds {
+        chunk, file, err := store.LoadChunk(authLogin, chunkId)
+        if err != nil {
+            return dserr.Err(err)
+        }
+        _, err = io.CopyN(writer, file, chunk.DataSize)
+        file.Close()
+        if err != nil {
+            return dserr.Err(err)
+        }
+    }
+    return dserr.Err(err)
+}
+
+// DeleteChunkFile removes the chunk file and releases its chunks,
+// chunks without references are removed.
+func (store *Store) DeleteChunkFile(authLogin, filePath string) error {
+    var err error
+
+    store.fileMtx.Lock()
+    defer store.fileMtx.Unlock()
+
+    descr, err := store.StatChunkFile(authLogin, filePath)
+    if err != nil {
+        return dserr.Err(err)
+    }
+    err = store.reg.DeleteChunkFile(authLogin, filePath)
+    if err != nil {
+        return dserr.Err(err)
+    }
+    err = store.refChunks(authLogin, descr.ChunkIds, -1)
+    if err != nil {
+        return dserr.Err(err)
+    }
+    err = store.addUsage(authLogin, 0, -1)
+    if err != nil {
+        return dserr.Err(err)
+    }
+    return dserr.Err(err)
+}
+
+// PurgeChunks removes chunks of the login without references
+// older than the age in seconds, such chunks are left by
+// interrupted uploads.
+func (store *Store) PurgeChunks(authLogin string, age int64) (int64, error) {
+    var err error
+    var count int64
+
+    _, err = store.getUserRole(authLogin)
+    if err != nil {
+        return count, dserr.Err(err)
+    }
+
+    store.fileMtx.Lock()
+    defer store.fileMtx.Unlock()
+
+    chunks, err := store.reg.ListChunks(authLogin)
+    if err != nil {
+        return count, dserr.Err(err)
+    }
+    deadline := time.Now().Unix() - age
+    for _, chunk := range chunks {
+        if chunk.RefCount > 0 || chunk.UpdatedAt > deadline {
+            continue
+        }
+        err = store.deleteChunk(chunk)
+        if err != nil {
+            return count, dserr.Err(err)
+        }
+        count += 1
+    }
+    return count, dserr.Err(err)
+}
+
+// refChunks changes reference counts of distinct chunks by the delta,
+// released chunks are removed. The caller holds the file lock.
+func (store *Store) refChunks(login string, chunkIds []string, delta int64) error {
+    var err error
+    seen := make(map[string]bool)
+    for _, chunkId := range chunkIds {
+        if seen[chunkId] {
+            continue
+        }
+        seen[chunkId] = true
+        chunk, err := store.reg.GetChunk(login, chunkId)
+        if err != nil {
+            return dserr.Err(err)
+        }
+        chunk.RefCount += delta
+        chunk.UpdatedAt = time.Now().Unix()
+        if chunk.RefCount <= 0 {
+            err = store.deleteChunk(chunk)
+            if err != nil {
+                return dserr.Err(err)
+            }
+            continue
+        }
+        err = store.reg.PutChunk(chunk)
+        if err != nil {
+            return dserr.Err(err)
+        }
+    }
+    return dserr.Err(err)
+}
+
+func (store *Store) deleteChunk(chunk *dsdescr.Chunk) error {
+    var err error
+    err = os.Remove(filepath.Join(store.dataDir, chunk.FilePath))
+    if err != nil && !os.IsNotExist(err) {
+        return dserr.Err(err)
+    }
+    if len(chunk.RecoPath) > 0 {
+        err = os.Remove(filepath.Join(store.dataDir, chunk.RecoPath))
+        if err != nil && !os.IsNotExist(err) {
+            return dserr.Err(err)
+        }
+    }
+    err = store.reg.DeleteChunk(chunk.Login, chunk.ChunkId)
+    if err != nil {
+        return dserr.Err(err)
+    }
+    err = store.addUsage(chunk.Login, -chunk.DataSize, 0)
+    if err != nil {
+        return dserr.Err(err)
+    }
+    return dserr.Err(err)
+}
+
+func chunkFilePath(login, chunkId string) string {
+    loginDir := hex.EncodeToString([]byte(login))
+    return filepath.Join(chunksDir, loginDir, chunkId[0:2], chunkId + ".chk")
+}
+
+func chunkRecoFilePath(login, chunkId string) string {
+    loginDir := hex.EncodeToString([]byte(login))
+    return filepath.Join(chunksDir, loginDir, chunkId[0:2], chunkId + ".rec")
+}
+
+func validateChunk(chunkId string, binSize int64) (bool, error) {
+    var err error
+    var ok bool
+    switch {
+        case !dschunk.ValidChunkId(chunkId):
+            err = fmt.Errorf("wrong chunk id %s", chunkId)
+        case binSize < 0 || binSize > maxChunkSize:
+            err = fmt.Errorf("chunk size out of range 0..%d", maxChunkSize)
+        default:
+            ok = true
+    }
+    return ok, dserr.Err(err)
+}
+
+func validateChunkIds(chunkIds []string) (bool, error) {
+    var err error
+    var ok bool
+    if len(chunkIds) > maxChunkIds {
+        err = fmt.Errorf("chunk id count exceeds %d", maxChunkIds)
+        return ok, dserr.Err(err)
+    }
+    for _, chunkId := range chunkIds {
+        if !dschunk.ValidChunkId(chunkId) {
+            err = fmt.Errorf("wrong chunk id %s", chunkId)
+            return ok, dserr.Err(err)
+        }
+    }
+    ok = true
+    return ok, dserr.Err(err)
+}
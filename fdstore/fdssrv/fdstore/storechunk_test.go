@@ -0,0 +1,253 @@
+/*
+ * Copyright 2022 Oleg Borodin  <borodin@unix7.org>
+ */
+
+package fdstore
+
+import (
+    "bytes"
+    "io"
+    "math/rand"
+    "os"
+    "path/filepath"
+    "testing"
+
+    "github.com/stretchr/testify/require"
+
+    "fdump/dscomm/dschunk"
+    "fdump/dscomm/dskvdb"
+    "fdump/fdstore/fdssrv/fdsreg"
+)
+
+func saveTestChunks(t *testing.T, store *Store, login string, data []byte) ([]string, int) {
+    chunker, err := dschunk.NewChunker(bytes.NewReader(data), 1024, 4096, 16384)
+    require.NoError(t, err)
+
+    chunkIds := make([]string, 0)
+    sent := 0
+    for {
+        chunk, err := chunker.Next()
+        if err == io.EOF {
+            break
+        }
+        require.NoError(t, err)
+        chunkId := dschunk.ChunkId(chunk)
+        chunkIds = append(chunkIds, chunkId)
+
+        hasList, err := store.HasChunks(login, []string{ chunkId })
+        require.NoError(t, err)
+        if hasList[0] {
+            continue
+        }
+        err = store.SaveChunk(login, chunkId, bytes.NewReader(chunk), int64(len(chunk)))
+        require.NoError(t, err)
+        sent += 1
+    }
+    return chunkIds, sent
+}
+
+func TestChunk01(t *testing.T) {
+    var err error
+
+    dataDir := t.TempDir()
+
+    db, err := dskvdb.OpenDB(dataDir, "storedb")
+    defer db.Close()
+    require.NoError(t, err)
+
+    reg, err := fdsreg.NewReg(db)
+    require.NoError(t, err)
+
+    store, err := NewStore(dataDir, reg)
+    require.NoError(t, err)
+
+    err = store.SeedUsers()
+    require.NoError(t, err)
+
+    authLogin := "admin"
+
+    data := []byte("qwerty123456")
+    badId := dschunk.ChunkId([]byte("other"))
+    err = store.SaveChunk(authLogin, badId, bytes.NewReader(data), int64(len(data)))
+    require.Error(t, err)
+
+    _, err = store.SaveChunkFile(authLogin, "bad.bin", []string{ badId })
+    require.Error(t, err)
+
+    data0 := make([]byte, 256 * 1024)
+    rand.New(rand.NewSource(1)).Read(data0)
+    chunkIds0, sent0 := saveTestChunks(t, store, authLogin, data0)
+    require.Equal(t, len(chunkIds0), sent0)
+
+    file0, err := store.SaveChunkFile(authLogin, "test0.bin", chunkIds0)
+    require.NoError(t, err)
+    require.Equal(t, int64(len(data0)), file0.DataSize)
+
+    data1 := append([]byte("inserted head"), data0...)
+    chunkIds1, sent1 := saveTestChunks(t, store, authLogin, data1)
+    require.Less(t, sent1, len(chunkIds1) / 2)
+
+    _, err = store.SaveChunkFile(authLogin, "test1.bin", chunkIds1)
+    require.NoError(t, err)
+
+    quota, err := store.getQuota(authLogin)
+    require.NoError(t, err)
+    require.Equal(t, int64(2), quota.UsedFiles)
+    require.Less(t, quota.UsedBytes, int64(len(data0) + len(data1)))
+
+    buffer := bytes.NewBuffer(nil)
+    err = store.LoadChunkFile(authLogin, "test1.bin", buffer)
+    require.NoError(t, err)
+    require.Equal(t, data1, buffer.Bytes())
+
+    err = store.DeleteChunkFile(authLogin, "test0.bin")
+    require.NoError(t, err)
+
+    buffer.Reset()
+    err = store.LoadChunkFile(authLogin, "test1.bin", buffer)
+    require.NoError(t, err)
+    require.Equal(t, data1, buffer.Bytes())
+
+    err = store.DeleteChunkFile(authLogin, "test1.bin")
+    require.NoError(t, err)
+
+    quota, err = store.getQuota(authLogin)
+    require.NoError(t, err)
+    require.Equal(t, int64(0), quota.UsedFiles)
+    require.Equal(t, int64(0), quota.UsedBytes)
+
+    hasList, err := store.HasChunks(authLogin, chunkIds1)
+    require.NoError(t, err)
+    for _, has := range hasList {
+        require.Equal(t, false, has)
+    }
+
+    chunkIds2, _ := saveTestChunks(t, store, authLogin, data)
+    count, err := store.PurgeChunks(authLogin, 0)
+    require.NoError(t, err)
+    require.Equal(t, int64(len(chunkIds2)), count)
+}
+
+func TestChunk02(t *testing.T) {
+    var err error
+
+    dataDir := t.TempDir()
+
+    db, err := dskvdb.OpenDB(dataDir, "storedb")
+    defer db.Close()
+    require.NoError(t, err)
+
+    reg, err := fdsreg.NewReg(db)
+    require.NoError(t, err)
+
+    store, err := NewStore(dataDir, reg)
+    require.NoError(t, err)
+
+    err = store.SeedUsers()
+    require.NoError(t, err)
+
+    authLogin := "admin"
+
+    data := make([]byte, 100 * 1024 + 3)
+    rand.New(rand.NewSource(2)).Read(data)
+    chunkId := dschunk.ChunkId(data)
+    err = store.SaveChunk(authLogin, chunkId, bytes.NewReader(data), int64(len(data)))
+    require.NoError(t, err)
+
+    chunk, err := reg.GetChunk(authLogin, chunkId)
+    require.NoError(t, err)
+    require.Equal(t, chunkDataShards + chunkRecoShards, len(chunk.ShardSums))
+    dataPath := filepath.Join(dataDir, chunk.FilePath)
+    recoPath := filepath.Join(dataDir, chunk.RecoPath)
+    recoData, err := os.ReadFile(recoPath)
+    require.NoError(t, err)
+    require.Equal(t, int64(chunkRecoShards) * chunkShardSize(chunk.DataSize), int64(len(recoData)))
+
+    checkChunk := func() {
+        _, file, err := store.LoadChunk(authLogin, chunkId)
+        require.NoError(t, err)
+        fileData, err := io.ReadAll(file)
+        file.Close()
+        require.NoError(t, err)
+        require.Equal(t, data, fileData)
+        storedData, err := os.ReadFile(dataPath)
+        require.NoError(t, err)
+        require.Equal(t, data, storedData)
+        storedReco, err := os.ReadFile(recoPath)
+        require.NoError(t, err)
+        require.Equal(t, recoData, storedReco)
+    }
+    corrupt := func(filePath string, offsets ...int64) {
+        fileData, err := os.ReadFile(filePath)
+        require.NoError(t, err)
+        for _, offset := range offsets {
+            fileData[offset] ^= 0xFF
+        }
+        err = os.WriteFile(filePath, fileData, 0644)
+        require.NoError(t, err)
+    }
+    shardSize := chunkShardSize(chunk.DataSize)
+
+    // Corrupted and lost chunk files are repaired on loading
+    corrupt(dataPath, 10)
+    checkChunk()
+    err = os.Remove(dataPath)
+    require.NoError(t, err)
+    checkChunk()
+
+    // The lost recovery file is written again
+    err = os.Remove(recoPath)
+    require.NoError(t, err)
+    err = store.repairChunk(authLogin, chunkId)
+    require.NoError(t, err)
+    checkChunk()
+
+    // Up to chunkRecoShards damaged shards are repaired
+    corrupt(dataPath, 0, shardSize + 1, int64(len(data)) - 1)
+    corrupt(recoPath, 5)
+    err = store.repairChunk(authLogin, chunkId)
+    require.NoError(t, err)
+    checkChunk()
+
+    corrupt(dataPath, 0, shardSize + 1, int64(len(data)) - 1)
+    corrupt(recoPath, 5, shardSize + 5)
+    err = store.repairChunk(authLogin, chunkId)
+    require.Error(t, err)
+    corrupt(dataPath, 0, shardSize + 1, int64(len(data)) - 1)
+    corrupt(recoPath, 5, shardSize + 5)
+
+    // Chunks stored without recovery files are encoded
+    chunk.RecoPath  = ""
+    chunk.ShardSums = nil
+    err = reg.PutChunk(chunk)
+    require.NoError(t, err)
+    err = os.Remove(recoPath)
+    require.NoError(t, err)
+    err = store.repairChunk(authLogin, chunkId)
+    require.NoError(t, err)
+    checkChunk()
+
+    // The empty chunk
+    emptyId := dschunk.ChunkId([]byte{})
+    err = store.SaveChunk(authLogin, emptyId, bytes.NewReader(nil), 0)
+    require.NoError(t, err)
+    emptyChunk, err := reg.GetChunk(authLogin, emptyId)
+    require.NoError(t, err)
+    err = os.Remove(filepath.Join(dataDir, emptyChunk.FilePath))
+    require.NoError(t, err)
+    _, file, err := store.LoadChunk(authLogin, emptyId)
+    require.NoError(t, err)
+    file.Close()
+
+    tmpFiles, err := filepath.Glob(filepath.Join(filepath.Dir(dataPath), "*.tmp"))
+    require.NoError(t, err)
+    require.Equal(t, 0, len(tmpFiles))
+
+    // Recovery files are removed with chunks
+    _, err = store.SaveChunkFile(authLogin, "test.bin", []string{ chunkId })
+    require.NoError(t, err)
+    err = store.DeleteChunkFile(authLogin, "test.bin")
+    require.NoError(t, err)
+    _, err = os.Stat(recoPath)
+    require.True(t, os.IsNotExist(err))
+}
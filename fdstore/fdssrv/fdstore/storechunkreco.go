@@ -0,0 +1,376 @@
+/*
+ * Copyright 2022 Oleg Borodin  <borodin@unix7.org>
+ */
+
+package fdstore
+
+import (
+    "crypto/sha256"
+    "encoding/hex"
+    "fmt"
+    "hash"
+    "io"
+    "os"
+    "path/filepath"
+    "time"
+
+    "github.com/klauspost/reedsolomon"
+
+    "fdump/dscomm/dsdescr"
+    "fdump/dscomm/dserr"
+)
+
+// The chunk is split into chunkDataShards shards padded to the shard
+// size, the recovery file next to the chunk file keeps chunkRecoShards
+// Reed-Solomon parity shards. Any chunkDataShards valid shards restore
+// the chunk, so the recovery file alone restores a lost chunk file.
+// Sums of all shards are kept in the chunk descr to find damaged shards.
+const chunkDataShards   int = 4
+const chunkRecoShards   int = 4
+
+func chunkShardSize(dataSize int64) int64 {
+    return (dataSize + int64(chunkDataShards) - 1) / int64(chunkDataShards)
+}
+
+// chunkShards returns readers of data shards of the data file and
+// parity shards of the reco file, shards of nil files are nil.
+func chunkShards(dataFile, recoFile *os.File, dataSize int64) []io.Reader {
+    shardSize := chunkShardSize(dataSize)
+    shards := make([]io.Reader, chunkDataShards + chunkRecoShards)
+    for i := range shards {
+        if i < chunkDataShards {
+            if dataFile == nil {
+                continue
+            }
+            offset := int64(i) * shardSize
+            size := dataSize - offset
+            if size < 0 {
+                size = 0
+            }
+            if size > shardSize {
+                size = shardSize
+            }
+            shards[i] = padReader(io.NewSectionReader(dataFile, offset, size), size, shardSize)
+            continue
+        }
+        if recoFile == nil {
+            continue
+        }
+        offset := int64(i - chunkDataShards) * shardSize
+        shards[i] = io.NewSectionReader(recoFile, offset, shardSize)
+    }
+    return shards
+}
+
+// chunkShardWriter writes the shard at its offset in the data
+// file or in the reco file.
+func chunkShardWriter(dataFile, recoFile *os.File, dataSize int64, shardId int) io.Writer {
+    shardSize := chunkShardSize(dataSize)
+    if shardId < chunkDataShards {
+        return &offsetWriter{ file: dataFile, offset: int64(shardId) * shardSize }
+    }
+    return &offsetWriter{ file: recoFile, offset: int64(shardId - chunkDataShards) * shardSize }
+}
+
+// writeChunkReco writes parity shards of the data file to the reco
+// file and returns sums of data and parity shards.
+func writeChunkReco(dataFile, recoFile *os.File, dataSize int64) ([]string, error) {
+    var err error
+    shardSums := make([]string, 0, chunkDataShards + chunkRecoShards)
+
+    shards := chunkShards(dataFile, nil, dataSize)
+    hashers := make([]hash.Hash, len(shards))
+    for i := range hashers {
+        hashers[i] = sha256.New()
+    }
+    readers := make([]io.Reader, chunkDataShards)
+    for i := range readers {
+        readers[i] = io.TeeReader(shards[i], hashers[i])
+    }
+    writers := make([]io.Writer, chunkRecoShards)
+    for i := range writers {
+        shardWriter := chunkShardWriter(dataFile, recoFile, dataSize, chunkDataShards + i)
+        writers[i] = io.MultiWriter(shardWriter, hashers[chunkDataShards + i])
+    }
+    if dataSize > 0 {
+        encoder, err := reedsolomon.NewStream(chunkDataShards, chunkRecoShards,
+                                    reedsolomon.WithStreamBlockSize(recoStripeSize))
+        if err != nil {
+            return shardSums, dserr.Err(err)
+        }
+        err = encoder.Encode(readers, writers)
+        if err != nil {
+            return shardSums, dserr.Err(err)
+        }
+    }
+    for _, hasher := range hashers {
+        shardSums = append(shardSums, hex.EncodeToString(hasher.Sum(nil)))
+    }
+    return shardSums, dserr.Err(err)
+}
+
+// checkChunkShards reports which shards of the chunk match their
+// sums, shards of missing files are damaged.
+func checkChunkShards(chunk *dsdescr.Chunk, dataFile, recoFile *os.File) []bool {
+    shardSize := chunkShardSize(chunk.DataSize)
+    valid := make([]bool, chunkDataShards + chunkRecoShards)
+    if len(chunk.ShardSums) != len(valid) {
+        return valid
+    }
+    for i, shard := range chunkShards(dataFile, recoFile, chunk.DataSize) {
+        if shard == nil {
+            continue
+        }
+        hasher := sha256.New()
+        size, err := io.Copy(hasher, shard)
+        valid[i] = err == nil && size == shardSize && hex.EncodeToString(hasher.Sum(nil)) == chunk.ShardSums[i]
+    }
+    return valid
+}
+
+// checkChunkFile verifies the size and the sum of the chunk data,
+// the file is positioned at the data start.
+func checkChunkFile(chunk *dsdescr.Chunk, file *os.File) (bool, error) {
+    var err error
+    _, err = file.Seek(0, io.SeekStart)
+    if err != nil {
+        return false, dserr.Err(err)
+    }
+    hasher := sha256.New()
+    size, err := io.Copy(hasher, file)
+    if err != nil {
+        return false, dserr.Err(err)
+    }
+    if size != chunk.DataSize || hex.EncodeToString(hasher.Sum(nil)) != chunk.ChunkId {
+        return false, dserr.Err(err)
+    }
+    _, err = file.Seek(0, io.SeekStart)
+    if err != nil {
+        return false, dserr.Err(err)
+    }
+    return true, dserr.Err(err)
+}
+
+// getChunk returns the chunk descr, the caller holds the file lock.
+func (store *Store) getChunk(login, chunkId string) (*dsdescr.Chunk, error) {
+    var err error
+    var chunk *dsdescr.Chunk
+    has, err := store.reg.HasChunk(login, chunkId)
+    if err != nil {
+        return chunk, dserr.Err(err)
+    }
+    if !has {
+        err = fmt.Errorf("chunk %s not exists", chunkId)
+        return chunk, dserr.Err(err)
+    }
+    chunk, err = store.reg.GetChunk(login, chunkId)
+    if err != nil {
+        return chunk, dserr.Err(err)
+    }
+    return chunk, dserr.Err(err)
+}
+
+// encodeChunk writes the recovery file of the chunk stored before
+// chunks were encoded, the chunk data must be valid.
+func (store *Store) encodeChunk(login, chunkId string) error {
+    var err error
+
+    store.fileMtx.Lock()
+    chunk, err := store.getChunk(login, chunkId)
+    store.fileMtx.Unlock()
+    if err != nil {
+        return dserr.Err(err)
+    }
+    dataFile, err := os.OpenFile(filepath.Join(store.dataDir, chunk.FilePath), os.O_RDONLY, 0)
+    if err != nil {
+        return dserr.Err(err)
+    }
+    defer dataFile.Close()
+    ok, err := checkChunkFile(chunk, dataFile)
+    if err != nil {
+        return dserr.Err(err)
+    }
+    if !ok {
+        err = fmt.Errorf("chunk %s checksum mismatch", chunkId)
+        return dserr.Err(err)
+    }
+    recoPath := chunkRecoFilePath(login, chunkId)
+    recoFile, err := store.createBlockTemp(recoPath)
+    if err != nil {
+        return dserr.Err(err)
+    }
+    defer os.Remove(recoFile.Name())
+    defer recoFile.Close()
+    shardSums, err := writeChunkReco(dataFile, recoFile, chunk.DataSize)
+    if err != nil {
+        return dserr.Err(err)
+    }
+    err = recoFile.Sync()
+    if err != nil {
+        return dserr.Err(err)
+    }
+
+    store.fileMtx.Lock()
+    defer store.fileMtx.Unlock()
+
+    current, err := store.getChunk(login, chunkId)
+    if err != nil || len(current.ShardSums) > 0 {
+        return dserr.Err(err)
+    }
+    err = os.Rename(recoFile.Name(), filepath.Join(store.dataDir, recoPath))
+    if err != nil {
+        return dserr.Err(err)
+    }
+    current.RecoPath  = recoPath
+    current.ShardSums = shardSums
+    current.UpdatedAt = time.Now().Unix()
+    err = store.reg.PutChunk(current)
+    if err != nil {
+        return dserr.Err(err)
+    }
+    return dserr.Err(err)
+}
+
+// repairChunk rebuilds damaged shards of the chunk file and of the
+// recovery file, unencoded chunks are encoded. The repaired chunk
+// is verified before it replaces stored files.
+func (store *Store) repairChunk(login, chunkId string) error {
+    var err error
+
+    store.fileMtx.Lock()
+    chunk, err := store.getChunk(login, chunkId)
+    store.fileMtx.Unlock()
+    if err != nil {
+        return dserr.Err(err)
+    }
+    if len(chunk.ShardSums) == 0 {
+        return store.encodeChunk(login, chunkId)
+    }
+    openFile := func(filePath string) *os.File {
+        file, err := os.OpenFile(filepath.Join(store.dataDir, filePath), os.O_RDONLY, 0)
+        if err != nil {
+            return nil
+        }
+        return file
+    }
+    dataFile := openFile(chunk.FilePath)
+    if dataFile != nil {
+        defer dataFile.Close()
+    }
+    recoFile := openFile(chunk.RecoPath)
+    if recoFile != nil {
+        defer recoFile.Close()
+    }
+    valid := checkChunkShards(chunk, dataFile, recoFile)
+    var damaged int
+    for _, ok := range valid {
+        if !ok {
+            damaged += 1
+        }
+    }
+    if damaged == 0 {
+        return dserr.Err(err)
+    }
+    if damaged > chunkRecoShards {
+        err = fmt.Errorf("chunk %s has %d damaged shards of %d", chunkId, damaged, len(valid))
+        return dserr.Err(err)
+    }
+
+    newData, err := store.createBlockTemp(chunk.FilePath)
+    if err != nil {
+        return dserr.Err(err)
+    }
+    defer os.Remove(newData.Name())
+    defer newData.Close()
+    newReco, err := store.createBlockTemp(chunk.RecoPath)
+    if err != nil {
+        return dserr.Err(err)
+    }
+    defer os.Remove(newReco.Name())
+    defer newReco.Close()
+
+    shards := chunkShards(dataFile, recoFile, chunk.DataSize)
+    readers := make([]io.Reader, len(shards))
+    fill := make([]io.Writer, len(shards))
+    for i := range shards {
+        if valid[i] {
+            readers[i] = shards[i]
+            continue
+        }
+        fill[i] = chunkShardWriter(newData, newReco, chunk.DataSize, i)
+    }
+    if chunk.DataSize > 0 {
+        decoder, err := reedsolomon.NewStream(chunkDataShards, chunkRecoShards,
+                                    reedsolomon.WithStreamBlockSize(recoStripeSize))
+        if err != nil {
+            return dserr.Err(err)
+        }
+        err = decoder.Reconstruct(readers, fill)
+        if err != nil {
+            return dserr.Err(err)
+        }
+    }
+    shards = chunkShards(dataFile, recoFile, chunk.DataSize)
+    for i := range shards {
+        if !valid[i] {
+            continue
+        }
+        _, err = io.Copy(chunkShardWriter(newData, newReco, chunk.DataSize, i), shards[i])
+        if err != nil {
+            return dserr.Err(err)
+        }
+    }
+    err = newData.Truncate(chunk.DataSize)
+    if err != nil {
+        return dserr.Err(err)
+    }
+    ok, err := checkChunkFile(chunk, newData)
+    if err != nil {
+        return dserr.Err(err)
+    }
+    for _, shardOk := range checkChunkShards(chunk, newData, newReco) {
+        ok = ok && shardOk
+    }
+    if !ok {
+        err = fmt.Errorf("recovered chunk %s checksum mismatch", chunkId)
+        return dserr.Err(err)
+    }
+    for _, file := range []*os.File{ newData, newReco } {
+        err = file.Sync()
+        if err != nil {
+            return dserr.Err(err)
+        }
+    }
+
+    store.fileMtx.Lock()
+    defer store.fileMtx.Unlock()
+
+    current, err := store.getChunk(login, chunkId)
+    if err != nil {
+        return dserr.Err(err)
+    }
+    if current.FilePath != chunk.FilePath || current.RecoPath != chunk.RecoPath {
+        return dserr.Err(err)
+    }
+    err = os.Rename(newData.Name(), filepath.Join(store.dataDir, chunk.FilePath))
+    if err != nil {
+        return dserr.Err(err)
+    }
+    err = os.Rename(newReco.Name(), filepath.Join(store.dataDir, chunk.RecoPath))
+    if err != nil {
+        return dserr.Err(err)
+    }
+    return dserr.Err(err)
+}
+
+// offsetWriter writes sequentially from the offset of the file.
+type offsetWriter struct {
+    file    *os.File
+    offset  int64
+}
+
+func (writer *offsetWriter) Write(data []byte) (int, error) {
+    written, err := writer.file.WriteAt(data, writer.offset)
+    writer.offset += int64(written)
+    return written, err
+}
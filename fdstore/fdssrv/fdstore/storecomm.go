@@ -6,6 +6,7 @@ package fdstore
 
 import (
     "io/fs"
+    "sync"
     "time"
     "syscall"
     "fdump/dscomm/dsinter"
@@ -17,6 +18,9 @@ type Store struct {
     dirPerm     fs.FileMode
     filePerm    fs.FileMode
     startTime   int64
+    recoCount   int64
+    fileMtx     sync.Mutex
+    scrub       scrubber
 }
 
 func NewStore(dataDir string, reg dsinter.BStoreReg) (*Store, error) {
@@ -38,6 +42,12 @@ func (store *Store) SetFilePerm(filePerm fs.FileMode) {
     store.filePerm = filePerm
 }
 
+// SetRecoCount sets the default number of recovery blocks per batch
+// for files created without own value.
+func (store *Store) SetRecoCount(recoCount int64) {
+    store.recoCount = recoCount
+}
+
 func (store *Store) GetUptime() (int64, error) {
     var err error
     uptime := time.Now().Unix() - store.startTime
@@ -0,0 +1,283 @@
+/*
+ * Copyright 2022 Oleg Borodin  <borodin@unix7.org>
+ */
+
+package fdstore
+
+import (
+    "errors"
+    "fmt"
+    "io"
+    "os"
+    "path/filepath"
+    "strconv"
+    "time"
+
+    "fdump/dscomm/dsdescr"
+    "fdump/dscomm/dserr"
+)
+
+const maxBatchSize  int64   = 256
+const maxBatchBytes int64   = 1024 * 1024 * 1024
+
+// CreateFile registers an empty file of the login. The file data is
+// uploaded as batches of batchSize data blocks of blockSize bytes,
+// only the last block of the file may be shorter. Zero recoCount
+// means the store default, negative one disables recovery blocks.
+// The batch with recovery blocks is limited to maxBatchBytes.
+func (store *Store) CreateFile(authLogin, filePath string, batchSize, blockSize, recoCount int64) (*dsdescr.File, error) {
+    var err error
+    descr := dsdescr.NewFile()
+
+    _, err = store.getUserRole(authLogin)
+    if err != nil {
+        return descr, dserr.Err(err)
+    }
+    switch {
+        case recoCount == 0:
+            recoCount = store.recoCount
+        case recoCount < 0:
+            recoCount = 0
+    }
+    ok, err := validateFile(filePath, batchSize, blockSize, recoCount)
+    if !ok {
+        return descr, dserr.Err(err)
+    }
+
+    store.fileMtx.Lock()
+    defer store.fileMtx.Unlock()
+
+    has, err := store.reg.HasFilePath(authLogin, filePath)
+    if err != nil {
+        return descr, dserr.Err(err)
+    }
+    if has {
+        err = fmt.Errorf("file %s already exists", filePath)
+        return descr, dserr.Err(err)
+    }
+    err = store.checkQuota(authLogin, 0, 1)
+    if err != nil {
+        return descr, dserr.Err(err)
+    }
+    fileId, err := store.reg.NewFileId()
+    if err != nil {
+        return descr, dserr.Err(err)
+    }
+    descr.FileId    = fileId
+    descr.FilePath  = filePath
+    descr.Login     = authLogin
+    descr.BatchSize = batchSize
+    descr.BlockSize = blockSize
+    descr.RecoCount = recoCount
+    descr.CreatedAt = time.Now().Unix()
+    descr.UpdatedAt = descr.CreatedAt
+
+    err = store.reg.PutFile(descr)
+    if err != nil {
+        store.reg.FreeFileId(fileId)
+        return descr, dserr.Err(err)
+    }
+    err = store.addUsage(authLogin, 0, 1)
+    if err != nil {
+        return descr, dserr.Err(err)
+    }
+    return descr, dserr.Err(err)
+}
+
+// AddBatch appends the next batch to the file.
+func (store *Store) AddBatch(authLogin string, fileId int64) (*dsdescr.Batch, error) {
+    var err error
+    batch := dsdescr.NewBatch()
+
+    store.fileMtx.Lock()
+    defer store.fileMtx.Unlock()
+
+    file, err := store.getFile(authLogin, fileId)
+    if err != nil {
+        return batch, dserr.Err(err)
+    }
+    batch.FileId    = fileId
+    batch.BatchId   = file.BatchCount
+    batch.BatchSize = file.BatchSize
+    batch.BlockSize = file.BlockSize
+    batch.RecoCount = file.RecoCount
+    batch.CreatedAt = time.Now().Unix()
+    batch.UpdatedAt = batch.CreatedAt
+
+    err = store.reg.PutBatch(batch)
+    if err != nil {
+        return batch, dserr.Err(err)
+    }
+    file.BatchCount += 1
+    file.UpdatedAt = batch.CreatedAt
+    err = store.reg.PutFile(file)
+    if err != nil {
+        return batch, dserr.Err(err)
+    }
+    return batch, dserr.Err(err)
+}
+
+func (store *Store) StatFile(authLogin string, fileId int64) (*dsdescr.File, error) {
+    var err error
+    file, err := store.getFile(authLogin, fileId)
+    if err != nil {
+        return file, dserr.Err(err)
+    }
+    return file, dserr.Err(err)
+}
+
+func (store *Store) ListFiles(authLogin, pathPrefix string) ([]*dsdescr.File, error) {
+    var err error
+    files := make([]*dsdescr.File, 0)
+    _, err = store.getUserRole(authLogin)
+    if err != nil {
+        return files, dserr.Err(err)
+    }
+    files, err = store.reg.ListFiles(authLogin, pathPrefix)
+    if err != nil {
+        return files, dserr.Err(err)
+    }
+    return files, dserr.Err(err)
+}
+
+// DeleteFile removes the file with all batches and blocks.
+func (store *Store) DeleteFile(authLogin string, fileId int64) error {
+    var err error
+
+    store.fileMtx.Lock()
+    defer store.fileMtx.Unlock()
+
+    file, err := store.getFile(authLogin, fileId)
+    if err != nil {
+        return dserr.Err(err)
+    }
+    blocks, err := store.reg.ListBlocks(fileId)
+    if err != nil {
+        return dserr.Err(err)
+    }
+    for _, block := range blocks {
+        err = store.deleteBlock(block)
+        if err != nil {
+            return dserr.Err(err)
+        }
+    }
+    batches, err := store.reg.ListBatches(fileId)
+    if err != nil {
+        return dserr.Err(err)
+    }
+    for _, batch := range batches {
+        err = store.reg.DeleteBatch(fileId, batch.BatchId)
+        if err != nil {
+            return dserr.Err(err)
+        }
+    }
+    err = os.RemoveAll(filepath.Join(store.dataDir, blocksDir, strconv.FormatInt(fileId, 10)))
+    if err != nil {
+        return dserr.Err(err)
+    }
+    err = store.reg.DeleteFile(fileId)
+    if err != nil {
+        return dserr.Err(err)
+    }
+    err = store.reg.FreeFileId(fileId)
+    if err != nil {
+        return dserr.Err(err)
+    }
+    err = store.addUsage(file.Login, -file.DataSize, -1)
+    if err != nil {
+        return dserr.Err(err)
+    }
+    return dserr.Err(err)
+}
+
+// LoadFile writes the file data block by block, each block checksum
+// is verified before the block is written and damaged blocks are
+// repaired from recovery blocks.
+func (store *Store) LoadFile(authLogin string, fileId int64, writer io.Writer) error {
+    var err error
+    file, err := store.getFile(authLogin, fileId)
+    if err != nil {
+        return dserr.Err(err)
+    }
+    var written int64
+    for batchId := int64(0); batchId < file.BatchCount; batchId++ {
+        for blockId := int64(0); blockId < file.BatchSize; blockId++ {
+            if written >= file.DataSize {
+                return dserr.Err(err)
+            }
+            size, err := store.copyBlock(file, batchId, blockId, writer)
+            if err != nil {
+                return dserr.Err(err)
+            }
+            written += size
+        }
+    }
+    if written != file.DataSize {
+        err = fmt.Errorf("file %d data size mismatch", fileId)
+        return dserr.Err(err)
+    }
+    return dserr.Err(err)
+}
+
+func (store *Store) copyBlock(file *dsdescr.File, batchId, blockId int64, writer io.Writer) (int64, error) {
+    var err error
+    var size int64
+    block, blockFile, err := store.openDataBlock(file, batchId, blockId)
+    if err != nil {
+        return size, dserr.Err(err)
+    }
+    defer blockFile.Close()
+    size, err = io.CopyN(writer, blockFile, block.DataSize)
+    if err != nil {
+        return size, dserr.Err(err)
+    }
+    return size, dserr.Err(err)
+}
+
+// getFile returns the file descr if the file belongs to the login
+// or the login is admin.
+func (store *Store) getFile(authLogin string, fileId int64) (*dsdescr.File, error) {
+    var err error
+    var file *dsdescr.File
+    userRole, err := store.getUserRole(authLogin)
+    if err != nil {
+        return file, dserr.Err(err)
+    }
+    has, err := store.reg.HasFile(fileId)
+    if err != nil {
+        return file, dserr.Err(err)
+    }
+    if !has {
+        err = fmt.Errorf("file %d not exists", fileId)
+        return file, dserr.Err(err)
+    }
+    file, err = store.reg.GetFile(fileId)
+    if err != nil {
+        return file, dserr.Err(err)
+    }
+    if file.Login != authLogin && userRole != dsdescr.URoleAdmin {
+        err = fmt.Errorf("user %s have insufficient rights", authLogin)
+        return file, dserr.Err(err)
+    }
+    return file, dserr.Err(err)
+}
+
+func validateFile(filePath string, batchSize, blockSize, recoCount int64) (bool, error) {
+    var err error
+    var ok bool
+    switch {
+        case len(filePath) == 0:
+            err = errors.New("zero len file path")
+        case batchSize < 1 || batchSize > maxBatchSize:
+            err = fmt.Errorf("batch size out of range 1..%d", maxBatchSize)
+        case batchSize + recoCount > maxBatchSize:
+            err = fmt.Errorf("batch size with recovery blocks exceeds %d", maxBatchSize)
+        case blockSize < 1 || blockSize > maxBlockSize:
+            err = fmt.Errorf("block size out of range 1..%d", maxBlockSize)
+        case (batchSize + recoCount) * blockSize > maxBatchBytes:
+            err = fmt.Errorf("batch with recovery blocks exceeds %d bytes", maxBatchBytes)
+        default:
+            ok = true
+    }
+    return ok, dserr.Err(err)
+}
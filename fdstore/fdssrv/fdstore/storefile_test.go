@@ -0,0 +1,123 @@
+/*
+ * Copyright 2022 Oleg Borodin  <borodin@unix7.org>
+ */
+
+package fdstore
+
+import (
+    "bytes"
+    "encoding/hex"
+    "math/rand"
+    "testing"
+
+    "github.com/minio/highwayhash"
+    "github.com/stretchr/testify/require"
+
+    "fdump/dscomm/dskvdb"
+    "fdump/dscomm/dsdescr"
+    "fdump/fdstore/fdssrv/fdsreg"
+)
+
+func saveTestBlock(t *testing.T, store *Store, authLogin string, fileId, batchId, blockId int64, data []byte) {
+    hashInit := make([]byte, 32)
+    hasher, err := highwayhash.New(hashInit)
+    require.NoError(t, err)
+    hasher.Write(data)
+
+    descr := dsdescr.NewBlock()
+    descr.FileId    = fileId
+    descr.BatchId   = batchId
+    descr.BlockType = dsdescr.BTData
+    descr.BlockId   = blockId
+    descr.HashInit  = hex.EncodeToString(hashInit)
+    descr.HashSum   = hex.EncodeToString(hasher.Sum(nil))
+    err = store.SaveBlock(authLogin, descr, bytes.NewReader(data), int64(len(data)))
+    require.NoError(t, err)
+}
+
+func TestFile01(t *testing.T) {
+    var err error
+
+    dataDir := t.TempDir()
+
+    db, err := dskvdb.OpenDB(dataDir, "storedb")
+    defer db.Close()
+    require.NoError(t, err)
+
+    reg, err := fdsreg.NewReg(db)
+    require.NoError(t, err)
+
+    store, err := NewStore(dataDir, reg)
+    require.NoError(t, err)
+
+    err = store.SeedUsers()
+    require.NoError(t, err)
+
+    adminLogin := "admin"
+    userLogin := "qwerty"
+    user := dsdescr.NewUser()
+    user.Login  = userLogin
+    user.Pass   = "123456"
+    err = store.AddUser(adminLogin, user)
+    require.NoError(t, err)
+
+    const batchSize int64 = 3
+    const blockSize int64 = 1024
+    data := make([]byte, 7 * blockSize + 100)
+    rand.Read(data)
+
+    _, err = store.CreateFile(userLogin, "", batchSize, blockSize, 0)
+    require.Error(t, err)
+
+    file, err := store.CreateFile(userLogin, "dump/test.bin", batchSize, blockSize, 0)
+    require.NoError(t, err)
+
+    _, err = store.CreateFile(userLogin, "dump/test.bin", batchSize, blockSize, 0)
+    require.Error(t, err)
+
+    var batchId, blockId int64
+    for offset := int64(0); offset < int64(len(data)); offset += blockSize {
+        if blockId == 0 {
+            batch, err := store.AddBatch(userLogin, file.FileId)
+            require.NoError(t, err)
+            batchId = batch.BatchId
+        }
+        end := offset + blockSize
+        if end > int64(len(data)) {
+            end = int64(len(data))
+        }
+        saveTestBlock(t, store, userLogin, file.FileId, batchId, blockId, data[offset:end])
+        blockId = (blockId + 1) % batchSize
+    }
+
+    file, err = store.StatFile(userLogin, file.FileId)
+    require.NoError(t, err)
+    require.Equal(t, int64(len(data)), file.DataSize)
+    require.Equal(t, int64(3), file.BatchCount)
+
+    _, err = store.StatFile("wrong", file.FileId)
+    require.Error(t, err)
+
+    buffer := bytes.NewBuffer(nil)
+    err = store.LoadFile(userLogin, file.FileId, buffer)
+    require.NoError(t, err)
+    require.Equal(t, data, buffer.Bytes())
+
+    files, err := store.ListFiles(userLogin, "dump/")
+    require.NoError(t, err)
+    require.Equal(t, 1, len(files))
+
+    files, err = store.ListFiles(adminLogin, "dump/")
+    require.NoError(t, err)
+    require.Equal(t, 0, len(files))
+
+    err = store.DeleteFile(userLogin, file.FileId)
+    require.NoError(t, err)
+
+    _, err = store.StatFile(userLogin, file.FileId)
+    require.Error(t, err)
+
+    blocks, err := reg.ListBlocks(file.FileId)
+    require.NoError(t, err)
+    require.Equal(t, 0, len(blocks))
+}
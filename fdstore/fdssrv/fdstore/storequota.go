@@ -0,0 +1,128 @@
+/*
+ * Copyright 2022 Oleg Borodin  <borodin@unix7.org>
+ */
+
+package fdstore
+
+import (
+    "errors"
+    "fmt"
+    "time"
+
+    "fdump/dscomm/dsdescr"
+    "fdump/dscomm/dserr"
+)
+
+func (store *Store) SetQuota(authLogin, login string, maxBytes, maxFiles int64) error {
+    var err error
+    userRole, err := store.getUserRole(authLogin)
+    if userRole != dsdescr.URoleAdmin {
+        err = fmt.Errorf("user %s have insufficient rights", authLogin)
+        return dserr.Err(err)
+    }
+    has, err := store.reg.HasUser(login)
+    if err != nil {
+        return dserr.Err(err)
+    }
+    if !has {
+        err = fmt.Errorf("user %s not exists", login)
+        return dserr.Err(err)
+    }
+    if maxBytes < 0 || maxFiles < 0 {
+        err = errors.New("negative quota limit")
+        return dserr.Err(err)
+    }
+
+    store.fileMtx.Lock()
+    defer store.fileMtx.Unlock()
+
+    quota, err := store.getQuota(login)
+    if err != nil {
+        return dserr.Err(err)
+    }
+    quota.MaxBytes  = maxBytes
+    quota.MaxFiles  = maxFiles
+    quota.UpdatedAt = time.Now().Unix()
+    err = store.reg.PutQuota(quota)
+    if err != nil {
+        return dserr.Err(err)
+    }
+    return dserr.Err(err)
+}
+
+func (store *Store) GetQuota(authLogin, login string) (*dsdescr.Quota, error) {
+    var err error
+    var quota *dsdescr.Quota
+    userRole, err := store.getUserRole(authLogin)
+    if authLogin != login && userRole != dsdescr.URoleAdmin {
+        err = fmt.Errorf("user %s have insufficient rights", authLogin)
+        return quota, dserr.Err(err)
+    }
+
+    store.fileMtx.Lock()
+    defer store.fileMtx.Unlock()
+
+    quota, err = store.getQuota(login)
+    if err != nil {
+        return quota, dserr.Err(err)
+    }
+    return quota, dserr.Err(err)
+}
+
+// getQuota returns the stored quota record or an empty one,
+// the caller holds the file lock.
+func (store *Store) getQuota(login string) (*dsdescr.Quota, error) {
+    var err error
+    quota := dsdescr.NewQuota()
+    quota.Login = login
+    has, err := store.reg.HasQuota(login)
+    if err != nil {
+        return quota, dserr.Err(err)
+    }
+    if !has {
+        return quota, dserr.Err(err)
+    }
+    quota, err = store.reg.GetQuota(login)
+    if err != nil {
+        return quota, dserr.Err(err)
+    }
+    return quota, dserr.Err(err)
+}
+
+// checkQuota reports an error if the usage of the login
+// increased by the values exceeds the limits.
+func (store *Store) checkQuota(login string, addBytes, addFiles int64) error {
+    var err error
+    quota, err := store.getQuota(login)
+    if err != nil {
+        return dserr.Err(err)
+    }
+    if quota.MaxBytes > 0 && addBytes > 0 && quota.UsedBytes + addBytes > quota.MaxBytes {
+        err = fmt.Errorf("user %s bytes quota %d exceeded", login, quota.MaxBytes)
+        return dserr.Err(err)
+    }
+    if quota.MaxFiles > 0 && addFiles > 0 && quota.UsedFiles + addFiles > quota.MaxFiles {
+        err = fmt.Errorf("user %s files quota %d exceeded", login, quota.MaxFiles)
+        return dserr.Err(err)
+    }
+    return dserr.Err(err)
+}
+
+func (store *Store) addUsage(login string, addBytes, addFiles int64) error {
+    var err error
+    if addBytes == 0 && addFiles == 0 {
+        return dserr.Err(err)
+    }
+    quota, err := store.getQuota(login)
+    if err != nil {
+        return dserr.Err(err)
+    }
+    quota.UsedBytes += addBytes
+    quota.UsedFiles += addFiles
+    quota.UpdatedAt = time.Now().Unix()
+    err = store.reg.PutQuota(quota)
+    if err != nil {
+        return dserr.Err(err)
+    }
+    return dserr.Err(err)
+}
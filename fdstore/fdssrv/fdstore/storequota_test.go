@@ -0,0 +1,106 @@
+/*
+ * Copyright 2022 Oleg Borodin  <borodin@unix7.org>
+ */
+
+package fdstore
+
+import (
+    "bytes"
+    "encoding/hex"
+    "testing"
+
+    "github.com/minio/highwayhash"
+    "github.com/stretchr/testify/require"
+
+    "fdump/dscomm/dskvdb"
+    "fdump/dscomm/dsdescr"
+    "fdump/fdstore/fdssrv/fdsreg"
+)
+
+func TestQuota01(t *testing.T) {
+    var err error
+
+    dataDir := t.TempDir()
+
+    db, err := dskvdb.OpenDB(dataDir, "storedb")
+    defer db.Close()
+    require.NoError(t, err)
+
+    reg, err := fdsreg.NewReg(db)
+    require.NoError(t, err)
+
+    store, err := NewStore(dataDir, reg)
+    require.NoError(t, err)
+
+    err = store.SeedUsers()
+    require.NoError(t, err)
+
+    adminLogin := "admin"
+    userLogin := "qwerty"
+    user := dsdescr.NewUser()
+    user.Login  = userLogin
+    user.Pass   = "123456"
+    err = store.AddUser(adminLogin, user)
+    require.NoError(t, err)
+
+    err = store.SetQuota(userLogin, userLogin, 1000, 1)
+    require.Error(t, err)
+
+    err = store.SetQuota(adminLogin, userLogin, 1000, 1)
+    require.NoError(t, err)
+
+    file, err := store.CreateFile(userLogin, "test.bin", 4, 1024, -1)
+    require.NoError(t, err)
+
+    _, err = store.CreateFile(userLogin, "test2.bin", 4, 1024, -1)
+    require.Error(t, err)
+
+    _, err = store.AddBatch(userLogin, file.FileId)
+    require.NoError(t, err)
+
+    saveTestBlock(t, store, userLogin, file.FileId, 0, 0, make([]byte, 600))
+
+    data := make([]byte, 600)
+    hashInit := make([]byte, 32)
+    hasher, err := highwayhash.New(hashInit)
+    require.NoError(t, err)
+    hasher.Write(data)
+    descr := dsdescr.NewBlock()
+    descr.FileId    = file.FileId
+    descr.BlockType = dsdescr.BTData
+    descr.BlockId   = 1
+    descr.HashInit  = hex.EncodeToString(hashInit)
+    descr.HashSum   = hex.EncodeToString(hasher.Sum(nil))
+    err = store.SaveBlock(userLogin, descr, bytes.NewReader(data), int64(len(data)))
+    require.Error(t, err)
+
+    // Replace of the block accounts the old size
+    saveTestBlock(t, store, userLogin, file.FileId, 0, 0, make([]byte, 900))
+
+    quota, err := store.GetQuota(userLogin, userLogin)
+    require.NoError(t, err)
+    require.Equal(t, int64(900), quota.UsedBytes)
+    require.Equal(t, int64(1), quota.UsedFiles)
+
+    _, err = store.GetQuota(userLogin, adminLogin)
+    require.Error(t, err)
+
+    err = store.DeleteBlock(userLogin, file.FileId, 0, dsdescr.BTData, 0)
+    require.NoError(t, err)
+    quota, err = store.GetQuota(adminLogin, userLogin)
+    require.NoError(t, err)
+    require.Equal(t, int64(0), quota.UsedBytes)
+
+    saveTestBlock(t, store, userLogin, file.FileId, 0, 0, make([]byte, 500))
+    err = store.DeleteFile(userLogin, file.FileId)
+    require.NoError(t, err)
+
+    quota, err = store.GetQuota(adminLogin, userLogin)
+    require.NoError(t, err)
+    require.Equal(t, int64(0), quota.UsedBytes)
+    require.Equal(t, int64(0), quota.UsedFiles)
+    require.Equal(t, int64(1000), quota.MaxBytes)
+
+    _, err = store.CreateFile(userLogin, "test2.bin", 4, 1024, -1)
+    require.NoError(t, err)
+}
@@ -0,0 +1,593 @@
+/*
+ * Copyright 2022 Oleg Borodin  <borodin@unix7.org>
+ */
+
+package fdstore
+
+import (
+    "crypto/rand"
+    "encoding/hex"
+    "errors"
+    "fmt"
+    "hash"
+    "io"
+    "os"
+    "path/filepath"
+    "time"
+
+    "github.com/klauspost/reedsolomon"
+
+    "fdump/dscomm/dsdescr"
+    "fdump/dscomm/dserr"
+)
+
+const recoStripeSize    int = 64 * 1024
+
+// The recovery blocks of a batch are Reed-Solomon parity shards over
+// all data blocks of the batch padded to the block size. Data blocks
+// absent at the encoding time, e.g. the tail of the last batch, are
+// encoded as zero shards. Shards are streamed by stripes, blocks are
+// read and written without the file lock and committed under the lock
+// only if the batch is not changed meanwhile.
+
+// CloseFile encodes batches not yet covered by recovery blocks,
+// the last partial batch of the file in the first place.
+func (store *Store) CloseFile(authLogin string, fileId int64) error {
+    var err error
+
+    dataCounts, err := store.unencodedBatches(authLogin, fileId)
+    if err != nil {
+        return dserr.Err(err)
+    }
+    for batchId := int64(0); batchId < int64(len(dataCounts)); batchId++ {
+        if dataCounts[batchId] == 0 {
+            continue
+        }
+        err = store.encodeBatch(fileId, batchId, dataCounts[batchId])
+        if err != nil {
+            return dserr.Err(err)
+        }
+    }
+    return dserr.Err(err)
+}
+
+// unencodedBatches returns counts of leading data blocks of batches
+// not covered by recovery blocks, zero for covered batches.
+func (store *Store) unencodedBatches(authLogin string, fileId int64) ([]int64, error) {
+    var err error
+    dataCounts := make([]int64, 0)
+
+    store.fileMtx.Lock()
+    defer store.fileMtx.Unlock()
+
+    file, err := store.getFile(authLogin, fileId)
+    if err != nil {
+        return dataCounts, dserr.Err(err)
+    }
+    if file.RecoCount == 0 {
+        return dataCounts, dserr.Err(err)
+    }
+    for batchId := int64(0); batchId < file.BatchCount; batchId++ {
+        var dataCount int64
+        batch, err := store.reg.GetBatch(fileId, batchId)
+        if err != nil {
+            return dataCounts, dserr.Err(err)
+        }
+        for len(batch.DataSizes) == 0 && dataCount < batch.BatchSize {
+            has, err := store.reg.HasBlock(fileId, batchId, dsdescr.BTData, dataCount)
+            if err != nil {
+                return dataCounts, dserr.Err(err)
+            }
+            if !has {
+                break
+            }
+            dataCount++
+        }
+        dataCounts = append(dataCounts, dataCount)
+    }
+    return dataCounts, dserr.Err(err)
+}
+
+// resetBatchReco drops recovery blocks of the batch changed by a data
+// block write or delete and reports whether all data blocks of
+// the batch are stored, so the batch can be encoded again.
+func (store *Store) resetBatchReco(file *dsdescr.File, batchId int64) (bool, error) {
+    var err error
+    var complete bool
+    if file.RecoCount == 0 {
+        return complete, dserr.Err(err)
+    }
+    batch, err := store.reg.GetBatch(file.FileId, batchId)
+    if err != nil {
+        return complete, dserr.Err(err)
+    }
+    if len(batch.DataSizes) > 0 {
+        err = store.dropBatchReco(batch)
+        if err != nil {
+            return complete, dserr.Err(err)
+        }
+    }
+    for blockId := int64(0); blockId < batch.BatchSize; blockId++ {
+        has, err := store.reg.HasBlock(file.FileId, batchId, dsdescr.BTData, blockId)
+        if err != nil {
+            return complete, dserr.Err(err)
+        }
+        if !has {
+            return complete, dserr.Err(err)
+        }
+    }
+    complete = true
+    return complete, dserr.Err(err)
+}
+
+func (store *Store) dropBatchReco(batch *dsdescr.Batch) error {
+    var err error
+    for recoId := int64(0); recoId < batch.RecoCount; recoId++ {
+        has, err := store.reg.HasBlock(batch.FileId, batch.BatchId, dsdescr.BTReco, recoId)
+        if err != nil {
+            return dserr.Err(err)
+        }
+        if !has {
+            continue
+        }
+        block, err := store.reg.GetBlock(batch.FileId, batch.BatchId, dsdescr.BTReco, recoId)
+        if err != nil {
+            return dserr.Err(err)
+        }
+        err = store.deleteBlock(block)
+        if err != nil {
+            return dserr.Err(err)
+        }
+    }
+    batch.DataSizes = nil
+    batch.UpdatedAt = time.Now().Unix()
+    err = store.reg.PutBatch(batch)
+    if err != nil {
+        return dserr.Err(err)
+    }
+    return dserr.Err(err)
+}
+
+// batchState is the batch with descrs of the first data blocks and
+// recovery blocks taken under the file lock, missing blocks are nil.
+type batchState struct {
+    batch   *dsdescr.Batch
+    data    []*dsdescr.Block
+    reco    []*dsdescr.Block
+}
+
+// getBatchState reads the batch state, the caller holds the file lock.
+func (store *Store) getBatchState(fileId, batchId, dataCount int64) (*batchState, error) {
+    var err error
+    state := &batchState{}
+    has, err := store.reg.HasBatch(fileId, batchId)
+    if err != nil {
+        return state, dserr.Err(err)
+    }
+    if !has {
+        err = fmt.Errorf("batch %d:%d not exists", fileId, batchId)
+        return state, dserr.Err(err)
+    }
+    state.batch, err = store.reg.GetBatch(fileId, batchId)
+    if err != nil {
+        return state, dserr.Err(err)
+    }
+    if dataCount > state.batch.BatchSize {
+        err = fmt.Errorf("batch %d:%d has %d data blocks", fileId, batchId, state.batch.BatchSize)
+        return state, dserr.Err(err)
+    }
+    getBlock := func(blockType, blockId int64) (*dsdescr.Block, error) {
+        var block *dsdescr.Block
+        has, err := store.reg.HasBlock(fileId, batchId, blockType, blockId)
+        if err != nil || !has {
+            return block, dserr.Err(err)
+        }
+        return store.reg.GetBlock(fileId, batchId, blockType, blockId)
+    }
+    state.data = make([]*dsdescr.Block, dataCount)
+    for blockId := range state.data {
+        state.data[blockId], err = getBlock(dsdescr.BTData, int64(blockId))
+        if err != nil {
+            return state, dserr.Err(err)
+        }
+    }
+    state.reco = make([]*dsdescr.Block, state.batch.RecoCount)
+    for recoId := range state.reco {
+        state.reco[recoId], err = getBlock(dsdescr.BTReco, int64(recoId))
+        if err != nil {
+            return state, dserr.Err(err)
+        }
+    }
+    return state, dserr.Err(err)
+}
+
+// changed reports whether the batch or its blocks are changed since
+// the state was taken, the caller holds the file lock.
+func (state *batchState) changed(store *Store) (bool, error) {
+    var err error
+    batch := state.batch
+    current, err := store.getBatchState(batch.FileId, batch.BatchId, int64(len(state.data)))
+    if err != nil {
+        return true, nil
+    }
+    if len(current.batch.DataSizes) != len(batch.DataSizes) {
+        return true, dserr.Err(err)
+    }
+    for i := range batch.DataSizes {
+        if current.batch.DataSizes[i] != batch.DataSizes[i] {
+            return true, dserr.Err(err)
+        }
+    }
+    blocks := append(append([]*dsdescr.Block{}, state.data...), state.reco...)
+    currentBlocks := append(append([]*dsdescr.Block{}, current.data...), current.reco...)
+    for i := range blocks {
+        if !sameBlock(blocks[i], currentBlocks[i]) {
+            return true, dserr.Err(err)
+        }
+    }
+    return false, dserr.Err(err)
+}
+
+func sameBlock(block, other *dsdescr.Block) bool {
+    if block == nil || other == nil {
+        return block == other
+    }
+    return block.FilePath == other.FilePath && block.DataSize == other.DataSize &&
+        block.HashInit == other.HashInit && block.HashSum == other.HashSum
+}
+
+// encodeBatch makes recovery blocks over the first dataCount
+// data blocks of the batch.
+func (store *Store) encodeBatch(fileId, batchId, dataCount int64) error {
+    var err error
+
+    store.fileMtx.Lock()
+    state, err := store.getBatchState(fileId, batchId, dataCount)
+    store.fileMtx.Unlock()
+    if err != nil {
+        return dserr.Err(err)
+    }
+    batch := state.batch
+    encoder, err := reedsolomon.NewStream(int(batch.BatchSize), int(batch.RecoCount),
+                                    reedsolomon.WithStreamBlockSize(recoStripeSize))
+    if err != nil {
+        return dserr.Err(err)
+    }
+    dataSizes := make([]int64, dataCount)
+    readers := make([]io.Reader, batch.BatchSize)
+    for i := range readers {
+        if int64(i) >= dataCount {
+            readers[i] = io.LimitReader(zeroReader{}, batch.BlockSize)
+            continue
+        }
+        block := state.data[i]
+        if block == nil {
+            err = fmt.Errorf("block %d:%d:%d not exists", fileId, batchId, i)
+            return dserr.Err(err)
+        }
+        blockFile, err := store.openBlockFile(block)
+        if err != nil {
+            return dserr.Err(err)
+        }
+        defer blockFile.Close()
+        readers[i] = padReader(blockFile, block.DataSize, batch.BlockSize)
+        dataSizes[i] = block.DataSize
+    }
+    blockWriters := make([]*blockWriter, batch.RecoCount)
+    writers := make([]io.Writer, batch.RecoCount)
+    defer func() {
+        for _, blockWriter := range blockWriters {
+            if blockWriter != nil {
+                blockWriter.abort()
+            }
+        }
+    }()
+    for recoId := range blockWriters {
+        block := dsdescr.NewBlock()
+        block.FileId    = fileId
+        block.BatchId   = batchId
+        block.BlockType = dsdescr.BTReco
+        block.BlockId   = int64(recoId)
+        block.BlockSize = batch.BlockSize
+        blockWriters[recoId], err = store.newBlockWriter(block, "")
+        if err != nil {
+            return dserr.Err(err)
+        }
+        writers[recoId] = blockWriters[recoId]
+    }
+    err = encoder.Encode(readers, writers)
+    if err != nil {
+        return dserr.Err(err)
+    }
+
+    store.fileMtx.Lock()
+    defer store.fileMtx.Unlock()
+
+    changed, err := state.changed(store)
+    if err != nil || changed {
+        return dserr.Err(err)
+    }
+    for _, blockWriter := range blockWriters {
+        err = blockWriter.commit()
+        if err != nil {
+            return dserr.Err(err)
+        }
+    }
+    batch.DataSizes = dataSizes
+    batch.UpdatedAt = time.Now().Unix()
+    err = store.reg.PutBatch(batch)
+    if err != nil {
+        return dserr.Err(err)
+    }
+    return dserr.Err(err)
+}
+
+// repairBlock rebuilds the missing or corrupted data block
+// from other data blocks and recovery blocks of the batch.
+// The rebuilt block keeps the hash init and the hash sum
+// of the stored block descr.
+func (store *Store) repairBlock(fileId, batchId, blockId int64) error {
+    var err error
+
+    store.fileMtx.Lock()
+    batch, err := store.reg.GetBatch(fileId, batchId)
+    if err != nil {
+        store.fileMtx.Unlock()
+        return dserr.Err(err)
+    }
+    dataCount := int64(len(batch.DataSizes))
+    if blockId >= dataCount {
+        store.fileMtx.Unlock()
+        err = fmt.Errorf("block %d:%d:%d not covered by recovery blocks", fileId, batchId, blockId)
+        return dserr.Err(err)
+    }
+    state, err := store.getBatchState(fileId, batchId, dataCount)
+    store.fileMtx.Unlock()
+    if err != nil {
+        return dserr.Err(err)
+    }
+    batch = state.batch
+    encoder, err := reedsolomon.NewStream(int(batch.BatchSize), int(batch.RecoCount),
+                                    reedsolomon.WithStreamBlockSize(recoStripeSize))
+    if err != nil {
+        return dserr.Err(err)
+    }
+    shardCount := batch.BatchSize + batch.RecoCount
+    readers := make([]io.Reader, shardCount)
+    for i := range readers {
+        var block *dsdescr.Block
+        switch {
+            case int64(i) == blockId:
+                continue
+            case int64(i) >= batch.BatchSize:
+                block = state.reco[int64(i) - batch.BatchSize]
+            case int64(i) >= dataCount:
+                readers[i] = io.LimitReader(zeroReader{}, batch.BlockSize)
+                continue
+            default:
+                block = state.data[i]
+        }
+        if block == nil {
+            continue
+        }
+        blockFile, err := store.openBlockFile(block)
+        if err != nil {
+            continue
+        }
+        defer blockFile.Close()
+        readers[i] = padReader(blockFile, block.DataSize, batch.BlockSize)
+    }
+
+    var hashInit string
+    prevBlock := state.data[blockId]
+    if prevBlock != nil {
+        hashInit = prevBlock.HashInit
+    }
+    block := dsdescr.NewBlock()
+    block.FileId    = fileId
+    block.BatchId   = batchId
+    block.BlockType = dsdescr.BTData
+    block.BlockId   = blockId
+    block.BlockSize = batch.BlockSize
+    blockWriter, err := store.newBlockWriter(block, hashInit)
+    if err != nil {
+        return dserr.Err(err)
+    }
+    defer blockWriter.abort()
+
+    writers := make([]io.Writer, shardCount)
+    writers[blockId] = &limitWriter{ writer: blockWriter, left: batch.DataSizes[blockId] }
+    err = encoder.Reconstruct(readers, writers)
+    if err != nil {
+        err = fmt.Errorf("unable to recover block %d:%d:%d: %v", fileId, batchId, blockId, err)
+        return dserr.Err(err)
+    }
+    if prevBlock != nil && blockWriter.hashSum() != prevBlock.HashSum {
+        err = fmt.Errorf("recovered block %d:%d:%d checksum mismatch", fileId, batchId, blockId)
+        return dserr.Err(err)
+    }
+
+    store.fileMtx.Lock()
+    defer store.fileMtx.Unlock()
+
+    changed, err := state.changed(store)
+    if err != nil || changed {
+        return dserr.Err(err)
+    }
+    err = blockWriter.commit()
+    if err != nil {
+        return dserr.Err(err)
+    }
+    return dserr.Err(err)
+}
+
+// openDataBlock opens the data block and repairs it
+// if the block is missing or corrupted.
+func (store *Store) openDataBlock(file *dsdescr.File, batchId, blockId int64) (*dsdescr.Block, *os.File, error) {
+    var err error
+    block, blockFile, err := store.openBlock(file.FileId, batchId, dsdescr.BTData, blockId)
+    if err == nil || file.RecoCount == 0 {
+        return block, blockFile, dserr.Err(err)
+    }
+    openErr := err
+    err = store.repairBlock(file.FileId, batchId, blockId)
+    if err != nil {
+        err = fmt.Errorf("%v, %v", openErr, err)
+        return block, blockFile, dserr.Err(err)
+    }
+    block, blockFile, err = store.openBlock(file.FileId, batchId, dsdescr.BTData, blockId)
+    if err != nil {
+        return block, blockFile, dserr.Err(err)
+    }
+    return block, blockFile, dserr.Err(err)
+}
+
+// blockWriter writes the block made by the store to the unique temp
+// file in the block dir hashing the data. The block is stored
+// by commit, abort removes the temp file if it is not committed.
+type blockWriter struct {
+    store       *Store
+    descr       *dsdescr.Block
+    file        *os.File
+    hasher      hash.Hash
+    size        int64
+    done        bool
+}
+
+// newBlockWriter creates the block writer, the empty hash init
+// is generated.
+func (store *Store) newBlockWriter(descr *dsdescr.Block, hashInit string) (*blockWriter, error) {
+    var err error
+    writer := &blockWriter{ store: store, descr: descr }
+    if len(hashInit) == 0 {
+        hashInitBin := make([]byte, 32)
+        _, err = rand.Read(hashInitBin)
+        if err != nil {
+            return writer, dserr.Err(err)
+        }
+        hashInit = hex.EncodeToString(hashInitBin)
+    }
+    writer.hasher, err = newBlockHasher(hashInit)
+    if err != nil {
+        return writer, dserr.Err(err)
+    }
+    descr.HashInit = hashInit
+    descr.FilePath = blockFilePath(descr.FileId, descr.BatchId, descr.BlockType, descr.BlockId)
+    writer.file, err = store.createBlockTemp(descr.FilePath)
+    if err != nil {
+        writer.done = true
+        return writer, dserr.Err(err)
+    }
+    return writer, dserr.Err(err)
+}
+
+// createBlockTemp creates the unique temp file in the dir
+// of the block path.
+func (store *Store) createBlockTemp(blockPath string) (*os.File, error) {
+    var err error
+    fullPath := filepath.Join(store.dataDir, blockPath)
+    err = os.MkdirAll(filepath.Dir(fullPath), store.dirPerm)
+    if err != nil {
+        return nil, dserr.Err(err)
+    }
+    file, err := os.CreateTemp(filepath.Dir(fullPath), filepath.Base(fullPath) + ".*.tmp")
+    if err != nil {
+        return file, dserr.Err(err)
+    }
+    err = file.Chmod(store.filePerm)
+    if err != nil {
+        file.Close()
+        os.Remove(file.Name())
+        return file, dserr.Err(err)
+    }
+    return file, dserr.Err(err)
+}
+
+func (writer *blockWriter) Write(data []byte) (int, error) {
+    written, err := writer.file.Write(data)
+    writer.hasher.Write(data[0:written])
+    writer.size += int64(written)
+    return written, err
+}
+
+func (writer *blockWriter) hashSum() string {
+    return hex.EncodeToString(writer.hasher.Sum(nil))
+}
+
+// commit syncs the temp file, renames it to the block path
+// and puts the block descr, the caller holds the file lock.
+func (writer *blockWriter) commit() error {
+    var err error
+    if writer.done {
+        err = errors.New("block writer is closed")
+        return dserr.Err(err)
+    }
+    writer.done = true
+    defer os.Remove(writer.file.Name())
+    defer writer.file.Close()
+    err = writer.file.Sync()
+    if err != nil {
+        return dserr.Err(err)
+    }
+    store := writer.store
+    descr := writer.descr
+    err = os.Rename(writer.file.Name(), filepath.Join(store.dataDir, descr.FilePath))
+    if err != nil {
+        return dserr.Err(err)
+    }
+    descr.DataSize  = writer.size
+    descr.HashSum   = writer.hashSum()
+    descr.HasLocal  = true
+    descr.CreatedAt = time.Now().Unix()
+    descr.UpdatedAt = descr.CreatedAt
+
+    err = store.reg.PutBlock(descr)
+    if err != nil {
+        return dserr.Err(err)
+    }
+    return dserr.Err(err)
+}
+
+func (writer *blockWriter) abort() {
+    if writer.done {
+        return
+    }
+    writer.done = true
+    writer.file.Close()
+    os.Remove(writer.file.Name())
+}
+
+type zeroReader struct{}
+
+func (zeroReader) Read(data []byte) (int, error) {
+    for i := range data {
+        data[i] = 0
+    }
+    return len(data), nil
+}
+
+// padReader reads the data of the block padded with zeros
+// to the block size.
+func padReader(reader io.Reader, dataSize, blockSize int64) io.Reader {
+    return io.MultiReader(io.LimitReader(reader, dataSize), io.LimitReader(zeroReader{}, blockSize - dataSize))
+}
+
+// limitWriter writes the first bytes to the writer and discards
+// the rest, the shard padding is not written to the block.
+type limitWriter struct {
+    writer  io.Writer
+    left    int64
+}
+
+func (writer *limitWriter) Write(data []byte) (int, error) {
+    var err error
+    if writer.left > 0 {
+        size := int64(len(data))
+        if size > writer.left {
+            size = writer.left
+        }
+        _, err = writer.writer.Write(data[0:size])
+        writer.left -= size
+    }
+    return len(data), err
+}
@@ -0,0 +1,206 @@
+/*
+ * Copyright 2022 Oleg Borodin  <borodin@unix7.org>
+ */
+
+package fdstore
+
+import (
+    "bytes"
+    "io"
+    "math/rand"
+    "os"
+    "path/filepath"
+    "testing"
+
+    "github.com/stretchr/testify/require"
+
+    "fdump/dscomm/dskvdb"
+    "fdump/dscomm/dsdescr"
+    "fdump/fdstore/fdssrv/fdsreg"
+)
+
+func TestReco01(t *testing.T) {
+    var err error
+
+    dataDir := t.TempDir()
+
+    db, err := dskvdb.OpenDB(dataDir, "storedb")
+    defer db.Close()
+    require.NoError(t, err)
+
+    reg, err := fdsreg.NewReg(db)
+    require.NoError(t, err)
+
+    store, err := NewStore(dataDir, reg)
+    require.NoError(t, err)
+    store.SetRecoCount(2)
+
+    err = store.SeedUsers()
+    require.NoError(t, err)
+
+    authLogin := "admin"
+
+    const batchSize int64 = 4
+    const blockSize int64 = 1024
+    data := make([]byte, 6 * blockSize + 100)
+    rand.Read(data)
+
+    file, err := store.CreateFile(authLogin, "test.bin", batchSize, blockSize, 0)
+    require.NoError(t, err)
+    require.Equal(t, int64(2), file.RecoCount)
+
+    var batchId, blockId int64
+    for offset := int64(0); offset < int64(len(data)); offset += blockSize {
+        if blockId == 0 {
+            batch, err := store.AddBatch(authLogin, file.FileId)
+            require.NoError(t, err)
+            batchId = batch.BatchId
+        }
+        end := offset + blockSize
+        if end > int64(len(data)) {
+            end = int64(len(data))
+        }
+        saveTestBlock(t, store, authLogin, file.FileId, batchId, blockId, data[offset:end])
+        blockId = (blockId + 1) % batchSize
+    }
+
+    batch, err := reg.GetBatch(file.FileId, 0)
+    require.NoError(t, err)
+    require.Equal(t, int(batchSize), len(batch.DataSizes))
+
+    batch, err = reg.GetBatch(file.FileId, 1)
+    require.NoError(t, err)
+    require.Equal(t, 0, len(batch.DataSizes))
+
+    err = store.CloseFile(authLogin, file.FileId)
+    require.NoError(t, err)
+
+    batch, err = reg.GetBatch(file.FileId, 1)
+    require.NoError(t, err)
+    require.Equal(t, 3, len(batch.DataSizes))
+
+    blockPath := func(batchId, blockId int64) string {
+        return filepath.Join(dataDir, blockFilePath(file.FileId, batchId, dsdescr.BTData, blockId))
+    }
+
+    // Damage two blocks of the full batch and the short tail block
+    err = os.Remove(blockPath(0, 1))
+    require.NoError(t, err)
+    err = os.WriteFile(blockPath(0, 3), make([]byte, blockSize), 0644)
+    require.NoError(t, err)
+    err = os.WriteFile(blockPath(1, 2), []byte("qwerty"), 0644)
+    require.NoError(t, err)
+
+    buffer := bytes.NewBuffer(nil)
+    err = store.LoadFile(authLogin, file.FileId, buffer)
+    require.NoError(t, err)
+    require.Equal(t, data, buffer.Bytes())
+
+    // Repaired blocks are read without recovery
+    _, blockFile, err := store.openBlock(file.FileId, 0, dsdescr.BTData, 1)
+    require.NoError(t, err)
+    blockFile.Close()
+
+    err = os.Remove(blockPath(1, 0))
+    require.NoError(t, err)
+    _, blockFile, err = store.LoadBlock(authLogin, file.FileId, 1, dsdescr.BTData, 0)
+    require.NoError(t, err)
+    block0, err := io.ReadAll(blockFile)
+    blockFile.Close()
+    require.NoError(t, err)
+    require.Equal(t, data[4 * blockSize:5 * blockSize], block0)
+
+    // More damaged blocks than recovery blocks
+    for i := int64(0); i < 3; i++ {
+        err = os.Remove(blockPath(0, i))
+        require.NoError(t, err)
+    }
+    err = store.LoadFile(authLogin, file.FileId, io.Discard)
+    require.Error(t, err)
+}
+
+func TestReco02(t *testing.T) {
+    var err error
+
+    dataDir := t.TempDir()
+
+    db, err := dskvdb.OpenDB(dataDir, "storedb")
+    defer db.Close()
+    require.NoError(t, err)
+
+    reg, err := fdsreg.NewReg(db)
+    require.NoError(t, err)
+
+    store, err := NewStore(dataDir, reg)
+    require.NoError(t, err)
+    store.SetRecoCount(2)
+
+    err = store.SeedUsers()
+    require.NoError(t, err)
+
+    authLogin := "admin"
+
+    // Batches are limited in bytes
+    _, err = store.CreateFile(authLogin, "huge.bin", maxBatchSize - 2, maxBlockSize, 0)
+    require.Error(t, err)
+
+    const batchSize int64 = 4
+    const blockSize int64 = 100 * 1024
+    data := make([]byte, batchSize * blockSize - 10)
+    rand.Read(data)
+
+    file, err := store.CreateFile(authLogin, "test.bin", batchSize, blockSize, 0)
+    require.NoError(t, err)
+    batch, err := store.AddBatch(authLogin, file.FileId)
+    require.NoError(t, err)
+    for blockId := int64(0); blockId < batchSize; blockId++ {
+        end := (blockId + 1) * blockSize
+        if end > int64(len(data)) {
+            end = int64(len(data))
+        }
+        saveTestBlock(t, store, authLogin, file.FileId, batch.BatchId, blockId, data[blockId * blockSize:end])
+    }
+    batch, err = reg.GetBatch(file.FileId, batch.BatchId)
+    require.NoError(t, err)
+    require.Equal(t, int(batchSize), len(batch.DataSizes))
+
+    // Repaired blocks keep the hash init and the hash sum
+    for _, blockId := range []int64{ 1, 3 } {
+        prevBlock, err := reg.GetBlock(file.FileId, batch.BatchId, dsdescr.BTData, blockId)
+        require.NoError(t, err)
+        err = os.Remove(filepath.Join(dataDir, prevBlock.FilePath))
+        require.NoError(t, err)
+
+        _, blockFile, err := store.LoadBlock(authLogin, file.FileId, batch.BatchId, dsdescr.BTData, blockId)
+        require.NoError(t, err)
+        blockData, err := io.ReadAll(blockFile)
+        blockFile.Close()
+        require.NoError(t, err)
+        end := (blockId + 1) * blockSize
+        if end > int64(len(data)) {
+            end = int64(len(data))
+        }
+        require.Equal(t, data[blockId * blockSize:end], blockData)
+
+        block, err := reg.GetBlock(file.FileId, batch.BatchId, dsdescr.BTData, blockId)
+        require.NoError(t, err)
+        require.Equal(t, prevBlock.HashInit, block.HashInit)
+        require.Equal(t, prevBlock.HashSum, block.HashSum)
+        require.Equal(t, prevBlock.DataSize, block.DataSize)
+    }
+
+    // Recovery blocks are made again after a lost one
+    recoBlock, err := reg.GetBlock(file.FileId, batch.BatchId, dsdescr.BTReco, 0)
+    require.NoError(t, err)
+    err = os.Remove(filepath.Join(dataDir, recoBlock.FilePath))
+    require.NoError(t, err)
+    err = store.repairReco(file.FileId, batch.BatchId)
+    require.NoError(t, err)
+    _, blockFile, err := store.openBlock(file.FileId, batch.BatchId, dsdescr.BTReco, 0)
+    require.NoError(t, err)
+    blockFile.Close()
+
+    tmpFiles, err := filepath.Glob(filepath.Join(dataDir, blocksDir, "*", "*.tmp"))
+    require.NoError(t, err)
+    require.Equal(t, 0, len(tmpFiles))
+}
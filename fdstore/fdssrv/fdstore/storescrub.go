@@ -0,0 +1,396 @@
+/*
+ * Copyright 2022 Oleg Borodin  <borodin@unix7.org>
+ */
+
+package fdstore
+
+import (
+    "errors"
+    "fmt"
+    "os"
+    "path/filepath"
+    "sync"
+    "time"
+
+    "fdump/dscomm/dsdescr"
+    "fdump/dscomm/dserr"
+)
+
+const maxScrubFailed    int = 1000
+
+type ScrubStatus struct {
+    Enabled         bool
+    Running         bool
+    Rate            int64
+    Passes          int64
+    StartedAt       int64
+    FinishedAt      int64
+    TotalBlocks     int64
+    CheckedBlocks   int64
+    TotalChunks     int64
+    CheckedChunks   int64
+    CheckedBytes    int64
+    Corrupted       int64
+    Repaired        int64
+    Failed          []string
+}
+
+type scrubber struct {
+    status      ScrubStatus
+    mtx         sync.Mutex
+    stop        chan struct{}
+    done        chan struct{}
+}
+
+// StartScrubber runs scrub passes in background with the interval
+// between passes. The rate limits read bytes per second.
+func (store *Store) StartScrubber(rate int64, interval time.Duration) {
+    store.scrub.mtx.Lock()
+    defer store.scrub.mtx.Unlock()
+    if store.scrub.status.Enabled {
+        return
+    }
+    store.scrub.status.Enabled = true
+    store.scrub.status.Rate = rate
+    store.scrub.stop = make(chan struct{})
+    store.scrub.done = make(chan struct{})
+
+    stop := store.scrub.stop
+    done := store.scrub.done
+    go func() {
+        defer close(done)
+        for {
+            store.ScrubPass()
+            select {
+                case <-stop:
+                    return
+                case <-time.After(interval):
+            }
+        }
+    }()
+}
+
+// StopScrubber stops the background scrubber and waits
+// for the running pass to stop.
+func (store *Store) StopScrubber() {
+    store.scrub.mtx.Lock()
+    if !store.scrub.status.Enabled {
+        store.scrub.mtx.Unlock()
+        return
+    }
+    store.scrub.status.Enabled = false
+    close(store.scrub.stop)
+    done := store.scrub.done
+    store.scrub.mtx.Unlock()
+    <-done
+}
+
+func (store *Store) GetScrubStatus(authLogin string) (*ScrubStatus, error) {
+    var err error
+    status := &ScrubStatus{}
+    userRole, err := store.getUserRole(authLogin)
+    if userRole != dsdescr.URoleAdmin {
+        err = fmt.Errorf("user %s have insufficient rights", authLogin)
+        return status, dserr.Err(err)
+    }
+    store.scrub.mtx.Lock()
+    defer store.scrub.mtx.Unlock()
+    *status = store.scrub.status
+    status.Failed = append([]string{}, store.scrub.status.Failed...)
+    return status, dserr.Err(err)
+}
+
+// ScrubPass verifies checksums of all blocks and chunks once, marks
+// corrupted blocks in the registry and repairs blocks and chunks
+// from recovery blocks and recovery files.
+func (store *Store) ScrubPass() error {
+    var err error
+
+    store.scrub.mtx.Lock()
+    if store.scrub.status.Running {
+        store.scrub.mtx.Unlock()
+        err = errors.New("scrub pass is already running")
+        return dserr.Err(err)
+    }
+    store.scrub.status.Running       = true
+    store.scrub.status.StartedAt     = time.Now().Unix()
+    store.scrub.status.TotalBlocks   = 0
+    store.scrub.status.CheckedBlocks = 0
+    store.scrub.status.TotalChunks   = 0
+    store.scrub.status.CheckedChunks = 0
+    store.scrub.status.CheckedBytes  = 0
+    store.scrub.status.Corrupted     = 0
+    store.scrub.status.Repaired      = 0
+    store.scrub.status.Failed        = make([]string, 0)
+    rate := store.scrub.status.Rate
+    stop := store.scrub.stop
+    store.scrub.mtx.Unlock()
+
+    defer func() {
+        store.scrub.mtx.Lock()
+        store.scrub.status.Running = false
+        store.scrub.status.FinishedAt = time.Now().Unix()
+        if err == nil {
+            store.scrub.status.Passes += 1
+        }
+        store.scrub.mtx.Unlock()
+    }()
+
+    fileIds, err := store.reg.ListFileIds()
+    if err != nil {
+        return dserr.Err(err)
+    }
+    fileBlocks := make([][]*dsdescr.Block, 0, len(fileIds))
+    var totalBlocks int64
+    for _, fileId := range fileIds {
+        blocks, err := store.reg.ListBlocks(fileId)
+        if err != nil {
+            return dserr.Err(err)
+        }
+        fileBlocks = append(fileBlocks, blocks)
+        totalBlocks += int64(len(blocks))
+    }
+    users, err := store.reg.ListUsers()
+    if err != nil {
+        return dserr.Err(err)
+    }
+    chunks := make([]*dsdescr.Chunk, 0)
+    for _, user := range users {
+        userChunks, err := store.reg.ListChunks(user.Login)
+        if err != nil {
+            return dserr.Err(err)
+        }
+        chunks = append(chunks, userChunks...)
+    }
+    store.scrub.mtx.Lock()
+    store.scrub.status.TotalBlocks = totalBlocks
+    store.scrub.status.TotalChunks = int64(len(chunks))
+    store.scrub.mtx.Unlock()
+
+    startTime := time.Now()
+    var checkedBytes int64
+    // next limits the read rate and reports false if the pass is stopped
+    next := func(size int64) bool {
+        checkedBytes += size
+        var delay time.Duration
+        if rate > 0 {
+            expected := time.Duration(checkedBytes * int64(time.Second) / rate)
+            elapsed := time.Since(startTime)
+            if expected > elapsed {
+                delay = expected - elapsed
+            }
+        }
+        select {
+            case <-stop:
+                return false
+            default:
+        }
+        if delay > 0 {
+            select {
+                case <-stop:
+                    return false
+                case <-time.After(delay):
+            }
+        }
+        return true
+    }
+    stopped := errors.New("scrub pass stopped")
+    if !next(0) {
+        return dserr.Err(stopped)
+    }
+    for _, blocks := range fileBlocks {
+        for _, block := range blocks {
+            ok, size := store.scrubBlock(block)
+
+            store.scrub.mtx.Lock()
+            store.scrub.status.CheckedBlocks += 1
+            store.scrub.status.CheckedBytes += size
+            store.scrub.mtx.Unlock()
+
+            if !ok {
+                store.handleCorrupted(block)
+            }
+            if !next(size) {
+                return dserr.Err(stopped)
+            }
+        }
+    }
+    for _, chunk := range chunks {
+        ok, size := store.scrubChunk(chunk)
+
+        store.scrub.mtx.Lock()
+        store.scrub.status.CheckedChunks += 1
+        store.scrub.status.CheckedBytes += size
+        store.scrub.mtx.Unlock()
+
+        if !ok {
+            store.handleCorruptedChunk(chunk)
+        }
+        if !next(size) {
+            return dserr.Err(stopped)
+        }
+    }
+    return dserr.Err(err)
+}
+
+// scrubBlock reports false for the missing or corrupted block.
+// A block deleted during the pass is reported as valid.
+func (store *Store) scrubBlock(block *dsdescr.Block) (bool, int64) {
+    var size int64
+    file, err := os.OpenFile(filepath.Join(store.dataDir, block.FilePath), os.O_RDONLY, 0)
+    if err != nil {
+        return store.recheckBlock(block), size
+    }
+    defer file.Close()
+    ok, err := checkBlockFile(block, file)
+    size = block.DataSize
+    if err != nil || !ok {
+        return store.recheckBlock(block), size
+    }
+    return true, size
+}
+
+// recheckBlock verifies the block again under the file lock,
+// the block may be replaced or deleted by a concurrent request.
+func (store *Store) recheckBlock(block *dsdescr.Block) bool {
+    store.fileMtx.Lock()
+    defer store.fileMtx.Unlock()
+
+    has, err := store.reg.HasBlock(block.FileId, block.BatchId, block.BlockType, block.BlockId)
+    if err != nil || !has {
+        return true
+    }
+    _, file, err := store.openBlock(block.FileId, block.BatchId, block.BlockType, block.BlockId)
+    if err == nil {
+        file.Close()
+        return true
+    }
+    descr, err := store.reg.GetBlock(block.FileId, block.BatchId, block.BlockType, block.BlockId)
+    if err != nil {
+        return false
+    }
+    descr.Corrupted = true
+    descr.UpdatedAt = time.Now().Unix()
+    store.reg.PutBlock(descr)
+    return false
+}
+
+func (store *Store) handleCorrupted(block *dsdescr.Block) {
+    store.scrub.mtx.Lock()
+    store.scrub.status.Corrupted += 1
+    store.scrub.mtx.Unlock()
+
+    var err error
+    switch block.BlockType {
+        case dsdescr.BTData:
+            err = store.repairBlock(block.FileId, block.BatchId, block.BlockId)
+        default:
+            err = store.repairReco(block.FileId, block.BatchId)
+    }
+
+    store.scrub.mtx.Lock()
+    defer store.scrub.mtx.Unlock()
+    if err == nil {
+        store.scrub.status.Repaired += 1
+        return
+    }
+    if len(store.scrub.status.Failed) < maxScrubFailed {
+        failed := fmt.Sprintf("%d:%d:%d:%d: %v", block.FileId, block.BatchId,
+                                            block.BlockType, block.BlockId, err)
+        store.scrub.status.Failed = append(store.scrub.status.Failed, failed)
+    }
+}
+
+// scrubChunk reports false for the missing or corrupted chunk file
+// or recovery file. Chunks stored without recovery files are encoded,
+// a chunk deleted during the pass is reported as valid.
+func (store *Store) scrubChunk(chunk *dsdescr.Chunk) (bool, int64) {
+    var size int64
+    openFile := func(filePath string) *os.File {
+        file, err := os.OpenFile(filepath.Join(store.dataDir, filePath), os.O_RDONLY, 0)
+        if err != nil {
+            return nil
+        }
+        return file
+    }
+    dataFile := openFile(chunk.FilePath)
+    if dataFile != nil {
+        defer dataFile.Close()
+    }
+    if len(chunk.ShardSums) == 0 {
+        if dataFile == nil {
+            return store.recheckChunk(chunk), size
+        }
+        size = chunk.DataSize
+        ok, err := checkChunkFile(chunk, dataFile)
+        if err != nil || !ok {
+            return store.recheckChunk(chunk), size
+        }
+        store.encodeChunk(chunk.Login, chunk.ChunkId)
+        return true, size
+    }
+    recoFile := openFile(chunk.RecoPath)
+    if recoFile != nil {
+        defer recoFile.Close()
+    }
+    size = chunk.DataSize + int64(chunkRecoShards) * chunkShardSize(chunk.DataSize)
+    for _, ok := range checkChunkShards(chunk, dataFile, recoFile) {
+        if !ok {
+            return store.recheckChunk(chunk), size
+        }
+    }
+    return true, size
+}
+
+// recheckChunk reports true if the chunk is deleted
+// by a concurrent request.
+func (store *Store) recheckChunk(chunk *dsdescr.Chunk) bool {
+    store.fileMtx.Lock()
+    defer store.fileMtx.Unlock()
+
+    has, err := store.reg.HasChunk(chunk.Login, chunk.ChunkId)
+    if err != nil || !has {
+        return true
+    }
+    return false
+}
+
+func (store *Store) handleCorruptedChunk(chunk *dsdescr.Chunk) {
+    store.scrub.mtx.Lock()
+    store.scrub.status.Corrupted += 1
+    store.scrub.mtx.Unlock()
+
+    err := store.repairChunk(chunk.Login, chunk.ChunkId)
+
+    store.scrub.mtx.Lock()
+    defer store.scrub.mtx.Unlock()
+    if err == nil {
+        store.scrub.status.Repaired += 1
+        return
+    }
+    if len(store.scrub.status.Failed) < maxScrubFailed {
+        failed := fmt.Sprintf("chunk %s:%s: %v", chunk.Login, chunk.ChunkId, err)
+        store.scrub.status.Failed = append(store.scrub.status.Failed, failed)
+    }
+}
+
+// repairReco makes recovery blocks of the batch again.
+func (store *Store) repairReco(fileId, batchId int64) error {
+    var err error
+
+    store.fileMtx.Lock()
+    batch, err := store.reg.GetBatch(fileId, batchId)
+    store.fileMtx.Unlock()
+    if err != nil {
+        return dserr.Err(err)
+    }
+    if len(batch.DataSizes) == 0 {
+        err = fmt.Errorf("batch %d:%d not encoded", fileId, batchId)
+        return dserr.Err(err)
+    }
+    err = store.encodeBatch(fileId, batchId, int64(len(batch.DataSizes)))
+    if err != nil {
+        return dserr.Err(err)
+    }
+    return dserr.Err(err)
+}
@@ -0,0 +1,247 @@
+/*
+ * Copyright 2022 Oleg Borodin  <borodin@unix7.org>
+ */
+
+package fdstore
+
+import (
+    "bytes"
+    "math/rand"
+    "os"
+    "path/filepath"
+    "testing"
+    "time"
+
+    "github.com/stretchr/testify/require"
+
+    "fdump/dscomm/dschunk"
+    "fdump/dscomm/dskvdb"
+    "fdump/dscomm/dsdescr"
+    "fdump/fdstore/fdssrv/fdsreg"
+)
+
+func TestScrub01(t *testing.T) {
+    var err error
+
+    dataDir := t.TempDir()
+
+    db, err := dskvdb.OpenDB(dataDir, "storedb")
+    defer db.Close()
+    require.NoError(t, err)
+
+    reg, err := fdsreg.NewReg(db)
+    require.NoError(t, err)
+
+    store, err := NewStore(dataDir, reg)
+    require.NoError(t, err)
+
+    err = store.SeedUsers()
+    require.NoError(t, err)
+
+    authLogin := "admin"
+
+    const batchSize int64 = 4
+    const blockSize int64 = 1024
+    data := make([]byte, batchSize * blockSize)
+    rand.Read(data)
+
+    file0, err := store.CreateFile(authLogin, "reco.bin", batchSize, blockSize, 2)
+    require.NoError(t, err)
+    file1, err := store.CreateFile(authLogin, "plain.bin", batchSize, blockSize, -1)
+    require.NoError(t, err)
+
+    for _, file := range []*dsdescr.File{ file0, file1 } {
+        _, err = store.AddBatch(authLogin, file.FileId)
+        require.NoError(t, err)
+        for i := int64(0); i < batchSize; i++ {
+            saveTestBlock(t, store, authLogin, file.FileId, 0, i, data[i * blockSize:(i + 1) * blockSize])
+        }
+    }
+
+    err = store.ScrubPass()
+    require.NoError(t, err)
+    status, err := store.GetScrubStatus(authLogin)
+    require.NoError(t, err)
+    require.Equal(t, int64(10), status.TotalBlocks)
+    require.Equal(t, int64(10), status.CheckedBlocks)
+    require.Equal(t, int64(0), status.Corrupted)
+
+    blockPath := func(fileId, blockType, blockId int64) string {
+        return filepath.Join(dataDir, blockFilePath(fileId, 0, blockType, blockId))
+    }
+    // Flip a byte in the data block, damage the recovery block
+    // and remove the data block without recovery blocks
+    path := blockPath(file0.FileId, dsdescr.BTData, 2)
+    blockData, err := os.ReadFile(path)
+    require.NoError(t, err)
+    blockData[100] ^= 0xff
+    err = os.WriteFile(path, blockData, 0644)
+    require.NoError(t, err)
+
+    err = os.WriteFile(blockPath(file0.FileId, dsdescr.BTReco, 1), []byte("qwerty"), 0644)
+    require.NoError(t, err)
+
+    err = os.Remove(blockPath(file1.FileId, dsdescr.BTData, 1))
+    require.NoError(t, err)
+
+    err = store.ScrubPass()
+    require.NoError(t, err)
+    status, err = store.GetScrubStatus(authLogin)
+    require.NoError(t, err)
+    require.Equal(t, int64(2), status.Passes)
+    require.Equal(t, int64(3), status.Corrupted)
+    require.Equal(t, int64(2), status.Repaired)
+    require.Equal(t, 1, len(status.Failed))
+
+    block, err := reg.GetBlock(file1.FileId, 0, dsdescr.BTData, 1)
+    require.NoError(t, err)
+    require.Equal(t, true, block.Corrupted)
+
+    block, err = reg.GetBlock(file0.FileId, 0, dsdescr.BTData, 2)
+    require.NoError(t, err)
+    require.Equal(t, false, block.Corrupted)
+
+    buffer := bytes.NewBuffer(nil)
+    err = store.LoadFile(authLogin, file0.FileId, buffer)
+    require.NoError(t, err)
+    require.Equal(t, data, buffer.Bytes())
+
+    user := dsdescr.NewUser()
+    user.Login  = "qwerty"
+    user.Pass   = "123456"
+    err = store.AddUser(authLogin, user)
+    require.NoError(t, err)
+    _, err = store.GetScrubStatus(user.Login)
+    require.Error(t, err)
+}
+
+func TestScrubRate(t *testing.T) {
+    var err error
+
+    dataDir := t.TempDir()
+
+    db, err := dskvdb.OpenDB(dataDir, "storedb")
+    defer db.Close()
+    require.NoError(t, err)
+
+    reg, err := fdsreg.NewReg(db)
+    require.NoError(t, err)
+
+    store, err := NewStore(dataDir, reg)
+    require.NoError(t, err)
+
+    err = store.SeedUsers()
+    require.NoError(t, err)
+
+    authLogin := "admin"
+    const blockSize int64 = 64 * 1024
+    data := make([]byte, blockSize)
+
+    file, err := store.CreateFile(authLogin, "test.bin", 4, blockSize, -1)
+    require.NoError(t, err)
+    _, err = store.AddBatch(authLogin, file.FileId)
+    require.NoError(t, err)
+    for i := int64(0); i < 4; i++ {
+        saveTestBlock(t, store, authLogin, file.FileId, 0, i, data)
+    }
+
+    startTime := time.Now()
+    store.StartScrubber(2 * blockSize * 4, time.Hour)
+    defer store.StopScrubber()
+
+    var status *ScrubStatus
+    for i := 0; i < 100; i++ {
+        status, err = store.GetScrubStatus(authLogin)
+        require.NoError(t, err)
+        if status.Passes > 0 {
+            break
+        }
+        time.Sleep(20 * time.Millisecond)
+    }
+    require.Equal(t, int64(1), status.Passes)
+    require.Equal(t, int64(4) * blockSize, status.CheckedBytes)
+    require.GreaterOrEqual(t, time.Since(startTime), 400 * time.Millisecond)
+}
+
+func TestScrubChunks(t *testing.T) {
+    var err error
+
+    dataDir := t.TempDir()
+
+    db, err := dskvdb.OpenDB(dataDir, "storedb")
+    defer db.Close()
+    require.NoError(t, err)
+
+    reg, err := fdsreg.NewReg(db)
+    require.NoError(t, err)
+
+    store, err := NewStore(dataDir, reg)
+    require.NoError(t, err)
+
+    err = store.SeedUsers()
+    require.NoError(t, err)
+
+    authLogin := "admin"
+
+    chunkIds := make([]string, 3)
+    chunks := make([]*dsdescr.Chunk, 3)
+    for i := range chunkIds {
+        data := make([]byte, 10 * 1024 + i)
+        rand.Read(data)
+        chunkIds[i] = dschunk.ChunkId(data)
+        err = store.SaveChunk(authLogin, chunkIds[i], bytes.NewReader(data), int64(len(data)))
+        require.NoError(t, err)
+        chunks[i], err = reg.GetChunk(authLogin, chunkIds[i])
+        require.NoError(t, err)
+    }
+
+    // Corrupt the chunk file, remove the recovery file
+    // and store the chunk without recovery file
+    dataPath := filepath.Join(dataDir, chunks[0].FilePath)
+    chunkData, err := os.ReadFile(dataPath)
+    require.NoError(t, err)
+    chunkData[100] ^= 0xff
+    err = os.WriteFile(dataPath, chunkData, 0644)
+    require.NoError(t, err)
+
+    err = os.Remove(filepath.Join(dataDir, chunks[1].RecoPath))
+    require.NoError(t, err)
+
+    err = os.Remove(filepath.Join(dataDir, chunks[2].RecoPath))
+    require.NoError(t, err)
+    chunks[2].RecoPath  = ""
+    chunks[2].ShardSums = nil
+    err = reg.PutChunk(chunks[2])
+    require.NoError(t, err)
+
+    err = store.ScrubPass()
+    require.NoError(t, err)
+    status, err := store.GetScrubStatus(authLogin)
+    require.NoError(t, err)
+    require.Equal(t, int64(3), status.TotalChunks)
+    require.Equal(t, int64(3), status.CheckedChunks)
+    require.Equal(t, int64(2), status.Corrupted)
+    require.Equal(t, int64(2), status.Repaired)
+    require.Equal(t, 0, len(status.Failed))
+
+    chunk, err := reg.GetChunk(authLogin, chunkIds[2])
+    require.NoError(t, err)
+    require.Equal(t, chunkDataShards + chunkRecoShards, len(chunk.ShardSums))
+
+    err = store.ScrubPass()
+    require.NoError(t, err)
+    status, err = store.GetScrubStatus(authLogin)
+    require.NoError(t, err)
+    require.Equal(t, int64(0), status.Corrupted)
+
+    // The stopped scrubber does not wait for the rate limit
+    store.StartScrubber(1024, time.Hour)
+    time.Sleep(50 * time.Millisecond)
+    startTime := time.Now()
+    store.StopScrubber()
+    require.Less(t, time.Since(startTime), time.Second)
+    status, err = store.GetScrubStatus(authLogin)
+    require.NoError(t, err)
+    require.False(t, status.Running)
+    require.False(t, status.Enabled)
+}
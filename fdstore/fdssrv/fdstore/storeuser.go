@@ -10,6 +10,7 @@ import (
     "time"
     "fdump/dscomm/dsdescr"
     "fdump/dscomm/dserr"
+    "fdump/dscomm/dsrpc"
 )
 
 
@@ -29,7 +30,7 @@ func (store *Store) SeedUsers() error {
         var user *dsdescr.User
         user = dsdescr.NewUser()
         user.Login  = defaultAUser
-        user.Pass   = defaultAPass
+        user.Pass   = hashPass(defaultAPass)
         user.State  = dsdescr.UStateEnabled
         user.Role   = dsdescr.URoleAdmin
         user.CreatedAt = time.Now().Unix()
@@ -41,7 +42,7 @@ func (store *Store) SeedUsers() error {
         }
         user = dsdescr.NewUser()
         user.Login  = defaultUser
-        user.Pass   = defaultPass
+        user.Pass   = hashPass(defaultPass)
         user.State  = dsdescr.UStateEnabled
         user.Role   = dsdescr.URoleUser
         user.CreatedAt = time.Now().Unix()
@@ -82,6 +83,7 @@ func (store *Store) AddUser(authLogin string, user *dsdescr.User) error {
         err = fmt.Errorf("login %s exist", user.Login)
 
     }
+    user.Pass   = hashPass(user.Pass)
     user.State  = dsdescr.UStateEnabled
     user.Role   = dsdescr.URoleUser
     user.CreatedAt = time.Now().Unix()
@@ -134,12 +136,43 @@ func (store *Store) CheckUser(authLogin, login, passw string) (bool, error) {
     if err != nil {
         return ok, dserr.Err(err)
     }
-    if passw == user.Pass {
-        ok = true
+    if !dsrpc.IsPassHash(user.Pass) {
+        ok = passw == user.Pass
+        if ok {
+            err = store.UpgradeUser(login)
+            if err != nil {
+                return ok, dserr.Err(err)
+            }
+        }
+        return ok, dserr.Err(err)
+    }
+    ok, err = dsrpc.CheckPass([]byte(passw), user.Pass)
+    if err != nil {
+        return ok, dserr.Err(err)
     }
     return ok, dserr.Err(err)
 }
 
+// UpgradeUser replaces the plaintext password of previous versions
+// with the password record. Hashed records are left untouched.
+func (store *Store) UpgradeUser(login string) error {
+    var err error
+    user, err := store.reg.GetUser(login)
+    if err != nil {
+        return dserr.Err(err)
+    }
+    if dsrpc.IsPassHash(user.Pass) {
+        return dserr.Err(err)
+    }
+    user.Pass = hashPass(user.Pass)
+    user.UpdatedAt = time.Now().Unix()
+    err = store.reg.PutUser(user)
+    if err != nil {
+        return dserr.Err(err)
+    }
+    return dserr.Err(err)
+}
+
 func (store *Store) UpdateUser(authLogin string, user *dsdescr.User) error {
     var err error
     // Get current role
@@ -172,7 +205,12 @@ func (store *Store) UpdateUser(authLogin string, user *dsdescr.User) error {
 
     // Update property if exists
     if len(user.Pass) > 0 {
-        newUser.Pass = user.Pass
+        var ok bool
+        ok, err = validatePass(user.Pass)
+        if !ok {
+            return dserr.Err(err)
+        }
+        newUser.Pass = hashPass(user.Pass)
     }
     if len(user.Role) > 0 {
         newUser.Role = user.Role
@@ -200,10 +238,6 @@ func (store *Store) UpdateUser(authLogin string, user *dsdescr.User) error {
     if !ok {
         return dserr.Err(err)
     }
-    ok, err = validatePass(newUser.Pass)
-    if !ok {
-        return dserr.Err(err)
-    }
     // Delete old user descr
     err = store.reg.DeleteUser(user.Login)
     if err != nil {
@@ -267,6 +301,10 @@ func (store *Store) getUserRole(authLogin string) (string, error) {
     return userRole, dserr.Err(err)
 }
 
+func hashPass(passw string) string {
+    return dsrpc.HashPass([]byte(passw))
+}
+
 func validateURole(role string) (bool, error) {
     var err error
     var ok bool = true
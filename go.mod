@@ -4,19 +4,23 @@ go 1.17
 
 require (
 	github.com/go-yaml/yaml v2.1.0+incompatible
+	github.com/klauspost/compress v1.15.15
+	github.com/klauspost/reedsolomon v1.11.7
 	github.com/minio/highwayhash v1.0.2
 	github.com/sirupsen/logrus v1.8.1
 	github.com/stretchr/testify v1.8.0
 	github.com/syndtr/goleveldb v1.0.0
 	github.com/vmihailenco/msgpack/v5 v5.3.5
+	golang.org/x/crypto v0.9.0
+	golang.org/x/sys v0.8.0
 )
 
 require (
 	github.com/davecgh/go-spew v1.1.1 // indirect
 	github.com/golang/snappy v0.0.0-20180518054509-2e65f85255db // indirect
+	github.com/klauspost/cpuid/v2 v2.1.1 // indirect
 	github.com/pmezard/go-difflib v1.0.0 // indirect
 	github.com/vmihailenco/tagparser/v2 v2.0.0 // indirect
-	golang.org/x/sys v0.0.0-20191026070338-33540a1f6037 // indirect
 	gopkg.in/yaml.v2 v2.2.2 // indirect
 	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
@@ -13,7 +13,10 @@ type HeadDescr struct {
     Path    string          `json:"path"`
     Mtime   int64           `json:"mtime"`
     Atime   int64           `json:"atime"`
-    Ctime   int64           `json:"atime"`
+    Ctime   int64           `json:"ctime"`
+    MtimeNsec   int64       `json:"mtimeNsec,omitempty"`
+    AtimeNsec   int64       `json:"atimeNsec,omitempty"`
+    CtimeNsec   int64       `json:"ctimeNsec,omitempty"`
     Size    int64           `json:"size"`
     Mode    uint32          `json:"mode"`
     Type    int64           `json:"type"`
@@ -54,10 +54,10 @@ func Pack(dirs []string, outWriter io.Writer) error {
 
                 var sysStat syscall.Stat_t
                 err = syscall.Stat(filePath, &sysStat)
-
-                headDescr.Mtime = sysStat.Mtimespec.Sec
-                headDescr.Atime = sysStat.Atimespec.Sec
-                headDescr.Ctime = sysStat.Ctimespec.Sec
+                if err != nil {
+                    return err
+                }
+                setStatTimes(headDescr, &sysStat)
 
                 headDescr.Uid = sysStat.Uid
                 headDescr.Gid = sysStat.Gid
@@ -98,10 +98,10 @@ func Pack(dirs []string, outWriter io.Writer) error {
 
                 var sysStat syscall.Stat_t
                 err = syscall.Lstat(filePath, &sysStat)
-
-                headDescr.Mtime = sysStat.Mtimespec.Sec
-                headDescr.Atime = sysStat.Atimespec.Sec
-                headDescr.Ctime = sysStat.Ctimespec.Sec
+                if err != nil {
+                    return err
+                }
+                setStatTimes(headDescr, &sysStat)
 
                 headDescr.Uid = sysStat.Uid
                 headDescr.Gid = sysStat.Gid
@@ -146,10 +146,10 @@ func Pack(dirs []string, outWriter io.Writer) error {
 
                 var sysStat syscall.Stat_t
                 err = syscall.Stat(filePath, &sysStat)
-
-                headDescr.Mtime = sysStat.Mtimespec.Sec
-                headDescr.Atime = sysStat.Atimespec.Sec
-                headDescr.Ctime = sysStat.Ctimespec.Sec
+                if err != nil {
+                    return err
+                }
+                setStatTimes(headDescr, &sysStat)
 
                 headDescr.Uid = sysStat.Uid
                 headDescr.Gid = sysStat.Gid
@@ -257,9 +257,8 @@ func Unpack(outReader io.Reader, baseDir string) ([]*HeadDescr, error) {
                     headDescr.Match = true
                 }
 
-                mTime := time.Unix(headDescr.Mtime, 0)
-                aTime := time.Now()
-                err = os.Chtimes(unpackPath, mTime, aTime)
+                mTime, aTime := descrTimes(headDescr)
+                err = os.Chtimes(unpackPath, aTime, mTime)
                 if err != nil {
                     return descrs, err
                 }
@@ -310,7 +309,7 @@ func Unpack(outReader io.Reader, baseDir string) ([]*HeadDescr, error) {
 
                 //mTime := time.Unix(headDescr.Mtime, 0)
                 //aTime := time.Now()
-                //err = os.Chtimes(unpackPath, mTime, aTime)
+                //err = os.Chtimes(unpackPath, aTime, mTime)
                 //if err != nil {
                 //    return descrs, err
                 //}
@@ -343,9 +342,8 @@ func Unpack(outReader io.Reader, baseDir string) ([]*HeadDescr, error) {
                         return descrs, err
                     }
                 }
-                mTime := time.Unix(headDescr.Mtime, 0)
-                aTime := time.Now()
-                err = os.Chtimes(unpackPath, mTime, aTime)
+                mTime, aTime := descrTimes(headDescr)
+                err = os.Chtimes(unpackPath, aTime, mTime)
                 if err != nil {
                     return descrs, err
                 }
@@ -472,3 +470,14 @@ func List(outReader io.Reader) ([]*HeadDescr, error) {
     }
     return descrs, err
 }
+
+// descrTimes returns archived modification and access times,
+// the access time is current if it was not archived.
+func descrTimes(headDescr *HeadDescr) (time.Time, time.Time) {
+    mTime := time.Unix(headDescr.Mtime, headDescr.MtimeNsec)
+    aTime := time.Now()
+    if headDescr.Atime != 0 {
+        aTime = time.Unix(headDescr.Atime, headDescr.AtimeNsec)
+    }
+    return mTime, aTime
+}
@@ -17,7 +17,7 @@ import(
 func TestPacker01(t *testing.T) {
     var err error
 
-    baseDir := "./" //t.TempDir()
+    baseDir := t.TempDir()
 
     packPath := filepath.Join(baseDir, "test.pack")
     dirs := []string{ "/usr/bin" }
@@ -46,8 +46,6 @@ func TestPacker01(t *testing.T) {
     defer packFile.Close()
     require.NoError(t, err)
 
-    descrs, err = Unpack(packFile, "./xxx")
+    descrs, err = Unpack(packFile, filepath.Join(baseDir, "xxx"))
     require.NoError(t, err)
-
-    os.RemoveAll("./xxx")
 }
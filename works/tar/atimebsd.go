@@ -0,0 +1,23 @@
+//go:build freebsd || netbsd || darwin
+// +build freebsd netbsd darwin
+
+/*
+ * Copyright 2022 Oleg Borodin  <borodin@unix7.org>
+ */
+
+package dstar
+
+import (
+    "os"
+    "syscall"
+    "time"
+)
+
+func Atime(fileInfo os.FileInfo) time.Time {
+    var aTime time.Time
+    sysStat, ok := fileInfo.Sys().(*syscall.Stat_t)
+    if ok {
+        aTime = time.Unix(sysStat.Atimespec.Unix())
+    }
+    return aTime
+}
@@ -11,24 +11,9 @@ import (
     "io/fs"
     "os"
     "path/filepath"
-    "runtime"
-    "syscall"
-    "time"
 )
 
 
-func Atime(fileInfo os.FileInfo) time.Time {
-    var aTime time.Time
-    switch runtime.GOOS {
-        case "freebsd":
-            ts := fileInfo.Sys().(*syscall.Stat_t).Atimespec
-            aTime = time.Unix(int64(ts.Sec), int64(ts.Nsec))
-        default:
-    }
-    return aTime
-}
-
-
 func Tar(baseDir, tarPath string) error {
     var err error
 